@@ -0,0 +1,57 @@
+package aka
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/networkgcorefullcode/ssm/safe"
+)
+
+// FC values of the 5G-AKA key derivations (TS 33.501 Annex A)
+const (
+	FcKausf   = 0x6A
+	FcResStar = 0x6B
+	FcKseaf   = 0x6C
+)
+
+// KDF is the generic 3GPP key derivation function (TS 33.220 Annex B.2):
+// HMAC-SHA-256(key, FC || P0 || L0 || P1 || L1 ...)
+func KDF(key []byte, fc byte, params ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte{fc})
+	for _, p := range params {
+		mac.Write(p)
+		mac.Write(binary.BigEndian.AppendUint16(nil, uint16(len(p))))
+	}
+	return mac.Sum(nil)
+}
+
+// Kausf derives K_AUSF from CK || IK, the serving network name and SQN xor AK
+// (TS 33.501 Annex A.2)
+func Kausf(ck, ik []byte, servingNetworkName string, sqnXorAk []byte) []byte {
+	key := append(append(make([]byte, 0, len(ck)+len(ik)), ck...), ik...)
+	defer safe.Zero(key)
+	return KDF(key, FcKausf, []byte(servingNetworkName), sqnXorAk)
+}
+
+// XresStar derives RES* / XRES* from CK || IK, the serving network name, RAND
+// and RES, it is the lowest 128 bits of the KDF output (TS 33.501 Annex A.4)
+func XresStar(ck, ik []byte, servingNetworkName string, rand, res []byte) []byte {
+	key := append(append(make([]byte, 0, len(ck)+len(ik)), ck...), ik...)
+	defer safe.Zero(key)
+	out := KDF(key, FcResStar, []byte(servingNetworkName), rand, res)
+	return out[len(out)-16:]
+}
+
+// HxresStar derives HRES* / HXRES* from RAND and XRES*, it is the lowest 128
+// bits of SHA-256(RAND || XRES*) (TS 33.501 Annex A.5)
+func HxresStar(rand, xresStar []byte) []byte {
+	sum := sha256.Sum256(append(append(make([]byte, 0, len(rand)+len(xresStar)), rand...), xresStar...))
+	return sum[len(sum)-16:]
+}
+
+// Kseaf derives K_SEAF from K_AUSF and the serving network name (TS 33.501 Annex A.6)
+func Kseaf(kausf []byte, servingNetworkName string) []byte {
+	return KDF(kausf, FcKseaf, []byte(servingNetworkName))
+}
@@ -0,0 +1,66 @@
+package aka
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// TestKeyDerivations5G checks the derivations of TS 33.501 Annex A for the
+// CK, IK, RES, SQN and AK of test set 1 of TS 35.208. TS 33.501 has no test
+// data for them, the expected values were computed with a separate
+// implementation of the KDF of TS 33.220 Annex B.2.
+func TestKeyDerivations5G(t *testing.T) {
+	const servingNetworkName = "5G:mnc093.mcc208.3gppnetwork.org"
+	set := milenageTestSets[0]
+	ck, ik := unhex(t, set.f3), unhex(t, set.f4)
+	rand := unhex(t, set.rand)
+
+	xresStar := XresStar(ck, ik, servingNetworkName, rand, unhex(t, set.f2))
+	kausf := Kausf(ck, ik, servingNetworkName, unhex(t, "55f328b43577"))
+
+	for _, tc := range []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"XRES*", xresStar, "5cc9527f4d21c43bee83a15443acf1c4"},
+		{"HXRES*", HxresStar(rand, xresStar), "6970075e3c8245fdc2073003cf166279"},
+		{"K_AUSF", kausf, "f2e35260f85194d4f891504d02111e56689ac23dd393bee3abbcc5bfbc013ef9"},
+		{"K_SEAF", Kseaf(kausf, servingNetworkName), "cfddde483bd1318a412e98870f556410905be4fb7500abed93ee16af71bbb3fa"},
+	} {
+		if hex.EncodeToString(tc.got) != tc.want {
+			t.Errorf("%s = %x, want %s", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+// TestGenerateVector5G checks that the vector puts the Milenage outputs and
+// the derivations together
+func TestGenerateVector5G(t *testing.T) {
+	const servingNetworkName = "5G:mnc093.mcc208.3gppnetwork.org"
+	set := milenageTestSets[0]
+	m, err := NewMilenage(unhex(t, set.k), unhex(t, set.opc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Destroy()
+
+	vector, err := GenerateVector5G(m, unhex(t, set.rand), unhex(t, set.sqn), unhex(t, set.amf), servingNetworkName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"RAND", vector.Rand, set.rand},
+		{"AUTN", vector.Autn, "55f328b43577b9b94a9ffac354dfafb3"},
+		{"XRES*", vector.XresStar, "5cc9527f4d21c43bee83a15443acf1c4"},
+		{"K_AUSF", vector.Kausf, "f2e35260f85194d4f891504d02111e56689ac23dd393bee3abbcc5bfbc013ef9"},
+	} {
+		if hex.EncodeToString(tc.got) != tc.want {
+			t.Errorf("%s = %x, want %s", tc.name, tc.got, tc.want)
+		}
+	}
+}
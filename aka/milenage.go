@@ -0,0 +1,130 @@
+// Package aka implements the 3GPP authentication functions SSM runs on behalf
+// of the UDM so the subscriber keys never leave SSM: the Milenage algorithm set
+// (TS 35.206) and the 5G-AKA key derivations (TS 33.501 Annex A). The TUAK
+// algorithm set (TS 35.231) is not implemented.
+package aka
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/networkgcorefullcode/ssm/safe"
+)
+
+// Sizes of the Milenage inputs and outputs in bytes
+const (
+	KeySize  = 16
+	RandSize = 16
+	SqnSize  = 6
+	AmfSize  = 2
+	MacSize  = 8
+	ResSize  = 8
+	AkSize   = 6
+)
+
+// Milenage rotation amounts in bytes (r1..r5 = 64, 0, 32, 64, 96 bits) and the
+// last byte of the constants c1..c5 (TS 35.206 section 4.1)
+var (
+	milenageRot   = [5]int{8, 0, 4, 8, 12}
+	milenageConst = [5]byte{0x00, 0x01, 0x02, 0x04, 0x08}
+)
+
+// Milenage holds the subscriber key and OPc of one computation, call Destroy
+// to zero them once done
+type Milenage struct {
+	block cipher.Block
+	opc   []byte
+}
+
+// NewMilenage prepares the Milenage functions for a subscriber, k and opc are
+// copied so the caller can zero its own buffers
+func NewMilenage(k, opc []byte) (*Milenage, error) {
+	if len(k) != KeySize {
+		return nil, fmt.Errorf("K must be %d bytes, got %d", KeySize, len(k))
+	}
+	if len(opc) != KeySize {
+		return nil, fmt.Errorf("OPc must be %d bytes, got %d", KeySize, len(opc))
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return &Milenage{block: block, opc: append([]byte(nil), opc...)}, nil
+}
+
+// Destroy zeros the OPc, the AES key schedule is left to the garbage collector
+func (m *Milenage) Destroy() {
+	safe.Zero(m.opc)
+}
+
+// GenerateOPc derives OPc = E_K(OP) xor OP
+func GenerateOPc(k, op []byte) ([]byte, error) {
+	if len(k) != KeySize || len(op) != KeySize {
+		return nil, fmt.Errorf("K and OP must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	opc := make([]byte, KeySize)
+	block.Encrypt(opc, op)
+	subtle.XORBytes(opc, opc, op)
+	return opc, nil
+}
+
+// temp computes TEMP = E_K(RAND xor OPc)
+func (m *Milenage) temp(rand []byte) []byte {
+	temp := make([]byte, KeySize)
+	subtle.XORBytes(temp, rand, m.opc)
+	m.block.Encrypt(temp, temp)
+	return temp
+}
+
+// out computes OUTi = E_K(rot(in xor OPc, ri) xor ci) xor OPc, f1 passes TEMP
+// in extra to be added after the rotation
+func (m *Milenage) out(i int, in, extra []byte) []byte {
+	x := make([]byte, KeySize)
+	subtle.XORBytes(x, in, m.opc)
+	rotated := make([]byte, KeySize)
+	for j := range x {
+		rotated[j] = x[(j+milenageRot[i])%KeySize]
+	}
+	rotated[KeySize-1] ^= milenageConst[i]
+	if extra != nil {
+		subtle.XORBytes(rotated, rotated, extra)
+	}
+	m.block.Encrypt(rotated, rotated)
+	subtle.XORBytes(rotated, rotated, m.opc)
+	safe.Zero(x)
+	return rotated
+}
+
+// F1 computes the network authentication code MAC-A and the resynchronisation
+// code MAC-S
+func (m *Milenage) F1(rand, sqn, amf []byte) (macA, macS []byte, err error) {
+	if len(rand) != RandSize || len(sqn) != SqnSize || len(amf) != AmfSize {
+		return nil, nil, fmt.Errorf("RAND, SQN and AMF must be %d, %d and %d bytes", RandSize, SqnSize, AmfSize)
+	}
+	temp := m.temp(rand)
+	defer safe.Zero(temp)
+
+	in1 := make([]byte, 0, KeySize)
+	in1 = append(append(append(append(in1, sqn...), amf...), sqn...), amf...)
+	out1 := m.out(0, in1, temp)
+	return out1[:MacSize], out1[MacSize:], nil
+}
+
+// F2345 computes RES, CK, IK, AK and the resynchronisation anonymity key AK*
+func (m *Milenage) F2345(rand []byte) (res, ck, ik, ak, akStar []byte, err error) {
+	if len(rand) != RandSize {
+		return nil, nil, nil, nil, nil, fmt.Errorf("RAND must be %d bytes", RandSize)
+	}
+	temp := m.temp(rand)
+	defer safe.Zero(temp)
+
+	out2 := m.out(1, temp, nil)
+	out5 := m.out(4, temp, nil)
+	return out2[KeySize-ResSize:], m.out(2, temp, nil), m.out(3, temp, nil), out2[:AkSize], out5[:AkSize], nil
+}
@@ -0,0 +1,89 @@
+package aka
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/networkgcorefullcode/ssm/safe"
+)
+
+// Vector5G is a 5G home environment authentication vector (TS 33.501 section 6.1.3.2)
+type Vector5G struct {
+	Rand     []byte
+	Autn     []byte
+	XresStar []byte
+	Kausf    []byte
+}
+
+// GenerateVector5G computes a 5G-AKA vector with Milenage, CK, IK and AK are
+// zeroed before it returns
+func GenerateVector5G(m *Milenage, rand, sqn, amf []byte, servingNetworkName string) (Vector5G, error) {
+	macA, _, err := m.F1(rand, sqn, amf)
+	if err != nil {
+		return Vector5G{}, err
+	}
+	res, ck, ik, ak, akStar, err := m.F2345(rand)
+	if err != nil {
+		return Vector5G{}, err
+	}
+	defer func() {
+		for _, b := range [][]byte{res, ck, ik, ak, akStar} {
+			safe.Zero(b)
+		}
+	}()
+
+	// AUTN = SQN xor AK || AMF || MAC-A
+	sqnXorAk := make([]byte, SqnSize)
+	subtle.XORBytes(sqnXorAk, sqn, ak)
+	autn := make([]byte, 0, SqnSize+AmfSize+MacSize)
+	autn = append(append(append(autn, sqnXorAk...), amf...), macA...)
+
+	return Vector5G{
+		Rand:     append([]byte(nil), rand...),
+		Autn:     autn,
+		XresStar: XresStar(ck, ik, servingNetworkName, rand, res),
+		Kausf:    Kausf(ck, ik, servingNetworkName, sqnXorAk),
+	}, nil
+}
+
+// AutsSize is the size of AUTS = SQN_MS xor AK* || MAC-S in bytes
+const AutsSize = SqnSize + MacSize
+
+// resyncAmf is the dummy AMF* MAC-S is computed over (TS 33.102 section 6.3.3)
+var resyncAmf = []byte{0x00, 0x00}
+
+// ErrAutsMac is returned by ResyncSqn when the MAC-S of an AUTS is not valid
+var ErrAutsMac = errors.New("the MAC-S of AUTS is not valid")
+
+// ResyncSqn checks the AUTS a UE returned with a synchronisation failure for
+// RAND and returns the SQN_MS it carries (TS 33.102 section 6.3.5)
+func ResyncSqn(m *Milenage, rand, auts []byte) ([]byte, error) {
+	return resyncSqn(m, rand, auts, resyncAmf)
+}
+
+func resyncSqn(m *Milenage, rand, auts, amf []byte) ([]byte, error) {
+	if len(auts) != AutsSize {
+		return nil, fmt.Errorf("AUTS must be %d bytes", AutsSize)
+	}
+	res, ck, ik, ak, akStar, err := m.F2345(rand)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, b := range [][]byte{res, ck, ik, ak, akStar} {
+			safe.Zero(b)
+		}
+	}()
+
+	sqn := make([]byte, SqnSize)
+	subtle.XORBytes(sqn, auts[:SqnSize], akStar)
+	_, macS, err := m.F1(rand, sqn, amf)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(macS, auts[SqnSize:]) != 1 {
+		return nil, ErrAutsMac
+	}
+	return sqn, nil
+}
@@ -0,0 +1,43 @@
+package aka
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// TestResyncSqn checks AUTS against MAC-S and AK* of test set 1 of TS 35.208,
+// the test set computes MAC-S over its AMF instead of the AMF* of a resync
+func TestResyncSqn(t *testing.T) {
+	m, err := NewMilenage(unhex(t, "465b5ce8b199b49faa5f0a2ee238a6bc"), unhex(t, "cd63cb71954a9f4e48a5994e37a02baf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Destroy()
+	rand := unhex(t, "23553cbe9637a89d218ae64dae47bf35")
+
+	// AUTS = SQN xor AK* || MAC-S
+	sqn, err := resyncSqn(m, rand, unhex(t, "ba853f3c123c01cfaf9ec4e871e9"), unhex(t, "b9b9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(sqn) != "ff9bb4d0b607" {
+		t.Fatalf("SQN_MS = %x", sqn)
+	}
+
+	// the same AUTS does not verify over the zero AMF* of a resync
+	if _, err := ResyncSqn(m, rand, unhex(t, "ba853f3c123c01cfaf9ec4e871e9")); !errors.Is(err, ErrAutsMac) {
+		t.Fatalf("ResyncSqn error = %v, want ErrAutsMac", err)
+	}
+	sqn, err = ResyncSqn(m, rand, unhex(t, "ba853f3c123ccf44e93596e355c6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(sqn) != "ff9bb4d0b607" {
+		t.Fatalf("SQN_MS = %x", sqn)
+	}
+
+	if _, err := ResyncSqn(m, rand, unhex(t, "ba853f3c123c")); err == nil {
+		t.Fatal("a short AUTS was accepted")
+	}
+}
@@ -1,26 +1,71 @@
 package constants
 
 const (
-	ACTION_ENCRYPT_DATA      = "ENCRYPT_DATA"
-	ACTION_DECRYPT_DATA      = "DECRYPT_DATA"
-	ACTION_ENCRYPT_GCM       = "ENCRYPT_AES_GCM"
-	ACTION_DECRYPT_GCM       = "DECRYPT_AES_GCM"
-	ACTION_GENERATE_AES_KEY  = "GENERATE_AES_KEY"
-	ACTION_GENERATE_DES_KEY  = "GENERATE_DES_KEY"
-	ACTION_GENERATE_DES3_KEY = "GENERATE_DES3_KEY"
-	ACTION_STORE_KEY         = "STORE_KEY"
-	ACTION_UPDATE_KEY        = "UPDATE_KEY"
-	ACTION_DELETE_KEY        = "DELETE_KEY"
-	ACTION_GET_KEY           = "GET_KEY"
-	ACTION_GET_KEYS          = "GET_KEYS"
-	ACTION_GET_ALL_KEYS      = "GET_ALL_KEYS"
-	ACTION_HEALTH_CHECK      = "HEALTH_CHECK"
-	ACTION_USER_LOGIN        = "USER_LOGIN"
+	ACTION_ENCRYPT_DATA                = "ENCRYPT_DATA"
+	ACTION_DECRYPT_DATA                = "DECRYPT_DATA"
+	ACTION_ENCRYPT_GCM                 = "ENCRYPT_AES_GCM"
+	ACTION_DECRYPT_GCM                 = "DECRYPT_AES_GCM"
+	ACTION_GENERATE_AES_KEY            = "GENERATE_AES_KEY"
+	ACTION_GENERATE_DES_KEY            = "GENERATE_DES_KEY"
+	ACTION_GENERATE_DES3_KEY           = "GENERATE_DES3_KEY"
+	ACTION_STORE_KEY                   = "STORE_KEY"
+	ACTION_UPDATE_KEY                  = "UPDATE_KEY"
+	ACTION_DELETE_KEY                  = "DELETE_KEY"
+	ACTION_GET_KEY                     = "GET_KEY"
+	ACTION_GET_KEYS                    = "GET_KEYS"
+	ACTION_GET_ALL_KEYS                = "GET_ALL_KEYS"
+	ACTION_HEALTH_CHECK                = "HEALTH_CHECK"
+	ACTION_USER_LOGIN                  = "USER_LOGIN"
+	ACTION_ROTATE_KEY                  = "ROTATE_KEY"
+	ACTION_PURGE_RETIRED_KEYS          = "PURGE_RETIRED_KEYS"
+	ACTION_REENCRYPT_DATA              = "REENCRYPT_DATA"
+	ACTION_ENCRYPT_BATCH               = "ENCRYPT_BATCH"
+	ACTION_DECRYPT_BATCH               = "DECRYPT_BATCH"
+	ACTION_GENERATE_RSA_KEY            = "GENERATE_RSA_KEY"
+	ACTION_GENERATE_EC_KEY             = "GENERATE_EC_KEY"
+	ACTION_SIGN_DATA                   = "SIGN_DATA"
+	ACTION_VERIFY_SIGNATURE            = "VERIFY_SIGNATURE"
+	ACTION_EXPORT_PUBLIC_KEY           = "EXPORT_PUBLIC_KEY"
+	ACTION_GENERATE_MAC_KEY            = "GENERATE_MAC_KEY"
+	ACTION_COMPUTE_MAC                 = "COMPUTE_MAC"
+	ACTION_VERIFY_MAC                  = "VERIFY_MAC"
+	ACTION_AUTH_VECTOR                 = "GENERATE_AUTH_VECTOR"
+	ACTION_AUTH_RESYNC                 = "RESYNC_AUTH_SQN"
+	ACTION_GENERATE_SUCI_KEY           = "GENERATE_SUCI_KEY"
+	ACTION_IMPORT_SUCI_KEY             = "IMPORT_SUCI_KEY"
+	ACTION_EXPORT_SUCI_PUBLIC_KEY      = "EXPORT_SUCI_PUBLIC_KEY"
+	ACTION_SUCI_DECONCEAL              = "SUCI_DECONCEAL"
+	ACTION_GENERATE_TRANSPORT_KEY_PAIR = "GENERATE_TRANSPORT_KEY_PAIR"
+	ACTION_GENERATE_TRANSPORT_KEY      = "GENERATE_TRANSPORT_KEY"
+	ACTION_WRAP_KEY                    = "WRAP_KEY"
+	ACTION_UNWRAP_KEY                  = "UNWRAP_KEY"
+	ACTION_REPLICATION_SYNC            = "REPLICATION_SYNC"
+	ACTION_REPLICATION_REPORT          = "REPLICATION_REPORT"
+	ACTION_BACKUP                      = "BACKUP"
+	ACTION_BACKUP_RESTORE              = "BACKUP_RESTORE"
+	ACTION_COMPONENT_IMPORT_START      = "COMPONENT_IMPORT_START"
+	ACTION_COMPONENT_IMPORT_SUBMIT     = "COMPONENT_IMPORT_SUBMIT"
+	ACTION_COMPONENT_IMPORT_FINALIZE   = "COMPONENT_IMPORT_FINALIZE"
+	ACTION_GET_JWKS                    = "GET_JWKS"
+	ACTION_USER_LOGOUT                 = "USER_LOGOUT"
+	ACTION_REFRESH_TOKEN               = "REFRESH_TOKEN"
+	ACTION_CREATE_SERVICE_ACCOUNT      = "CREATE_SERVICE_ACCOUNT"
+	ACTION_LIST_SERVICE_ACCOUNTS       = "LIST_SERVICE_ACCOUNTS"
+	ACTION_RESET_SERVICE_PASSWORD      = "RESET_SERVICE_PASSWORD"
+	ACTION_DISABLE_SERVICE_ACCOUNT     = "DISABLE_SERVICE_ACCOUNT"
 
 	USER_UDM        = "udm"
 	USER_WEBCONSOLE = "webconsole"
+
+	// Roles of the service accounts, the udm and webconsole accounts have the
+	// role of the same name
+	ROLE_UDM        = "udm"
+	ROLE_WEBCONSOLE = "webconsole"
 )
 
+// ServiceRoles keep the roles a service account may have
+var ServiceRoles []string = []string{ROLE_UDM, ROLE_WEBCONSOLE}
+
 // ActionList keep all action for the http api, if you add new action you must add that action here
 var ActionList []string = []string{
 	ACTION_ENCRYPT_DATA,
@@ -38,4 +83,41 @@ var ActionList []string = []string{
 	ACTION_USER_LOGIN,
 	ACTION_ENCRYPT_GCM,
 	ACTION_DECRYPT_GCM,
+	ACTION_ROTATE_KEY,
+	ACTION_PURGE_RETIRED_KEYS,
+	ACTION_REENCRYPT_DATA,
+	ACTION_ENCRYPT_BATCH,
+	ACTION_DECRYPT_BATCH,
+	ACTION_GENERATE_RSA_KEY,
+	ACTION_GENERATE_EC_KEY,
+	ACTION_SIGN_DATA,
+	ACTION_VERIFY_SIGNATURE,
+	ACTION_EXPORT_PUBLIC_KEY,
+	ACTION_GENERATE_MAC_KEY,
+	ACTION_COMPUTE_MAC,
+	ACTION_VERIFY_MAC,
+	ACTION_AUTH_VECTOR,
+	ACTION_AUTH_RESYNC,
+	ACTION_GENERATE_SUCI_KEY,
+	ACTION_IMPORT_SUCI_KEY,
+	ACTION_EXPORT_SUCI_PUBLIC_KEY,
+	ACTION_SUCI_DECONCEAL,
+	ACTION_GENERATE_TRANSPORT_KEY_PAIR,
+	ACTION_GENERATE_TRANSPORT_KEY,
+	ACTION_WRAP_KEY,
+	ACTION_UNWRAP_KEY,
+	ACTION_REPLICATION_SYNC,
+	ACTION_REPLICATION_REPORT,
+	ACTION_BACKUP,
+	ACTION_BACKUP_RESTORE,
+	ACTION_COMPONENT_IMPORT_START,
+	ACTION_COMPONENT_IMPORT_SUBMIT,
+	ACTION_COMPONENT_IMPORT_FINALIZE,
+	ACTION_GET_JWKS,
+	ACTION_USER_LOGOUT,
+	ACTION_REFRESH_TOKEN,
+	ACTION_CREATE_SERVICE_ACCOUNT,
+	ACTION_LIST_SERVICE_ACCOUNTS,
+	ACTION_RESET_SERVICE_PASSWORD,
+	ACTION_DISABLE_SERVICE_ACCOUNT,
 }
@@ -43,11 +43,30 @@ const (
 	LABEL_ENCRYPTION_KEY_DES    = "KEY_ENCRYPTION_DES"
 	LABEL_ENCRYPTION_KEY_DES3   = "KEY_ENCRYPTION_DES3"
 
+	// Home network key pairs of the SUCI protection schemes, the CKA_ID is the
+	// home network public key identifier
+	LABEL_SUCI_PROFILE_A = "SUCI_PROFILE_A"
+	LABEL_SUCI_PROFILE_B = "SUCI_PROFILE_B"
+
+	// Key transport between SSM instances: the RSA key pair unwraps the AES
+	// transport key a peer sends, the transport key wraps the exported keys
+	LABEL_TRANSPORT_KEY      = "TRANSPORT_KEY"
+	LABEL_TRANSPORT_KEY_PAIR = "TRANSPORT_RSA"
+
+	// Replication to the replica token: the replication key is shared by both
+	// tokens and wraps the replicated keys, it reaches the replica under the
+	// replication RSA key pair of the replica
+	LABEL_REPLICATION_KEY      = "REPLICATION_KEY"
+	LABEL_REPLICATION_KEY_PAIR = "REPLICATION_RSA"
+
 	// For SSM internal use
 	LABEL_ENCRYPTION_KEY_INTERNAL_AES256 = "ENCRYPTION_KEY_INTERNAL_AES256"
 	LABEL_ENCRYPTION_KEY_INTERNAL_AES128 = "ENCRYPTION_KEY_INTERNAL_AES128"
 	AuditKeyLabel                        = "AUDIT_SIGNING_KEY"
 	JWTKeyLabel                          = "JWT_SIGNING_KEY"
+	// HMAC key peppering the service passwords before Argon2id, it only
+	// leaves the HSM wrapped so the stored hashes can not be attacked offline
+	LABEL_PASSWORD_PEPPER = "PASSWORD_PEPPER_HMAC"
 
 	// Algorithm constants
 	ALGORITHM_AES256          = 1
@@ -78,6 +97,9 @@ const (
 	TYPE_AES  = "AES"
 	TYPE_DES  = "DES"
 	TYPE_DES3 = "DES3"
+	// TYPE_GENERIC_SECRET is the type of the HMAC keys, they are not stored
+	// through the API and only wrapped by the replication and the backups
+	TYPE_GENERIC_SECRET = "GENERIC_SECRET"
 )
 
 var KeyTypeAllow [3]string = [3]string{
@@ -85,3 +107,76 @@ var KeyTypeAllow [3]string = [3]string{
 	TYPE_DES,
 	TYPE_DES3,
 }
+
+// Label families, each family can be placed on its own token
+const (
+	LABEL_FAMILY_K4         = "k4"         // subscriber keys stored by the webconsole
+	LABEL_FAMILY_ENCRYPTION = "encryption" // keys used to encrypt the K4 keys in transit
+	LABEL_FAMILY_INTERNAL   = "internal"   // SSM internal encryption keys
+	LABEL_FAMILY_SIGNING    = "signing"    // audit and JWT signing key pairs
+	LABEL_FAMILY_AKA        = "aka"        // subscriber K and operator OP keys
+	LABEL_FAMILY_SUCI       = "suci"       // home network keys for SUCI de-concealment
+	LABEL_FAMILY_TRANSPORT  = "transport"  // keys wrapping the keys exchanged with other SSM instances
+)
+
+// LABEL_FAMILY_APPLICATION is the key usage policy family of the labels outside
+// of LabelFamilyMap, the key pairs and MAC keys created through the API. It is
+// not a label family of the tokens, these keys stay on the first token.
+const LABEL_FAMILY_APPLICATION = "application"
+
+var LabelFamilyMap = map[string]string{
+	LABEL_K4_KEY_AES:                     LABEL_FAMILY_K4,
+	LABEL_K4_KEY_DES:                     LABEL_FAMILY_K4,
+	LABEL_K4_KEY_DES3:                    LABEL_FAMILY_K4,
+	LABEL_ENCRYPTION_KEY:                 LABEL_FAMILY_ENCRYPTION,
+	LABEL_ENCRYPTION_KEY_AES256:          LABEL_FAMILY_ENCRYPTION,
+	LABEL_ENCRYPTION_KEY_AES128:          LABEL_FAMILY_ENCRYPTION,
+	LABEL_ENCRYPTION_KEY_DES:             LABEL_FAMILY_ENCRYPTION,
+	LABEL_ENCRYPTION_KEY_DES3:            LABEL_FAMILY_ENCRYPTION,
+	LABEL_ENCRYPTION_KEY_INTERNAL_AES256: LABEL_FAMILY_INTERNAL,
+	LABEL_ENCRYPTION_KEY_INTERNAL_AES128: LABEL_FAMILY_INTERNAL,
+	LABEL_PASSWORD_PEPPER:                LABEL_FAMILY_INTERNAL,
+	AuditKeyLabel:                        LABEL_FAMILY_SIGNING,
+	JWTKeyLabel:                          LABEL_FAMILY_SIGNING,
+	LABEL_SUCI_PROFILE_A:                 LABEL_FAMILY_SUCI,
+	LABEL_SUCI_PROFILE_B:                 LABEL_FAMILY_SUCI,
+	LABEL_TRANSPORT_KEY:                  LABEL_FAMILY_TRANSPORT,
+	LABEL_TRANSPORT_KEY_PAIR:             LABEL_FAMILY_TRANSPORT,
+	LABEL_REPLICATION_KEY:                LABEL_FAMILY_TRANSPORT,
+	LABEL_REPLICATION_KEY_PAIR:           LABEL_FAMILY_TRANSPORT,
+}
+
+var LabelFamilies [7]string = [7]string{
+	LABEL_FAMILY_K4,
+	LABEL_FAMILY_ENCRYPTION,
+	LABEL_FAMILY_INTERNAL,
+	LABEL_FAMILY_SIGNING,
+	LABEL_FAMILY_SUCI,
+	LABEL_FAMILY_TRANSPORT,
+}
+
+// ReplicatedLabelFamilies are the label families copied to the replica token
+var ReplicatedLabelFamilies [3]string = [3]string{
+	LABEL_FAMILY_K4,
+	LABEL_FAMILY_ENCRYPTION,
+	LABEL_FAMILY_INTERNAL,
+}
+
+// Key operations of the key usage policies, see factory.KeyPolicy. Deleting
+// and updating a key are governed by the deletable and updatable flags.
+const (
+	KEY_OPERATION_GENERATE = "generate"
+	KEY_OPERATION_STORE    = "store" // store-key and component import
+	KEY_OPERATION_READ     = "read"  // get-key and get-data-keys
+	KEY_OPERATION_UPDATE   = "update"
+	KEY_OPERATION_DELETE   = "delete" // also the purge of retired versions
+	KEY_OPERATION_ENCRYPT  = "encrypt"
+	KEY_OPERATION_DECRYPT  = "decrypt"
+	KEY_OPERATION_ROTATE   = "rotate"
+	KEY_OPERATION_WRAP     = "wrap"
+	KEY_OPERATION_UNWRAP   = "unwrap"
+	KEY_OPERATION_SIGN     = "sign"
+	KEY_OPERATION_VERIFY   = "verify" // signatures and MACs
+	KEY_OPERATION_MAC      = "mac"
+	KEY_OPERATION_DERIVE   = "derive" // authentication vectors and SUCI de-concealment
+)
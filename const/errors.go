@@ -2,4 +2,5 @@ package constants
 
 const (
 	ERROR_STRING_KEY_NOT_FOUND = "error Key With The Label Not Found"
+	ERROR_STRING_KEY_EXISTS    = "the key is in the SSM"
 )
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SecretStore reads and restores the user secrets of the backup bundles in MongoDB
+type SecretStore struct{}
+
+// BackupSecrets returns the user secrets of every service, still encrypted
+// under the internal key or hashed
+func (SecretStore) BackupSecrets() ([]pkcs11mgr.BackupSecret, error) {
+	documents, err := FindAllData(Client, factory.SsmConfig.Configuration.Mongodb.DBName, CollSecret, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make([]pkcs11mgr.BackupSecret, 0, len(documents))
+	for _, document := range documents {
+		user := UserSecret{}
+		bsonBytes, err := bson.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+		if err := bson.Unmarshal(bsonBytes, &user); err != nil {
+			return nil, err
+		}
+		secret := pkcs11mgr.BackupSecret{
+			ServiceId: user.ServiceID,
+			Role:      user.ServiceRole(),
+			Disabled:  user.Disabled,
+		}
+		if user.PasswordSecret != nil {
+			secret.EncryptedData = user.PasswordSecret.EncryptedData
+			secret.IV = user.PasswordSecret.IV
+			secret.KeyId = user.PasswordSecret.Id
+			secret.KeyLabel = user.PasswordSecret.KeyLabel
+			secret.EncryptionAlgorithm = user.PasswordSecret.EncryptionAlgorithm
+		}
+		if user.PasswordHash != nil {
+			secret.PasswordHash = user.PasswordHash.Hash
+			secret.PepperId = user.PasswordHash.PepperId
+			secret.PepperCheck = user.PasswordHash.PepperCheck
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// RestoreSecrets replaces the user secret of every service of the bundle
+func (SecretStore) RestoreSecrets(secrets []pkcs11mgr.BackupSecret) error {
+	for _, secret := range secrets {
+		user := UserSecret{
+			ServiceID: secret.ServiceId,
+			Role:      secret.Role,
+			Disabled:  secret.Disabled,
+		}
+		if secret.PasswordHash != "" {
+			user.PasswordHash = &PasswordHash{Hash: secret.PasswordHash, PepperId: secret.PepperId, PepperCheck: secret.PepperCheck}
+		} else {
+			user.PasswordSecret = &EncryptedSecret{
+				EncryptedData:       secret.EncryptedData,
+				IV:                  secret.IV,
+				Id:                  secret.KeyId,
+				KeyLabel:            secret.KeyLabel,
+				EncryptionAlgorithm: secret.EncryptionAlgorithm,
+			}
+		}
+		filter := bson.M{"service_id": secret.ServiceId}
+		if err := ReplaceData(Client, factory.SsmConfig.Configuration.Mongodb.DBName, CollSecret, filter, user); err != nil {
+			return err
+		}
+		logger.AppLog.Infof("User secret of %s restored", secret.ServiceId)
+	}
+	return nil
+}
@@ -5,4 +5,7 @@ const (
 	CollClientLogs = "client_logs"
 	CollAuditLogs  = "audit_logs"
 	CollSecret     = "secrets"
+	// Refresh tokens of /login and access tokens revoked by /logout
+	CollRefreshTokens = "refresh_tokens"
+	CollRevokedTokens = "revoked_tokens"
 )
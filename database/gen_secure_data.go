@@ -3,23 +3,39 @@ package database
 import (
 	"crypto/rand"
 	"encoding/hex"
-	"fmt"
 	"math/big"
-	"os"
 	"sync"
+	"time"
 
-	"github.com/miekg/pkcs11"
-	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/factory"
 	"github.com/networkgcorefullcode/ssm/logger"
-	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
-	"github.com/networkgcorefullcode/ssm/safe"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
 type UserSecret struct {
-	PasswordSecret EncryptedSecret `bson:"encrypted_data"`
-	ServiceID      string          `bson:"service_id"`
+	// PasswordSecret is the AES encrypted password of the accounts created
+	// before the password hashes, it is replaced by PasswordHash on the next
+	// successful login
+	PasswordSecret *EncryptedSecret `bson:"encrypted_data,omitempty"`
+	PasswordHash   *PasswordHash    `bson:"password_hash,omitempty"`
+	ServiceID      string           `bson:"service_id"`
+	// Role of the account, empty for the accounts created before the roles,
+	// see ServiceRole
+	Role              string    `bson:"role,omitempty"`
+	Disabled          bool      `bson:"disabled,omitempty"`
+	CreatedAt         time.Time `bson:"created_at,omitempty"`
+	PasswordChangedAt time.Time `bson:"password_changed_at,omitempty"`
+	LastLoginAt       time.Time `bson:"last_login_at,omitempty"`
+	DisabledAt        time.Time `bson:"disabled_at,omitempty"`
+}
+
+// ServiceRole returns the role of the account, the udm and webconsole accounts
+// created before the roles have the role of their name
+func (u UserSecret) ServiceRole() string {
+	if u.Role == "" {
+		return u.ServiceID
+	}
+	return u.Role
 }
 
 type EncryptedSecret struct {
@@ -58,82 +74,22 @@ func genSecret(serviceID string) error {
 		return nil
 	}
 
-	password := generateSecurePassword(16)
-
-	// encrypt this secrets before storing in DB
-	secret, err := encryptSecret(password)
-	if err != nil {
-		return err
-	}
-
+	// the bootstrap accounts are created without a password, it is never
+	// written to disk or to the logs and is only printed by `ssm account reset`
+	now := time.Now()
 	userSecret := UserSecret{
-		PasswordSecret: secret,
-		ServiceID:      serviceID,
-	}
-
-	// Create the directory if it doesn't exist
-	secretDir := "/tmp/user-secret-ssm"
-	if err := os.MkdirAll(secretDir, 0755); err != nil {
-		logger.AppLog.Errorf("Failed to create directory %s: %v", secretDir, err)
-		return err
-	}
-
-	file, err := os.OpenFile("/tmp/user-secret-ssm/user_secrets.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		logger.AppLog.Errorf("Failed to create user_secrets.txt file: %v", err)
-		return err
+		ServiceID: serviceID,
+		Role:      serviceID,
+		CreatedAt: now,
 	}
-	defer file.Close()
-
-	envContent := fmt.Sprintf(`user_%s = "%s"
-	password_secret_%s = "%s"
-
-	`, serviceID, serviceID, serviceID, password)
-
-	_, err = file.WriteString(envContent)
-	if err != nil {
-		logger.AppLog.Errorf("Failed to write to user_secrets.txt file: %v", err)
-	}
-
 	if _, err = InsertData(Client, factory.SsmConfig.Configuration.Mongodb.DBName, CollSecret, userSecret); err != nil {
 		return err
 	}
 
+	logger.AppLog.Warnf("Service account %s created without a password, run `ssm account reset --service-id %s` to set it", serviceID, serviceID)
 	return nil
 }
 
-func encryptSecret(secret string) (EncryptedSecret, error) {
-	password, err := hex.DecodeString(secret)
-	if err != nil {
-		return EncryptedSecret{}, err
-	}
-	session := mgrpkcs11.GetSession()
-	keyHandle, err := pkcs11mgr.FindKeyLabelReturnRandom(constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256, *session)
-	if err != nil {
-		return EncryptedSecret{}, err
-	}
-	iv := make([]byte, 16)
-	if err := safe.RandRead(iv); err != nil {
-		logger.AppLog.Errorf("Failed to generate IV: %v", err)
-		return EncryptedSecret{}, err
-	}
-	encrypted, err := pkcs11mgr.EncryptKey(keyHandle, iv, password, pkcs11.CKM_AES_CBC_PAD, *session)
-	if err != nil {
-		return EncryptedSecret{}, err
-	}
-	attrs, err := pkcs11mgr.GetObjectAttributes(keyHandle, *session)
-	if err != nil {
-		return EncryptedSecret{}, err
-	}
-	mgrpkcs11.LogoutSession(session)
-	return EncryptedSecret{
-		EncryptedData: hex.EncodeToString(encrypted),
-		IV:            hex.EncodeToString(iv),
-		Id:            attrs.Id,
-		KeyLabel:      constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256,
-	}, nil
-}
-
 func generateSecurePassword(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*"
 
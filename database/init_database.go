@@ -12,7 +12,7 @@ import (
 )
 
 var Client *mongo.Client = &mongo.Client{}
-var mgrpkcs11 *pkcs11mgr.Manager
+var provider pkcs11mgr.CryptoProvider
 
 func initDatabase(client_pass *mongo.Client, url string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
@@ -27,14 +27,27 @@ func initDatabase(client_pass *mongo.Client, url string) error {
 }
 
 func InitDB() {
-	if err := initDatabase(Client, factory.SsmConfig.Configuration.Mongodb.Url); err != nil {
+	if err := Connect(); err != nil {
 		logger.AppLog.Errorf("Failed to initialize database: %v", err)
 		panic(err)
 	}
 
 	GenSecrets()
+
+	if err := EnsureAccountIndexes(); err != nil {
+		logger.AppLog.Errorf("Failed to create the index of the service accounts: %v", err)
+	}
+	if err := EnsureTokenIndexes(); err != nil {
+		logger.AppLog.Errorf("Failed to create the indexes of the tokens: %v", err)
+	}
+}
+
+// Connect connects the client to the configured database without generating
+// the user secrets
+func Connect() error {
+	return initDatabase(Client, factory.SsmConfig.Configuration.Mongodb.Url)
 }
 
-func SetPKCS11Manager(mgr *pkcs11mgr.Manager) {
-	mgrpkcs11 = mgr
+func SetCryptoProvider(p pkcs11mgr.CryptoProvider) {
+	provider = p
 }
@@ -4,7 +4,9 @@ import (
 	"context"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // InsertData insert a mongoDB document
@@ -37,3 +39,34 @@ func InsertMultipleData(client *mongo.Client, database string, collection string
 
 	return result.InsertedIDs, nil
 }
+
+// ReplaceData replaces the mongoDB document matching the filter, it inserts
+// the document when none matches
+func ReplaceData(client *mongo.Client, database string, collection string, filter bson.M, data any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	DbContext.GenMutex.Lock()
+	defer DbContext.GenMutex.Unlock()
+
+	coll := client.Database(database).Collection(collection)
+	_, err := coll.ReplaceOne(ctx, filter, data, options.Replace().SetUpsert(true))
+	return err
+}
+
+// UpdateData applies the update to the mongoDB document matching the filter
+// and returns the number of documents matched
+func UpdateData(client *mongo.Client, database string, collection string, filter bson.M, update bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	DbContext.GenMutex.Lock()
+	defer DbContext.GenMutex.Unlock()
+
+	coll := client.Database(database).Collection(collection)
+	result, err := coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.MatchedCount, nil
+}
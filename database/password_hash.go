@@ -0,0 +1,126 @@
+package database
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/safe"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	passwordSaltSize = 16
+	passwordHashSize = 32
+)
+
+// ErrInvalidPasswordHash is returned for a stored hash that can not be parsed
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// PasswordHash is the stored form of a service password, an Argon2id hash of
+// the HMAC-SHA256 of the password under the pepper key of the HSM. Without the
+// pepper key a leaked hash can not be attacked offline.
+type PasswordHash struct {
+	Hash     string `bson:"hash"`      // PHC string with the Argon2id parameters and salt
+	PepperId int32  `bson:"pepper_id"` // version of PASSWORD_PEPPER_HMAC
+	// PepperCheck is the hex check value of the pepper version, empty for the
+	// hashes created before it, see pkcs11mgr.PepperCheckValue
+	PepperCheck string `bson:"pepper_check,omitempty"`
+}
+
+// NewServicePassword generates a service password and returns it with its
+// hash, the password is never stored
+func NewServicePassword(ks pkcs11mgr.KeyStore) (PasswordHash, string, error) {
+	password := generateSecurePassword(16)
+	hash, err := HashPassword(ks, password)
+	if err != nil {
+		return PasswordHash{}, "", err
+	}
+	return hash, password, nil
+}
+
+// HashPassword hashes a password with the newest pepper key and the configured
+// Argon2id parameters
+func HashPassword(ks pkcs11mgr.KeyStore, password string) (PasswordHash, error) {
+	peppered, pepperID, err := pkcs11mgr.PepperPassword(ks, 0, []byte(password))
+	if err != nil {
+		return PasswordHash{}, err
+	}
+
+	salt := make([]byte, passwordSaltSize)
+	if err := safe.RandRead(salt); err != nil {
+		return PasswordHash{}, err
+	}
+
+	check, err := pkcs11mgr.PepperCheckValue(ks, pepperID)
+	if err != nil {
+		return PasswordHash{}, err
+	}
+
+	params := factory.SsmConfig.GetPasswordHash()
+	key := argon2.IDKey(peppered, salt, params.Time, params.Memory, params.Threads, passwordHashSize)
+	return PasswordHash{
+		Hash: fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Time, params.Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)),
+		PepperId:    pepperID,
+		PepperCheck: hex.EncodeToString(check),
+	}, nil
+}
+
+// VerifyPassword reports whether password matches the hash, the hashes are
+// compared in constant time. It returns pkcs11mgr.ErrPepperMismatch when the
+// pepper key is not the one the hash was created with.
+func VerifyPassword(ks pkcs11mgr.KeyStore, hash PasswordHash, password string) (bool, error) {
+	var memory, time uint32
+	var threads uint8
+	parts := strings.Split(hash.Hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false, ErrInvalidPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time == 0 || threads == 0 {
+		return false, ErrInvalidPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrInvalidPasswordHash
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 {
+		return false, ErrInvalidPasswordHash
+	}
+
+	if hash.PepperCheck != "" {
+		check, err := pkcs11mgr.PepperCheckValue(ks, hash.PepperId)
+		if err != nil {
+			return false, err
+		}
+		if hex.EncodeToString(check) != hash.PepperCheck {
+			return false, pkcs11mgr.ErrPepperMismatch
+		}
+	}
+	peppered, _, err := pkcs11mgr.PepperPassword(ks, hash.PepperId, []byte(password))
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey(peppered, salt, time, memory, threads, uint32(len(expected)))
+	return subtle.ConstantTimeCompare(key, expected) == 1, nil
+}
+
+// VerifyDummyPassword does the work of VerifyPassword for a login without a
+// password hash to check, an unknown service ID or an account without one, so
+// that its answer takes as long as the one to a wrong password
+func VerifyDummyPassword(ks pkcs11mgr.KeyStore, password string) {
+	_, _ = pkcs11mgr.PepperCheckValue(ks, 0)
+	peppered, _, err := pkcs11mgr.PepperPassword(ks, 0, []byte(password))
+	if err != nil {
+		peppered = []byte(password)
+	}
+	params := factory.SsmConfig.GetPasswordHash()
+	key := argon2.IDKey(peppered, make([]byte, passwordSaltSize), params.Time, params.Memory, params.Threads, passwordHashSize)
+	subtle.ConstantTimeCompare(key, make([]byte, passwordHashSize))
+}
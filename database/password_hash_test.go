@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+// TestPasswordPepperReplicated checks that the pepper key follows the hashes
+// to the replica and that a different pepper key is detected
+func TestPasswordPepperReplicated(t *testing.T) {
+	factory.SsmConfig.Configuration = &factory.Configuration{PasswordHash: &factory.PasswordHash{Time: 1, Memory: 1024, Threads: 1}}
+	pkcs11mgr.SetKeyExport(true)
+	t.Cleanup(func() { pkcs11mgr.SetKeyExport(false) })
+
+	primary, replica, other := pkcs11mgr.NewMemoryProvider(), pkcs11mgr.NewMemoryProvider(), pkcs11mgr.NewMemoryProvider()
+	t.Cleanup(primary.Finalize)
+	t.Cleanup(replica.Finalize)
+	t.Cleanup(other.Finalize)
+	keyStore := func(p pkcs11mgr.CryptoProvider) pkcs11mgr.KeyStore {
+		ks, err := p.GetKeyStore(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { p.ReleaseKeyStore(ks) })
+		if err := pkcs11mgr.InitPasswordPepperKey(ks); err != nil {
+			t.Fatal(err)
+		}
+		return ks
+	}
+
+	hash, password, err := NewServicePassword(keyStore(primary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash.PepperCheck == "" {
+		t.Fatal("the hash has no pepper check value")
+	}
+	if _, err := pkcs11mgr.NewReplicator(primary, replica, 16).FullSync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	replicaKeyStore, err := replica.GetKeyStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.ReleaseKeyStore(replicaKeyStore)
+	if ok, err := VerifyPassword(replicaKeyStore, hash, password); !ok || err != nil {
+		t.Fatalf("VerifyPassword on the replica = %v, %v", ok, err)
+	}
+
+	// a token that created its own pepper key under the same id
+	if _, err := VerifyPassword(keyStore(other), hash, password); !errors.Is(err, pkcs11mgr.ErrPepperMismatch) {
+		t.Fatalf("VerifyPassword with another pepper key = %v", err)
+	}
+}
+
+func passwordKeyStore(t *testing.T) pkcs11mgr.KeyStore {
+	t.Helper()
+	factory.SsmConfig.Configuration = &factory.Configuration{PasswordHash: &factory.PasswordHash{Time: 1, Memory: 1024, Threads: 1}}
+	p := pkcs11mgr.NewMemoryProvider()
+	t.Cleanup(p.Finalize)
+	ks, err := p.GetKeyStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.ReleaseKeyStore(ks) })
+	return ks
+}
+
+func TestHashPassword(t *testing.T) {
+	ks := passwordKeyStore(t)
+	if _, err := HashPassword(ks, "password"); err == nil {
+		t.Fatal("hashed a password without a pepper key")
+	}
+	if err := pkcs11mgr.InitPasswordPepperKey(ks); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := HashPassword(ks, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash.Hash, "$argon2id$v=19$m=1024,t=1,p=1$") || strings.Contains(hash.Hash, "password") {
+		t.Fatalf("unexpected password hash %s", hash.Hash)
+	}
+	if hash.PepperId != 1 || len(hash.PepperCheck) != 2*pkcs11mgr.KCV_LENGTH {
+		t.Fatalf("pepper id %d, check value %q", hash.PepperId, hash.PepperCheck)
+	}
+	again, err := HashPassword(ks, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Hash == hash.Hash {
+		t.Fatal("two hashes of a password share their salt")
+	}
+
+	for _, tc := range []struct {
+		password string
+		valid    bool
+	}{
+		{"password", true},
+		{"Password", false},
+		{"", false},
+	} {
+		if ok, err := VerifyPassword(ks, hash, tc.password); ok != tc.valid || err != nil {
+			t.Fatalf("VerifyPassword(%q) = %v, %v, want %v", tc.password, ok, err, tc.valid)
+		}
+	}
+
+	// the hashes of the older pepper versions still verify after a new one
+	if _, _, err := ks.GenerateMACKey(constants.LABEL_PASSWORD_PEPPER, 2, pkcs11.CKK_GENERIC_SECRET, 256); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyPassword(ks, hash, "password"); !ok || err != nil {
+		t.Fatalf("VerifyPassword after a new pepper version = %v, %v", ok, err)
+	}
+	newer, err := HashPassword(ks, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newer.PepperId != 2 || newer.PepperCheck == hash.PepperCheck {
+		t.Fatalf("new hash of pepper %d with check value %s", newer.PepperId, newer.PepperCheck)
+	}
+}
+
+func TestVerifyPasswordPepperCheck(t *testing.T) {
+	ks := passwordKeyStore(t)
+	if err := pkcs11mgr.InitPasswordPepperKey(ks); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashPassword(ks, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the hashes created before the check values are verified without one
+	unchecked := hash
+	unchecked.PepperCheck = ""
+	if ok, err := VerifyPassword(ks, unchecked, "password"); !ok || err != nil {
+		t.Fatalf("VerifyPassword without a check value = %v, %v", ok, err)
+	}
+
+	tampered := hash
+	tampered.PepperCheck = "000000"
+	if _, err := VerifyPassword(ks, tampered, "password"); !errors.Is(err, pkcs11mgr.ErrPepperMismatch) {
+		t.Fatalf("VerifyPassword with another check value = %v, want %v", err, pkcs11mgr.ErrPepperMismatch)
+	}
+
+	missing := hash
+	missing.PepperId = 7
+	if ok, err := VerifyPassword(ks, missing, "password"); ok || err == nil {
+		t.Fatalf("VerifyPassword with a missing pepper version = %v, %v", ok, err)
+	}
+}
+
+func TestVerifyPasswordInvalidHash(t *testing.T) {
+	ks := passwordKeyStore(t)
+	if err := pkcs11mgr.InitPasswordPepperKey(ks); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashPassword(ks, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(hash.Hash, "$")
+
+	for _, invalid := range []string{
+		"",
+		"password",
+		strings.Replace(hash.Hash, "argon2id", "argon2i", 1),
+		strings.Replace(hash.Hash, "v=19", "v=16", 1),
+		strings.Replace(hash.Hash, "t=1", "t=0", 1),
+		strings.Replace(hash.Hash, "p=1", "p=0", 1),
+		strings.Replace(hash.Hash, "m=1024", "m=x", 1),
+		strings.Join(append(parts[:4:4], "!!", parts[5]), "$"),
+		strings.Join(append(parts[:5:5], ""), "$"),
+		hash.Hash + "$",
+	} {
+		if ok, err := VerifyPassword(ks, PasswordHash{Hash: invalid, PepperId: hash.PepperId}, "password"); ok || !errors.Is(err, ErrInvalidPasswordHash) {
+			t.Fatalf("VerifyPassword(%q) = %v, %v, want %v", invalid, ok, err, ErrInvalidPasswordHash)
+		}
+	}
+}
+
+// TestVerifyDummyPassword checks that the dummy verification works on a token
+// with and without a pepper key
+func TestVerifyDummyPassword(t *testing.T) {
+	ks := passwordKeyStore(t)
+	VerifyDummyPassword(ks, "password")
+	if err := pkcs11mgr.InitPasswordPepperKey(ks); err != nil {
+		t.Fatal(err)
+	}
+	VerifyDummyPassword(ks, "password")
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/networkgcorefullcode/ssm/factory"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrAccountNotFound is returned for an unknown service id
+	ErrAccountNotFound = errors.New("service account not found")
+	// ErrAccountExists is returned when a service id is already taken
+	ErrAccountExists = errors.New("service account already exists")
+)
+
+// AccountStore keeps the service accounts of /login in MongoDB, the account of
+// a service is its UserSecret document
+type AccountStore struct{}
+
+// EnsureAccountIndexes creates the unique index of the service ids
+func EnsureAccountIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := Client.Database(factory.SsmConfig.Configuration.Mongodb.DBName).Collection(CollSecret)
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "service_id", Value: 1}}, Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// decodeUserSecret decodes a document of the secrets collection
+func decodeUserSecret(document bson.M) (UserSecret, error) {
+	user := UserSecret{}
+	bsonBytes, err := bson.Marshal(document)
+	if err != nil {
+		return UserSecret{}, err
+	}
+	if err := bson.Unmarshal(bsonBytes, &user); err != nil {
+		return UserSecret{}, err
+	}
+	return user, nil
+}
+
+// GetAccount returns the account of a service
+func (AccountStore) GetAccount(serviceID string) (UserSecret, error) {
+	document, err := FindOneData(Client, factory.SsmConfig.Configuration.Mongodb.DBName, CollSecret, bson.M{"service_id": serviceID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return UserSecret{}, ErrAccountNotFound
+	}
+	if err != nil {
+		return UserSecret{}, err
+	}
+	return decodeUserSecret(document)
+}
+
+// ListAccounts returns every service account
+func (AccountStore) ListAccounts() ([]UserSecret, error) {
+	documents, err := FindAllData(Client, factory.SsmConfig.Configuration.Mongodb.DBName, CollSecret, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]UserSecret, 0, len(documents))
+	for _, document := range documents {
+		account, err := decodeUserSecret(document)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// CreateAccount stores a new service account
+func (AccountStore) CreateAccount(account UserSecret) error {
+	_, err := InsertData(Client, factory.SsmConfig.Configuration.Mongodb.DBName, CollSecret, account)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAccountExists
+	}
+	return err
+}
+
+// SetPassword replaces the password of a service account
+func (AccountStore) SetPassword(serviceID string, hash PasswordHash, now time.Time) error {
+	return updateAccount(serviceID, bson.M{
+		"$set":   bson.M{"password_hash": hash, "password_changed_at": now},
+		"$unset": bson.M{"encrypted_data": ""},
+	})
+}
+
+// MigratePassword replaces the AES encrypted password of an account by its
+// hash, the password itself is unchanged
+func (AccountStore) MigratePassword(serviceID string, hash PasswordHash) error {
+	return updateAccount(serviceID, bson.M{
+		"$set":   bson.M{"password_hash": hash},
+		"$unset": bson.M{"encrypted_data": ""},
+	})
+}
+
+// DisableAccount disables a service account, its service can no longer log in
+func (AccountStore) DisableAccount(serviceID string, now time.Time) error {
+	return updateAccount(serviceID, bson.M{"$set": bson.M{"disabled": true, "disabled_at": now}})
+}
+
+// RecordLogin keeps the time of the last successful login of a service
+func (AccountStore) RecordLogin(serviceID string, now time.Time) error {
+	return updateAccount(serviceID, bson.M{"$set": bson.M{"last_login_at": now}})
+}
+
+func updateAccount(serviceID string, update bson.M) error {
+	matched, err := UpdateData(Client, factory.SsmConfig.Configuration.Mongodb.DBName, CollSecret, bson.M{"service_id": serviceID}, update)
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		return ErrAccountNotFound
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/networkgcorefullcode/ssm/factory"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrTokenNotFound is returned for an unknown or already used refresh token
+var ErrTokenNotFound = errors.New("token not found")
+
+// RefreshToken is a refresh token of /login, only the SHA-256 of the token
+// is stored. A refresh token is used once, /token/refresh replaces it.
+type RefreshToken struct {
+	TokenHash       string    `bson:"token_hash"`
+	ServiceID       string    `bson:"service_id"`
+	AccessJti       string    `bson:"access_jti"` // jti of the access token issued with it
+	AccessExpiresAt time.Time `bson:"access_expires_at"`
+	CreatedAt       time.Time `bson:"created_at"`
+	ExpiresAt       time.Time `bson:"expires_at"`
+}
+
+// RevokedToken is an access token revoked before it expires, the entry is
+// dropped by MongoDB once the token has expired
+type RevokedToken struct {
+	Jti       string    `bson:"jti"`
+	ServiceID string    `bson:"service_id"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// TokenStore keeps the refresh tokens and the revoked access tokens in MongoDB
+type TokenStore struct{}
+
+// EnsureTokenIndexes creates the unique index of the refresh tokens and the
+// TTL indexes that drop the expired tokens
+func EnsureTokenIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	db := Client.Database(factory.SsmConfig.Configuration.Mongodb.DBName)
+	_, err := db.Collection(CollRefreshTokens).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "access_jti", Value: 1}}},
+		{Keys: bson.D{{Key: "service_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection(CollRevokedTokens).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// SaveRefreshToken stores a new refresh token
+func (TokenStore) SaveRefreshToken(token RefreshToken) error {
+	_, err := InsertData(Client, factory.SsmConfig.Configuration.Mongodb.DBName, CollRefreshTokens, token)
+	return err
+}
+
+// ConsumeRefreshToken removes the refresh token with the hash and returns it,
+// a token can only be consumed once
+func (TokenStore) ConsumeRefreshToken(tokenHash string) (RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := Client.Database(factory.SsmConfig.Configuration.Mongodb.DBName).Collection(CollRefreshTokens)
+	var token RefreshToken
+	err := coll.FindOneAndDelete(ctx, bson.M{"token_hash": tokenHash}).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return RefreshToken{}, ErrTokenNotFound
+	}
+	return token, err
+}
+
+// DeleteRefreshTokens removes the refresh tokens issued with an access token
+func (TokenStore) DeleteRefreshTokens(accessJti string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := Client.Database(factory.SsmConfig.Configuration.Mongodb.DBName).Collection(CollRefreshTokens)
+	_, err := coll.DeleteMany(ctx, bson.M{"access_jti": accessJti})
+	return err
+}
+
+// RevokeToken adds an access token to the revocation list
+func (TokenStore) RevokeToken(token RevokedToken) error {
+	_, err := InsertData(Client, factory.SsmConfig.Configuration.Mongodb.DBName, CollRevokedTokens, token)
+	return err
+}
+
+// RevokeServiceTokens deletes the refresh tokens of a service and revokes the
+// access tokens issued with them, it returns the revoked access tokens
+func (TokenStore) RevokeServiceTokens(serviceID string, now time.Time) ([]RevokedToken, error) {
+	dbName := factory.SsmConfig.Configuration.Mongodb.DBName
+	documents, err := FindAllData(Client, dbName, CollRefreshTokens, bson.M{"service_id": serviceID})
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked []RevokedToken
+	for _, document := range documents {
+		var token RefreshToken
+		bsonBytes, err := bson.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+		if err := bson.Unmarshal(bsonBytes, &token); err != nil {
+			return nil, err
+		}
+		if token.AccessJti == "" || !now.Before(token.AccessExpiresAt) {
+			continue
+		}
+		entry := RevokedToken{Jti: token.AccessJti, ServiceID: serviceID, ExpiresAt: token.AccessExpiresAt}
+		if _, err := InsertData(Client, dbName, CollRevokedTokens, entry); err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, entry)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := Client.Database(dbName).Collection(CollRefreshTokens).DeleteMany(ctx, bson.M{"service_id": serviceID}); err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+// RevokedTokens returns the revoked access tokens that have not expired at now
+func (TokenStore) RevokedTokens(now time.Time) ([]RevokedToken, error) {
+	documents, err := FindAllData(Client, factory.SsmConfig.Configuration.Mongodb.DBName, CollRevokedTokens, bson.M{"expires_at": bson.M{"$gt": now}})
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]RevokedToken, 0, len(documents))
+	for _, document := range documents {
+		var token RevokedToken
+		bsonBytes, err := bson.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+		if err := bson.Unmarshal(bsonBytes, &token); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
@@ -5,6 +5,7 @@
 package factory
 
 import (
+	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/omec-project/util/logger"
 )
 
@@ -32,23 +33,37 @@ const (
 )
 
 type Configuration struct {
-	SsmName         string     `yaml:"ssmName,omitempty"`
-	SsmId           string     `yaml:"ssmId,omitempty"`
-	SocketPath      string     `yaml:"socketPath,omitempty"`
-	PkcsPath        string     `yaml:"pkcsPath,omitempty"`
-	Pin             string     `yaml:"pin,omitempty"`
-	LotsNumber      int        `yaml:"lotsNumber,omitempty"`
-	BindAddr        string     `yaml:"bindAddr,omitempty"`
-	ExposeSwaggerUi *bool      `yaml:"exposeSwaggerUi,omitempty"`
-	IsHttps         *bool      `yaml:"isHttps,omitempty"`
-	CertFile        string     `yaml:"certFile,omitempty"`
-	KeyFile         string     `yaml:"keyFile,omitempty"`
-	CAFile          string     `yaml:"caFile,omitempty"`
-	MaxSessions     int        `yaml:"maxSessions,omitempty"`
-	IsSecure        bool       `yaml:"isSecure,omitempty"`
-	CORS            *CORS      `yaml:"cors,omitempty"`
-	Mongodb         *Mongodb   `yaml:"mongodb"`
-	RateLimit       *RateLimit `yaml:"rateLimit,omitempty"`
+	SsmName         string        `yaml:"ssmName,omitempty"`
+	SsmId           string        `yaml:"ssmId,omitempty"`
+	SocketPath      string        `yaml:"socketPath,omitempty"`
+	CryptoProvider  string        `yaml:"cryptoProvider,omitempty"` // pkcs11 (default) or memory
+	PkcsPath        string        `yaml:"pkcsPath,omitempty"`
+	Pin             string        `yaml:"pin,omitempty"`
+	LotsNumber      int           `yaml:"lotsNumber,omitempty"`
+	TokenLabel      string        `yaml:"tokenLabel,omitempty"`  // takes precedence over lotsNumber
+	TokenSerial     string        `yaml:"tokenSerial,omitempty"` // takes precedence over lotsNumber
+	BindAddr        string        `yaml:"bindAddr,omitempty"`
+	ExposeSwaggerUi *bool         `yaml:"exposeSwaggerUi,omitempty"`
+	IsHttps         *bool         `yaml:"isHttps,omitempty"`
+	CertFile        string        `yaml:"certFile,omitempty"`
+	KeyFile         string        `yaml:"keyFile,omitempty"`
+	CAFile          string        `yaml:"caFile,omitempty"`
+	MaxSessions     int           `yaml:"maxSessions,omitempty"`
+	SessionPool     *SessionPool  `yaml:"sessionPool,omitempty"`
+	Tokens          []Token       `yaml:"tokens,omitempty"`
+	Replica         *Replica      `yaml:"replica,omitempty"`
+	Backup          *Backup       `yaml:"backup,omitempty"`
+	IsSecure        bool          `yaml:"isSecure,omitempty"`
+	CORS            *CORS         `yaml:"cors,omitempty"`
+	Mongodb         *Mongodb      `yaml:"mongodb"`
+	RateLimit       *RateLimit    `yaml:"rateLimit,omitempty"`
+	JWT             *JWT          `yaml:"jwt,omitempty"`
+	PasswordHash    *PasswordHash `yaml:"passwordHash,omitempty"`
+	// TransportPeers are the only peers a TRANSPORT_KEY is sent to
+	TransportPeers []TransportPeer `yaml:"transportPeers,omitempty"`
+	// KeyPolicies are the key usage policies per label family, a configured
+	// family replaces its default policy
+	KeyPolicies map[string]KeyPolicy `yaml:"keyPolicies,omitempty"`
 }
 
 type Mongodb struct {
@@ -64,6 +79,77 @@ type RateLimit struct {
 	CleanupInterval int  `yaml:"cleanupInterval,omitempty"` // en minutos
 }
 
+// Token is a PKCS#11 token that holds some label families, the families not
+// listed by any token are kept on the first token
+type Token struct {
+	Name        string   `yaml:"name"`
+	Slot        int      `yaml:"slot,omitempty"`
+	Label       string   `yaml:"label,omitempty"`  // takes precedence over slot
+	Serial      string   `yaml:"serial,omitempty"` // takes precedence over slot
+	Pin         string   `yaml:"pin,omitempty"`
+	MaxSessions int      `yaml:"maxSessions,omitempty"`
+	Families    []string `yaml:"families,omitempty"`
+}
+
+// Replica is the token the keys of the replicated label families are copied
+// to, typically a second SoftHSM instance kept as a secure backup
+type Replica struct {
+	PkcsPath    string `yaml:"pkcsPath,omitempty"` // defaults to configuration.pkcsPath
+	Slot        int    `yaml:"slot,omitempty"`
+	Label       string `yaml:"label,omitempty"`  // takes precedence over slot
+	Serial      string `yaml:"serial,omitempty"` // takes precedence over slot
+	Pin         string `yaml:"pin,omitempty"`
+	MaxSessions int    `yaml:"maxSessions,omitempty"`
+	QueueSize   int    `yaml:"queueSize,omitempty"` // keys waiting for the incremental sync
+}
+
+// TransportPeer is a peer SSM a TRANSPORT_KEY can be wrapped for, its
+// TRANSPORT_RSA public key is pinned so a caller can not export a transport
+// key under a key of its own
+type TransportPeer struct {
+	Name          string `yaml:"name,omitempty"`
+	PublicKeyFile string `yaml:"publicKeyFile,omitempty"` // PEM encoded TRANSPORT_RSA public key
+}
+
+// Backup configures the backup bundles of the key inventory
+type Backup struct {
+	Enabled    bool `yaml:"enabled,omitempty"`    // keys of the replicated families are created extractable
+	Iterations int  `yaml:"iterations,omitempty"` // PBKDF2 iterations of the password derived bundle key
+}
+
+// KeyPolicy is the key usage policy of a label family. An empty algorithms
+// or roles list allows every algorithm or role, the roles are the role claims
+// of the JWTs and are only checked when the API is secured.
+type KeyPolicy struct {
+	Operations []string `yaml:"operations,omitempty"` // constants.KEY_OPERATION_*
+	Algorithms []int    `yaml:"algorithms,omitempty"` // constants.ALGORITHM_*
+	Roles      []string `yaml:"roles,omitempty"`
+	Deletable  bool     `yaml:"deletable,omitempty"`
+	Updatable  bool     `yaml:"updatable,omitempty"`
+}
+
+// JWT configures the JWTs issued by /login
+type JWT struct {
+	Algorithm        string `yaml:"algorithm,omitempty"`        // RS256 (default), PS256 or ES256
+	GracePeriod      int    `yaml:"gracePeriod,omitempty"`      // in hours, a previous signing key still verifies tokens
+	RotationInterval int    `yaml:"rotationInterval,omitempty"` // in days, 0 disables the scheduled rotation
+	AccessTokenTTL   int    `yaml:"accessTokenTTL,omitempty"`   // in minutes
+	RefreshTokenTTL  int    `yaml:"refreshTokenTTL,omitempty"`  // in hours
+	RevocationSync   int    `yaml:"revocationSync,omitempty"`   // in seconds, how often the revoked tokens are read from MongoDB
+}
+
+// PasswordHash configures the Argon2id hashes of the service passwords
+type PasswordHash struct {
+	Time    uint32 `yaml:"time,omitempty"`    // Argon2id passes over the memory
+	Memory  uint32 `yaml:"memory,omitempty"`  // in KiB
+	Threads uint8  `yaml:"threads,omitempty"` // Argon2id lanes
+}
+
+type SessionPool struct {
+	WaitTimeout         int `yaml:"waitTimeout,omitempty"`         // en segundos
+	HealthCheckInterval int `yaml:"healthCheckInterval,omitempty"` // en segundos
+}
+
 func (c *Config) GetVersion() string {
 	if c.Info != nil && c.Info.Version != "" {
 		return c.Info.Version
@@ -98,3 +184,199 @@ func (c *Config) GetRateLimit() *RateLimit {
 		CleanupInterval: 15,
 	}
 }
+
+// GetSessionPool returns the PKCS#11 session pool configuration with defaults
+func (c *Config) GetSessionPool() *SessionPool {
+	if c.Configuration != nil && c.Configuration.SessionPool != nil {
+		sp := c.Configuration.SessionPool
+
+		// Set defaults if values are not configured
+		if sp.WaitTimeout <= 0 {
+			sp.WaitTimeout = 10 // default: wait up to 10 seconds for a free session
+		}
+		if sp.HealthCheckInterval <= 0 {
+			sp.HealthCheckInterval = 30 // default: validate idle sessions every 30 seconds
+		}
+
+		return sp
+	}
+
+	// Return default configuration if none provided
+	return &SessionPool{
+		WaitTimeout:         10,
+		HealthCheckInterval: 30,
+	}
+}
+
+// GetJWT returns the JWT configuration with defaults
+func (c *Config) GetJWT() *JWT {
+	if c.Configuration != nil && c.Configuration.JWT != nil {
+		j := c.Configuration.JWT
+
+		// Set defaults if values are not configured
+		if j.Algorithm == "" {
+			j.Algorithm = "RS256"
+		}
+		if j.GracePeriod <= 0 {
+			j.GracePeriod = 24
+		}
+		if j.AccessTokenTTL <= 0 {
+			j.AccessTokenTTL = 15
+		}
+		if j.RefreshTokenTTL <= 0 {
+			j.RefreshTokenTTL = 24
+		}
+		if j.RevocationSync <= 0 {
+			j.RevocationSync = 10
+		}
+
+		return j
+	}
+
+	// Return default configuration if none provided
+	return &JWT{
+		Algorithm:       "RS256",
+		GracePeriod:     24,
+		AccessTokenTTL:  15,
+		RefreshTokenTTL: 24,
+		RevocationSync:  10,
+	}
+}
+
+// GetReplica returns the replica token configuration with defaults, nil when
+// no replica is configured
+func (c *Config) GetReplica() *Replica {
+	if c.Configuration == nil || c.Configuration.Replica == nil {
+		return nil
+	}
+	r := c.Configuration.Replica
+
+	// Set defaults if values are not configured
+	if r.PkcsPath == "" {
+		r.PkcsPath = c.Configuration.PkcsPath
+	}
+	if r.QueueSize <= 0 {
+		r.QueueSize = 1024 // default: up to 1024 keys waiting for the replica
+	}
+
+	return r
+}
+
+// GetBackup returns the backup configuration with defaults
+func (c *Config) GetBackup() *Backup {
+	if c.Configuration != nil && c.Configuration.Backup != nil {
+		b := c.Configuration.Backup
+
+		// Set defaults if values are not configured
+		if b.Iterations <= 0 {
+			b.Iterations = 600000 // default: OWASP recommendation for PBKDF2-HMAC-SHA256
+		}
+
+		return b
+	}
+
+	// Return default configuration if none provided
+	return &Backup{
+		Enabled:    false,
+		Iterations: 600000,
+	}
+}
+
+// GetPasswordHash returns the Argon2id parameters of the service passwords
+// with defaults, the parameters are stored with each hash so a change only
+// applies to the passwords set or migrated afterwards
+func (c *Config) GetPasswordHash() *PasswordHash {
+	if c.Configuration != nil && c.Configuration.PasswordHash != nil {
+		p := c.Configuration.PasswordHash
+
+		// Set defaults if values are not configured
+		if p.Time == 0 {
+			p.Time = 3 // default: second recommended option of RFC 9106
+		}
+		if p.Memory == 0 {
+			p.Memory = 64 * 1024
+		}
+		if p.Threads == 0 {
+			p.Threads = 4
+		}
+
+		return p
+	}
+
+	// Return default configuration if none provided
+	return &PasswordHash{
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+	}
+}
+
+// DefaultKeyPolicies are the key usage policies of the label families that
+// are not configured. The internal and signing keys are only used by SSM
+// itself and the private SUCI keys only derive the SUPI of a SUCI. The udm
+// role is listed where it derives authentication vectors, SUCIs or checks
+// MACs, the other operations are refused to it before the policy is checked.
+var DefaultKeyPolicies = map[string]KeyPolicy{
+	constants.LABEL_FAMILY_K4: {
+		Operations: []string{
+			constants.KEY_OPERATION_STORE, constants.KEY_OPERATION_READ,
+			constants.KEY_OPERATION_ENCRYPT, constants.KEY_OPERATION_DECRYPT,
+			constants.KEY_OPERATION_ROTATE, constants.KEY_OPERATION_WRAP, constants.KEY_OPERATION_UNWRAP,
+			constants.KEY_OPERATION_DERIVE,
+		},
+		Algorithms: []int{
+			constants.ALGORITHM_AES256, constants.ALGORITHM_AES128, constants.ALGORITHM_DES, constants.ALGORITHM_DES3,
+			constants.ALGORITHM_AES256_OurUsers, constants.ALGORITHM_AES128_OurUsers,
+			constants.ALGORITHM_DES_OurUsers, constants.ALGORITHM_DES3_OurUsers,
+		},
+		Roles:     []string{constants.ROLE_WEBCONSOLE, constants.ROLE_UDM},
+		Deletable: true,
+		Updatable: true,
+	},
+	constants.LABEL_FAMILY_ENCRYPTION: {
+		Operations: []string{
+			constants.KEY_OPERATION_GENERATE, constants.KEY_OPERATION_READ,
+			constants.KEY_OPERATION_ENCRYPT, constants.KEY_OPERATION_DECRYPT,
+			constants.KEY_OPERATION_WRAP, constants.KEY_OPERATION_UNWRAP, constants.KEY_OPERATION_DERIVE,
+		},
+		Algorithms: []int{
+			constants.ALGORITHM_AES256_OurUsers, constants.ALGORITHM_AES128_OurUsers,
+			constants.ALGORITHM_DES_OurUsers, constants.ALGORITHM_DES3_OurUsers,
+			constants.ALGORITHM_AES256_GCM,
+		},
+		Roles:     []string{constants.ROLE_WEBCONSOLE, constants.ROLE_UDM},
+		Deletable: true,
+	},
+	constants.LABEL_FAMILY_TRANSPORT: {
+		Operations: []string{constants.KEY_OPERATION_GENERATE, constants.KEY_OPERATION_READ, constants.KEY_OPERATION_WRAP, constants.KEY_OPERATION_UNWRAP},
+		Roles:      []string{constants.ROLE_WEBCONSOLE},
+	},
+	constants.LABEL_FAMILY_SUCI: {
+		Operations: []string{constants.KEY_OPERATION_GENERATE, constants.KEY_OPERATION_STORE, constants.KEY_OPERATION_READ, constants.KEY_OPERATION_DERIVE},
+		Roles:      []string{constants.ROLE_WEBCONSOLE, constants.ROLE_UDM},
+	},
+	constants.LABEL_FAMILY_APPLICATION: {
+		Operations: []string{
+			constants.KEY_OPERATION_GENERATE, constants.KEY_OPERATION_READ,
+			constants.KEY_OPERATION_SIGN, constants.KEY_OPERATION_VERIFY, constants.KEY_OPERATION_MAC,
+		},
+		Roles: []string{constants.ROLE_WEBCONSOLE, constants.ROLE_UDM},
+	},
+	constants.LABEL_FAMILY_INTERNAL: {},
+	constants.LABEL_FAMILY_SIGNING:  {},
+}
+
+// GetKeyPolicies returns the key usage policies of every label family, the
+// configured families replace their default policy
+func (c *Config) GetKeyPolicies() map[string]KeyPolicy {
+	policies := make(map[string]KeyPolicy, len(DefaultKeyPolicies))
+	for family, policy := range DefaultKeyPolicies {
+		policies[family] = policy
+	}
+	if c.Configuration != nil {
+		for family, policy := range c.Configuration.KeyPolicies {
+			policies[family] = policy
+		}
+	}
+	return policies
+}
@@ -37,6 +37,11 @@ func InitConfigFactory(f string) error {
 		SsmConfig.Configuration.BindAddr = SSM_DEFAULT_BIND_ADDR
 		logger.CfgLog.Infof("bindAddr not set in configuration file. Using %s", SsmConfig.Configuration.BindAddr)
 	}
+	if SsmConfig.Configuration.CryptoProvider == "" {
+		SsmConfig.Configuration.CryptoProvider = "pkcs11"
+		logger.CfgLog.Infof("cryptoProvider not set in configuration file. Using %s", SsmConfig.Configuration.CryptoProvider)
+	}
+
 	if SsmConfig.Configuration.PkcsPath == "" {
 		SsmConfig.Configuration.PkcsPath = "/usr/local/lib/softhsm/libsofthsm2.so"
 		logger.CfgLog.Infof("pkcsPath not set in configuration file. Using %s", SsmConfig.Configuration.PkcsPath)
@@ -52,6 +57,18 @@ func InitConfigFactory(f string) error {
 		logger.CfgLog.Infof("maxSessions not set in configuration file. Using default value: %d", SsmConfig.Configuration.MaxSessions)
 	}
 
+	for i := range SsmConfig.Configuration.Tokens {
+		token := &SsmConfig.Configuration.Tokens[i]
+		if token.Pin == "" {
+			token.Pin = SsmConfig.Configuration.Pin
+			logger.CfgLog.Infof("pin not set for token %s. Using the configuration pin", token.Name)
+		}
+		if token.MaxSessions == 0 {
+			token.MaxSessions = SsmConfig.Configuration.MaxSessions
+			logger.CfgLog.Infof("maxSessions not set for token %s. Using default value: %d", token.Name, token.MaxSessions)
+		}
+	}
+
 	// Check CORS configs and set default if not present
 	initializeCORSConfig()
 
@@ -1,9 +1,141 @@
 package handlers
 
-import "github.com/networkgcorefullcode/ssm/pkcs11mgr"
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
 
-var mgr *pkcs11mgr.Manager
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/pkcs11"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
 
-func SetPKCS11Manager(manager *pkcs11mgr.Manager) {
-	mgr = manager
+var provider pkcs11mgr.CryptoProvider
+
+func SetCryptoProvider(p pkcs11mgr.CryptoProvider) {
+	provider = p
+}
+
+// getKeyStore takes a key store from the crypto provider, on failure it writes the problem details and returns false
+func getKeyStore(c *gin.Context) (pkcs11mgr.KeyStore, bool) {
+	ks, err := provider.GetKeyStore(c.Request.Context())
+	if err != nil {
+		logger.AppLog.Errorf("Failed to get key store: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "The crypto backend is not available", ErrorCodeInternalError, http.StatusServiceUnavailable, c.Request.URL.Path)
+		return nil, false
+	}
+	return ks, true
+}
+
+// keyCheckValues returns the KCV and the AES-CMAC KCV of a key in hexadecimal,
+// they are empty when the token cannot compute them
+func keyCheckValues(s pkcs11mgr.KeyStore, handle pkcs11.ObjectHandle) (string, string) {
+	values, err := pkcs11mgr.GetKeyCheckValues(s, handle)
+	if err != nil {
+		logger.AppLog.Warnf("No key check values for handle %d: %v", handle, err)
+	}
+	return hex.EncodeToString(values.KCV), hex.EncodeToString(values.CMAC)
+}
+
+// dataKeyInfo describes a key for the key listings
+func dataKeyInfo(s pkcs11mgr.KeyStore, handle pkcs11.ObjectHandle) (models.DataKeyInfo, error) {
+	metadata, err := s.GetKeyMetadata(handle)
+	if err != nil {
+		return models.DataKeyInfo{}, err
+	}
+	info := models.DataKeyInfo{
+		Handle:      int32(metadata.Handle),
+		Id:          metadata.Id,
+		SizeBits:    int32(metadata.SizeBits),
+		KeyType:     metadata.KeyType,
+		Encrypt:     metadata.Encrypt,
+		Decrypt:     metadata.Decrypt,
+		Wrap:        metadata.Wrap,
+		Unwrap:      metadata.Unwrap,
+		Extractable: metadata.Extractable,
+		Sensitive:   metadata.Sensitive,
+		StartDate:   formatKeyDate(metadata.Validity.Start),
+		EndDate:     formatKeyDate(metadata.Validity.End),
+	}
+	if !metadata.CreatedAt.IsZero() {
+		info.CreatedAt = metadata.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	info.Kcv, info.KcvCmac = keyCheckValues(s, handle)
+	return info, nil
+}
+
+func formatKeyDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(time.DateOnly)
+}
+
+// parseKeyValidity reads the validity dates of a generate or store request, on
+// failure it writes the problem details and returns false
+func parseKeyValidity(c *gin.Context, startDate, endDate string) (pkcs11mgr.KeyValidity, bool) {
+	var validity pkcs11mgr.KeyValidity
+	for _, date := range []struct {
+		name  string
+		value string
+		out   *time.Time
+	}{{"start_date", startDate, &validity.Start}, {"end_date", endDate, &validity.End}} {
+		if date.value == "" {
+			continue
+		}
+		parsed, err := time.ParseInLocation(time.DateOnly, date.value, time.UTC)
+		if err != nil {
+			sendProblemDetails(c, ErrorTitleValidationError, date.name+" must be a date in the YYYY-MM-DD format", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+			return validity, false
+		}
+		*date.out = parsed
+	}
+	if !validity.Start.IsZero() && !validity.End.IsZero() && validity.End.Before(validity.Start) {
+		sendProblemDetails(c, ErrorTitleValidationError, "end_date can not be before start_date", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return validity, false
+	}
+	return validity, true
+}
+
+// setKeyMetadata records the creation time and the validity of a new key. A
+// key whose validity could not be set is destroyed so that it is never used
+// outside of the requested period, on failure it writes the problem details
+// and returns false.
+func setKeyMetadata(c *gin.Context, s pkcs11mgr.KeyStore, handle pkcs11.ObjectHandle, label string, id int32, validity pkcs11mgr.KeyValidity) bool {
+	err := s.SetKeyMetadata(handle, time.Now().UTC(), validity)
+	if err == nil {
+		return true
+	}
+	if validity == (pkcs11mgr.KeyValidity{}) {
+		logger.AppLog.Warnf("No creation record for key %s id %d: %v", label, id, err)
+		return true
+	}
+	logger.AppLog.Errorf("Failed to set the validity of key %s id %d: %v", label, id, err)
+	if err := s.DeleteKey(label, id); err != nil {
+		logger.AppLog.Errorf("Failed to destroy key %s id %d: %v", label, id, err)
+	}
+	sendProblemDetails(c, ErrorTitleInternalServerError, "Error setting the validity period of the key", "KEY_METADATA_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+	return false
+}
+
+// keyValidityCode maps the errors of KeyValidity.Check to their error code
+func keyValidityCode(err error) string {
+	if errors.Is(err, pkcs11mgr.ErrKeyNotYetValid) {
+		return "KEY_NOT_YET_VALID"
+	}
+	return "KEY_EXPIRED"
+}
+
+// checkKeyValidity checks that a key may encrypt today, on failure it writes
+// the problem details and returns false
+func checkKeyValidity(c *gin.Context, validity pkcs11mgr.KeyValidity) bool {
+	if err := validity.Check(time.Now().UTC()); err != nil {
+		logger.AppLog.Warnf("Key used outside of its validity period: %v", err)
+		sendProblemDetails(c, ErrorTitleForbidden, err.Error(), keyValidityCode(err), http.StatusForbidden, c.Request.URL.Path)
+		return false
+	}
+	return true
 }
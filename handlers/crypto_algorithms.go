@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/safe"
+)
+
+// gcmTagLen is the size of the AES-GCM authentication tag (128 bits)
+const gcmTagLen = 16
+
+var errUnsupportedAlgorithm = errors.New("unsupported encryption algorithm")
+
+// cbcMechanisms returns the padded and unpadded CBC mechanisms, the ECB
+// mechanism and the block size of an encryption algorithm
+func cbcMechanisms(algorithm int32) (pad, cbc, ecb uint, blockSize int, err error) {
+	switch algorithm {
+	case constants.ALGORITHM_AES128, constants.ALGORITHM_AES256, constants.ALGORITHM_AES128_OurUsers, constants.ALGORITHM_AES256_OurUsers:
+		return pkcs11.CKM_AES_CBC_PAD, pkcs11.CKM_AES_CBC, pkcs11.CKM_AES_ECB, 16, nil
+	case constants.ALGORITHM_DES, constants.ALGORITHM_DES_OurUsers:
+		return pkcs11.CKM_DES_CBC_PAD, pkcs11.CKM_DES_CBC, pkcs11.CKM_DES_ECB, 8, nil
+	case constants.ALGORITHM_DES3, constants.ALGORITHM_DES3_OurUsers:
+		return pkcs11.CKM_DES3_CBC_PAD, pkcs11.CKM_DES3_CBC, pkcs11.CKM_DES3_ECB, 8, nil
+	default:
+		return 0, 0, 0, 0, fmt.Errorf("%w: %d", errUnsupportedAlgorithm, algorithm)
+	}
+}
+
+// decryptWithAlgorithm decrypts a ciphertext the same way /crypto/decrypt and
+// /crypto/decrypt-aes-gcm do. The caller owns the returned plaintext and must
+// zero it.
+func decryptWithAlgorithm(s pkcs11mgr.KeyStore, handle pkcs11.ObjectHandle, algorithm int32, cipher, iv, tag, aad []byte) ([]byte, error) {
+	if algorithm == constants.ALGORITHM_AES256_GCM {
+		if len(iv) == 0 {
+			return nil, errors.New("IV is required for AES-GCM")
+		}
+		if len(tag) != gcmTagLen {
+			return nil, fmt.Errorf("authentication tag must be %d bytes", gcmTagLen)
+		}
+		ciphertextWithTag := make([]byte, 0, len(cipher)+len(tag))
+		ciphertextWithTag = append(append(ciphertextWithTag, cipher...), tag...)
+		return s.DecryptKeyAesGCM(handle, iv, ciphertextWithTag, aad)
+	}
+
+	pad, cbc, ecb, blockSize, err := cbcMechanisms(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	switch len(iv) {
+	case 0:
+		return s.DecryptKey(handle, nil, cipher, ecb)
+	case blockSize:
+		plain, err := s.DecryptKey(handle, iv, cipher, pad)
+		if err != nil {
+			plain, err = s.DecryptKey(handle, iv, cipher, cbc)
+		}
+		return plain, err
+	default:
+		return nil, fmt.Errorf("IV must be %d bytes, got %d", blockSize, len(iv))
+	}
+}
+
+// encryptWithAlgorithm encrypts a plaintext with a fresh IV the same way
+// /crypto/encrypt and /crypto/encrypt-aes-gcm do. The tag is only set for
+// AES-GCM.
+func encryptWithAlgorithm(s pkcs11mgr.KeyStore, handle pkcs11.ObjectHandle, algorithm int32, plain, aad []byte) (cipher, iv, tag []byte, err error) {
+	if algorithm == constants.ALGORITHM_AES256_GCM {
+		iv = make([]byte, 12) // 12 bytes (96 bits) is recommended for GCM
+		if err := safe.RandRead(iv); err != nil {
+			return nil, nil, nil, err
+		}
+		ciphertextWithTag, err := s.EncryptKeyAesGCM(handle, iv, plain, aad)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(ciphertextWithTag) < gcmTagLen {
+			return nil, nil, nil, fmt.Errorf("invalid AES-GCM output length %d", len(ciphertextWithTag))
+		}
+		split := len(ciphertextWithTag) - gcmTagLen
+		return ciphertextWithTag[:split], iv, ciphertextWithTag[split:], nil
+	}
+
+	pad, cbc, _, blockSize, err := cbcMechanisms(algorithm)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	iv = make([]byte, blockSize)
+	if err := safe.RandRead(iv); err != nil {
+		return nil, nil, nil, err
+	}
+	cipher, err = s.EncryptKey(handle, iv, plain, pad)
+	if err != nil {
+		cipher, err = s.EncryptKey(handle, iv, plain, cbc)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cipher, iv, nil, nil
+}
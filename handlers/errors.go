@@ -15,6 +15,8 @@ const (
 	ErrorTitleNotFound            = "Not Found"
 	ErrorTitleUnauthorized        = "Unauthorized"
 	ErrorTitleForbidden           = "Forbidden"
+	ErrorTitleConflict            = "Conflict"
+	ErrorTitleNotImplemented      = "Not Implemented"
 )
 
 // Error details
@@ -45,6 +47,10 @@ const (
 	ErrorDetailCiphertextRequired   = "Ciphertext is required"
 	ErrorDetailIVRequired           = "IV is required"
 	ErrorDetailTagRequired          = "Authentication tag is required"
+	ErrorDetailDataRequired         = "Data is required"
+	ErrorDetailSignatureRequired    = "Signature is required"
+	ErrorDetailKeyAlreadyExists     = "A key with the specified label already exists in the HSM"
+	ErrorDetailReservedKeyLabel     = "The key label is reserved for SSM keys"
 )
 
 // Error types/codes
@@ -68,4 +74,8 @@ const (
 	ErrorCodeUnauthorized         = "UNAUTHORIZED"
 	ErrorCodeForbidden            = "FORBIDDEN"
 	ErrorCodeInternalError        = "INTERNAL_ERROR"
+	ErrorCodeKeyAlreadyExists     = "KEY_ALREADY_EXISTS"
+	ErrorCodeKeyPolicyDenied      = "KEY_POLICY_DENIED"
+	ErrorCodeAccountExists        = "ACCOUNT_ALREADY_EXISTS"
+	ErrorCodeMechanismUnsupported = "MECHANISM_NOT_SUPPORTED"
 )
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/awnumar/memguard"
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/pkcs11"
+	"github.com/networkgcorefullcode/ssm/aka"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/safe"
+)
+
+// errInvalidSecretHex is returned by decryptSecret when a field is not valid hex
+var errInvalidSecretHex = errors.New("invalid hex in encrypted secret")
+
+// decryptSecret decrypts a subscriber secret into protected memory, the caller
+// must destroy the returned buffer
+func decryptSecret(s pkcs11mgr.KeyStore, handle pkcs11.ObjectHandle, algorithm int32, secret models.EncryptedSecret) (*memguard.LockedBuffer, error) {
+	var fields [4][]byte
+	for i, value := range []string{secret.Cipher, secret.Iv, secret.Tag, secret.Aad} {
+		decoded, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, errInvalidSecretHex
+		}
+		fields[i] = decoded
+	}
+
+	plain, err := decryptWithAlgorithm(s, handle, algorithm, fields[0], fields[1], fields[2], fields[3])
+	if err != nil {
+		safe.Zero(plain)
+		return nil, err
+	}
+	buf := memguard.NewBufferFromBytes(plain)
+	safe.Zero(plain)
+	return buf, nil
+}
+
+// decodeFixedHex decodes a hex field that must have the given size in bytes
+func decodeFixedHex(name, value string, size int) ([]byte, error) {
+	b, err := hex.DecodeString(value)
+	if err != nil || len(b) != size {
+		return nil, fmt.Errorf("%s must be %d bytes in hexadecimal", name, size)
+	}
+	return b, nil
+}
+
+// subscriberMilenage checks the key usage policy of the K4 key, decrypts K and
+// OPc with it and prepares Milenage. K and OPc only exist in protected memory
+// until Milenage copied them, the caller must destroy the result. On failure
+// it writes the problem details and returns false.
+func subscriberMilenage(c *gin.Context, s pkcs11mgr.KeyStore, keyLabel string, id, algorithm int32, k, opc models.EncryptedSecret) (*aka.Milenage, bool) {
+	if !authorizeKeyOperation(c, keyLabel, constants.KEY_OPERATION_DERIVE, int(algorithm)) {
+		return nil, false
+	}
+
+	keyHandle, err := s.FindKey(keyLabel, id)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to find key by label '%s': %v", keyLabel, err)
+		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return nil, false
+	}
+
+	var secrets [2]*memguard.LockedBuffer
+	for i, secret := range []models.EncryptedSecret{k, opc} {
+		secrets[i], err = decryptSecret(s, keyHandle, algorithm, secret)
+		if err != nil {
+			logger.AppLog.Errorf("Failed to decrypt subscriber secret: %v", err)
+			switch {
+			case errors.Is(err, errInvalidSecretHex):
+				sendProblemDetails(c, ErrorTitleBadRequest, "The encrypted K or OPc hex data is not valid", ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+			case errors.Is(err, errUnsupportedAlgorithm):
+				sendProblemDetails(c, ErrorTitleBadRequest, "Unsupported decryption algorithm", "UNSUPPORTED_ALGORITHM", http.StatusBadRequest, c.Request.URL.Path)
+			default:
+				sendProblemDetails(c, ErrorTitleDecryptionFailed, ErrorDetailDecryptionError, ErrorCodeDecryptionError, http.StatusInternalServerError, c.Request.URL.Path)
+			}
+			return nil, false
+		}
+		defer secrets[i].Destroy()
+	}
+
+	milenage, err := aka.NewMilenage(secrets[0].Bytes(), secrets[1].Bytes())
+	if err != nil {
+		logger.AppLog.Errorf("Invalid subscriber keys: %v", err)
+		sendProblemDetails(c, ErrorTitleValidationError, "The decrypted K and OPc must be 16 bytes", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return nil, false
+	}
+	return milenage, true
+}
+
+// HandleAuthVector handles 5G-AKA authentication vector requests
+// @Summary Generate 5G-AKA authentication vector
+// @Description Decrypts the subscriber K and OPc with a K4 key and runs Milenage inside SSM, K and OPc are never returned. TUAK is not supported.
+// @Tags Encryption
+// @Accept json
+// @Produce json
+// @Param request body models.AuthVectorRequest true "Encrypted subscriber keys and AKA inputs"
+// @Success 200 {object} models.AuthVectorResponse "Authentication vector generated successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/auth-vector [post]
+func HandleAuthVector(c *gin.Context) {
+	logger.AppLog.Info("Processing authentication vector request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.AuthVectorRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	// Validate required fields
+	if req.KeyLabel == "" {
+		logger.AppLog.Error("Key label is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailKeyLabelRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.K.Cipher == "" || req.Opc.Cipher == "" {
+		logger.AppLog.Error("Encrypted K and OPc are required")
+		sendProblemDetails(c, ErrorTitleValidationError, "The encrypted K and OPc are required", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.ServingNetworkName == "" {
+		logger.AppLog.Error("Serving network name is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, "The serving network name is required", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	sqn, err := decodeFixedHex("SQN", req.Sqn, aka.SqnSize)
+	if err != nil {
+		logger.AppLog.Errorf("Invalid authentication input: %v", err)
+		sendProblemDetails(c, ErrorTitleValidationError, err.Error(), ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	amf, err := decodeFixedHex("AMF", req.Amf, aka.AmfSize)
+	if err != nil {
+		logger.AppLog.Errorf("Invalid authentication input: %v", err)
+		sendProblemDetails(c, ErrorTitleValidationError, err.Error(), ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	rand := make([]byte, aka.RandSize)
+	if req.Rand == "" {
+		if err := safe.RandRead(rand); err != nil {
+			logger.AppLog.Errorf("Failed to generate RAND: %v", err)
+			sendProblemDetails(c, ErrorTitleInternalServerError, "Error generating RAND", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+			return
+		}
+	} else if rand, err = decodeFixedHex("RAND", req.Rand, aka.RandSize); err != nil {
+		logger.AppLog.Errorf("Invalid authentication input: %v", err)
+		sendProblemDetails(c, ErrorTitleValidationError, err.Error(), ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	milenage, ok := subscriberMilenage(c, s, req.KeyLabel, req.Id, req.EncryptionAlgorithm, req.K, req.Opc)
+	if !ok {
+		return
+	}
+	defer milenage.Destroy()
+
+	vector, err := aka.GenerateVector5G(milenage, rand, sqn, amf, req.ServingNetworkName)
+	if err != nil {
+		logger.AppLog.Errorf("Authentication vector generation failed: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error generating the authentication vector", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("5G-AKA vector generated with K4 key '%s' (id %d) for %s", req.KeyLabel, req.Id, req.ServingNetworkName)
+
+	resp := models.AuthVectorResponse{
+		Rand:     hex.EncodeToString(vector.Rand),
+		Autn:     hex.EncodeToString(vector.Autn),
+		XresStar: hex.EncodeToString(vector.XresStar),
+		Kausf:    hex.EncodeToString(vector.Kausf),
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleAuthResync handles the resynchronisation of the SQN of a subscriber
+// @Summary Resynchronise the SQN of a subscriber
+// @Description Decrypts the subscriber K and OPc with a K4 key, checks the MAC-S of the AUTS the UE returned with a synchronisation failure and returns SQN_MS. The UDM generates the next vector from it.
+// @Tags Encryption
+// @Accept json
+// @Produce json
+// @Param request body models.AuthResyncRequest true "Encrypted subscriber keys, RAND and AUTS"
+// @Success 200 {object} models.AuthResyncResponse "AUTS checked"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/auth-resync [post]
+func HandleAuthResync(c *gin.Context) {
+	logger.AppLog.Info("Processing SQN resynchronisation request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.AuthResyncRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.KeyLabel == "" {
+		logger.AppLog.Error("Key label is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailKeyLabelRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.K.Cipher == "" || req.Opc.Cipher == "" {
+		logger.AppLog.Error("Encrypted K and OPc are required")
+		sendProblemDetails(c, ErrorTitleValidationError, "The encrypted K and OPc are required", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	rand, err := decodeFixedHex("RAND", req.Rand, aka.RandSize)
+	if err != nil {
+		logger.AppLog.Errorf("Invalid resynchronisation input: %v", err)
+		sendProblemDetails(c, ErrorTitleValidationError, err.Error(), ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	auts, err := decodeFixedHex("AUTS", req.Auts, aka.AutsSize)
+	if err != nil {
+		logger.AppLog.Errorf("Invalid resynchronisation input: %v", err)
+		sendProblemDetails(c, ErrorTitleValidationError, err.Error(), ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	milenage, ok := subscriberMilenage(c, s, req.KeyLabel, req.Id, req.EncryptionAlgorithm, req.K, req.Opc)
+	if !ok {
+		return
+	}
+	defer milenage.Destroy()
+
+	resp := models.AuthResyncResponse{}
+	sqn, err := aka.ResyncSqn(milenage, rand, auts)
+	switch {
+	case errors.Is(err, aka.ErrAutsMac):
+		logger.AppLog.Warnf("AUTS checked with K4 key '%s' (id %d) has an invalid MAC-S", req.KeyLabel, req.Id)
+	case err != nil:
+		logger.AppLog.Errorf("SQN resynchronisation failed: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error checking the AUTS", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	default:
+		logger.AppLog.Infof("SQN resynchronised with K4 key '%s' (id %d)", req.KeyLabel, req.Id)
+		resp.Valid = true
+		resp.Sqn = hex.EncodeToString(sqn)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
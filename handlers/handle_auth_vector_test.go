@@ -0,0 +1,97 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/handlers"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+// subscriberSecrets returns a function that encrypts a subscriber secret with
+// the KEY_ENCRYPTION_AES256 key of id 1
+func subscriberSecrets(f *ssmtest.Fixture) func(plain string) models.EncryptedSecret {
+	return func(plain string) models.EncryptedSecret {
+		var encResp models.EncryptResponse
+		f.DoJSON("/crypto/encrypt", models.EncryptRequest{
+			KeyLabel:            constants.LABEL_ENCRYPTION_KEY_AES256,
+			Plain:               plain,
+			EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers,
+		}, http.StatusCreated, &encResp)
+		return models.EncryptedSecret{Cipher: encResp.Cipher, Iv: encResp.Iv}
+	}
+}
+
+func TestAuthVectorMilenageTestSet1(t *testing.T) {
+	f := ssmtest.New(t)
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, nil)
+	encrypt := subscriberSecrets(f)
+
+	// TS 35.208 test set 1
+	var resp models.AuthVectorResponse
+	f.DoJSON("/crypto/auth-vector", models.AuthVectorRequest{
+		KeyLabel:            constants.LABEL_ENCRYPTION_KEY_AES256,
+		Id:                  1,
+		EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers,
+		K:                   encrypt("465b5ce8b199b49faa5f0a2ee238a6bc"),
+		Opc:                 encrypt("cd63cb71954a9f4e48a5994e37a02baf"),
+		Rand:                "23553cbe9637a89d218ae64dae47bf35",
+		Sqn:                 "ff9bb4d0b607",
+		Amf:                 "b9b9",
+		ServingNetworkName:  "5G:mnc093.mcc208.3gppnetwork.org",
+	}, http.StatusOK, &resp)
+
+	if resp.Autn != "55f328b43577b9b94a9ffac354dfafb3" {
+		t.Fatalf("AUTN = %s", resp.Autn)
+	}
+	if resp.XresStar != "5cc9527f4d21c43bee83a15443acf1c4" ||
+		resp.Kausf != "f2e35260f85194d4f891504d02111e56689ac23dd393bee3abbcc5bfbc013ef9" {
+		t.Fatalf("unexpected XRES* %q or KAUSF %q", resp.XresStar, resp.Kausf)
+	}
+}
+
+func TestAuthResync(t *testing.T) {
+	f := ssmtest.New(t)
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, nil)
+	encrypt := subscriberSecrets(f)
+
+	// AUTS of SQN_MS ff9bb4d0b607 for the K, OPc and RAND of TS 35.208 test set 1
+	req := models.AuthResyncRequest{
+		KeyLabel:            constants.LABEL_ENCRYPTION_KEY_AES256,
+		Id:                  1,
+		EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers,
+		K:                   encrypt("465b5ce8b199b49faa5f0a2ee238a6bc"),
+		Opc:                 encrypt("cd63cb71954a9f4e48a5994e37a02baf"),
+		Rand:                "23553cbe9637a89d218ae64dae47bf35",
+		Auts:                "ba853f3c123ccf44e93596e355c6",
+	}
+
+	// the udm user resynchronises the SQN itself
+	w := f.DoAs(constants.ROLE_UDM, "/crypto/auth-resync", handlers.HandleAuthResync, req)
+	checkStatus(t, w, http.StatusOK)
+	var resp models.AuthResyncResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Valid || resp.Sqn != "ff9bb4d0b607" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	// a wrong MAC-S is reported, SQN_MS is not returned
+	req.Auts = "ba853f3c123ccf44e93596e355c7"
+	resp = models.AuthResyncResponse{}
+	f.DoJSON("/crypto/auth-resync", req, http.StatusOK, &resp)
+	if resp.Valid || resp.Sqn != "" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	req.Auts = "ba853f3c123c"
+	f.DoJSON("/crypto/auth-resync", req, http.StatusBadRequest, nil)
+
+	restrictToUDM(constants.LABEL_FAMILY_ENCRYPTION, constants.KEY_OPERATION_ENCRYPT)
+	req.Auts = "ba853f3c123ccf44e93596e355c6"
+	checkStatus(t, f.DoAs(constants.ROLE_UDM, "/crypto/auth-resync", handlers.HandleAuthResync, req), http.StatusForbidden)
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/networkgcorefullcode/ssm/database"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+// SecretStore keeps the user secrets the backup bundles carry
+type SecretStore interface {
+	BackupSecrets() ([]pkcs11mgr.BackupSecret, error)
+	RestoreSecrets(secrets []pkcs11mgr.BackupSecret) error
+}
+
+var secretStore SecretStore = database.SecretStore{}
+
+// SetSecretStore replaces the MongoDB store of the user secrets
+func SetSecretStore(s SecretStore) {
+	secretStore = s
+}
+
+// HandleBackup handles requests for a backup bundle of the key inventory
+// @Summary Backup bundle of the key inventory
+// @Description Wraps every extractable secret key under a bundle key derived from a password or from key components and signs the bundle
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.BackupRequest true "Password or key components of the bundle"
+// @Success 200 {object} models.BackupResponse "Backup bundle created"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /backup [post]
+func HandleBackup(c *gin.Context) {
+	logger.AppLog.Info("Processing backup request")
+	var req models.BackupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.AppLog.Errorf("Invalid JSON payload: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	credentials, err := NewBackupCredentials(req.Password, req.Components)
+	if err != nil {
+		logger.AppLog.Errorf("Invalid backup credentials: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, err.Error(), "INVALID_BACKUP_CREDENTIALS", http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	secrets, err := secretStore.BackupSecrets()
+	if err != nil {
+		logger.AppLog.Errorf("Failed to read the user secrets: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error reading the user secrets", "BACKUP_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	bundle, err := pkcs11mgr.CreateBackup(s, credentials, factory.SsmConfig.GetBackup().Iterations, secrets)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to create the backup bundle: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error creating the backup bundle", "BACKUP_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	raw, err := json.Marshal(bundle)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to encode the backup bundle: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error encoding the backup bundle", "BACKUP_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	c.JSON(http.StatusOK, NewBackupResponse(bundle, raw))
+}
+
+// HandleBackupRestore handles requests to check or restore a backup bundle
+// @Summary Check or restore a backup bundle
+// @Description Checks the signature of a bundle and compares its keys with the token. Unless dry_run is set the keys and the user secrets are restored, only into a token without secret keys: a running SSM already holds its internal keys, use ssm backup restore before the first start.
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.BackupRestoreRequest true "Bundle and its password or key components"
+// @Success 200 {object} models.BackupRestoreResponse "Bundle checked or restored"
+// @Failure 400 {object} models.ProblemDetails "Invalid request, bundle or signature"
+// @Failure 409 {object} models.ProblemDetails "The token is not empty"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /backup-restore [post]
+func HandleBackupRestore(c *gin.Context) {
+	logger.AppLog.Info("Processing backup restore request")
+	var req models.BackupRestoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.AppLog.Errorf("Invalid JSON payload: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	raw, err := base64.StdEncoding.DecodeString(req.Bundle)
+	if err != nil || req.Bundle == "" {
+		sendProblemDetails(c, ErrorTitleBadRequest, "The bundle must be base64 encoded", "INVALID_BACKUP_BUNDLE", http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	var bundle pkcs11mgr.BackupBundle
+	if err := json.Unmarshal(raw, &bundle); err != nil {
+		logger.AppLog.Errorf("Invalid backup bundle: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, "The bundle is not a backup bundle", "INVALID_BACKUP_BUNDLE", http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	credentials, err := NewBackupCredentials(req.Password, req.Components)
+	if err != nil {
+		logger.AppLog.Errorf("Invalid backup credentials: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, err.Error(), "INVALID_BACKUP_CREDENTIALS", http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var plan pkcs11mgr.RestorePlan
+	if req.DryRun {
+		plan, err = pkcs11mgr.PlanRestore(s, &bundle, credentials)
+	} else {
+		plan, err = pkcs11mgr.RestoreBackup(s, &bundle, credentials)
+	}
+	switch {
+	case errors.Is(err, pkcs11mgr.ErrBackupSignature):
+		logger.AppLog.Errorf("Backup bundle rejected: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, err.Error(), "BACKUP_SIGNATURE_INVALID", http.StatusBadRequest, c.Request.URL.Path)
+		return
+	case errors.Is(err, pkcs11mgr.ErrInvalidBundle):
+		logger.AppLog.Errorf("Backup bundle rejected: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, err.Error(), "INVALID_BACKUP_BUNDLE", http.StatusBadRequest, c.Request.URL.Path)
+		return
+	case errors.Is(err, pkcs11mgr.ErrBackupTokenNotEmpty):
+		sendProblemDetails(c, ErrorTitleConflict, err.Error(), "BACKUP_TOKEN_NOT_EMPTY", http.StatusConflict, c.Request.URL.Path)
+		return
+	case err != nil:
+		logger.AppLog.Errorf("Failed to restore the backup bundle: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error restoring the backup bundle", "BACKUP_RESTORE_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	if !req.DryRun {
+		if err := secretStore.RestoreSecrets(bundle.UserSecrets); err != nil {
+			logger.AppLog.Errorf("Failed to restore the user secrets: %v", err)
+			sendProblemDetails(c, ErrorTitleInternalServerError, "The keys were restored but not the user secrets", "BACKUP_RESTORE_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, NewBackupRestoreResponse(plan, req.DryRun))
+}
+
+// NewBackupCredentials builds the credentials of a bundle from a password or
+// from hexadecimal key components
+func NewBackupCredentials(password string, components []string) (pkcs11mgr.BackupCredentials, error) {
+	credentials := pkcs11mgr.BackupCredentials{Password: password}
+	for i, component := range components {
+		value, err := hex.DecodeString(component)
+		if err != nil {
+			return pkcs11mgr.BackupCredentials{}, fmt.Errorf("key component %d is not hexadecimal", i+1)
+		}
+		credentials.Components = append(credentials.Components, value)
+	}
+	return credentials, credentials.Validate()
+}
+
+// NewBackupResponse converts a bundle and its JSON encoding to the API model
+func NewBackupResponse(bundle *pkcs11mgr.BackupBundle, raw []byte) models.BackupResponse {
+	response := models.BackupResponse{
+		Bundle:      base64.StdEncoding.EncodeToString(raw),
+		Version:     int32(bundle.Version),
+		CreatedAt:   bundle.CreatedAt.Format(time.RFC3339),
+		NotExported: []models.BackupKeyStatus{},
+		UserSecrets: int32(len(bundle.UserSecrets)),
+	}
+	for _, key := range bundle.Keys {
+		if key.WrappedKey != "" {
+			response.Exported++
+			continue
+		}
+		response.NotExported = append(response.NotExported, models.BackupKeyStatus{
+			KeyLabel: key.Label,
+			Id:       key.Id,
+			KeyType:  key.KeyType,
+			Kcv:      key.CheckValue,
+			Status:   pkcs11mgr.BACKUP_KEY_NOT_EXPORTED,
+		})
+	}
+	return response
+}
+
+// NewBackupRestoreResponse converts a restore plan to its API model
+func NewBackupRestoreResponse(plan pkcs11mgr.RestorePlan, dryRun bool) models.BackupRestoreResponse {
+	keys := make([]models.BackupKeyStatus, 0, len(plan.Keys))
+	for _, key := range plan.Keys {
+		keys = append(keys, models.BackupKeyStatus{
+			KeyLabel: key.Label,
+			Id:       key.Id,
+			KeyType:  key.KeyType,
+			Kcv:      key.CheckValue,
+			Status:   key.Status,
+		})
+	}
+	return models.BackupRestoreResponse{
+		DryRun:      dryRun,
+		Restored:    !dryRun,
+		EmptyToken:  plan.EmptyToken,
+		Keys:        keys,
+		UserSecrets: int32(plan.UserSecrets),
+	}
+}
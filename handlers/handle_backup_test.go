@@ -0,0 +1,99 @@
+package handlers_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+func TestBackupBundleRestore(t *testing.T) {
+	f := ssmtest.New(t)
+	factory.SsmConfig.Configuration.Backup = &factory.Backup{Iterations: 1000}
+	secrets := f.UseSecrets(pkcs11mgr.BackupSecret{ServiceId: constants.USER_UDM, EncryptedData: "aabb", IV: "00", KeyId: 1, KeyLabel: constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256})
+
+	// keys created before the export was enabled are only listed
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 9, KeyValue: "00112233445566778899aabbccddeeff", KeyType: constants.TYPE_AES}, http.StatusOK, nil)
+	pkcs11mgr.SetKeyExport(true)
+	t.Cleanup(func() { pkcs11mgr.SetKeyExport(false) })
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyValue: "000102030405060708090a0b0c0d0e0f", KeyType: constants.TYPE_AES}, http.StatusOK, nil)
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_DES, Id: 1, KeyValue: "0123456789abcdef", KeyType: constants.TYPE_DES}, http.StatusOK, nil)
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, nil)
+
+	const password = "correct horse battery staple"
+	f.DoJSON("/crypto/backup", models.BackupRequest{Password: "short"}, http.StatusBadRequest, nil)
+	var backup models.BackupResponse
+	f.DoJSON("/crypto/backup", models.BackupRequest{Password: password}, http.StatusOK, &backup)
+	if backup.Version != 1 || backup.Exported != 3 || backup.UserSecrets != 1 ||
+		len(backup.NotExported) != 1 || backup.NotExported[0].KeyLabel != constants.LABEL_K4_KEY_AES || backup.NotExported[0].Id != 9 {
+		t.Fatalf("backup = %+v", backup)
+	}
+
+	statuses := func(resp models.BackupRestoreResponse) map[string]string {
+		keys := make(map[string]string)
+		for _, key := range resp.Keys {
+			keys[fmt.Sprintf("%s/%d", key.KeyLabel, key.Id)] = key.Status
+		}
+		return keys
+	}
+
+	// the source token holds every key of the bundle
+	var plan models.BackupRestoreResponse
+	f.DoJSON("/crypto/backup-restore", models.BackupRestoreRequest{Bundle: backup.Bundle, Password: password, DryRun: true}, http.StatusOK, &plan)
+	keys := statuses(plan)
+	if plan.EmptyToken || plan.Restored || keys["K4_AES/1"] != pkcs11mgr.BACKUP_KEY_IDENTICAL || keys["K4_AES/9"] != pkcs11mgr.BACKUP_KEY_NOT_EXPORTED {
+		t.Fatalf("plan on the source token = %+v", plan)
+	}
+	f.DoJSON("/crypto/backup-restore", models.BackupRestoreRequest{Bundle: backup.Bundle, Password: password}, http.StatusConflict, nil)
+
+	// a wrong password or a changed bundle fails the signature
+	f.DoJSON("/crypto/backup-restore", models.BackupRestoreRequest{Bundle: backup.Bundle, Password: "wrong horse battery staple", DryRun: true}, http.StatusBadRequest, nil)
+	raw, _ := base64.StdEncoding.DecodeString(backup.Bundle)
+	var bundle pkcs11mgr.BackupBundle
+	if err := json.Unmarshal(raw, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	bundle.Keys[0].Id = 2
+	tampered, _ := json.Marshal(bundle)
+	f.DoJSON("/crypto/backup-restore", models.BackupRestoreRequest{Bundle: base64.StdEncoding.EncodeToString(tampered), Password: password, DryRun: true}, http.StatusBadRequest, nil)
+
+	// restore into an empty token
+	f = ssmtest.New(t)
+	factory.SsmConfig.Configuration.Backup = &factory.Backup{Iterations: 1000}
+	f.DoJSON("/crypto/backup-restore", models.BackupRestoreRequest{Bundle: backup.Bundle, Password: password, DryRun: true}, http.StatusOK, &plan)
+	keys = statuses(plan)
+	if !plan.EmptyToken || keys["K4_AES/1"] != pkcs11mgr.BACKUP_KEY_ADD || keys["K4_DES/1"] != pkcs11mgr.BACKUP_KEY_ADD || len(secrets.Restored) != 0 {
+		t.Fatalf("plan on an empty token = %+v", plan)
+	}
+	f.DoJSON("/crypto/backup-restore", models.BackupRestoreRequest{Bundle: backup.Bundle, Password: password}, http.StatusOK, &plan)
+	if !plan.Restored || len(secrets.Restored) != 1 || secrets.Restored[0].ServiceId != constants.USER_UDM {
+		t.Fatalf("restore = %+v, secrets %+v", plan, secrets.Restored)
+	}
+	f.DoJSON("/crypto/backup-restore", models.BackupRestoreRequest{Bundle: backup.Bundle, Password: password, DryRun: true}, http.StatusOK, &plan)
+	keys = statuses(plan)
+	if keys["K4_AES/1"] != pkcs11mgr.BACKUP_KEY_IDENTICAL || keys["K4_DES/1"] != pkcs11mgr.BACKUP_KEY_IDENTICAL || keys[constants.LABEL_ENCRYPTION_KEY_AES256+"/1"] != pkcs11mgr.BACKUP_KEY_IDENTICAL {
+		t.Fatalf("plan after the restore = %+v", plan)
+	}
+	// the restored encryption key still encrypts
+	f.DoJSON("/crypto/encrypt", models.EncryptRequest{
+		KeyLabel:            constants.LABEL_ENCRYPTION_KEY_AES256,
+		Plain:               "00112233445566778899aabbccddeeff",
+		EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers,
+	}, http.StatusCreated, nil)
+
+	// split custody: the components open the bundle in any order, not one of them alone
+	first, second := strings.Repeat("11", 32), strings.Repeat("2c", 32)
+	f.DoJSON("/crypto/backup", models.BackupRequest{Components: []string{first}}, http.StatusBadRequest, nil)
+	f.DoJSON("/crypto/backup", models.BackupRequest{Components: []string{first, second}}, http.StatusOK, &backup)
+	f.DoJSON("/crypto/backup-restore", models.BackupRestoreRequest{Bundle: backup.Bundle, Components: []string{second, first}, DryRun: true}, http.StatusOK, &plan)
+	f.DoJSON("/crypto/backup-restore", models.BackupRestoreRequest{Bundle: backup.Bundle, Components: []string{first, strings.Repeat("2d", 32)}, DryRun: true}, http.StatusBadRequest, nil)
+	f.DoJSON("/crypto/backup-restore", models.BackupRestoreRequest{Bundle: backup.Bundle, Password: password, DryRun: true}, http.StatusBadRequest, nil)
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/safe"
+	"github.com/networkgcorefullcode/ssm/server/middleware"
+)
+
+// maxBatchItems is the largest number of items accepted by a batch request
+const maxBatchItems = 1000
+
+// batchItemError is the error code and detail reported for a failed item
+type batchItemError struct {
+	code   string
+	detail string
+}
+
+func (e *batchItemError) Error() string {
+	return e.code + ": " + e.detail
+}
+
+// batchKeys resolves the key handles of a batch once per label and id, all
+// the items of a batch share the same key store
+type batchKeys struct {
+	s       pkcs11mgr.KeyStore
+	role    string // checked against the key usage policies
+	current map[string]pkcs11mgr.KeyVersion
+	byId    map[string]pkcs11.ObjectHandle
+}
+
+func newBatchKeys(s pkcs11mgr.KeyStore, role string) *batchKeys {
+	return &batchKeys{
+		s:       s,
+		role:    role,
+		current: make(map[string]pkcs11mgr.KeyVersion),
+		byId:    make(map[string]pkcs11.ObjectHandle),
+	}
+}
+
+// currentKey returns the version of a label used to encrypt
+func (k *batchKeys) currentKey(label string) (pkcs11mgr.KeyVersion, error) {
+	if version, ok := k.current[label]; ok {
+		return version, nil
+	}
+	version, err := pkcs11mgr.FindCurrentKey(k.s, label)
+	if err != nil {
+		return pkcs11mgr.KeyVersion{}, err
+	}
+	k.current[label] = version
+	return version, nil
+}
+
+// key returns the handle of a label and id
+func (k *batchKeys) key(label string, id int32) (pkcs11.ObjectHandle, error) {
+	cacheKey := fmt.Sprintf("%s/%d", label, id)
+	if handle, ok := k.byId[cacheKey]; ok {
+		return handle, nil
+	}
+	handle, err := k.s.FindKey(label, id)
+	if err != nil {
+		return 0, err
+	}
+	k.byId[cacheKey] = handle
+	return handle, nil
+}
+
+// decodeBatchRequest decodes a batch request body and checks its size, on
+// failure it writes the problem details and returns false
+func decodeBatchRequest(c *gin.Context, req any, items func() int) bool {
+	if err := json.NewDecoder(c.Request.Body).Decode(req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return false
+	}
+	if items() == 0 {
+		logger.AppLog.Error("Batch request without items")
+		sendProblemDetails(c, ErrorTitleValidationError, "At least one item is required", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return false
+	}
+	if items() > maxBatchItems {
+		logger.AppLog.Errorf("Batch request with %d items, the limit is %d", items(), maxBatchItems)
+		sendProblemDetails(c, ErrorTitleValidationError, fmt.Sprintf("A batch can not have more than %d items", maxBatchItems), ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return false
+	}
+	return true
+}
+
+// HandleEncryptBatch handles batch encryption requests
+// @Summary Encrypt a batch of data
+// @Description Encrypts several plaintexts with one session, every item reports its own result
+// @Tags Encryption
+// @Accept json
+// @Produce json
+// @Param request body models.EncryptBatchRequest true "Data to encrypt"
+// @Success 200 {object} models.EncryptBatchResponse "Batch processed"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/encrypt-batch [post]
+func HandleEncryptBatch(c *gin.Context) {
+	logger.AppLog.Info("Processing batch encrypt request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.EncryptBatchRequest
+	if !decodeBatchRequest(c, &req, func() int { return len(req.Items) }) {
+		return
+	}
+
+	keys := newBatchKeys(s, middleware.ServiceRole(c))
+	audit := middleware.BatchAudit{Items: len(req.Items), Labels: make(map[string]int)}
+	resp := models.EncryptBatchResponse{Results: make([]models.EncryptBatchResult, 0, len(req.Items))}
+	for i, item := range req.Items {
+		audit.Labels[item.KeyLabel]++
+		result, err := encryptBatchItem(keys, item)
+		result.Index = int32(i)
+		if err != nil {
+			var itemErr *batchItemError
+			if errors.As(err, &itemErr) {
+				result.Error, result.Detail = itemErr.code, itemErr.detail
+			}
+			logger.AppLog.Warnf("Batch item %d with key %s failed: %v", i, item.KeyLabel, err)
+			resp.Failed++
+		} else {
+			resp.Succeeded++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	audit.Succeeded, audit.Failed = int(resp.Succeeded), int(resp.Failed)
+	middleware.SetBatchAudit(c, audit)
+
+	logger.AppLog.Infof("Batch encrypt finished: %d succeeded, %d failed", resp.Succeeded, resp.Failed)
+	c.JSON(http.StatusOK, resp)
+}
+
+func encryptBatchItem(keys *batchKeys, item models.EncryptBatchItem) (models.EncryptBatchResult, error) {
+	if item.KeyLabel == "" {
+		return models.EncryptBatchResult{}, &batchItemError{ErrorCodeValidationFailed, ErrorDetailKeyLabelRequired}
+	}
+	if err := checkKeyPolicy(keys.role, item.KeyLabel, constants.KEY_OPERATION_ENCRYPT, int(item.EncryptionAlgorithm)); err != nil {
+		return models.EncryptBatchResult{}, &batchItemError{ErrorCodeKeyPolicyDenied, err.Error()}
+	}
+	if item.Plain == "" {
+		return models.EncryptBatchResult{}, &batchItemError{ErrorCodeValidationFailed, ErrorDetailPlaintextRequired}
+	}
+
+	pt, err := hex.DecodeString(item.Plain)
+	if err != nil {
+		return models.EncryptBatchResult{}, &batchItemError{ErrorCodeInvalidHex, ErrorDetailInvalidHexPlaintext}
+	}
+	defer safe.Zero(pt)
+
+	aad, err := hex.DecodeString(item.Aad)
+	if err != nil {
+		return models.EncryptBatchResult{}, &batchItemError{ErrorCodeInvalidHex, ErrorDetailInvalidHexAAD}
+	}
+
+	currentKey, err := keys.currentKey(item.KeyLabel)
+	if err != nil {
+		return models.EncryptBatchResult{}, &batchItemError{ErrorCodeKeyNotFound, ErrorDetailKeyNotExist}
+	}
+	if err := pkcs11mgr.CheckKeyValidity(keys.s, currentKey.Handle, time.Now().UTC()); err != nil {
+		if errors.Is(err, pkcs11mgr.ErrKeyNotYetValid) || errors.Is(err, pkcs11mgr.ErrKeyExpired) {
+			return models.EncryptBatchResult{}, &batchItemError{keyValidityCode(err), err.Error()}
+		}
+		return models.EncryptBatchResult{}, &batchItemError{ErrorCodeAttributesNotFound, ErrorDetailAttributesNotFound}
+	}
+
+	cipher, iv, tag, err := encryptWithAlgorithm(keys.s, currentKey.Handle, item.EncryptionAlgorithm, pt, aad)
+	if err != nil {
+		if errors.Is(err, errUnsupportedAlgorithm) {
+			return models.EncryptBatchResult{}, &batchItemError{"UNSUPPORTED_ALGORITHM", "The specified encryption algorithm is not supported"}
+		}
+		logger.AppLog.Errorf("Encryption failed: %v", err)
+		return models.EncryptBatchResult{}, &batchItemError{ErrorCodeEncryptionError, ErrorDetailEncryptionError}
+	}
+
+	return models.EncryptBatchResult{
+		Ok:     true,
+		Cipher: hex.EncodeToString(cipher),
+		Iv:     hex.EncodeToString(iv),
+		Tag:    hex.EncodeToString(tag),
+		Id:     currentKey.Id,
+	}, nil
+}
+
+// HandleDecryptBatch handles batch decryption requests
+// @Summary Decrypt a batch of data
+// @Description Decrypts several ciphertexts with one session, every item reports its own result
+// @Tags Encryption
+// @Accept json
+// @Produce json
+// @Param request body models.DecryptBatchRequest true "Data to decrypt"
+// @Success 200 {object} models.DecryptBatchResponse "Batch processed"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/decrypt-batch [post]
+func HandleDecryptBatch(c *gin.Context) {
+	logger.AppLog.Info("Processing batch decrypt request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.DecryptBatchRequest
+	if !decodeBatchRequest(c, &req, func() int { return len(req.Items) }) {
+		return
+	}
+
+	keys := newBatchKeys(s, middleware.ServiceRole(c))
+	audit := middleware.BatchAudit{Items: len(req.Items), Labels: make(map[string]int)}
+	resp := models.DecryptBatchResponse{Results: make([]models.DecryptBatchResult, 0, len(req.Items))}
+	for i, item := range req.Items {
+		audit.Labels[item.KeyLabel]++
+		result, err := decryptBatchItem(keys, item)
+		result.Index = int32(i)
+		if err != nil {
+			var itemErr *batchItemError
+			if errors.As(err, &itemErr) {
+				result.Error, result.Detail = itemErr.code, itemErr.detail
+			}
+			logger.AppLog.Warnf("Batch item %d with key %s failed: %v", i, item.KeyLabel, err)
+			resp.Failed++
+		} else {
+			resp.Succeeded++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	audit.Succeeded, audit.Failed = int(resp.Succeeded), int(resp.Failed)
+	middleware.SetBatchAudit(c, audit)
+
+	logger.AppLog.Infof("Batch decrypt finished: %d succeeded, %d failed", resp.Succeeded, resp.Failed)
+	c.JSON(http.StatusOK, resp)
+}
+
+func decryptBatchItem(keys *batchKeys, item models.DecryptBatchItem) (models.DecryptBatchResult, error) {
+	if item.KeyLabel == "" {
+		return models.DecryptBatchResult{}, &batchItemError{ErrorCodeValidationFailed, ErrorDetailKeyLabelRequired}
+	}
+	if err := checkKeyPolicy(keys.role, item.KeyLabel, constants.KEY_OPERATION_DECRYPT, int(item.EncryptionAlgorithm)); err != nil {
+		return models.DecryptBatchResult{}, &batchItemError{ErrorCodeKeyPolicyDenied, err.Error()}
+	}
+	if item.Cipher == "" {
+		return models.DecryptBatchResult{}, &batchItemError{ErrorCodeValidationFailed, ErrorDetailCiphertextRequired}
+	}
+
+	cipher, err := hex.DecodeString(item.Cipher)
+	if err != nil {
+		return models.DecryptBatchResult{}, &batchItemError{ErrorCodeInvalidHex, ErrorDetailInvalidHexCiphertext}
+	}
+	iv, err := hex.DecodeString(item.Iv)
+	if err != nil {
+		return models.DecryptBatchResult{}, &batchItemError{ErrorCodeInvalidHex, ErrorDetailInvalidHexIV}
+	}
+	tag, err := hex.DecodeString(item.Tag)
+	if err != nil {
+		return models.DecryptBatchResult{}, &batchItemError{ErrorCodeInvalidHex, ErrorDetailInvalidHexTag}
+	}
+	aad, err := hex.DecodeString(item.Aad)
+	if err != nil {
+		return models.DecryptBatchResult{}, &batchItemError{ErrorCodeInvalidHex, ErrorDetailInvalidHexAAD}
+	}
+
+	keyHandle, err := keys.key(item.KeyLabel, item.Id)
+	if err != nil {
+		return models.DecryptBatchResult{}, &batchItemError{ErrorCodeKeyNotFound, ErrorDetailKeyNotExist}
+	}
+
+	rawPlaintext, err := decryptWithAlgorithm(keys.s, keyHandle, item.EncryptionAlgorithm, cipher, iv, tag, aad)
+	defer safe.Zero(rawPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnsupportedAlgorithm):
+			return models.DecryptBatchResult{}, &batchItemError{"UNSUPPORTED_ALGORITHM", "Unsupported decryption algorithm"}
+		case item.EncryptionAlgorithm == constants.ALGORITHM_AES256_GCM:
+			return models.DecryptBatchResult{}, &batchItemError{ErrorCodeAuthenticationFailed, ErrorDetailAuthenticationFailed}
+		default:
+			logger.AppLog.Errorf("Decryption failed: %v", err)
+			return models.DecryptBatchResult{}, &batchItemError{ErrorCodeDecryptionError, ErrorDetailDecryptionError}
+		}
+	}
+
+	return models.DecryptBatchResult{
+		Ok:    true,
+		Plain: hex.EncodeToString(rawPlaintext),
+		Id:    item.Id,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+func TestEncryptDecryptBatch(t *testing.T) {
+	f := ssmtest.New(t)
+
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, nil)
+	f.DoJSON("/crypto/generate-des3-key", models.GenDES3KeyRequest{Id: 1}, http.StatusCreated, nil)
+
+	plains := []string{"00112233445566778899aabbccddeeff", "0102030405060708", "cafebabe"}
+	var encResp models.EncryptBatchResponse
+	f.DoJSON("/crypto/encrypt-batch", models.EncryptBatchRequest{Items: []models.EncryptBatchItem{
+		{KeyLabel: constants.LABEL_ENCRYPTION_KEY_AES256, Plain: plains[0], EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers},
+		{KeyLabel: constants.LABEL_ENCRYPTION_KEY_DES3, Plain: plains[1], EncryptionAlgorithm: constants.ALGORITHM_DES3_OurUsers},
+		{KeyLabel: constants.LABEL_ENCRYPTION_KEY_AES256, Plain: plains[2], EncryptionAlgorithm: constants.ALGORITHM_AES256_GCM},
+		{KeyLabel: "UNKNOWN", Plain: plains[2], EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers},
+	}}, http.StatusOK, &encResp)
+	if encResp.Succeeded != 3 || encResp.Failed != 1 || encResp.Results[3].Error != "KEY_POLICY_DENIED" {
+		t.Fatalf("unexpected batch encryption %+v", encResp)
+	}
+
+	algorithms := []int32{constants.ALGORITHM_AES256_OurUsers, constants.ALGORITHM_DES3_OurUsers, constants.ALGORITHM_AES256_GCM}
+	labels := []string{constants.LABEL_ENCRYPTION_KEY_AES256, constants.LABEL_ENCRYPTION_KEY_DES3, constants.LABEL_ENCRYPTION_KEY_AES256}
+	var items []models.DecryptBatchItem
+	for i, result := range encResp.Results[:3] {
+		items = append(items, models.DecryptBatchItem{
+			KeyLabel:            labels[i],
+			Id:                  result.Id,
+			Cipher:              result.Cipher,
+			Iv:                  result.Iv,
+			Tag:                 result.Tag,
+			EncryptionAlgorithm: algorithms[i],
+		})
+	}
+	var decResp models.DecryptBatchResponse
+	f.DoJSON("/crypto/decrypt-batch", models.DecryptBatchRequest{Items: items}, http.StatusOK, &decResp)
+	if decResp.Failed != 0 {
+		t.Fatalf("unexpected batch decryption %+v", decResp)
+	}
+	for i, result := range decResp.Results {
+		if result.Plain != plains[i] {
+			t.Fatalf("item %d decrypted %q, want %q", i, result.Plain, plains[i])
+		}
+	}
+}
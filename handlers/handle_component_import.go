@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/server/middleware"
+)
+
+// HandleComponentImportStart handles requests to start a key component import
+// @Summary Start a key component import
+// @Description Starts the split knowledge import of a key delivered as clear key components held by different custodians
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.ComponentImportStartRequest true "Key to import and number of components"
+// @Success 201 {object} models.ComponentImportResponse "Component import started"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 409 {object} models.ProblemDetails "The key is in the SSM"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Failure 501 {object} models.ProblemDetails "The token does not support CKM_XOR_BASE_AND_DATA"
+// @Router /component-import-start [post]
+func HandleComponentImportStart(c *gin.Context) {
+	logger.AppLog.Info("Processing component import start request")
+	var req models.ComponentImportStartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.AppLog.Errorf("Invalid JSON payload: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	if !storableLabel(req.KeyLabel) {
+		sendProblemDetails(c, ErrorTitleBadRequest, "The specified key type is not supported", "UNSUPPORTED_KEY_TYPE", http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	if req.Id <= 0 {
+		sendProblemDetails(c, ErrorTitleValidationError, "The id of the key must be positive", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_STORE, 0) {
+		return
+	}
+
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	imp, err := pkcs11mgr.StartComponentImport(s, req.KeyLabel, req.Id, req.KeyType, int(req.Components))
+	if err != nil && err.Error() == constants.ERROR_STRING_KEY_EXISTS {
+		sendProblemDetails(c, ErrorTitleConflict, "The key is already in the SSM", "KEY_EXISTS", http.StatusConflict, c.Request.URL.Path)
+		return
+	}
+	if errors.Is(err, pkcs11mgr.ErrMechanismNotSupported) {
+		logger.AppLog.Errorf("Failed to start the component import: %v", err)
+		sendProblemDetails(c, ErrorTitleNotImplemented, err.Error(), ErrorCodeMechanismUnsupported, http.StatusNotImplemented, c.Request.URL.Path)
+		return
+	}
+	if err != nil {
+		logger.AppLog.Errorf("Failed to start the component import: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, err.Error(), ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	middleware.SetComponentAudit(c, middleware.ComponentAudit{
+		ImportId:  imp.Id,
+		KeyLabel:  imp.Label,
+		KeyId:     imp.KeyId,
+		Custodian: middleware.ServiceIdentity(c),
+	})
+	c.JSON(http.StatusCreated, newComponentImportResponse(imp))
+}
+
+// HandleComponentImportSubmit handles requests to enter a key component
+// @Summary Enter a key component
+// @Description Checks a key component against its KCV and XORs it inside the HSM with the components entered before, the component is never stored
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.ComponentSubmitRequest true "Key component and its KCV"
+// @Success 200 {object} models.ComponentImportResponse "Key component entered"
+// @Failure 400 {object} models.ProblemDetails "Invalid request or KCV mismatch"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "No such component import"
+// @Failure 409 {object} models.ProblemDetails "The component was already entered, or the custodian entered another one"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /component-import-submit [post]
+func HandleComponentImportSubmit(c *gin.Context) {
+	logger.AppLog.Info("Processing component import submit request")
+	var req models.ComponentSubmitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.AppLog.Errorf("Invalid JSON payload: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	component, err := hex.DecodeString(req.KeyComponent)
+	if err != nil {
+		sendProblemDetails(c, ErrorTitleBadRequest, "The key component in HEX is not valid", ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	defer clear(component)
+	kcv, err := hex.DecodeString(req.Kcv)
+	if err != nil || len(kcv) == 0 {
+		sendProblemDetails(c, ErrorTitleBadRequest, "The KCV of the component in HEX is required", ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	if !authorizeComponentImport(c, s, req.ImportId) {
+		return
+	}
+
+	custodian := middleware.ServiceIdentity(c)
+	imp, err := pkcs11mgr.SubmitKeyComponent(s, req.ImportId, int(req.Component), component, kcv, custodian)
+	if err != nil {
+		logger.AppLog.Errorf("Key component %d of import %s rejected: %v", req.Component, req.ImportId, err)
+		sendComponentImportError(c, err)
+		return
+	}
+	middleware.SetComponentAudit(c, middleware.ComponentAudit{
+		ImportId:  imp.Id,
+		KeyLabel:  imp.Label,
+		KeyId:     imp.KeyId,
+		Component: int(req.Component),
+		Custodian: custodian,
+		Kcv:       hex.EncodeToString(kcv),
+	})
+	c.JSON(http.StatusOK, newComponentImportResponse(imp))
+}
+
+// HandleComponentImportFinalize handles requests to store the key of a component import
+// @Summary Store the key of a component import
+// @Description Stores the XOR of the key components under the label and id of the import, a key that does not match the expected KCV is discarded with the import
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.ComponentImportFinalizeRequest true "Component import and expected KCV"
+// @Success 201 {object} models.ComponentImportFinalizeResponse "Key stored"
+// @Failure 400 {object} models.ProblemDetails "Invalid request or KCV mismatch"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "No such component import"
+// @Failure 409 {object} models.ProblemDetails "Components are missing"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /component-import-finalize [post]
+func HandleComponentImportFinalize(c *gin.Context) {
+	logger.AppLog.Info("Processing component import finalize request")
+	var req models.ComponentImportFinalizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.AppLog.Errorf("Invalid JSON payload: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	// a wrong expected KCV would discard the components, it is checked first
+	expected, err := hex.DecodeString(req.ExpectedKcv)
+	if err != nil || (len(expected) != 0 && len(expected) != pkcs11mgr.KCV_LENGTH && len(expected) != pkcs11mgr.KCV_CMAC_LENGTH) {
+		sendProblemDetails(c, ErrorTitleBadRequest, "The expected KCV in HEX is not valid", ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	if !authorizeComponentImport(c, s, req.ImportId) {
+		return
+	}
+
+	handle, values, imp, err := pkcs11mgr.FinishComponentImport(s, req.ImportId, expected)
+	audit := middleware.ComponentAudit{
+		ImportId:   req.ImportId,
+		KeyLabel:   imp.Label,
+		KeyId:      imp.KeyId,
+		Custodian:  middleware.ServiceIdentity(c),
+		Kcv:        hex.EncodeToString(values.KCV),
+		Custodians: imp.Custodians,
+	}
+	middleware.SetComponentAudit(c, audit)
+	if err != nil {
+		logger.AppLog.Errorf("Component import %s failed: %v", req.ImportId, err)
+		sendComponentImportError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.ComponentImportFinalizeResponse{
+		ImportId:   imp.Id,
+		Handle:     int32(handle),
+		KeyLabel:   imp.Label,
+		Id:         imp.KeyId,
+		Kcv:        hex.EncodeToString(values.KCV),
+		KcvCmac:    hex.EncodeToString(values.CMAC),
+		Custodians: imp.Custodians,
+	})
+}
+
+// authorizeComponentImport checks the key usage policy of the label of an
+// import, a change of the policy or another role than the one that started it
+// stops the import. On failure it writes the problem details and returns false.
+func authorizeComponentImport(c *gin.Context, s pkcs11mgr.KeyStore, importId string) bool {
+	imp, err := pkcs11mgr.GetComponentImport(s, importId)
+	if err != nil {
+		sendComponentImportError(c, err)
+		return false
+	}
+	return authorizeKeyOperation(c, imp.Label, constants.KEY_OPERATION_STORE, 0)
+}
+
+// sendComponentImportError maps the errors of a component import to problem details
+func sendComponentImportError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, pkcs11mgr.ErrComponentImportNotFound):
+		sendProblemDetails(c, ErrorTitleNotFound, err.Error(), "COMPONENT_IMPORT_NOT_FOUND", http.StatusNotFound, c.Request.URL.Path)
+	case errors.Is(err, pkcs11mgr.ErrComponentSubmitted), errors.Is(err, pkcs11mgr.ErrCustodianSubmitted), errors.Is(err, pkcs11mgr.ErrComponentsMissing):
+		sendProblemDetails(c, ErrorTitleConflict, err.Error(), "COMPONENT_IMPORT_CONFLICT", http.StatusConflict, c.Request.URL.Path)
+	case errors.Is(err, pkcs11mgr.ErrKCVMismatch):
+		sendProblemDetails(c, ErrorTitleBadRequest, err.Error(), "KCV_MISMATCH", http.StatusBadRequest, c.Request.URL.Path)
+	case errors.Is(err, pkcs11mgr.ErrInvalidKCV), errors.Is(err, pkcs11mgr.ErrInvalidComponent):
+		sendProblemDetails(c, ErrorTitleBadRequest, err.Error(), ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+	case err.Error() == constants.ERROR_STRING_KEY_EXISTS:
+		sendProblemDetails(c, ErrorTitleConflict, "The key is already in the SSM", "KEY_EXISTS", http.StatusConflict, c.Request.URL.Path)
+	default:
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error importing the key components", "COMPONENT_IMPORT_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+	}
+}
+
+// newComponentImportResponse converts a component import to its API model
+func newComponentImportResponse(imp pkcs11mgr.ComponentImport) models.ComponentImportResponse {
+	return models.ComponentImportResponse{
+		ImportId:   imp.Id,
+		KeyLabel:   imp.Label,
+		Id:         imp.KeyId,
+		KeyType:    imp.KeyType,
+		Components: int32(imp.Components),
+		Submitted:  int32(imp.Submitted),
+		ExpiresAt:  imp.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+}
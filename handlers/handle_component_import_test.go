@@ -0,0 +1,97 @@
+package handlers_test
+
+import (
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/handlers"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+func TestComponentImport(t *testing.T) {
+	f := ssmtest.New(t)
+	// the two components XOR to 000102030405060708090a0b0c0d0e0f
+	components := []string{"00112233445566778899aabbccddeeff", "00102030405060708090a0b0c0d0e0f0"}
+	kcvs := make([]string, len(components))
+	for i, component := range components {
+		value, _ := hex.DecodeString(component)
+		values, err := pkcs11mgr.ComputeKeyCheckValues(constants.TYPE_AES, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kcvs[i] = hex.EncodeToString(values.KCV)
+	}
+
+	start := func(id int32) models.ComponentImportResponse {
+		var imp models.ComponentImportResponse
+		f.DoJSON("/crypto/component-import-start", models.ComponentImportStartRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: id, KeyType: constants.TYPE_AES, Components: 2}, http.StatusCreated, &imp)
+		return imp
+	}
+	submit := func(importId string, n int, kcv string, wantStatus int) {
+		f.DoJSON("/crypto/component-import-submit", models.ComponentSubmitRequest{ImportId: importId, Component: int32(n), KeyComponent: components[n-1], Kcv: kcv}, wantStatus, nil)
+	}
+
+	imp := start(1)
+	submit(imp.ImportId, 1, kcvs[1], http.StatusBadRequest)
+	submit(imp.ImportId, 1, kcvs[0], http.StatusOK)
+	submit(imp.ImportId, 1, kcvs[0], http.StatusConflict)
+	f.DoJSON("/crypto/component-import-finalize", models.ComponentImportFinalizeRequest{ImportId: imp.ImportId}, http.StatusConflict, nil)
+	f.DoJSON("/crypto/component-import-submit", models.ComponentSubmitRequest{ImportId: imp.ImportId, Component: 2, KeyComponent: "0011", Kcv: kcvs[1]}, http.StatusBadRequest, nil)
+	submit(imp.ImportId, 2, kcvs[1], http.StatusOK)
+
+	var stored models.ComponentImportFinalizeResponse
+	f.DoJSON("/crypto/component-import-finalize", models.ComponentImportFinalizeRequest{ImportId: imp.ImportId, ExpectedKcv: "c6a13b"}, http.StatusCreated, &stored)
+	if stored.Kcv != "c6a13b" || stored.KcvCmac != "be7ed6ae78" || len(stored.Custodians) != 2 {
+		t.Fatalf("finalize = %+v", stored)
+	}
+	f.DoJSON("/crypto/component-import-finalize", models.ComponentImportFinalizeRequest{ImportId: imp.ImportId}, http.StatusNotFound, nil)
+	f.DoJSON("/crypto/component-import-start", models.ComponentImportStartRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyType: constants.TYPE_AES, Components: 2}, http.StatusConflict, nil)
+
+	var info models.GetKeyResponse
+	f.DoJSON("/crypto/get-key", models.GetKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1}, http.StatusOK, &info)
+	if info.KeyInfo.Handle != stored.Handle || info.KeyInfo.Kcv != "c6a13b" {
+		t.Fatalf("get-key = %+v, want handle %d", info.KeyInfo, stored.Handle)
+	}
+
+	// a combined key that does not match the KCV of the vendor is discarded
+	imp = start(2)
+	submit(imp.ImportId, 2, kcvs[1], http.StatusOK)
+	submit(imp.ImportId, 1, kcvs[0], http.StatusOK)
+	f.DoJSON("/crypto/component-import-finalize", models.ComponentImportFinalizeRequest{ImportId: imp.ImportId, ExpectedKcv: "ebc958"}, http.StatusBadRequest, nil)
+	f.DoJSON("/crypto/component-import-finalize", models.ComponentImportFinalizeRequest{ImportId: imp.ImportId}, http.StatusNotFound, nil)
+	var missing models.GetKeyResponse
+	f.DoJSON("/crypto/get-key", models.GetKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 2}, http.StatusOK, &missing)
+	if missing.KeyInfo.Handle != 0 {
+		t.Fatalf("the rejected key was stored with handle %d", missing.KeyInfo.Handle)
+	}
+}
+
+func TestComponentImportPolicy(t *testing.T) {
+	f := ssmtest.New(t)
+	var imp models.ComponentImportResponse
+	f.DoJSON("/crypto/component-import-start", models.ComponentImportStartRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyType: constants.TYPE_AES, Components: 2}, http.StatusCreated, &imp)
+
+	// the policy is checked again when a component is entered and when the key is stored
+	restrictToUDM(constants.LABEL_FAMILY_K4, constants.KEY_OPERATION_STORE)
+	submit := models.ComponentSubmitRequest{ImportId: imp.ImportId, Component: 1, KeyComponent: "00112233445566778899aabbccddeeff", Kcv: "0000"}
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/component-import-submit", handlers.HandleComponentImportSubmit, submit), http.StatusForbidden)
+	finalize := models.ComponentImportFinalizeRequest{ImportId: imp.ImportId}
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/component-import-finalize", handlers.HandleComponentImportFinalize, finalize), http.StatusForbidden)
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/component-import-finalize", handlers.HandleComponentImportFinalize, models.ComponentImportFinalizeRequest{ImportId: "00"}), http.StatusNotFound)
+
+	// the import is left open, the roles of the default policy go on
+	factory.SsmConfig.Configuration.KeyPolicies = nil
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/component-import-finalize", handlers.HandleComponentImportFinalize, finalize), http.StatusConflict)
+}
+
+func TestComponentImportWithoutXOR(t *testing.T) {
+	f := ssmtest.New(t)
+	f.Provider.DisableMechanisms(pkcs11.CKM_XOR_BASE_AND_DATA)
+	f.DoJSON("/crypto/component-import-start", models.ComponentImportStartRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyType: constants.TYPE_AES, Components: 2}, http.StatusNotImplemented, nil)
+}
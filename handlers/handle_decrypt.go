@@ -11,7 +11,6 @@ import (
 	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/models"
-	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
 	"github.com/networkgcorefullcode/ssm/safe"
 )
 
@@ -23,6 +22,7 @@ import (
 // @Param        request  body      models.DecryptRequest  true  "Data to decrypt"
 // @Success      200      {object}  models.DecryptResponse "Data decrypted successfully"
 // @Failure      400      {object}  models.ProblemDetails  "Validation error or invalid JSON"
+// @Failure      403      {object}  models.ProblemDetails  "Denied by the key usage policy"
 // @Failure      404      {object}  models.ProblemDetails  "Key not found"
 // @Failure      405      {object}  models.ProblemDetails  "HTTP method not allowed"
 // @Failure      500      {object}  models.ProblemDetails  "Internal server error"
@@ -32,10 +32,11 @@ func HandleDecrypt(c *gin.Context) {
 
 	logger.AppLog.Debugf("Processing decrypt request for %s", c.Request.URL.Path)
 	// init the session
-	s := mgr.GetSession()
-	//
-
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	var req models.DecryptRequest
 	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
@@ -52,6 +53,9 @@ func HandleDecrypt(c *gin.Context) {
 		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailKeyLabelRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
 		return
 	}
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_DECRYPT, int(req.EncryptionAlgorithm)) {
+		return
+	}
 
 	if req.Cipher == "" {
 		logger.AppLog.Error("Ciphertext is required but was empty")
@@ -81,7 +85,7 @@ func HandleDecrypt(c *gin.Context) {
 	logger.AppLog.Debugf("Decoded ciphertext length: %d bytes, IV length: %d bytes", len(cipher), len(iv))
 
 	// Find key by label
-	keyHandle, err := pkcs11mgr.FindKey(req.KeyLabel, req.Id, *s)
+	keyHandle, err := s.FindKey(req.KeyLabel, req.Id)
 	if err != nil {
 		logger.AppLog.Errorf("Failed to find key by label '%s': %v", req.KeyLabel, err)
 		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusInternalServerError, c.Request.URL.Path)
@@ -92,36 +96,36 @@ func HandleDecrypt(c *gin.Context) {
 	switch req.EncryptionAlgorithm {
 	case constants.ALGORITHM_AES128, constants.ALGORITHM_AES256, constants.ALGORITHM_AES128_OurUsers, constants.ALGORITHM_AES256_OurUsers:
 		if len(iv) == 16 {
-			rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_AES_CBC_PAD, *s)
+			rawPlaintext, err = s.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_AES_CBC_PAD)
 			if err != nil {
-				rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_AES_CBC, *s)
+				rawPlaintext, err = s.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_AES_CBC)
 			}
 		} else if iv == nil {
-			rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_AES_ECB, *s)
+			rawPlaintext, err = s.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_AES_ECB)
 		}
 		// if err != nil {
 		// 	rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_AES_ECB_ENCRYPT_DATA)
 		// }
 	case constants.ALGORITHM_DES, constants.ALGORITHM_DES_OurUsers:
 		if len(iv) == 8 {
-			rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES_CBC_PAD, *s)
+			rawPlaintext, err = s.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES_CBC_PAD)
 			if err != nil {
-				rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES_CBC, *s)
+				rawPlaintext, err = s.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES_CBC)
 			}
 		} else if iv == nil {
-			rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES_ECB, *s)
+			rawPlaintext, err = s.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES_ECB)
 		}
 		// if err != nil {
 		// 	rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES_ECB_ENCRYPT_DATA)
 		// }
 	case constants.ALGORITHM_DES3, constants.ALGORITHM_DES3_OurUsers:
 		if len(iv) == 8 {
-			rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES3_CBC_PAD, *s)
+			rawPlaintext, err = s.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES3_CBC_PAD)
 			if err != nil {
-				rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES3_CBC, *s)
+				rawPlaintext, err = s.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES3_CBC)
 			}
 		} else if iv == nil {
-			rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES3_ECB, *s)
+			rawPlaintext, err = s.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES3_ECB)
 		}
 		// if err != nil {
 		// 	rawPlaintext, err = pkcs11mgr.DecryptKey(keyHandle, iv, cipher, pkcs11.CKM_DES3_ECB_ENCRYPT_DATA)
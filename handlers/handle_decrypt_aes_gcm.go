@@ -7,9 +7,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/models"
-	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
 	"github.com/networkgcorefullcode/ssm/safe"
 )
 
@@ -23,6 +23,7 @@ import (
 // @Success 200 {object} models.DecryptAESGCMResponse "Data decrypted successfully"
 // @Failure 400 {object} models.ProblemDetails "Invalid request"
 // @Failure 401 {object} models.ProblemDetails "Authentication failed (invalid tag)"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
 // @Failure 404 {object} models.ProblemDetails "Key not found"
 // @Failure 500 {object} models.ProblemDetails "Internal server error"
 // @Router /crypto/decrypt-aes-gcm [post]
@@ -30,8 +31,11 @@ func HandleDecryptAESGCM(c *gin.Context) {
 	logger.AppLog.Info("Processing AES-GCM decrypt request")
 
 	// init the session
-	s := mgr.GetSession()
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	var req models.DecryptAESGCMRequest
 	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
@@ -51,6 +55,9 @@ func HandleDecryptAESGCM(c *gin.Context) {
 		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailKeyLabelRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
 		return
 	}
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_DECRYPT, constants.ALGORITHM_AES256_GCM) {
+		return
+	}
 
 	if req.Cipher == "" {
 		logger.AppLog.Error("Ciphertext is required but was empty")
@@ -114,7 +121,7 @@ func HandleDecryptAESGCM(c *gin.Context) {
 	logger.AppLog.Debugf("Decoded ciphertext length: %d bytes, IV length: %d bytes, Tag length: %d bytes", len(cipher), len(iv), len(tag))
 
 	// Find key by label
-	keyHandle, err := pkcs11mgr.FindKey(req.KeyLabel, req.Id, *s)
+	keyHandle, err := s.FindKey(req.KeyLabel, req.Id)
 	if err != nil {
 		logger.AppLog.Errorf("Failed to find key by label '%s': %v", req.KeyLabel, err)
 		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusInternalServerError, c.Request.URL.Path)
@@ -127,7 +134,7 @@ func HandleDecryptAESGCM(c *gin.Context) {
 	ciphertextWithTag := append(cipher, tag...)
 
 	// Decrypt with AES-GCM
-	rawPlaintext, err := pkcs11mgr.DecryptKeyAesGCM(keyHandle, iv, ciphertextWithTag, aad, *s)
+	rawPlaintext, err := s.DecryptKeyAesGCM(keyHandle, iv, ciphertextWithTag, aad)
 	if err != nil {
 		logger.AppLog.Errorf("AES-GCM decryption failed (authentication may have failed): %v", err)
 		sendProblemDetails(c, ErrorTitleDecryptionFailed, "AES-GCM decryption failed. The authentication tag may be invalid or the data may have been tampered with.", ErrorCodeDecryptionError, http.StatusUnauthorized, c.Request.URL.Path)
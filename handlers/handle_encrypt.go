@@ -24,15 +24,17 @@ import (
 // @Param request body models.EncryptRequest true "Data to encrypt"
 // @Success 200 {object} models.EncryptResponse "Data encrypted successfully"
 // @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "The key is outside of its validity period or denied by the key usage policy"
 // @Failure 404 {object} models.ProblemDetails "Key not found"
 // @Failure 500 {object} models.ProblemDetails "Internal server error"
 // @Router /encrypt [post]
 func HandleEncrypt(c *gin.Context) {
 	// init the session
-	s := mgr.GetSession()
-	//
-
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	logger.AppLog.Info("Processing encrypt request")
 
@@ -43,6 +45,10 @@ func HandleEncrypt(c *gin.Context) {
 		return
 	}
 
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_ENCRYPT, int(req.EncryptionAlgorithm)) {
+		return
+	}
+
 	logger.AppLog.Infof("Decoding hex plaintext for key: %s", req.KeyLabel)
 	pt, err := hex.DecodeString(req.Plain)
 	if err != nil {
@@ -51,19 +57,23 @@ func HandleEncrypt(c *gin.Context) {
 		return
 	}
 
-	logger.AppLog.Infof("Finding key by label: %s", req.KeyLabel)
-	keyHandle, err := pkcs11mgr.FindKeyLabelReturnRandom(req.KeyLabel, *s)
+	logger.AppLog.Infof("Finding current key for label: %s", req.KeyLabel)
+	currentKey, err := pkcs11mgr.FindCurrentKey(s, req.KeyLabel)
 	if err != nil {
 		logger.AppLog.Errorf("Key not found: %s, error: %v", req.KeyLabel, err)
 		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
 		return
 	}
-	atrr, err := pkcs11mgr.GetObjectAttributes(keyHandle, *s)
+	keyHandle := currentKey.Handle
+	atrr, err := s.GetObjectAttributes(keyHandle)
 	if err != nil {
 		logger.AppLog.Errorf("Atributes not found: %s, error: %v", req.KeyLabel, err)
 		sendProblemDetails(c, ErrorTitleAttributesNotFound, ErrorDetailAttributesNotFound, ErrorCodeAttributesNotFound, http.StatusNotFound, c.Request.URL.Path)
 		return
 	}
+	if !checkKeyValidity(c, atrr.Validity) {
+		return
+	}
 
 	logger.AppLog.Info("Generating initialization vector (IV)")
 	var size int
@@ -85,10 +95,10 @@ func HandleEncrypt(c *gin.Context) {
 	var ciphertext []byte
 	switch req.EncryptionAlgorithm {
 	case constants.ALGORITHM_AES128_OurUsers, constants.ALGORITHM_AES256_OurUsers:
-		ciphertext, err = pkcs11mgr.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_AES_CBC_PAD, *s)
+		ciphertext, err = s.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_AES_CBC_PAD)
 		if err != nil {
 			logger.AppLog.Errorf("Encryption failed: %v", err)
-			ciphertext, err = pkcs11mgr.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_AES_CBC, *s)
+			ciphertext, err = s.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_AES_CBC)
 			if err != nil {
 				logger.AppLog.Errorf("Encryption failed: %v", err)
 				sendProblemDetails(c, ErrorTitleEncryptionFailed, ErrorDetailEncryptionError, ErrorCodeEncryptionError, http.StatusInternalServerError, c.Request.URL.Path)
@@ -96,10 +106,10 @@ func HandleEncrypt(c *gin.Context) {
 			}
 		}
 	case constants.ALGORITHM_DES3_OurUsers:
-		ciphertext, err = pkcs11mgr.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_DES3_CBC_PAD, *s)
+		ciphertext, err = s.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_DES3_CBC_PAD)
 		if err != nil {
 			logger.AppLog.Errorf("Encryption failed: %v", err)
-			ciphertext, err = pkcs11mgr.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_DES3_CBC, *s)
+			ciphertext, err = s.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_DES3_CBC)
 			if err != nil {
 				logger.AppLog.Errorf("Encryption failed: %v", err)
 				sendProblemDetails(c, ErrorTitleEncryptionFailed, ErrorDetailEncryptionError, ErrorCodeEncryptionError, http.StatusInternalServerError, c.Request.URL.Path)
@@ -107,10 +117,10 @@ func HandleEncrypt(c *gin.Context) {
 			}
 		}
 	case constants.ALGORITHM_DES_OurUsers:
-		ciphertext, err = pkcs11mgr.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_DES_CBC_PAD, *s)
+		ciphertext, err = s.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_DES_CBC_PAD)
 		if err != nil {
 			logger.AppLog.Errorf("Encryption failed: %v", err)
-			ciphertext, err = pkcs11mgr.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_DES_CBC, *s)
+			ciphertext, err = s.EncryptKey(keyHandle, iv, pt, pkcs11.CKM_DES_CBC)
 			if err != nil {
 				logger.AppLog.Errorf("Encryption failed: %v", err)
 				sendProblemDetails(c, ErrorTitleEncryptionFailed, ErrorDetailEncryptionError, ErrorCodeEncryptionError, http.StatusInternalServerError, c.Request.URL.Path)
@@ -129,7 +139,7 @@ func HandleEncrypt(c *gin.Context) {
 
 	ciphertextStr := hex.EncodeToString(ciphertext)
 	ivStr := hex.EncodeToString(iv)
-	ok := true
+	ok = true
 
 	timeCreated := time.Now()
 	timeUpdated := timeCreated
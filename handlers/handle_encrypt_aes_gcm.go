@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/models"
 	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
@@ -22,13 +23,17 @@ import (
 // @Param request body models.EncryptAESGCMRequest true "Data to encrypt with AES-GCM"
 // @Success 200 {object} models.EncryptAESGCMResponse "Data encrypted successfully"
 // @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "The key is outside of its validity period or denied by the key usage policy"
 // @Failure 404 {object} models.ProblemDetails "Key not found"
 // @Failure 500 {object} models.ProblemDetails "Internal server error"
 // @Router /crypto/encrypt-aes-gcm [post]
 func HandleEncryptAESGCM(c *gin.Context) {
 	// init the session
-	s := mgr.GetSession()
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	logger.AppLog.Info("Processing AES-GCM encrypt request")
 
@@ -45,6 +50,9 @@ func HandleEncryptAESGCM(c *gin.Context) {
 		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailKeyLabelRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
 		return
 	}
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_ENCRYPT, constants.ALGORITHM_AES256_GCM) {
+		return
+	}
 
 	if req.Plain == "" {
 		logger.AppLog.Error("Plaintext is required but was empty")
@@ -72,21 +80,25 @@ func HandleEncryptAESGCM(c *gin.Context) {
 		logger.AppLog.Infof("AAD provided: %d bytes", len(aad))
 	}
 
-	logger.AppLog.Infof("Finding key by label: %s", req.KeyLabel)
-	keyHandle, err := pkcs11mgr.FindKeyLabelReturnRandom(req.KeyLabel, *s)
+	logger.AppLog.Infof("Finding current key for label: %s", req.KeyLabel)
+	currentKey, err := pkcs11mgr.FindCurrentKey(s, req.KeyLabel)
 	if err != nil {
 		logger.AppLog.Errorf("Key not found: %s, error: %v", req.KeyLabel, err)
 		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
 		return
 	}
+	keyHandle := currentKey.Handle
 
 	// Get key attributes
-	attr, err := pkcs11mgr.GetObjectAttributes(keyHandle, *s)
+	attr, err := s.GetObjectAttributes(keyHandle)
 	if err != nil {
 		logger.AppLog.Errorf("Attributes not found: %s, error: %v", req.KeyLabel, err)
 		sendProblemDetails(c, ErrorTitleAttributesNotFound, ErrorDetailAttributesNotFound, ErrorCodeAttributesNotFound, http.StatusNotFound, c.Request.URL.Path)
 		return
 	}
+	if !checkKeyValidity(c, attr.Validity) {
+		return
+	}
 
 	logger.AppLog.Info("Generating initialization vector (IV/nonce) for GCM - 12 bytes recommended")
 	iv := make([]byte, 12) // 12 bytes (96 bits) is recommended for GCM
@@ -99,7 +111,7 @@ func HandleEncryptAESGCM(c *gin.Context) {
 	logger.AppLog.Info("Encrypting data with AES-GCM")
 
 	// Encrypt with AES-GCM (returns ciphertext + authentication tag)
-	ciphertextWithTag, err := pkcs11mgr.EncryptKeyAesGCM(keyHandle, iv, pt, aad, *s)
+	ciphertextWithTag, err := s.EncryptKeyAesGCM(keyHandle, iv, pt, aad)
 	if err != nil {
 		logger.AppLog.Errorf("AES-GCM encryption failed: %v", err)
 		sendProblemDetails(c, ErrorTitleEncryptionFailed, ErrorDetailEncryptionError, ErrorCodeEncryptionError, http.StatusInternalServerError, c.Request.URL.Path)
@@ -0,0 +1,50 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+func TestEncryptDecryptWithMemoryProvider(t *testing.T) {
+	f := ssmtest.New(t)
+
+	var genResp models.GenAESKeyResponse
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, &genResp)
+
+	plain := "00112233445566778899aabbccddeeff"
+	var encResp models.EncryptResponse
+	f.DoJSON("/crypto/encrypt", models.EncryptRequest{
+		KeyLabel:            constants.LABEL_ENCRYPTION_KEY_AES256,
+		Plain:               plain,
+		EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers,
+	}, http.StatusCreated, &encResp)
+
+	var decResp models.DecryptResponse
+	f.DoJSON("/crypto/decrypt", models.DecryptRequest{
+		KeyLabel:            constants.LABEL_ENCRYPTION_KEY_AES256,
+		Cipher:              encResp.Cipher,
+		Iv:                  encResp.Iv,
+		Id:                  encResp.Id,
+		EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers,
+	}, http.StatusOK, &decResp)
+
+	if decResp.Plain != plain {
+		t.Fatalf("decrypted %q, want %q", decResp.Plain, plain)
+	}
+}
+
+func TestDecryptUnknownKeyWithMemoryProvider(t *testing.T) {
+	f := ssmtest.New(t)
+
+	f.DoJSON("/crypto/decrypt", models.DecryptRequest{
+		KeyLabel:            constants.LABEL_ENCRYPTION_KEY_AES256,
+		Cipher:              "00112233445566778899aabbccddeeff",
+		Iv:                  "00112233445566778899aabbccddeeff",
+		Id:                  7,
+		EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers,
+	}, http.StatusInternalServerError, nil)
+}
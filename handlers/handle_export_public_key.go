@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+// Public key export formats
+const (
+	publicKeyFormatPEM = "pem"
+	publicKeyFormatJWK = "jwk"
+)
+
+// HandleExportPublicKey handles public key export requests
+// @Summary Export public key
+// @Description Returns the public key of an RSA or EC key pair as PEM and/or JWK
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.ExportPublicKeyRequest true "Key pair label and format"
+// @Success 200 {object} models.ExportPublicKeyResponse "Public key exported successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/public-key [post]
+func HandleExportPublicKey(c *gin.Context) {
+	logger.AppLog.Info("Processing public key export request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.ExportPublicKeyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.KeyLabel == "" {
+		logger.AppLog.Error("Key label is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailKeyLabelRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if _, reserved := constants.LabelFamilyMap[req.KeyLabel]; reserved {
+		logger.AppLog.Errorf("Key label %s is reserved", req.KeyLabel)
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailReservedKeyLabel, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_READ, 0) {
+		return
+	}
+
+	if req.Format != "" && req.Format != publicKeyFormatPEM && req.Format != publicKeyFormatJWK {
+		logger.AppLog.Errorf("Unsupported public key format: %s", req.Format)
+		sendProblemDetails(c, ErrorTitleValidationError, "The format must be pem or jwk", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	handle, err := s.FindPublicKey(req.KeyLabel)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to find public key by label '%s': %v", req.KeyLabel, err)
+		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return
+	}
+
+	pub, err := s.GetPublicKey(handle)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to read public key '%s': %v", req.KeyLabel, err)
+		sendProblemDetails(c, ErrorTitleAttributesNotFound, ErrorDetailAttributesNotFound, ErrorCodeAttributesNotFound, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	resp := models.ExportPublicKeyResponse{
+		KeyLabel: req.KeyLabel,
+		KeyType:  publicKeyType(pub),
+	}
+
+	if req.Format != publicKeyFormatJWK {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			logger.AppLog.Errorf("Failed to encode public key '%s': %v", req.KeyLabel, err)
+			sendProblemDetails(c, ErrorTitleInternalServerError, "Error encoding the public key", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+			return
+		}
+		resp.Pem = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+
+	if req.Format != publicKeyFormatPEM {
+		resp.Jwk, err = publicKeyToJWK(pub, req.KeyLabel, "")
+		if err != nil {
+			logger.AppLog.Errorf("Failed to encode public key '%s' as JWK: %v", req.KeyLabel, err)
+			sendProblemDetails(c, ErrorTitleInternalServerError, "Error encoding the public key", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+			return
+		}
+	}
+
+	logger.AppLog.Infof("Public key '%s' exported", req.KeyLabel)
+	c.JSON(http.StatusOK, resp)
+}
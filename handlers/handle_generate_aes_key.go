@@ -8,7 +8,6 @@ import (
 	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/models"
-	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
 )
 
 // HandleGenerateAESKey maneja las peticiones de generación de claves AES
@@ -20,12 +19,16 @@ import (
 // @Param request body models.GenAESKeyRequest true "Parámetros para generar la clave AES"
 // @Success 201 {object} models.GenAESKeyResponse "Clave AES generada exitosamente"
 // @Failure 400 {object} models.ProblemDetails "Petición inválida"
+// @Failure 403 {object} models.ProblemDetails "Operación denegada por la política de uso de claves"
 // @Failure 500 {object} models.ProblemDetails "Error interno del servidor"
 // @Router /generate-aes-key [post]
 func HandleGenerateAESKey(c *gin.Context) {
 	// init the session
-	s := mgr.GetSession()
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	logger.AppLog.Info("Processing AES key generation request")
 
@@ -42,6 +45,11 @@ func HandleGenerateAESKey(c *gin.Context) {
 		return
 	}
 
+	validity, ok := parseKeyValidity(c, req.StartDate, req.EndDate)
+	if !ok {
+		return
+	}
+
 	if req.Bits != 128 && req.Bits != 256 {
 		logger.AppLog.Errorf("Invalid key size: %d bits", req.Bits)
 		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailInvalidKeySize, ErrorCodeInvalidKeySize, http.StatusBadRequest, c.Request.URL.Path)
@@ -57,13 +65,21 @@ func HandleGenerateAESKey(c *gin.Context) {
 		label = constants.LABEL_ENCRYPTION_KEY_AES256
 	}
 
-	handle, id, err := pkcs11mgr.GenerateAESKey(label, req.Id, int(req.Bits), *s)
+	if !authorizeKeyOperation(c, label, constants.KEY_OPERATION_GENERATE, 0) {
+		return
+	}
+
+	handle, id, err := s.GenerateAESKey(label, req.Id, int(req.Bits))
 	if err != nil {
 		logger.AppLog.Errorf("AES key generation failed: %v", err)
 		sendProblemDetails(c, ErrorTitleKeyGenerationFailed, ErrorDetailKeyGenerationError, ErrorCodeKeyGenerationError, http.StatusInternalServerError, c.Request.URL.Path)
 		return
 	}
 
+	if !setKeyMetadata(c, s, handle, label, id, validity) {
+		return
+	}
+
 	logger.AppLog.Infof("AES key generated successfully - Handle: %d", handle)
 
 	resp := models.GenAESKeyResponse{
@@ -71,6 +87,7 @@ func HandleGenerateAESKey(c *gin.Context) {
 		Id:     id,
 		Bits:   req.Bits,
 	}
+	resp.Kcv, resp.KcvCmac = keyCheckValues(s, handle)
 
 	c.JSON(http.StatusCreated, resp)
 }
@@ -8,7 +8,6 @@ import (
 	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/models"
-	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
 )
 
 // HandleGenerateDES3Key maneja las peticiones de generación de claves DES3
@@ -20,14 +19,18 @@ import (
 // @Param request body models.GenDES3KeyRequest true "Parámetros para generar la clave DES3"
 // @Success 201 {object} models.GenDES3KeyResponse "Clave DES3 generada exitosamente"
 // @Failure 400 {object} models.ProblemDetails "Petición inválida"
+// @Failure 403 {object} models.ProblemDetails "Operación denegada por la política de uso de claves"
 // @Failure 500 {object} models.ProblemDetails "Error interno del servidor"
 // @Router /generate-des3-key [post]
 func HandleGenerateDES3Key(c *gin.Context) {
 	logger.AppLog.Info("Processing DES3 key generation request")
 
 	// init the session
-	s := mgr.GetSession()
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	var req models.GenDES3KeyRequest
 	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
@@ -42,20 +45,34 @@ func HandleGenerateDES3Key(c *gin.Context) {
 		return
 	}
 
+	validity, ok := parseKeyValidity(c, req.StartDate, req.EndDate)
+	if !ok {
+		return
+	}
+
 	logger.AppLog.Infof("Generating DES3 key , ID: %d", req.Id)
-	handle, id, err := pkcs11mgr.GenerateDES3Key(constants.LABEL_ENCRYPTION_KEY_DES3, req.Id, *s)
+	if !authorizeKeyOperation(c, constants.LABEL_ENCRYPTION_KEY_DES3, constants.KEY_OPERATION_GENERATE, 0) {
+		return
+	}
+
+	handle, id, err := s.GenerateDES3Key(constants.LABEL_ENCRYPTION_KEY_DES3, req.Id)
 	if err != nil {
 		logger.AppLog.Errorf("DES3 key generation failed: %v", err)
 		sendProblemDetails(c, ErrorTitleKeyGenerationFailed, ErrorDetailKeyGenerationError, ErrorCodeKeyGenerationError, http.StatusInternalServerError, c.Request.URL.Path)
 		return
 	}
 
+	if !setKeyMetadata(c, s, handle, constants.LABEL_ENCRYPTION_KEY_DES3, id, validity) {
+		return
+	}
+
 	logger.AppLog.Infof("DES3 key generated successfully - Handle: %d", handle)
 
 	resp := models.GenDES3KeyResponse{
 		Handle: int32(handle),
 		Id:     id,
 	}
+	resp.Kcv, _ = keyCheckValues(s, handle)
 
 	c.JSON(http.StatusCreated, resp)
 }
@@ -8,7 +8,6 @@ import (
 	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/models"
-	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
 )
 
 // HandleGenerateDESKey maneja las peticiones de generación de claves DES
@@ -20,14 +19,18 @@ import (
 // @Param request body models.GenDESKeyRequest true "Parámetros para generar la clave DES"
 // @Success 201 {object} models.GenDESKeyResponse "Clave DES generada exitosamente"
 // @Failure 400 {object} models.ProblemDetails "Petición inválida"
+// @Failure 403 {object} models.ProblemDetails "Operación denegada por la política de uso de claves"
 // @Failure 500 {object} models.ProblemDetails "Error interno del servidor"
 // @Router /generate-des-key [post]
 func HandleGenerateDESKey(c *gin.Context) {
 	logger.AppLog.Info("Processing DES key generation request")
 
 	// init the session
-	s := mgr.GetSession()
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	var req models.GenDESKeyRequest
 	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
@@ -41,20 +44,34 @@ func HandleGenerateDESKey(c *gin.Context) {
 		return
 	}
 
+	validity, ok := parseKeyValidity(c, req.StartDate, req.EndDate)
+	if !ok {
+		return
+	}
+
 	logger.AppLog.Infof("Generating DES key - ID: %d", req.Id)
-	handle, id, err := pkcs11mgr.GenerateDESKey(constants.LABEL_ENCRYPTION_KEY_DES, req.Id, *s)
+	if !authorizeKeyOperation(c, constants.LABEL_ENCRYPTION_KEY_DES, constants.KEY_OPERATION_GENERATE, 0) {
+		return
+	}
+
+	handle, id, err := s.GenerateDESKey(constants.LABEL_ENCRYPTION_KEY_DES, req.Id)
 	if err != nil {
 		logger.AppLog.Errorf("DES key generation failed: %v", err)
 		sendProblemDetails(c, ErrorTitleKeyGenerationFailed, ErrorDetailKeyGenerationError, ErrorCodeKeyGenerationError, http.StatusInternalServerError, c.Request.URL.Path)
 		return
 	}
 
+	if !setKeyMetadata(c, s, handle, constants.LABEL_ENCRYPTION_KEY_DES, id, validity) {
+		return
+	}
+
 	logger.AppLog.Infof("DES key generated successfully - Handle: %d", handle)
 
 	resp := models.GenDESKeyResponse{
 		Handle: int32(handle),
 		Id:     id,
 	}
+	resp.Kcv, _ = keyCheckValues(s, handle)
 
 	c.JSON(http.StatusCreated, resp)
 }
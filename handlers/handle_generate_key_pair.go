@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+// checkKeyPairLabel checks that a new key pair label is set, is not one of the
+// SSM labels, may be generated by the key usage policy and is not used yet, on
+// failure it writes the problem details and returns false
+func checkKeyPairLabel(c *gin.Context, s pkcs11mgr.KeyStore, label string) bool {
+	if label == "" {
+		logger.AppLog.Error("Key label is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailKeyLabelRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return false
+	}
+	if _, reserved := constants.LabelFamilyMap[label]; reserved {
+		logger.AppLog.Errorf("Key label %s is reserved", label)
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailReservedKeyLabel, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return false
+	}
+	if !authorizeKeyOperation(c, label, constants.KEY_OPERATION_GENERATE, 0) {
+		return false
+	}
+	if _, err := s.FindPrivateKey(label); err == nil {
+		logger.AppLog.Errorf("Key pair %s already exists", label)
+		sendProblemDetails(c, ErrorTitleConflict, ErrorDetailKeyAlreadyExists, ErrorCodeKeyAlreadyExists, http.StatusConflict, c.Request.URL.Path)
+		return false
+	}
+	return true
+}
+
+// HandleGenerateRSAKey handles RSA key pair generation requests
+// @Summary Generate RSA key pair
+// @Description Generates an RSA signing key pair in the HSM, the private key never leaves it
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.GenRSAKeyRequest true "Label and size of the key pair"
+// @Success 201 {object} models.GenKeyPairResponse "Key pair generated successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 409 {object} models.ProblemDetails "Key already exists"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/generate-rsa-key [post]
+func HandleGenerateRSAKey(c *gin.Context) {
+	logger.AppLog.Info("Processing RSA key pair generation request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.GenRSAKeyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.Bits == 0 {
+		req.Bits = 2048
+	}
+	if !slices.Contains(pkcs11mgr.RSAKeySizes, int(req.Bits)) {
+		logger.AppLog.Errorf("Invalid RSA key size: %d bits", req.Bits)
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailInvalidKeySize, ErrorCodeInvalidKeySize, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if !checkKeyPairLabel(c, s, req.KeyLabel) {
+		return
+	}
+
+	pub, priv, err := s.GenerateRSAKeyPair(req.KeyLabel, int(req.Bits))
+	if err != nil {
+		logger.AppLog.Errorf("RSA key pair generation failed: %v", err)
+		sendProblemDetails(c, ErrorTitleKeyGenerationFailed, ErrorDetailKeyGenerationError, ErrorCodeKeyGenerationError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("RSA key pair %s generated - Public: %d, Private: %d", req.KeyLabel, pub, priv)
+
+	resp := models.GenKeyPairResponse{
+		KeyLabel:      req.KeyLabel,
+		KeyType:       "RSA",
+		Bits:          req.Bits,
+		PublicHandle:  int32(pub),
+		PrivateHandle: int32(priv),
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// HandleGenerateECKey handles EC key pair generation requests
+// @Summary Generate EC key pair
+// @Description Generates an EC signing key pair in the HSM, the private key never leaves it
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.GenECKeyRequest true "Label and curve of the key pair"
+// @Success 201 {object} models.GenKeyPairResponse "Key pair generated successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 409 {object} models.ProblemDetails "Key already exists"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/generate-ec-key [post]
+func HandleGenerateECKey(c *gin.Context) {
+	logger.AppLog.Info("Processing EC key pair generation request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.GenECKeyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.Curve == "" {
+		req.Curve = pkcs11mgr.EC_CURVE_P256
+	}
+	if req.Curve != pkcs11mgr.EC_CURVE_P256 && req.Curve != pkcs11mgr.EC_CURVE_P384 {
+		logger.AppLog.Errorf("Unsupported curve: %s", req.Curve)
+		sendProblemDetails(c, ErrorTitleValidationError, "The curve must be P-256 or P-384", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if !checkKeyPairLabel(c, s, req.KeyLabel) {
+		return
+	}
+
+	pub, priv, err := s.GenerateECKeyPair(req.KeyLabel, req.Curve)
+	if err != nil {
+		logger.AppLog.Errorf("EC key pair generation failed: %v", err)
+		sendProblemDetails(c, ErrorTitleKeyGenerationFailed, ErrorDetailKeyGenerationError, ErrorCodeKeyGenerationError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("EC key pair %s generated - Public: %d, Private: %d", req.KeyLabel, pub, priv)
+
+	resp := models.GenKeyPairResponse{
+		KeyLabel:      req.KeyLabel,
+		KeyType:       "EC",
+		Curve:         req.Curve,
+		PublicHandle:  int32(pub),
+		PrivateHandle: int32(priv),
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
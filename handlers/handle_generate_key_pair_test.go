@@ -0,0 +1,24 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/handlers"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+func TestGenerateKeyPairPolicy(t *testing.T) {
+	f := ssmtest.New(t)
+
+	restrictToUDM(constants.LABEL_FAMILY_APPLICATION, constants.KEY_OPERATION_GENERATE)
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/generate-rsa-key", handlers.HandleGenerateRSAKey, models.GenRSAKeyRequest{KeyLabel: "NF_RSA"}), http.StatusForbidden)
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/generate-ec-key", handlers.HandleGenerateECKey, models.GenECKeyRequest{KeyLabel: "NF_EC"}), http.StatusForbidden)
+
+	// the default policy lets the webconsole role generate the application key pairs
+	factory.SsmConfig.Configuration.KeyPolicies = nil
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/generate-ec-key", handlers.HandleGenerateECKey, models.GenECKeyRequest{KeyLabel: "NF_EC"}), http.StatusCreated)
+}
@@ -7,12 +7,12 @@ import (
 	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/models"
-	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/server/middleware"
 )
 
 // HandleGetAllKeys handles requests to get all keys from HSM
 // @Summary Get all keys
-// @Description Retrieves all keys from the HSM grouped by label
+// @Description Retrieves all keys from the HSM grouped by label, the labels whose key usage policy denies the read are left out
 // @Tags Key Management
 // @Accept json
 // @Produce json
@@ -22,12 +22,15 @@ import (
 func HandleGetAllKeys(c *gin.Context) {
 	logger.AppLog.Info("Processing get all keys request")
 	//// init the session
-	s := mgr.GetSession()
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	// Find all keys grouped by label
 	logger.AppLog.Info("Searching all keys in HSM")
-	keysByLabel, err := pkcs11mgr.FindAllKeys(*s)
+	keysByLabel, err := s.FindAllKeys()
 	if err != nil && err.Error() == constants.ERROR_STRING_KEY_NOT_FOUND {
 		// Prepare the response
 		resp := models.GetAllKeysResponse{}
@@ -47,31 +50,30 @@ func HandleGetAllKeys(c *gin.Context) {
 	resp := models.GetAllKeysResponse{
 		KeysByLabel: make(map[string][]models.DataKeyInfo),
 		TotalKeys:   0,
-		TotalLabels: int32(len(keysByLabel)),
 	}
 
 	// Process each label and its keys
+	role := middleware.ServiceRole(c)
 	for label, handles := range keysByLabel {
-		logger.AppLog.Infof("Processing label: %s with %d keys", label, len(handles))
-
-		objAttrs, err := pkcs11mgr.GetValuesForObjects(handles, *s)
-		if err != nil {
-			logger.AppLog.Errorf("Failed to get object attributes for label %s: %v", label, err)
-			sendProblemDetails(c, ErrorTitleAttributesNotFound, "Error getting key attributes", "KEY_GET_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
-			return
+		if err := checkKeyPolicy(role, label, constants.KEY_OPERATION_READ, 0); err != nil {
+			logger.AppLog.Debugf("Leaving out label %s: %v", label, err)
+			continue
 		}
+		logger.AppLog.Infof("Processing label: %s with %d keys", label, len(handles))
 
-		// Convert to DataKeyInfo
-		keysInfo := make([]models.DataKeyInfo, 0, len(objAttrs))
-		for _, attr := range objAttrs {
-			keysInfo = append(keysInfo, models.DataKeyInfo{
-				Handle: attr.Handle,
-				Id:     attr.Id,
-			})
+		keysInfo := make([]models.DataKeyInfo, 0, len(handles))
+		for _, handle := range handles {
+			info, err := dataKeyInfo(s, handle)
+			if err != nil {
+				logger.AppLog.Errorf("Failed to get attributes for handle %d: %v", handle, err)
+				continue
+			}
+			keysInfo = append(keysInfo, info)
 		}
 
 		resp.KeysByLabel[label] = keysInfo
 		resp.TotalKeys += int32(len(keysInfo))
+		resp.TotalLabels++
 	}
 
 	logger.AppLog.Infof("Successfully retrieved %d keys across %d labels", resp.TotalKeys, resp.TotalLabels)
@@ -0,0 +1,47 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/handlers"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+func TestGetAllKeysPolicy(t *testing.T) {
+	f := ssmtest.New(t)
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, nil)
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyValue: "000102030405060708090a0b0c0d0e0f", KeyType: constants.TYPE_AES}, http.StatusOK, nil)
+	if _, _, err := f.KeyStore.GenerateAESKey(constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256, 1, 256); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func() models.GetAllKeysResponse {
+		t.Helper()
+		w := f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/get-all-keys", handlers.HandleGetAllKeys, nil)
+		checkStatus(t, w, http.StatusOK)
+		var resp models.GetAllKeysResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	all := list()
+	if len(all.KeysByLabel[constants.LABEL_K4_KEY_AES]) != 1 || len(all.KeysByLabel[constants.LABEL_ENCRYPTION_KEY_AES256]) != 1 {
+		t.Fatalf("get-all-keys = %+v", all)
+	}
+	// the internal keys are only used by SSM itself
+	if _, ok := all.KeysByLabel[constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256]; ok {
+		t.Fatalf("get-all-keys lists the internal keys: %+v", all)
+	}
+
+	// the labels the role may not read are left out
+	restrictToUDM(constants.LABEL_FAMILY_K4, constants.KEY_OPERATION_READ)
+	denied := list()
+	if _, ok := denied.KeysByLabel[constants.LABEL_K4_KEY_AES]; ok || len(denied.KeysByLabel[constants.LABEL_ENCRYPTION_KEY_AES256]) != 1 || denied.TotalLabels != all.TotalLabels-1 {
+		t.Fatalf("get-all-keys with a denied K4 family = %+v", denied)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/models"
-	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
 )
 
 // HandleGetDataKey
@@ -20,13 +19,17 @@ import (
 // @Param request body models.StoreKeyRequest true "Key data to store"
 // @Success 200 {object} models.StoreKeyResponse "Key stored successfully"
 // @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
 // @Failure 500 {object} models.ProblemDetails "Internal server error"
 // @Router /store-key [post]
 func HandleGetDataKey(c *gin.Context) {
 	logger.AppLog.Info("Processing get data key request")
 	// init the session
-	s := mgr.GetSession()
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	var req models.GetKeyRequest
 	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
@@ -35,10 +38,14 @@ func HandleGetDataKey(c *gin.Context) {
 		return
 	}
 
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_READ, 0) {
+		return
+	}
+
 	label := req.KeyLabel
 
 	logger.AppLog.Infof("Searching key in HSM - using the Label: %s", label)
-	handle, err := pkcs11mgr.FindKey(label, req.Id, *s)
+	handle, err := s.FindKey(label, req.Id)
 	if err != nil && err.Error() == constants.ERROR_STRING_KEY_NOT_FOUND {
 		resp := models.GetKeyResponse{}
 		logger.AppLog.Info("Not key found")
@@ -53,7 +60,7 @@ func HandleGetDataKey(c *gin.Context) {
 
 	logger.AppLog.Info("Key get successfully")
 
-	objAtr, err := pkcs11mgr.GetObjectAttributes(handle, *s)
+	info, err := dataKeyInfo(s, handle)
 	if err != nil {
 		logger.AppLog.Errorf("Failed to get object attribute: %v", err)
 		sendProblemDetails(c, "Key get Failed", "Error getting key attribute", "KEY_GET_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
@@ -61,10 +68,7 @@ func HandleGetDataKey(c *gin.Context) {
 	}
 
 	resp := models.GetKeyResponse{
-		KeyInfo: models.DataKeyInfo{
-			Handle: objAtr.Handle,
-			Id:     objAtr.Id,
-		},
+		KeyInfo: info,
 	}
 
 	c.JSON(http.StatusOK, resp)
@@ -0,0 +1,130 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+func TestKeyCheckValues(t *testing.T) {
+	f := ssmtest.New(t)
+	const k4 = "000102030405060708090a0b0c0d0e0f"
+
+	// a K4 that does not match the KCV of the SIM vendor is not stored
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyValue: k4, KeyType: constants.TYPE_AES, ExpectedKcv: "ebc958"}, http.StatusBadRequest, nil)
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyValue: k4, KeyType: constants.TYPE_AES, ExpectedKcv: "c6a1"}, http.StatusBadRequest, nil)
+	var missing models.GetKeyResponse
+	f.DoJSON("/crypto/get-key", models.GetKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1}, http.StatusOK, &missing)
+	if missing.KeyInfo.Handle != 0 {
+		t.Fatalf("the rejected key was stored with handle %d", missing.KeyInfo.Handle)
+	}
+
+	var stored models.StoreKeyResponse
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyValue: k4, KeyType: constants.TYPE_AES, ExpectedKcv: "c6a13b"}, http.StatusOK, &stored)
+	if stored.Kcv != "c6a13b" || stored.KcvCmac != "be7ed6ae78" {
+		t.Fatalf("store-key check values = %s %s", stored.Kcv, stored.KcvCmac)
+	}
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 2, KeyValue: k4, KeyType: constants.TYPE_AES, ExpectedKcv: "BE7ED6AE78"}, http.StatusOK, nil)
+
+	// the stored K4 only decrypts, the token still reports its KCV
+	var k4Info models.GetKeyResponse
+	f.DoJSON("/crypto/get-key", models.GetKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1}, http.StatusOK, &k4Info)
+	if k4Info.KeyInfo.Kcv != "c6a13b" || k4Info.KeyInfo.KcvCmac != "" {
+		t.Fatalf("get-key check values = %q %q", k4Info.KeyInfo.Kcv, k4Info.KeyInfo.KcvCmac)
+	}
+
+	var aesKey models.GenAESKeyResponse
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, &aesKey)
+	if len(aesKey.Kcv) != 6 || len(aesKey.KcvCmac) != 10 {
+		t.Fatalf("generate-aes-key check values = %q %q", aesKey.Kcv, aesKey.KcvCmac)
+	}
+	var desKey models.GenDESKeyResponse
+	f.DoJSON("/crypto/generate-des-key", models.GenDESKeyRequest{Id: 1}, http.StatusCreated, &desKey)
+	if len(desKey.Kcv) != 6 {
+		t.Fatalf("generate-des-key check value = %q", desKey.Kcv)
+	}
+
+	var all models.GetAllKeysResponse
+	f.DoJSON("/crypto/get-all-keys", nil, http.StatusOK, &all)
+	checked := 0
+	for _, info := range append(all.KeysByLabel[constants.LABEL_ENCRYPTION_KEY_AES256], all.KeysByLabel[constants.LABEL_ENCRYPTION_KEY_DES]...) {
+		switch info.Handle {
+		case aesKey.Handle:
+			if info.Kcv != aesKey.Kcv || info.KcvCmac != aesKey.KcvCmac {
+				t.Fatalf("get-all-keys check values = %s %s, want %s %s", info.Kcv, info.KcvCmac, aesKey.Kcv, aesKey.KcvCmac)
+			}
+			checked++
+		case desKey.Handle:
+			if info.Kcv != desKey.Kcv {
+				t.Fatalf("get-all-keys check value = %s, want %s", info.Kcv, desKey.Kcv)
+			}
+			checked++
+		}
+	}
+	if checked != 2 {
+		t.Fatalf("get-all-keys listed %d of the generated keys", checked)
+	}
+}
+
+func TestKeyMetadataAndValidity(t *testing.T) {
+	f := ssmtest.New(t)
+	today := time.Now().UTC()
+	tomorrow := today.AddDate(0, 0, 1).Format(time.DateOnly)
+	yesterday := today.AddDate(0, 0, -1).Format(time.DateOnly)
+	encrypt := func(label string, algorithm int32, wantStatus int) {
+		f.DoJSON("/crypto/encrypt", models.EncryptRequest{KeyLabel: label, Plain: "00112233445566778899aabbccddeeff", EncryptionAlgorithm: algorithm}, wantStatus, nil)
+	}
+
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256, StartDate: "2026-13-01"}, http.StatusBadRequest, nil)
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256, StartDate: tomorrow, EndDate: yesterday}, http.StatusBadRequest, nil)
+
+	var aesKey models.GenAESKeyResponse
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256, StartDate: tomorrow}, http.StatusCreated, &aesKey)
+	encrypt(constants.LABEL_ENCRYPTION_KEY_AES256, constants.ALGORITHM_AES256_OurUsers, http.StatusForbidden)
+
+	var info models.GetKeyResponse
+	f.DoJSON("/crypto/get-key", models.GetKeyRequest{KeyLabel: constants.LABEL_ENCRYPTION_KEY_AES256, Id: 1}, http.StatusOK, &info)
+	key := info.KeyInfo
+	if key.Handle != aesKey.Handle || key.KeyType != constants.TYPE_AES || key.SizeBits != 256 {
+		t.Fatalf("get-key = %+v", key)
+	}
+	if !key.Encrypt || !key.Decrypt || key.Wrap || key.Unwrap || !key.Sensitive {
+		t.Fatalf("get-key usage flags = %+v", key)
+	}
+	if key.StartDate != tomorrow || key.EndDate != "" {
+		t.Fatalf("get-key validity = %q %q, want %q", key.StartDate, key.EndDate, tomorrow)
+	}
+	createdAt, err := time.Parse(time.RFC3339, key.CreatedAt)
+	if err != nil || createdAt.Before(today.Add(-time.Minute)) {
+		t.Fatalf("get-key created_at = %q: %v", key.CreatedAt, err)
+	}
+
+	// the end date is the last day the key encrypts
+	f.DoJSON("/crypto/generate-des-key", models.GenDESKeyRequest{Id: 1, EndDate: yesterday}, http.StatusCreated, nil)
+	encrypt(constants.LABEL_ENCRYPTION_KEY_DES, constants.ALGORITHM_DES_OurUsers, http.StatusForbidden)
+	f.DoJSON("/crypto/generate-des3-key", models.GenDES3KeyRequest{Id: 1, StartDate: yesterday, EndDate: today.Format(time.DateOnly)}, http.StatusCreated, nil)
+	encrypt(constants.LABEL_ENCRYPTION_KEY_DES3, constants.ALGORITHM_DES3_OurUsers, http.StatusCreated)
+
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyValue: "000102030405060708090a0b0c0d0e0f", KeyType: constants.TYPE_AES, EndDate: "2030-06-30"}, http.StatusOK, nil)
+	var keys models.GetDataKeysResponse
+	f.DoJSON("/crypto/get-data-keys", models.GetDataKeysRequest{KeyLabel: constants.LABEL_K4_KEY_AES}, http.StatusOK, &keys)
+	if len(keys.Keys) != 1 {
+		t.Fatalf("get-data-keys listed %d keys", len(keys.Keys))
+	}
+	k4 := keys.Keys[0]
+	if k4.SizeBits != 128 || k4.Encrypt || !k4.Decrypt || k4.Extractable || k4.EndDate != "2030-06-30" || k4.CreatedAt == "" || k4.Kcv != "c6a13b" {
+		t.Fatalf("get-data-keys = %+v", k4)
+	}
+
+	// a decrypt only key past its end date was never retired
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 2, KeyValue: "101112131415161718191a1b1c1d1e1f", KeyType: constants.TYPE_AES, EndDate: yesterday}, http.StatusOK, nil)
+	var purge models.PurgeRetiredKeysResponse
+	f.DoJSON("/crypto/purge-retired-keys", models.PurgeRetiredKeysRequest{KeyLabel: constants.LABEL_K4_KEY_AES}, http.StatusOK, &purge)
+	if len(purge.DestroyedIds) != 0 {
+		t.Fatalf("purged versions %v by their end date", purge.DestroyedIds)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/models"
-	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
 )
 
 // HandleGetDataKeys
@@ -20,13 +19,17 @@ import (
 // @Param request body models.StoreKeyRequest true "Key data to store"
 // @Success 200 {object} models.StoreKeyResponse "Key stored successfully"
 // @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
 // @Failure 500 {object} models.ProblemDetails "Internal server error"
 // @Router /store-key [post]
 func HandleGetDataKeys(c *gin.Context) {
 	logger.AppLog.Info("Processing store key request")
 	// init the session
-	s := mgr.GetSession()
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	var req models.GetDataKeysRequest
 	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
@@ -35,10 +38,14 @@ func HandleGetDataKeys(c *gin.Context) {
 		return
 	}
 
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_READ, 0) {
+		return
+	}
+
 	label := req.KeyLabel
 
 	logger.AppLog.Infof("Searching key in HSM - using the Label: %s", label)
-	handles, err := pkcs11mgr.FindKeysLabel(label, *s)
+	handles, err := s.FindKeysLabel(label)
 	if err != nil && err.Error() == constants.ERROR_STRING_KEY_NOT_FOUND {
 		resp := models.GetDataKeysResponse{
 			Keys: make([]models.DataKeyInfo, 0),
@@ -55,21 +62,16 @@ func HandleGetDataKeys(c *gin.Context) {
 
 	logger.AppLog.Info("Keys get successfully")
 
-	objAtr, err := pkcs11mgr.GetValuesForObjects(handles, *s)
-	if err != nil {
-		logger.AppLog.Errorf("Failed to get object attributes: %v", err)
-		sendProblemDetails(c, "Key get Failed", "Error getting key attributes", "KEY_GET_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
-		return
-	}
-
 	resp := models.GetDataKeysResponse{
-		Keys: make([]models.DataKeyInfo, 0, len(objAtr)),
+		Keys: make([]models.DataKeyInfo, 0, len(handles)),
 	}
-	for _, attr := range objAtr {
-		resp.Keys = append(resp.Keys, models.DataKeyInfo{
-			Handle: attr.Handle,
-			Id:     attr.Id,
-		})
+	for _, handle := range handles {
+		info, err := dataKeyInfo(s, handle)
+		if err != nil {
+			logger.AppLog.Errorf("Failed to get attributes for handle %d: %v", handle, err)
+			continue
+		}
+		resp.Keys = append(resp.Keys, info)
 	}
 
 	c.JSON(http.StatusOK, resp)
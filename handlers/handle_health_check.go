@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
 )
 
 // HandleHealthCheck handles health check requests
@@ -26,6 +27,11 @@ func HandleHealthCheck(c *gin.Context) {
 		Message: "Service is healthy",
 	}
 
+	// Report the session pool metrics when the provider keeps a pool
+	if reporter, ok := provider.(pkcs11mgr.SessionPoolReporter); ok {
+		response.Sessions = sessionPoolStats(reporter.Stats())
+	}
+
 	// Set response headers
 	c.Header("Content-Type", "application/json")
 	c.Status(http.StatusOK)
@@ -39,3 +45,23 @@ func HandleHealthCheck(c *gin.Context) {
 
 	logger.AppLog.Info("Health check response sent successfully")
 }
+
+func sessionPoolStats(stats pkcs11mgr.PoolStats) *models.SessionPoolStats {
+	var avgWaitMs float64
+	if stats.Acquired > 0 {
+		avgWaitMs = float64(stats.TotalWaitTime.Microseconds()) / float64(stats.Acquired) / 1000
+	}
+	return &models.SessionPoolStats{
+		MaxSessions:     int32(stats.MaxSessions),
+		Open:            int32(stats.Open),
+		InUse:           int32(stats.InUse),
+		Idle:            int32(stats.Idle),
+		Waiters:         int32(stats.Waiters),
+		Acquired:        int64(stats.Acquired),
+		Timeouts:        int64(stats.Timeouts),
+		InvalidSessions: int64(stats.InvalidSessions),
+		Reconnects:      int64(stats.Reconnects),
+		AvgWaitMs:       avgWaitMs,
+		MaxWaitMs:       float64(stats.MaxWaitTime.Microseconds()) / 1000,
+	}
+}
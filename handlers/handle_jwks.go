@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+// HandleGetJWKS handles JSON Web Key Set requests
+// @Summary JWT signing keys
+// @Description Returns the public keys of the JWT_SIGNING_KEY versions that verify tokens as a JSON Web Key Set so the relying services verify the tokens without calling SSM
+// @Tags Authentication
+// @Produce json
+// @Success 200 {object} models.JwkSet "JSON Web Key Set"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /.well-known/jwks.json [get]
+func HandleGetJWKS(c *gin.Context) {
+	logger.AppLog.Info("Processing JWKS request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	// the signing version and the previous versions still in their grace period
+	keys := pkcs11mgr.GetJWTKeys(s)
+	if len(keys) == 0 {
+		logger.AppLog.Error("The JWT signing key is not initialized")
+		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	jwks := models.JwkSet{Keys: make([]models.Jwk, 0, len(keys))}
+	for _, key := range keys {
+		jwk, err := publicKeyToJWK(key.PublicKey, key.Kid, pkcs11mgr.GetJWTAlgorithm())
+		if err != nil {
+			logger.AppLog.Errorf("Failed to encode the JWT public key as JWK: %v", err)
+			sendProblemDetails(c, ErrorTitleInternalServerError, "Error encoding the public key", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+			return
+		}
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+
+	// the relying services may cache the keys, a new key gets a new kid
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
+}
@@ -0,0 +1,211 @@
+package handlers_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+// TestJWTVerifiedWithJWKS checks that the tokens are standard JWTs a relying
+// service verifies with the key of the JWKS whose kid is in the JWT header
+func TestJWTVerifiedWithJWKS(t *testing.T) {
+	for _, alg := range pkcs11mgr.JWTAlgorithms {
+		t.Run(alg, func(t *testing.T) {
+			f := ssmtest.New(t)
+			ks := f.KeyStore
+
+			if err := pkcs11mgr.SetJWTAlgorithm(alg); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = pkcs11mgr.SetJWTAlgorithm("RS256") })
+			f.InitJWT()
+			token, _, err := pkcs11mgr.CreateStandardJWT(ks, pkcs11mgr.JWTIssuer, constants.USER_UDM, pkcs11mgr.JWTAudience, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var jwks models.JwkSet
+			f.DoRequest(http.MethodGet, "/.well-known/jwks.json", nil, http.StatusOK, &jwks)
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Alg != alg {
+				t.Fatalf("unexpected JWKS %+v", jwks)
+			}
+			jwk := jwks.Keys[0]
+
+			parts := strings.Split(token, ".")
+			if len(parts) != 3 {
+				t.Fatalf("token %q is not a JWS compact serialization", token)
+			}
+			var header pkcs11mgr.JWTHeader
+			rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+			if err != nil || json.Unmarshal(rawHeader, &header) != nil {
+				t.Fatalf("header %q is not base64url JSON", parts[0])
+			}
+			if header.Alg != alg || header.Typ != "JWT" || header.Kid != jwk.Kid {
+				t.Fatalf("header %+v does not match the JWK %+v", header, jwk)
+			}
+
+			// verify offline with the JWK only
+			signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			b64 := func(s string) *big.Int {
+				v, err := base64.RawURLEncoding.DecodeString(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return new(big.Int).SetBytes(v)
+			}
+			switch alg {
+			case "RS256", "PS256":
+				pub := &rsa.PublicKey{N: b64(jwk.N), E: int(b64(jwk.E).Int64())}
+				if alg == "RS256" {
+					err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature)
+				} else {
+					err = rsa.VerifyPSS(pub, crypto.SHA256, digest[:], signature, nil)
+				}
+				if err != nil {
+					t.Fatalf("%s signature does not verify: %v", alg, err)
+				}
+			case "ES256":
+				pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: b64(jwk.X), Y: b64(jwk.Y)}
+				if len(signature) != 64 || !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+					t.Fatal("ES256 signature does not verify")
+				}
+			}
+
+			payload, err := pkcs11mgr.VerifyJWT(ks, token)
+			if err != nil || payload.Sub != constants.USER_UDM {
+				t.Fatalf("VerifyJWT = %+v, %v", payload, err)
+			}
+			// the algorithm of the header is never trusted
+			none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"` + jwk.Kid + `"}`))
+			if _, err := pkcs11mgr.VerifyJWT(ks, none+"."+parts[1]+"."); err == nil {
+				t.Fatal("a token with alg none was accepted")
+			}
+			tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"webconsole"}`)) + "." + parts[2]
+			if _, err := pkcs11mgr.VerifyJWT(ks, tampered); err == nil {
+				t.Fatal("a tampered token was accepted")
+			}
+			// a signed token must be revocable and be issued for the clients
+			for _, claims := range []pkcs11mgr.JWTPayload{
+				{Iss: pkcs11mgr.JWTIssuer, Sub: constants.USER_UDM, Aud: pkcs11mgr.JWTAudience, Exp: time.Now().Add(time.Hour).Unix()},
+				{Iss: pkcs11mgr.JWTIssuer, Sub: constants.USER_UDM, Aud: "other", Exp: time.Now().Add(time.Hour).Unix(), Jti: "00"},
+				{Iss: "other", Sub: constants.USER_UDM, Aud: pkcs11mgr.JWTAudience, Exp: time.Now().Add(time.Hour).Unix(), Jti: "00"},
+			} {
+				forged, err := pkcs11mgr.SignJWT(ks, claims)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := pkcs11mgr.VerifyJWT(ks, forged); err == nil {
+					t.Fatalf("a token with the claims %+v was accepted", claims)
+				}
+			}
+		})
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	f := ssmtest.New(t)
+	ks := f.KeyStore
+
+	pkcs11mgr.SetJWTGracePeriod(2 * time.Hour)
+	t.Cleanup(func() { pkcs11mgr.SetJWTGracePeriod(24 * time.Hour) })
+
+	// a key pair created before the versions is version 0
+	if _, _, err := ks.GenerateRSAKeyPair(constants.JWTKeyLabel, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkcs11mgr.InitJWTKey(ks); err != nil {
+		t.Fatal(err)
+	}
+	sign := func() string {
+		token, _, err := pkcs11mgr.CreateStandardJWT(ks, pkcs11mgr.JWTIssuer, constants.USER_UDM, pkcs11mgr.JWTAudience, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	jwksKids := func() []string {
+		var jwks models.JwkSet
+		f.DoRequest(http.MethodGet, "/.well-known/jwks.json", nil, http.StatusOK, &jwks)
+		var kids []string
+		for _, key := range jwks.Keys {
+			kids = append(kids, key.Kid)
+		}
+		return kids
+	}
+	legacyKid, legacyToken := pkcs11mgr.GetJWTKid(), sign()
+
+	// the legacy version has no creation time, the schedule rotates it at once
+	now := time.Now()
+	interval := 30 * 24 * time.Hour
+	if rotated, err := pkcs11mgr.RotateJWTKeyIfDue(ks, interval, now.Add(-90*time.Minute)); err != nil || !rotated {
+		t.Fatalf("RotateJWTKeyIfDue = %v, %v", rotated, err)
+	}
+	if rotated, err := pkcs11mgr.RotateJWTKeyIfDue(ks, interval, now); err != nil || rotated {
+		t.Fatalf("second RotateJWTKeyIfDue = %v, %v", rotated, err)
+	}
+	firstKid, firstToken := pkcs11mgr.GetJWTKid(), sign()
+	if firstKid == legacyKid {
+		t.Fatal("the rotation kept the kid")
+	}
+	if _, err := pkcs11mgr.VerifyJWT(ks, legacyToken); err != nil {
+		t.Fatalf("the previous key is rejected during the grace period: %v", err)
+	}
+	if kids := jwksKids(); !slices.Equal(kids, []string{legacyKid, firstKid}) {
+		t.Fatalf("JWKS kids %v", kids)
+	}
+
+	// the second rotation keeps both previous keys until their grace period ends
+	if _, err := pkcs11mgr.RotateJWTKey(ks, now); err != nil {
+		t.Fatal(err)
+	}
+	secondKid := pkcs11mgr.GetJWTKid()
+	if kids := jwksKids(); !slices.Equal(kids, []string{legacyKid, firstKid, secondKid}) {
+		t.Fatalf("JWKS kids %v", kids)
+	}
+
+	// 30 minutes later the grace period of the legacy key has ended
+	purged, err := pkcs11mgr.PurgeJWTKeys(ks, now.Add(time.Hour), false)
+	if err != nil || !slices.Equal(purged, []int32{0}) {
+		t.Fatalf("PurgeJWTKeys = %v, %v", purged, err)
+	}
+	if _, err := pkcs11mgr.VerifyJWT(ks, legacyToken); err == nil {
+		t.Fatal("a token of a destroyed key was accepted")
+	}
+	if _, err := pkcs11mgr.VerifyJWT(ks, firstToken); err != nil {
+		t.Fatalf("the previous key is rejected during the grace period: %v", err)
+	}
+
+	// a compromised key is revoked with every previous key
+	purged, err = pkcs11mgr.PurgeJWTKeys(ks, now, true)
+	if err != nil || !slices.Equal(purged, []int32{1}) {
+		t.Fatalf("PurgeJWTKeys = %v, %v", purged, err)
+	}
+	if _, err := pkcs11mgr.VerifyJWT(ks, firstToken); err == nil {
+		t.Fatal("a token of a revoked key was accepted")
+	}
+	if _, err := pkcs11mgr.VerifyJWT(ks, sign()); err != nil {
+		t.Fatalf("a token of the signing key is rejected: %v", err)
+	}
+	if kids := jwksKids(); !slices.Equal(kids, []string{secondKid}) {
+		t.Fatalf("JWKS kids %v", kids)
+	}
+}
@@ -1,17 +1,18 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/miekg/pkcs11"
 	"github.com/networkgcorefullcode/ssm/database"
-	"github.com/networkgcorefullcode/ssm/factory"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/models"
 	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 func HandleLogin(c *gin.Context) {
@@ -24,88 +25,113 @@ func HandleLogin(c *gin.Context) {
 		return
 	}
 
-	// Get MongoDB client from context or initialize
-	client := database.Client
+	// Get PKCS11 session
+	session, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(session)
 
-	// Query user from MongoDB
-	filter := bson.M{"service_id": loginReq.ServiceId}
-	userData, err := database.FindOneData(client, factory.SsmConfig.Configuration.Mongodb.DBName, database.CollSecret, filter)
-	if err != nil {
+	user, err := accountStore.GetAccount(loginReq.ServiceId)
+	if errors.Is(err, database.ErrAccountNotFound) {
+		// an unknown service ID is answered as slowly as a wrong password
+		database.VerifyDummyPassword(session, loginReq.Password)
 		logger.AppLog.Errorf("User not found: %v", err)
 		sendProblemDetails(c, ErrorTitleUnauthorized, "Invalid service ID or password", ErrorCodeUnauthorized, http.StatusUnauthorized, c.Request.URL.Path)
 		return
 	}
-
-	// Decodify using bson
-	user := database.UserSecret{}
-	bsonBytes, _ := bson.Marshal(userData)
-	if err := bson.Unmarshal(bsonBytes, &user); err != nil {
-		logger.AppLog.Errorf("Failed to unmarshal user data: %v", err)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to read user data: %v", err)
 		sendProblemDetails(c, ErrorTitleInternalServerError, "User data processing error", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
 		return
 	}
 
-	var iv []byte
-	if iv, err = hex.DecodeString(user.PasswordSecret.IV); err != nil {
-		logger.AppLog.Errorf("Failed to decode IV: %v", err)
-		sendProblemDetails(c, ErrorTitleInternalServerError, ErrorDetailInvalidHexIV, ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
-		return
-	}
-
-	// Get PKCS11 session
-	session := mgr.GetSession()
-	defer mgr.LogoutSession(session)
-
-	// Get key handle
-	keyHandle, err := pkcs11mgr.FindKey(user.PasswordSecret.KeyLabel, user.PasswordSecret.Id, *session)
+	valid, err := verifyServicePassword(session, user, loginReq.Password)
 	if err != nil {
-		logger.AppLog.Errorf("Failed to find key: %v", err)
-		sendProblemDetails(c, ErrorTitleInternalServerError, "Key retrieval error", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+		logger.AppLog.Errorf("Failed to verify the password of %s: %v", loginReq.ServiceId, err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Password verification error", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
 		return
 	}
-
-	// Decrypt stored password
-	encryptedPassword, err := hex.DecodeString(user.PasswordSecret.EncryptedData)
-	if err != nil {
-		logger.AppLog.Errorf("Failed to decode encrypted password: %v", err)
-		sendProblemDetails(c, ErrorTitleInternalServerError, "Password decryption error", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+	if !valid {
+		logger.AppLog.Warnf("Password mismatch for user: %s", loginReq.ServiceId)
+		sendProblemDetails(c, ErrorTitleUnauthorized, "Invalid service ID or password", ErrorCodeUnauthorized, http.StatusUnauthorized, c.Request.URL.Path)
 		return
 	}
 
-	decryptedPassword, err := pkcs11mgr.DecryptKey(keyHandle, iv, encryptedPassword, pkcs11.CKM_AES_CBC_PAD, *session)
-	if err != nil {
-		logger.AppLog.Errorf("Failed to decrypt password: %v", err)
-		sendProblemDetails(c, ErrorTitleInternalServerError, "Password decryption failed", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+	// a disabled account is rejected like a wrong password
+	if user.Disabled {
+		logger.AppLog.Warnf("Login of the disabled account: %s", loginReq.ServiceId)
+		sendProblemDetails(c, ErrorTitleUnauthorized, "Invalid service ID or password", ErrorCodeUnauthorized, http.StatusUnauthorized, c.Request.URL.Path)
 		return
 	}
 
-	// Compare passwords
-	if hex.EncodeToString(decryptedPassword) != loginReq.Password {
-		logger.AppLog.Warnf("Password mismatch for user: %s", loginReq.ServiceId)
-		sendProblemDetails(c, ErrorTitleUnauthorized, "Invalid service ID or password", ErrorCodeUnauthorized, http.StatusUnauthorized, c.Request.URL.Path)
-		return
+	// the password of an account created before the password hashes is only
+	// known at its login, the encrypted password is replaced by its hash
+	if user.PasswordHash == nil {
+		migratePassword(session, user.ServiceID, loginReq.Password)
 	}
 
-	// Generate JWT token
-	token, err := generateJWTToken(user.ServiceID, session)
+	// Issue the access token and its refresh token
+	response, err := issueTokens(session, user, "Login successful")
 	if err != nil {
 		logger.AppLog.Errorf("Failed to generate JWT token: %v", err)
 		sendProblemDetails(c, ErrorTitleInternalServerError, "Token generation failed", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
 		return
 	}
 
+	if err := accountStore.RecordLogin(user.ServiceID, time.Now()); err != nil {
+		logger.AppLog.Warnf("Failed to record the login of %s: %v", user.ServiceID, err)
+	}
+
 	logger.AppLog.Infof("User %s logged in successfully", user.ServiceID)
-	c.JSON(http.StatusOK, models.LoginResponse{
-		Token:   token,
-		Message: "Login successful",
-	})
+	c.JSON(http.StatusOK, response)
 }
 
-func generateJWTToken(username string, s *pkcs11mgr.Session) (string, error) {
-	token, err := pkcs11mgr.CreateStandardJWT(s, "ssm-service", username, "ssm-clients", 24)
+// verifyServicePassword checks the password of a login against the hash of
+// the account or, for the accounts not migrated yet, its AES encrypted password.
+// Every account costs an Argon2id verification, a disabled bootstrap account
+// without a password included.
+func verifyServicePassword(ks pkcs11mgr.KeyStore, user database.UserSecret, password string) (bool, error) {
+	if user.PasswordHash != nil {
+		return database.VerifyPassword(ks, *user.PasswordHash, password)
+	}
+	// the accounts without a hash are answered as slowly as the others
+	database.VerifyDummyPassword(ks, password)
+	// the bootstrap accounts have no password until `ssm account reset`
+	if user.PasswordSecret == nil {
+		return false, nil
+	}
+
+	secret := user.PasswordSecret
+	iv, err := hex.DecodeString(secret.IV)
+	if err != nil {
+		return false, err
+	}
+	encryptedPassword, err := hex.DecodeString(secret.EncryptedData)
 	if err != nil {
-		return "", err
+		return false, err
 	}
+	keyHandle, err := ks.FindKey(secret.KeyLabel, secret.Id)
+	if err != nil {
+		return false, err
+	}
+	decryptedPassword, err := ks.DecryptKey(keyHandle, iv, encryptedPassword, pkcs11.CKM_AES_CBC_PAD)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(decryptedPassword)), []byte(password)) == 1, nil
+}
 
-	return token, nil
+// migratePassword stores the hash of a verified password in place of its
+// encrypted form, a failure is retried on the next login
+func migratePassword(ks pkcs11mgr.KeyStore, serviceID, password string) {
+	hash, err := database.HashPassword(ks, password)
+	if err == nil {
+		err = accountStore.MigratePassword(serviceID, hash)
+	}
+	if err != nil {
+		logger.AppLog.Warnf("Failed to migrate the password of %s to a hash: %v", serviceID, err)
+		return
+	}
+	logger.AppLog.Infof("Password of %s migrated to a hash", serviceID)
 }
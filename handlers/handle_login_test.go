@@ -0,0 +1,83 @@
+package handlers_test
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/database"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+func TestLoginMigratesEncryptedPassword(t *testing.T) {
+	f := ssmtest.New(t)
+	f.InitJWT()
+	f.InitPasswords()
+	ks := f.KeyStore
+
+	// a password encrypted under the internal key like the first SSM versions
+	keyHandle, _, err := ks.GenerateAESKey(constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256, 1, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := []byte("Secret#Password1")
+	iv := make([]byte, 16)
+	encrypted, err := ks.EncryptKey(keyHandle, iv, raw, pkcs11.CKM_AES_CBC_PAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := hex.EncodeToString(raw)
+
+	accounts, _ := f.UseAccounts(database.UserSecret{
+		ServiceID: constants.USER_UDM,
+		PasswordSecret: &database.EncryptedSecret{
+			EncryptedData: hex.EncodeToString(encrypted),
+			IV:            hex.EncodeToString(iv),
+			Id:            1,
+			KeyLabel:      constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256,
+		},
+	})
+
+	// a wrong password is rejected and leaves the account unchanged
+	f.DoJSON("/login", models.LoginRequest{ServiceId: constants.USER_UDM, Password: password[:len(password)-2]}, http.StatusUnauthorized, nil)
+	if accounts.Accounts[constants.USER_UDM].PasswordHash != nil {
+		t.Fatal("the password was migrated after a failed login")
+	}
+
+	// the first successful login replaces the encrypted password by its hash
+	f.DoJSON("/login", models.LoginRequest{ServiceId: constants.USER_UDM, Password: password}, http.StatusOK, nil)
+	account := accounts.Accounts[constants.USER_UDM]
+	if account.PasswordSecret != nil || account.PasswordHash == nil || account.PasswordHash.PepperId != 1 {
+		t.Fatalf("the password was not migrated: %+v", account)
+	}
+	if !strings.HasPrefix(account.PasswordHash.Hash, "$argon2id$v=19$m=1024,t=1,p=1$") || strings.Contains(account.PasswordHash.Hash, password) {
+		t.Fatalf("unexpected password hash %s", account.PasswordHash.Hash)
+	}
+
+	// the next logins are verified against the hash
+	f.DoJSON("/login", models.LoginRequest{ServiceId: constants.USER_UDM, Password: password}, http.StatusOK, nil)
+	f.DoJSON("/login", models.LoginRequest{ServiceId: constants.USER_UDM, Password: strings.ToUpper(password)}, http.StatusUnauthorized, nil)
+
+	// the pepper key can not be used through the MAC API
+	f.DoJSON("/crypto/mac", models.MACRequest{KeyLabel: constants.LABEL_PASSWORD_PEPPER, Data: password, Algorithm: "HMAC-SHA256"}, http.StatusBadRequest, nil)
+}
+
+// TestLoginWithoutPasswordHash checks that an unknown service ID and an account
+// without a password are refused like a wrong password
+func TestLoginWithoutPasswordHash(t *testing.T) {
+	f := ssmtest.New(t)
+	f.InitJWT()
+	f.InitPasswords()
+	f.UseAccounts(database.UserSecret{ServiceID: constants.USER_UDM})
+
+	var unknown, noPassword models.ProblemDetails
+	f.DoJSON("/login", models.LoginRequest{ServiceId: "unknown", Password: "password"}, http.StatusUnauthorized, &unknown)
+	f.DoJSON("/login", models.LoginRequest{ServiceId: constants.USER_UDM, Password: "password"}, http.StatusUnauthorized, &noPassword)
+	if unknown.Detail != noPassword.Detail || unknown.Detail != "Invalid service ID or password" {
+		t.Fatalf("details %q and %q", unknown.Detail, noPassword.Detail)
+	}
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/safe"
+)
+
+// findMACKey checks the algorithm and the key usage policy of a MAC request
+// and returns the handle and id of the key, id 0 is the newest key of the
+// label. On failure it writes the problem details and returns false.
+func findMACKey(c *gin.Context, s pkcs11mgr.KeyStore, label string, id int32, operation, algorithm string) (pkcs11mgr.MACAlgorithm, pkcs11.ObjectHandle, int32, bool) {
+	if label == "" {
+		logger.AppLog.Error("Key label is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailKeyLabelRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return pkcs11mgr.MACAlgorithm{}, 0, 0, false
+	}
+
+	// the internal keys are only used by SSM itself, a MAC with the password
+	// pepper would allow offline attacks on the password hashes
+	if constants.LabelFamilyMap[label] == constants.LABEL_FAMILY_INTERNAL {
+		logger.AppLog.Errorf("Key label %s is reserved", label)
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailReservedKeyLabel, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return pkcs11mgr.MACAlgorithm{}, 0, 0, false
+	}
+
+	if !authorizeKeyOperation(c, label, operation, 0) {
+		return pkcs11mgr.MACAlgorithm{}, 0, 0, false
+	}
+
+	alg, ok := pkcs11mgr.MACAlgorithms[algorithm]
+	if !ok {
+		logger.AppLog.Errorf("Unsupported MAC algorithm: %s", algorithm)
+		sendProblemDetails(c, ErrorTitleBadRequest, "The specified MAC algorithm is not supported", "UNSUPPORTED_ALGORITHM", http.StatusBadRequest, c.Request.URL.Path)
+		return pkcs11mgr.MACAlgorithm{}, 0, 0, false
+	}
+
+	if id != 0 {
+		handle, err := s.FindKey(label, id)
+		if err != nil {
+			logger.AppLog.Errorf("Failed to find key by label '%s' and id %d: %v", label, id, err)
+			sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+			return pkcs11mgr.MACAlgorithm{}, 0, 0, false
+		}
+		return alg, handle, id, true
+	}
+
+	versions, err := s.GetKeyVersions(label)
+	if err != nil || len(versions) == 0 {
+		logger.AppLog.Errorf("Failed to find key by label '%s': %v", label, err)
+		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return pkcs11mgr.MACAlgorithm{}, 0, 0, false
+	}
+	newest := versions[len(versions)-1]
+	return alg, newest.Handle, newest.Id, true
+}
+
+// HandleGenerateMACKey handles MAC key generation requests
+// @Summary Generate MAC key
+// @Description Generates an HMAC or AES-CMAC key in the HSM, the key can only compute and verify MACs
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.GenMACKeyRequest true "Label and algorithm of the key"
+// @Success 201 {object} models.GenMACKeyResponse "Key generated successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 409 {object} models.ProblemDetails "Key already exists"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/generate-mac-key [post]
+func HandleGenerateMACKey(c *gin.Context) {
+	logger.AppLog.Info("Processing MAC key generation request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.GenMACKeyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.KeyLabel == "" {
+		logger.AppLog.Error("Key label is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailKeyLabelRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if _, reserved := constants.LabelFamilyMap[req.KeyLabel]; reserved {
+		logger.AppLog.Errorf("Key label %s is reserved", req.KeyLabel)
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailReservedKeyLabel, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.Id < 0 {
+		logger.AppLog.Errorf("Invalid key id: %d", req.Id)
+		sendProblemDetails(c, ErrorTitleValidationError, "The key id can not be negative", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_GENERATE, 0) {
+		return
+	}
+
+	alg, ok := pkcs11mgr.MACAlgorithms[req.Algorithm]
+	if !ok {
+		logger.AppLog.Errorf("Unsupported MAC algorithm: %s", req.Algorithm)
+		sendProblemDetails(c, ErrorTitleBadRequest, "The specified MAC algorithm is not supported", "UNSUPPORTED_ALGORITHM", http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.Bits == 0 {
+		req.Bits = int32(alg.DefaultBits)
+	}
+	if !alg.ValidKeySize(int(req.Bits)) {
+		logger.AppLog.Errorf("Invalid %s key size: %d bits", alg.Name, req.Bits)
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailInvalidKeySize, ErrorCodeInvalidKeySize, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	handle, id, err := s.GenerateMACKey(req.KeyLabel, req.Id, alg.KeyType, int(req.Bits))
+	if err != nil {
+		logger.AppLog.Errorf("MAC key generation failed: %v", err)
+		if err.Error() == constants.ERROR_STRING_KEY_EXISTS {
+			sendProblemDetails(c, ErrorTitleConflict, ErrorDetailKeyAlreadyExists, ErrorCodeKeyAlreadyExists, http.StatusConflict, c.Request.URL.Path)
+			return
+		}
+		sendProblemDetails(c, ErrorTitleKeyGenerationFailed, ErrorDetailKeyGenerationError, ErrorCodeKeyGenerationError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("%s key %s generated - ID: %d, Handle: %d", alg.Name, req.KeyLabel, id, handle)
+
+	resp := models.GenMACKeyResponse{
+		KeyLabel:  req.KeyLabel,
+		Id:        id,
+		Algorithm: alg.Name,
+		Bits:      req.Bits,
+		Handle:    int32(handle),
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// HandleMAC handles MAC computation requests
+// @Summary Compute MAC
+// @Description Computes an HMAC-SHA256, HMAC-SHA512 or AES-CMAC over data with a key held in the HSM
+// @Tags Encryption
+// @Accept json
+// @Produce json
+// @Param request body models.MACRequest true "Data to authenticate"
+// @Success 200 {object} models.MACResponse "MAC computed successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/mac [post]
+func HandleMAC(c *gin.Context) {
+	logger.AppLog.Info("Processing MAC request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.MACRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	data, err := hex.DecodeString(req.Data)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to decode data hex: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidHexData, ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	alg, handle, id, ok := findMACKey(c, s, req.KeyLabel, req.Id, constants.KEY_OPERATION_MAC, req.Algorithm)
+	if !ok {
+		return
+	}
+
+	mac, err := s.Sign(handle, alg.Mechanism, data)
+	if err != nil {
+		logger.AppLog.Errorf("%s with key '%s' failed: %v", alg.Name, req.KeyLabel, err)
+		sendProblemDetails(c, ErrorTitleSigningFailed, ErrorDetailSigningError, ErrorCodeSigningError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("%s computed with key '%s' (id %d)", alg.Name, req.KeyLabel, id)
+
+	resp := models.MACResponse{
+		KeyLabel:  req.KeyLabel,
+		Id:        id,
+		Algorithm: alg.Name,
+		Mac:       hex.EncodeToString(mac),
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleMACVerify handles MAC verification requests
+// @Summary Verify MAC
+// @Description Computes the MAC of data in the HSM and compares it in constant time with the given MAC
+// @Tags Encryption
+// @Accept json
+// @Produce json
+// @Param request body models.MACVerifyRequest true "Authenticated data and MAC"
+// @Success 200 {object} models.MACVerifyResponse "MAC checked"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/mac-verify [post]
+func HandleMACVerify(c *gin.Context) {
+	logger.AppLog.Info("Processing MAC verify request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.MACVerifyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.Mac == "" {
+		logger.AppLog.Error("MAC is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, "MAC is required", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	data, err := hex.DecodeString(req.Data)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to decode data hex: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidHexData, ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	expected, err := hex.DecodeString(req.Mac)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to decode MAC hex: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, "The MAC hex data is not valid", ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	alg, handle, id, ok := findMACKey(c, s, req.KeyLabel, req.Id, constants.KEY_OPERATION_VERIFY, req.Algorithm)
+	if !ok {
+		return
+	}
+
+	// The MAC is computed again and compared here instead of with C_Verify so
+	// the comparison is constant time whatever the token does
+	mac, err := s.Sign(handle, alg.Mechanism, data)
+	defer safe.Zero(mac)
+	if err != nil {
+		logger.AppLog.Errorf("%s with key '%s' failed: %v", alg.Name, req.KeyLabel, err)
+		sendProblemDetails(c, ErrorTitleVerificationFailed, ErrorDetailVerificationError, ErrorCodeVerificationError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	valid := subtle.ConstantTimeCompare(mac, expected) == 1
+
+	logger.AppLog.Infof("%s with key '%s' (id %d) checked, valid: %v", alg.Name, req.KeyLabel, id, valid)
+
+	resp := models.MACVerifyResponse{
+		KeyLabel:  req.KeyLabel,
+		Id:        id,
+		Algorithm: alg.Name,
+		Valid:     valid,
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
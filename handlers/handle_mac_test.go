@@ -0,0 +1,60 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/handlers"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+func TestMACAndVerify(t *testing.T) {
+	f := ssmtest.New(t)
+
+	data := "7b22696d7369223a22303031303130303030303030303031227d"
+	for _, algorithm := range []string{"HMAC-SHA256", "HMAC-SHA512", "AES-CMAC"} {
+		label := "MAC_" + algorithm
+		var genResp models.GenMACKeyResponse
+		f.DoJSON("/crypto/generate-mac-key", models.GenMACKeyRequest{KeyLabel: label, Algorithm: algorithm}, http.StatusCreated, &genResp)
+
+		var macResp models.MACResponse
+		f.DoJSON("/crypto/mac", models.MACRequest{KeyLabel: label, Algorithm: algorithm, Data: data}, http.StatusOK, &macResp)
+		if macResp.Id != genResp.Id {
+			t.Fatalf("%s: MAC computed with key id %d, want %d", algorithm, macResp.Id, genResp.Id)
+		}
+
+		var verifyResp models.MACVerifyResponse
+		f.DoJSON("/crypto/mac-verify", models.MACVerifyRequest{KeyLabel: label, Id: macResp.Id, Algorithm: algorithm, Data: data, Mac: macResp.Mac}, http.StatusOK, &verifyResp)
+		if !verifyResp.Valid {
+			t.Fatalf("%s: MAC not valid", algorithm)
+		}
+		f.DoJSON("/crypto/mac-verify", models.MACVerifyRequest{KeyLabel: label, Id: macResp.Id, Algorithm: algorithm, Data: data + "00", Mac: macResp.Mac}, http.StatusOK, &verifyResp)
+		if verifyResp.Valid {
+			t.Fatalf("%s: MAC valid for other data", algorithm)
+		}
+	}
+
+	// MAC keys can not encrypt and encryption keys can not compute MACs, the
+	// key usage policy refuses it and so does the token
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, nil)
+	f.DoJSON("/crypto/mac", models.MACRequest{KeyLabel: constants.LABEL_ENCRYPTION_KEY_AES256, Algorithm: "AES-CMAC", Data: data}, http.StatusForbidden, nil)
+	factory.SsmConfig.Configuration.KeyPolicies = map[string]factory.KeyPolicy{
+		constants.LABEL_FAMILY_ENCRYPTION: {Operations: []string{constants.KEY_OPERATION_MAC}},
+	}
+	f.DoJSON("/crypto/mac", models.MACRequest{KeyLabel: constants.LABEL_ENCRYPTION_KEY_AES256, Algorithm: "AES-CMAC", Data: data}, http.StatusInternalServerError, nil)
+	factory.SsmConfig.Configuration.KeyPolicies = nil
+	f.DoJSON("/crypto/generate-mac-key", models.GenMACKeyRequest{KeyLabel: "MAC_AES-CMAC", Id: 1, Algorithm: "AES-CMAC"}, http.StatusConflict, nil)
+}
+
+func TestGenerateMACKeyPolicy(t *testing.T) {
+	f := ssmtest.New(t)
+
+	restrictToUDM(constants.LABEL_FAMILY_APPLICATION, constants.KEY_OPERATION_GENERATE)
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/generate-mac-key", handlers.HandleGenerateMACKey, models.GenMACKeyRequest{KeyLabel: "NF_HMAC", Id: 1, Algorithm: "HMAC-SHA256"}), http.StatusForbidden)
+
+	factory.SsmConfig.Configuration.KeyPolicies = nil
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/generate-mac-key", handlers.HandleGenerateMACKey, models.GenMACKeyRequest{KeyLabel: "NF_HMAC", Id: 1, Algorithm: "HMAC-SHA256"}), http.StatusCreated)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/awnumar/memguard"
+	"github.com/gin-gonic/gin"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/safe"
+)
+
+// HandleReencrypt handles re-encryption requests
+// @Summary Re-encrypt data
+// @Description Decrypts a ciphertext and encrypts it again with another key or algorithm, the plaintext never leaves SSM
+// @Tags Encryption
+// @Accept json
+// @Produce json
+// @Param request body models.ReencryptRequest true "Ciphertext and target key"
+// @Success 200 {object} models.ReencryptResponse "Data re-encrypted successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 401 {object} models.ProblemDetails "Authentication failed (invalid tag)"
+// @Failure 403 {object} models.ProblemDetails "The key is outside of its validity period or denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/reencrypt [post]
+func HandleReencrypt(c *gin.Context) {
+	logger.AppLog.Info("Processing re-encrypt request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.ReencryptRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	// Validate required fields
+	if req.KeyLabel == "" {
+		logger.AppLog.Error("Key label is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailKeyLabelRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_DECRYPT, int(req.EncryptionAlgorithm)) {
+		return
+	}
+
+	if req.Cipher == "" {
+		logger.AppLog.Error("Ciphertext is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailCiphertextRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	// Reject an unknown target before anything is decrypted
+	if req.TargetEncryptionAlgorithm != constants.ALGORITHM_AES256_GCM {
+		if _, _, _, _, err := cbcMechanisms(req.TargetEncryptionAlgorithm); err != nil {
+			logger.AppLog.Errorf("Unsupported target encryption algorithm: %d", req.TargetEncryptionAlgorithm)
+			sendProblemDetails(c, ErrorTitleBadRequest, "The specified target encryption algorithm is not supported", "UNSUPPORTED_ALGORITHM", http.StatusBadRequest, c.Request.URL.Path)
+			return
+		}
+	}
+
+	targetLabel := req.TargetKeyLabel
+	if targetLabel == "" {
+		targetLabel = constants.AlgorithmLabelMap[int(req.TargetEncryptionAlgorithm)]
+	}
+	if !authorizeKeyOperation(c, targetLabel, constants.KEY_OPERATION_ENCRYPT, int(req.TargetEncryptionAlgorithm)) {
+		return
+	}
+
+	cipher, err := hex.DecodeString(req.Cipher)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to decode ciphertext hex: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidHexCiphertext, ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	iv, err := hex.DecodeString(req.Iv)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to decode IV hex: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidHexIV, ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	tag, err := hex.DecodeString(req.Tag)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to decode tag hex: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidHexTag, ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	aad, err := hex.DecodeString(req.Aad)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to decode hex AAD: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidHexAAD, ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	targetAad, err := hex.DecodeString(req.TargetAad)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to decode hex target AAD: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidHexAAD, ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	// Find both keys before decrypting
+	sourceHandle, err := s.FindKey(req.KeyLabel, req.Id)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to find key by label '%s': %v", req.KeyLabel, err)
+		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("Finding current key for label: %s", targetLabel)
+	targetKey, err := pkcs11mgr.FindCurrentKey(s, targetLabel)
+	if err != nil {
+		logger.AppLog.Errorf("Target key not found: %s, error: %v", targetLabel, err)
+		sendProblemDetails(c, ErrorTitleKeyNotFound, "The specified target key does not exist in the HSM", ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return
+	}
+	targetAttr, err := s.GetObjectAttributes(targetKey.Handle)
+	if err != nil {
+		logger.AppLog.Errorf("Attributes not found: %s, error: %v", targetLabel, err)
+		sendProblemDetails(c, ErrorTitleAttributesNotFound, ErrorDetailAttributesNotFound, ErrorCodeAttributesNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return
+	}
+	if !checkKeyValidity(c, targetAttr.Validity) {
+		return
+	}
+
+	rawPlaintext, err := decryptWithAlgorithm(s, sourceHandle, req.EncryptionAlgorithm, cipher, iv, tag, aad)
+	if err != nil {
+		safe.Zero(rawPlaintext)
+		logger.AppLog.Errorf("Decryption failed: %v", err)
+		switch {
+		case errors.Is(err, errUnsupportedAlgorithm):
+			sendProblemDetails(c, ErrorTitleBadRequest, "Unsupported decryption algorithm", "UNSUPPORTED_ALGORITHM", http.StatusBadRequest, c.Request.URL.Path)
+		case req.EncryptionAlgorithm == constants.ALGORITHM_AES256_GCM:
+			sendProblemDetails(c, ErrorTitleDecryptionFailed, "AES-GCM decryption failed. The authentication tag may be invalid or the data may have been tampered with.", ErrorCodeDecryptionError, http.StatusUnauthorized, c.Request.URL.Path)
+		default:
+			sendProblemDetails(c, ErrorTitleDecryptionFailed, ErrorDetailDecryptionError, ErrorCodeDecryptionError, http.StatusInternalServerError, c.Request.URL.Path)
+		}
+		return
+	}
+
+	// Keep the plaintext in protected memory until it is encrypted again
+	secureBuf := memguard.NewBufferFromBytes(rawPlaintext)
+	safe.Zero(rawPlaintext)
+	rawPlaintext = nil
+	defer secureBuf.Destroy()
+
+	newCipher, newIv, newTag, err := encryptWithAlgorithm(s, targetKey.Handle, req.TargetEncryptionAlgorithm, secureBuf.Bytes(), targetAad)
+	if err != nil {
+		logger.AppLog.Errorf("Encryption failed: %v", err)
+		sendProblemDetails(c, ErrorTitleEncryptionFailed, ErrorDetailEncryptionError, ErrorCodeEncryptionError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("Re-encrypted data from key '%s' (id %d) to key '%s' (id %d)", req.KeyLabel, req.Id, targetLabel, targetKey.Id)
+
+	resp := models.ReencryptResponse{
+		KeyLabel:            targetLabel,
+		Id:                  targetKey.Id,
+		EncryptionAlgorithm: req.TargetEncryptionAlgorithm,
+		Cipher:              hex.EncodeToString(newCipher),
+		Iv:                  hex.EncodeToString(newIv),
+		Tag:                 hex.EncodeToString(newTag),
+		Ok:                  true,
+		TimeCreated:         time.Now(),
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,50 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+func TestReencryptToAESGCM(t *testing.T) {
+	f := ssmtest.New(t)
+
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 128}, http.StatusCreated, nil)
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, nil)
+
+	plain := "00112233445566778899aabbccddeeff"
+	var encResp models.EncryptResponse
+	f.DoJSON("/crypto/encrypt", models.EncryptRequest{
+		KeyLabel:            constants.LABEL_ENCRYPTION_KEY_AES128,
+		Plain:               plain,
+		EncryptionAlgorithm: constants.ALGORITHM_AES128_OurUsers,
+	}, http.StatusCreated, &encResp)
+
+	var reResp models.ReencryptResponse
+	f.DoJSON("/crypto/reencrypt", models.ReencryptRequest{
+		KeyLabel:                  constants.LABEL_ENCRYPTION_KEY_AES128,
+		Id:                        encResp.Id,
+		EncryptionAlgorithm:       constants.ALGORITHM_AES128_OurUsers,
+		Cipher:                    encResp.Cipher,
+		Iv:                        encResp.Iv,
+		TargetEncryptionAlgorithm: constants.ALGORITHM_AES256_GCM,
+	}, http.StatusOK, &reResp)
+	if reResp.KeyLabel != constants.LABEL_ENCRYPTION_KEY_AES256 || reResp.Tag == "" {
+		t.Fatalf("unexpected re-encryption %+v", reResp)
+	}
+
+	var decResp models.DecryptAESGCMResponse
+	f.DoJSON("/crypto/decrypt-aes-gcm", models.DecryptAESGCMRequest{
+		KeyLabel: reResp.KeyLabel,
+		Cipher:   reResp.Cipher,
+		Iv:       reResp.Iv,
+		Tag:      reResp.Tag,
+		Id:       reResp.Id,
+	}, http.StatusOK, &decResp)
+	if decResp.Plain != plain {
+		t.Fatalf("decrypted %q, want %q", decResp.Plain, plain)
+	}
+}
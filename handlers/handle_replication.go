@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+// getReplicator returns the Replicator of the provider, it sends a 409 when no
+// replica token is configured
+func getReplicator(c *gin.Context) (*pkcs11mgr.Replicator, bool) {
+	replicating, ok := provider.(*pkcs11mgr.ReplicatingProvider)
+	if !ok {
+		sendProblemDetails(c, ErrorTitleConflict, "No replica token is configured", "REPLICATION_NOT_CONFIGURED", http.StatusConflict, c.Request.URL.Path)
+		return nil, false
+	}
+	return replicating.Replicator(), true
+}
+
+// HandleReplicationSync handles requests to copy every replicated key to the replica token
+// @Summary Full sync of the replica token
+// @Description Copies every key of the k4, encryption and internal families to the replica token with its retirement and validity dates
+// @Tags Key Management
+// @Produce json
+// @Success 200 {object} models.ReplicationSyncResponse "Replica synchronized"
+// @Failure 409 {object} models.ProblemDetails "No replica token is configured"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /replication-sync [post]
+func HandleReplicationSync(c *gin.Context) {
+	logger.AppLog.Info("Processing replication sync request")
+	replicator, ok := getReplicator(c)
+	if !ok {
+		return
+	}
+
+	result, err := replicator.FullSync(c.Request.Context())
+	if err != nil {
+		logger.AppLog.Errorf("Failed to sync the replica token: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error synchronizing the replica token", "REPLICATION_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	c.JSON(http.StatusOK, NewReplicationSyncResponse(result))
+}
+
+// HandleReplicationReport handles requests to compare the replicated keys of both tokens
+// @Summary Consistency report of the replica token
+// @Description Compares the labels, identifiers, key check values, retirement and validity dates of the replicated keys on both tokens
+// @Tags Key Management
+// @Produce json
+// @Success 200 {object} models.ReplicationReportResponse "Consistency report"
+// @Failure 409 {object} models.ProblemDetails "No replica token is configured"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /replication-report [post]
+func HandleReplicationReport(c *gin.Context) {
+	logger.AppLog.Info("Processing replication report request")
+	replicator, ok := getReplicator(c)
+	if !ok {
+		return
+	}
+
+	report, err := replicator.Report(c.Request.Context())
+	if err != nil {
+		logger.AppLog.Errorf("Failed to compare the replica token: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error comparing the replica token", "REPLICATION_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	c.JSON(http.StatusOK, NewReplicationReportResponse(report))
+}
+
+// NewReplicationSyncResponse converts the result of a full sync to its API model
+func NewReplicationSyncResponse(result pkcs11mgr.SyncResult) models.ReplicationSyncResponse {
+	return models.ReplicationSyncResponse{
+		Copied:    int32(result.Copied),
+		Updated:   int32(result.Updated),
+		Deleted:   int32(result.Deleted),
+		Unchanged: int32(result.Unchanged),
+		Failed:    replicationKeyStatuses(result.Failed),
+	}
+}
+
+// NewReplicationReportResponse converts a consistency report to its API model
+func NewReplicationReportResponse(report pkcs11mgr.ReplicationReport) models.ReplicationReportResponse {
+	return models.ReplicationReportResponse{
+		Consistent: report.Consistent,
+		Keys:       replicationKeyStatuses(report.Keys),
+	}
+}
+
+func replicationKeyStatuses(keys []pkcs11mgr.ReplicaKeyStatus) []models.ReplicationKeyStatus {
+	statuses := make([]models.ReplicationKeyStatus, 0, len(keys))
+	for _, key := range keys {
+		statuses = append(statuses, models.ReplicationKeyStatus{
+			KeyLabel:   key.Label,
+			Id:         key.Id,
+			PrimaryKcv: hex.EncodeToString(key.PrimaryCheckValue),
+			ReplicaKcv: hex.EncodeToString(key.ReplicaCheckValue),
+			Status:     key.Status,
+			Error:      key.Error,
+		})
+	}
+	return statuses
+}
@@ -0,0 +1,93 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+func TestReplicationToReplicaToken(t *testing.T) {
+	f := ssmtest.New(t)
+	f.DoJSON("/crypto/replication-report", nil, http.StatusConflict, nil)
+
+	primary, replica := pkcs11mgr.NewMemoryProvider(), pkcs11mgr.NewMemoryProvider()
+	replicator := pkcs11mgr.NewReplicator(primary, replica, 16)
+	replicating := pkcs11mgr.NewReplicatingProvider(primary, replicator)
+	t.Cleanup(replicating.Finalize)
+	f.UseProvider(replicating)
+
+	// keys created before the replication was enabled can not be wrapped
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 9, KeyValue: "00112233445566778899aabbccddeeff", KeyType: constants.TYPE_AES}, http.StatusOK, nil)
+	pkcs11mgr.SetKeyExport(true)
+	t.Cleanup(func() { pkcs11mgr.SetKeyExport(false) })
+
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyValue: "000102030405060708090a0b0c0d0e0f", KeyType: constants.TYPE_AES}, http.StatusOK, nil)
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_DES, Id: 1, KeyValue: "0123456789abcdef", KeyType: constants.TYPE_DES}, http.StatusOK, nil)
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, nil)
+
+	var result models.ReplicationSyncResponse
+	f.DoJSON("/crypto/replication-sync", nil, http.StatusOK, &result)
+	if result.Copied != 3 || len(result.Failed) != 1 || result.Failed[0].Status != pkcs11mgr.REPLICA_KEY_NOT_REPLICABLE {
+		t.Fatalf("sync result = %+v", result)
+	}
+	f.DoJSON("/crypto/replication-sync", nil, http.StatusOK, &result)
+	if result.Copied != 0 || result.Unchanged != 3 {
+		t.Fatalf("second sync result = %+v", result)
+	}
+
+	report := func() map[string]models.ReplicationKeyStatus {
+		var resp models.ReplicationReportResponse
+		f.DoJSON("/crypto/replication-report", nil, http.StatusOK, &resp)
+		if resp.Consistent {
+			t.Fatalf("report is consistent with a key that is not replicable: %+v", resp)
+		}
+		keys := make(map[string]models.ReplicationKeyStatus)
+		for _, key := range resp.Keys {
+			keys[fmt.Sprintf("%s/%d", key.KeyLabel, key.Id)] = key
+		}
+		return keys
+	}
+	keys := report()
+	if key := keys["K4_AES/1"]; key.Status != pkcs11mgr.REPLICA_KEY_OK || key.ReplicaKcv != "c6a13b" {
+		t.Fatalf("K4_AES/1 = %+v", key)
+	}
+	if key := keys["K4_AES/9"]; key.Status != pkcs11mgr.REPLICA_KEY_NOT_REPLICABLE {
+		t.Fatalf("K4_AES/9 = %+v", key)
+	}
+
+	// the incremental sync follows updates and deletions
+	replicator.Start()
+	ks, err := replicating.GetKeyStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffee, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100")
+	if _, err := ks.UpdateKey(constants.LABEL_K4_KEY_AES, ffee, 1, constants.TYPE_AES); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.DeleteKey(constants.LABEL_K4_KEY_DES, 1); err != nil {
+		t.Fatal(err)
+	}
+	replicating.ReleaseKeyStore(ks)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		keys = report()
+		_, desReplicated := keys["K4_DES/1"]
+		if keys["K4_AES/1"].ReplicaKcv == "ebc958" && !desReplicated {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replica not updated: %+v", keys)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
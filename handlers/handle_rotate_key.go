@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/safe"
+)
+
+// defaultRetireAfterDays is how long a retired version keeps decrypting when
+// the request does not say otherwise
+const defaultRetireAfterDays = 30
+
+// k4KeyTypes maps the K4 labels that can be rotated to their key type
+var k4KeyTypes = map[string]string{
+	constants.LABEL_K4_KEY_AES:  constants.TYPE_AES,
+	constants.LABEL_K4_KEY_DES:  constants.TYPE_DES,
+	constants.LABEL_K4_KEY_DES3: constants.TYPE_DES3,
+}
+
+// HandleRotateKey handles key rotation requests
+// @Summary Rotate key
+// @Description Creates a new version of a K4 key and makes the previous versions decrypt only until their retirement date
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.RotateKeyRequest true "Key to rotate"
+// @Success 200 {object} models.RotateKeyResponse "Key rotated successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/rotate-key [post]
+func HandleRotateKey(c *gin.Context) {
+	logger.AppLog.Info("Processing rotate key request")
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.RotateKeyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_ROTATE, 0) {
+		return
+	}
+
+	keyType, ok := k4KeyTypes[req.KeyLabel]
+	if !ok {
+		logger.AppLog.Errorf("Unsupported key label for rotation: %s", req.KeyLabel)
+		sendProblemDetails(c, ErrorTitleBadRequest, "Only K4 keys can be rotated", "UNSUPPORTED_KEY_TYPE", http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.RetireAfterDays < 0 {
+		logger.AppLog.Errorf("Invalid retirement period: %d days", req.RetireAfterDays)
+		sendProblemDetails(c, ErrorTitleValidationError, "retire_after_days can not be negative", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	retireAfterDays := int(req.RetireAfterDays)
+	if retireAfterDays == 0 {
+		retireAfterDays = defaultRetireAfterDays
+	}
+
+	bits := int(req.Bits)
+	if bits == 0 {
+		bits = 256
+	}
+	if keyType == constants.TYPE_AES && bits != 128 && bits != 256 {
+		logger.AppLog.Errorf("Invalid AES key size: %d", bits)
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailInvalidKeySize, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	var keyValue []byte
+	if req.KeyValue != "" {
+		var err error
+		keyValue, err = hex.DecodeString(req.KeyValue)
+		if err != nil {
+			logger.AppLog.Errorf("Failed to decode HEX key value: %v", err)
+			sendProblemDetails(c, ErrorTitleBadRequest, "The key value in HEX is not valid", ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+			return
+		}
+		defer safe.Zero(keyValue)
+	}
+
+	rotation, err := pkcs11mgr.RotateKey(s, pkcs11mgr.RotationRequest{
+		Label:    req.KeyLabel,
+		KeyType:  keyType,
+		Key:      keyValue,
+		Bits:     bits,
+		RetireAt: time.Now().UTC().AddDate(0, 0, retireAfterDays),
+	})
+	if err != nil {
+		logger.AppLog.Errorf("Failed to rotate key %s: %v", req.KeyLabel, err)
+		sendProblemDetails(c, "Key Rotation Failed", "Error rotating key in HSM", "KEY_ROTATION_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	resp := models.RotateKeyResponse{
+		KeyLabel: req.KeyLabel,
+		Id:       rotation.Current.Id,
+		Handle:   int32(rotation.Current.Handle),
+		Retired:  []models.RetiredKeyVersion{},
+	}
+	for _, version := range rotation.Retired {
+		resp.Retired = append(resp.Retired, models.RetiredKeyVersion{
+			Id:       version.Id,
+			RetireAt: version.RetireAt.Format(time.DateOnly),
+		})
+	}
+
+	logger.AppLog.Infof("Key %s rotated to version %d", req.KeyLabel, rotation.Current.Id)
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandlePurgeRetiredKeys handles the destruction of retired key versions
+// @Summary Purge retired keys
+// @Description Destroys the versions of a K4 key that are past their retirement date
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.PurgeRetiredKeysRequest true "Key to purge"
+// @Success 200 {object} models.PurgeRetiredKeysResponse "Retired versions destroyed"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/purge-retired-keys [post]
+func HandlePurgeRetiredKeys(c *gin.Context) {
+	logger.AppLog.Info("Processing purge retired keys request")
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.PurgeRetiredKeysRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_DELETE, 0) {
+		return
+	}
+
+	if _, ok := k4KeyTypes[req.KeyLabel]; !ok {
+		logger.AppLog.Errorf("Unsupported key label for purge: %s", req.KeyLabel)
+		sendProblemDetails(c, ErrorTitleBadRequest, "Only K4 keys can be purged", "UNSUPPORTED_KEY_TYPE", http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	destroyed, err := pkcs11mgr.PurgeRetiredKeys(s, req.KeyLabel, time.Now().UTC())
+	if err != nil {
+		if err.Error() == constants.ERROR_STRING_KEY_NOT_FOUND {
+			sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+			return
+		}
+		logger.AppLog.Errorf("Failed to purge retired versions of %s: %v", req.KeyLabel, err)
+		sendProblemDetails(c, "Key Deletion Failed", "Error destroying retired key versions", "KEY_DELETION_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	resp := models.PurgeRetiredKeysResponse{
+		KeyLabel:     req.KeyLabel,
+		DestroyedIds: []int32{},
+	}
+	for _, version := range destroyed {
+		resp.DestroyedIds = append(resp.DestroyedIds, version.Id)
+	}
+
+	logger.AppLog.Infof("Destroyed %d retired versions of key %s", len(destroyed), req.KeyLabel)
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,66 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+func TestRotateKeyKeepsOldVersionForDecrypt(t *testing.T) {
+	f := ssmtest.New(t)
+
+	var first models.RotateKeyResponse
+	f.DoJSON("/crypto/rotate-key", models.RotateKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES}, http.StatusOK, &first)
+
+	plain := "00112233445566778899aabbccddeeff"
+	encrypt := func() models.EncryptResponse {
+		var resp models.EncryptResponse
+		f.DoJSON("/crypto/encrypt", models.EncryptRequest{
+			KeyLabel:            constants.LABEL_K4_KEY_AES,
+			Plain:               plain,
+			EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers,
+		}, http.StatusCreated, &resp)
+		return resp
+	}
+	oldCipher := encrypt()
+
+	var second models.RotateKeyResponse
+	f.DoJSON("/crypto/rotate-key", models.RotateKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, RetireAfterDays: 7}, http.StatusOK, &second)
+	if second.Id != first.Id+1 || len(second.Retired) != 1 || second.Retired[0].Id != first.Id {
+		t.Fatalf("unexpected rotation %+v after %+v", second, first)
+	}
+
+	if newCipher := encrypt(); newCipher.Id != second.Id {
+		t.Fatalf("encrypt used version %d, want %d", newCipher.Id, second.Id)
+	}
+
+	var decResp models.DecryptResponse
+	f.DoJSON("/crypto/decrypt", models.DecryptRequest{
+		KeyLabel:            constants.LABEL_K4_KEY_AES,
+		Cipher:              oldCipher.Cipher,
+		Iv:                  oldCipher.Iv,
+		Id:                  oldCipher.Id,
+		EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers,
+	}, http.StatusOK, &decResp)
+	if decResp.Plain != plain {
+		t.Fatalf("decrypted %q with the retired version, want %q", decResp.Plain, plain)
+	}
+
+	var purge models.PurgeRetiredKeysResponse
+	f.DoJSON("/crypto/purge-retired-keys", models.PurgeRetiredKeysRequest{KeyLabel: constants.LABEL_K4_KEY_AES}, http.StatusOK, &purge)
+	if len(purge.DestroyedIds) != 0 {
+		t.Fatalf("purged versions %v before their retirement date", purge.DestroyedIds)
+	}
+
+	// the retirement date is not the end of the validity period
+	var keys models.GetDataKeysResponse
+	f.DoJSON("/crypto/get-data-keys", models.GetDataKeysRequest{KeyLabel: constants.LABEL_K4_KEY_AES}, http.StatusOK, &keys)
+	for _, key := range keys.Keys {
+		if key.EndDate != "" {
+			t.Fatalf("version %d has end date %q after the rotation", key.Id, key.EndDate)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/database"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/server/middleware"
+)
+
+// AccountStore keeps the service accounts of /login
+type AccountStore interface {
+	GetAccount(serviceID string) (database.UserSecret, error)
+	ListAccounts() ([]database.UserSecret, error)
+	CreateAccount(account database.UserSecret) error
+	SetPassword(serviceID string, hash database.PasswordHash, now time.Time) error
+	MigratePassword(serviceID string, hash database.PasswordHash) error
+	DisableAccount(serviceID string, now time.Time) error
+	RecordLogin(serviceID string, now time.Time) error
+}
+
+var accountStore AccountStore = database.AccountStore{}
+
+// SetAccountStore replaces the MongoDB store of the service accounts
+func SetAccountStore(s AccountStore) {
+	accountStore = s
+}
+
+// ErrInvalidServiceAccount is returned for a malformed service id or an unknown role
+var ErrInvalidServiceAccount = errors.New("invalid service account")
+
+var serviceIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// CreateServiceAccount creates a service account with a new password, the
+// password is only returned here
+func CreateServiceAccount(ks pkcs11mgr.KeyStore, serviceID, role string) (models.ServiceAccountPasswordResponse, error) {
+	if !serviceIDPattern.MatchString(serviceID) {
+		return models.ServiceAccountPasswordResponse{}, fmt.Errorf("%w: service id %q", ErrInvalidServiceAccount, serviceID)
+	}
+	if !slices.Contains(constants.ServiceRoles, role) {
+		return models.ServiceAccountPasswordResponse{}, fmt.Errorf("%w: role %q", ErrInvalidServiceAccount, role)
+	}
+
+	hash, password, err := database.NewServicePassword(ks)
+	if err != nil {
+		return models.ServiceAccountPasswordResponse{}, err
+	}
+	now := time.Now()
+	err = accountStore.CreateAccount(database.UserSecret{
+		PasswordHash:      &hash,
+		ServiceID:         serviceID,
+		Role:              role,
+		CreatedAt:         now,
+		PasswordChangedAt: now,
+	})
+	if err != nil {
+		return models.ServiceAccountPasswordResponse{}, err
+	}
+
+	logger.AppLog.Infof("Service account %s created with role %s", serviceID, role)
+	return models.ServiceAccountPasswordResponse{
+		ServiceId: serviceID,
+		Role:      role,
+		Password:  password,
+		Message:   "Service account created",
+	}, nil
+}
+
+// ResetServicePassword replaces the password of a service account and ends
+// its sessions, the new password is only returned here
+func ResetServicePassword(ks pkcs11mgr.KeyStore, serviceID string) (models.ServiceAccountPasswordResponse, error) {
+	account, err := accountStore.GetAccount(serviceID)
+	if err != nil {
+		return models.ServiceAccountPasswordResponse{}, err
+	}
+	hash, password, err := database.NewServicePassword(ks)
+	if err != nil {
+		return models.ServiceAccountPasswordResponse{}, err
+	}
+	now := time.Now()
+	if err := accountStore.SetPassword(serviceID, hash, now); err != nil {
+		return models.ServiceAccountPasswordResponse{}, err
+	}
+	if _, err := revokeServiceTokens(serviceID, now); err != nil {
+		return models.ServiceAccountPasswordResponse{}, err
+	}
+
+	logger.AppLog.Infof("Password of service account %s reset", serviceID)
+	return models.ServiceAccountPasswordResponse{
+		ServiceId: serviceID,
+		Role:      account.ServiceRole(),
+		Password:  password,
+		Message:   "Password reset",
+	}, nil
+}
+
+// DisableServiceAccount disables a service account and revokes the access
+// tokens of its sessions
+func DisableServiceAccount(serviceID string) (models.DisableServiceAccountResponse, error) {
+	now := time.Now()
+	if err := accountStore.DisableAccount(serviceID, now); err != nil {
+		return models.DisableServiceAccountResponse{}, err
+	}
+	revoked, err := revokeServiceTokens(serviceID, now)
+	if err != nil {
+		return models.DisableServiceAccountResponse{}, err
+	}
+
+	logger.AppLog.Infof("Service account %s disabled, %d access tokens revoked", serviceID, revoked)
+	return models.DisableServiceAccountResponse{
+		ServiceId:     serviceID,
+		RevokedTokens: int32(revoked),
+		Message:       "Service account disabled",
+	}, nil
+}
+
+// ListServiceAccounts returns every service account sorted by service id
+func ListServiceAccounts() (models.ServiceAccountListResponse, error) {
+	accounts, err := accountStore.ListAccounts()
+	if err != nil {
+		return models.ServiceAccountListResponse{}, err
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ServiceID < accounts[j].ServiceID })
+
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	response := models.ServiceAccountListResponse{Accounts: make([]models.ServiceAccount, 0, len(accounts))}
+	for _, account := range accounts {
+		response.Accounts = append(response.Accounts, models.ServiceAccount{
+			ServiceId:         account.ServiceID,
+			Role:              account.ServiceRole(),
+			Disabled:          account.Disabled,
+			CreatedAt:         formatTime(account.CreatedAt),
+			PasswordChangedAt: formatTime(account.PasswordChangedAt),
+			LastLoginAt:       formatTime(account.LastLoginAt),
+			DisabledAt:        formatTime(account.DisabledAt),
+		})
+	}
+	return response, nil
+}
+
+// revokeServiceTokens ends the sessions of a service, the revoked access
+// tokens are rejected at once by this SSM and after the next sync by the others
+func revokeServiceTokens(serviceID string, now time.Time) (int, error) {
+	revoked, err := tokenStore.RevokeServiceTokens(serviceID, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, token := range revoked {
+		middleware.RevokeToken(token.Jti, token.ExpiresAt)
+	}
+	return len(revoked), nil
+}
+
+// sendServiceAccountError maps the errors of the service account operations
+// to their problem details
+func sendServiceAccountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidServiceAccount):
+		sendProblemDetails(c, ErrorTitleValidationError, err.Error(), ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+	case errors.Is(err, database.ErrAccountNotFound):
+		sendProblemDetails(c, ErrorTitleNotFound, "The service account does not exist", ErrorCodeNotFound, http.StatusNotFound, c.Request.URL.Path)
+	case errors.Is(err, database.ErrAccountExists):
+		sendProblemDetails(c, ErrorTitleConflict, "A service account with the service id already exists", ErrorCodeAccountExists, http.StatusConflict, c.Request.URL.Path)
+	default:
+		logger.AppLog.Errorf("Service account operation failed: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Service account store error", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+	}
+}
+
+// HandleCreateServiceAccount handles service account creation requests
+// @Summary Create a service account
+// @Description Creates a service account with the udm or webconsole role and returns its password once, the password is never written to disk
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.CreateServiceAccountRequest true "Service account"
+// @Success 201 {object} models.ServiceAccountPasswordResponse "Service account created"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 401 {object} models.ProblemDetails "Unauthorized"
+// @Failure 409 {object} models.ProblemDetails "Service id already taken"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/service-account [post]
+func HandleCreateServiceAccount(c *gin.Context) {
+	logger.AppLog.Info("Processing service account creation request")
+	var req models.CreateServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.AppLog.Errorf("Invalid JSON payload: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	response, err := CreateServiceAccount(s, req.ServiceId, req.Role)
+	if err != nil {
+		sendServiceAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// HandleListServiceAccounts handles service account listing requests
+// @Summary List the service accounts
+// @Description Lists every service account with its role, state and the time of its last login
+// @Tags Authentication
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.ServiceAccountListResponse "Service accounts"
+// @Failure 401 {object} models.ProblemDetails "Unauthorized"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/service-accounts [get]
+func HandleListServiceAccounts(c *gin.Context) {
+	logger.AppLog.Info("Processing service account list request")
+	response, err := ListServiceAccounts()
+	if err != nil {
+		sendServiceAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleResetServicePassword handles password reset requests
+// @Summary Reset the password of a service account
+// @Description Generates a new password for a service account and returns it once, the sessions of the account are ended
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.ServiceAccountRequest true "Service account"
+// @Success 200 {object} models.ServiceAccountPasswordResponse "Password reset"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 401 {object} models.ProblemDetails "Unauthorized"
+// @Failure 404 {object} models.ProblemDetails "Unknown service account"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/service-account-reset [post]
+func HandleResetServicePassword(c *gin.Context) {
+	logger.AppLog.Info("Processing service account password reset request")
+	var req models.ServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.AppLog.Errorf("Invalid JSON payload: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	response, err := ResetServicePassword(s, req.ServiceId)
+	if err != nil {
+		sendServiceAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleDisableServiceAccount handles service account disable requests
+// @Summary Disable a service account
+// @Description Disables a service account, it can no longer log in or refresh its tokens and its access tokens are revoked
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.ServiceAccountRequest true "Service account"
+// @Success 200 {object} models.DisableServiceAccountResponse "Service account disabled"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 401 {object} models.ProblemDetails "Unauthorized"
+// @Failure 404 {object} models.ProblemDetails "Unknown service account"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/service-account-disable [post]
+func HandleDisableServiceAccount(c *gin.Context) {
+	logger.AppLog.Info("Processing service account disable request")
+	var req models.ServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.AppLog.Errorf("Invalid JSON payload: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	response, err := DisableServiceAccount(req.ServiceId)
+	if err != nil {
+		sendServiceAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
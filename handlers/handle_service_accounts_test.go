@@ -0,0 +1,110 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/database"
+	"github.com/networkgcorefullcode/ssm/handlers"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/server/middleware"
+)
+
+func TestServiceAccounts(t *testing.T) {
+	f := ssmtest.New(t)
+	f.InitJWT()
+	f.InitPasswords()
+	ks := f.KeyStore
+
+	accounts, _ := f.UseAccounts()
+
+	var created models.ServiceAccountPasswordResponse
+	f.DoJSON("/crypto/service-account", models.CreateServiceAccountRequest{ServiceId: "smf-01", Role: constants.ROLE_UDM}, http.StatusCreated, &created)
+	if created.Password == "" || created.Role != constants.ROLE_UDM {
+		t.Fatalf("unexpected creation response %+v", created)
+	}
+	if stored := accounts.Accounts["smf-01"].PasswordHash; stored == nil || !strings.HasPrefix(stored.Hash, "$argon2id$") || strings.Contains(stored.Hash, created.Password) {
+		t.Fatal("the password is not stored hashed")
+	}
+	f.DoJSON("/crypto/service-account", models.CreateServiceAccountRequest{ServiceId: "smf-01", Role: constants.ROLE_UDM}, http.StatusConflict, nil)
+	f.DoJSON("/crypto/service-account", models.CreateServiceAccountRequest{ServiceId: "smf-02", Role: "admin"}, http.StatusBadRequest, nil)
+	f.DoJSON("/crypto/service-account", models.CreateServiceAccountRequest{ServiceId: "SMF 02", Role: constants.ROLE_UDM}, http.StatusBadRequest, nil)
+
+	// the account logs in with the role of the account
+	var login models.LoginResponse
+	f.DoJSON("/login", models.LoginRequest{ServiceId: "smf-01", Password: created.Password}, http.StatusOK, &login)
+	payload, err := pkcs11mgr.VerifyJWT(ks, login.Token)
+	if err != nil || payload.Sub != "smf-01" || payload.Role != constants.ROLE_UDM {
+		t.Fatalf("VerifyJWT = %+v, %v", payload, err)
+	}
+	secure := gin.New()
+	secure.Use(middleware.AuthenticateRequest())
+	secure.GET("/crypto/health-check", func(c *gin.Context) { c.Status(http.StatusOK) })
+	secure.GET("/crypto/service-accounts", func(c *gin.Context) { c.Status(http.StatusOK) })
+	if w := ssmtest.DoBearer(secure, http.MethodGet, "/crypto/health-check", login.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("health-check with the udm role: status %d, body: %s", w.Code, w.Body.String())
+	}
+	if w := ssmtest.DoBearer(secure, http.MethodGet, "/crypto/service-accounts", login.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("service accounts with the udm role: status %d", w.Code)
+	}
+
+	// the key usage policies are checked against the role, not the service id
+	var provisioner models.ServiceAccountPasswordResponse
+	f.DoJSON("/crypto/service-account", models.CreateServiceAccountRequest{ServiceId: "prov-01", Role: constants.ROLE_WEBCONSOLE}, http.StatusCreated, &provisioner)
+	var provisionerLogin models.LoginResponse
+	f.DoJSON("/login", models.LoginRequest{ServiceId: "prov-01", Password: provisioner.Password}, http.StatusOK, &provisionerLogin)
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, nil)
+	secure.POST("/crypto/encrypt", handlers.HandleEncrypt)
+	encrypt := models.EncryptRequest{KeyLabel: constants.LABEL_ENCRYPTION_KEY_AES256, Plain: "00112233445566778899aabbccddeeff", EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers}
+	if w := ssmtest.DoBearer(secure, http.MethodPost, "/crypto/encrypt", provisionerLogin.Token, encrypt); w.Code != http.StatusCreated {
+		t.Fatalf("encrypt with the webconsole role: status %d, body: %s", w.Code, w.Body.String())
+	}
+	accounts.Accounts = map[string]database.UserSecret{"smf-01": accounts.Accounts["smf-01"]}
+
+	var list models.ServiceAccountListResponse
+	f.DoRequest(http.MethodGet, "/crypto/service-accounts", nil, http.StatusOK, &list)
+	if len(list.Accounts) != 1 || list.Accounts[0].ServiceId != "smf-01" || list.Accounts[0].LastLoginAt == "" || list.Accounts[0].Disabled {
+		t.Fatalf("unexpected account list %+v", list)
+	}
+
+	// a reset replaces the password and ends the sessions
+	var reset models.ServiceAccountPasswordResponse
+	f.DoJSON("/crypto/service-account-reset", models.ServiceAccountRequest{ServiceId: "smf-01"}, http.StatusOK, &reset)
+	if reset.Password == "" || reset.Password == created.Password {
+		t.Fatalf("unexpected reset response %+v", reset)
+	}
+	f.DoJSON("/login", models.LoginRequest{ServiceId: "smf-01", Password: created.Password}, http.StatusUnauthorized, nil)
+	if w := ssmtest.DoBearer(secure, http.MethodGet, "/crypto/health-check", login.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("health-check after the reset: status %d", w.Code)
+	}
+	f.DoJSON("/token/refresh", models.RefreshTokenRequest{RefreshToken: login.RefreshToken}, http.StatusUnauthorized, nil)
+	f.DoJSON("/login", models.LoginRequest{ServiceId: "smf-01", Password: reset.Password}, http.StatusOK, &login)
+	f.DoJSON("/crypto/service-account-reset", models.ServiceAccountRequest{ServiceId: "smf-02"}, http.StatusNotFound, nil)
+
+	// a disabled account can neither log in nor refresh
+	var disabled models.DisableServiceAccountResponse
+	f.DoJSON("/crypto/service-account-disable", models.ServiceAccountRequest{ServiceId: "smf-01"}, http.StatusOK, &disabled)
+	if disabled.RevokedTokens != 1 {
+		t.Fatalf("unexpected disable response %+v", disabled)
+	}
+	f.DoJSON("/login", models.LoginRequest{ServiceId: "smf-01", Password: reset.Password}, http.StatusUnauthorized, nil)
+	f.DoJSON("/token/refresh", models.RefreshTokenRequest{RefreshToken: login.RefreshToken}, http.StatusUnauthorized, nil)
+	if w := ssmtest.DoBearer(secure, http.MethodGet, "/crypto/health-check", login.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("health-check after the disable: status %d", w.Code)
+	}
+	f.DoRequest(http.MethodGet, "/crypto/service-accounts", nil, http.StatusOK, &list)
+	if !list.Accounts[0].Disabled || list.Accounts[0].DisabledAt == "" {
+		t.Fatalf("unexpected account list %+v", list)
+	}
+
+	// a bootstrap account has no password until it is reset
+	accounts.Accounts[constants.USER_UDM] = database.UserSecret{ServiceID: constants.USER_UDM, Role: constants.ROLE_UDM}
+	f.DoJSON("/login", models.LoginRequest{ServiceId: constants.USER_UDM, Password: "password"}, http.StatusUnauthorized, nil)
+	f.DoJSON("/crypto/service-account-reset", models.ServiceAccountRequest{ServiceId: constants.USER_UDM}, http.StatusOK, &reset)
+	f.DoJSON("/login", models.LoginRequest{ServiceId: constants.USER_UDM, Password: reset.Password}, http.StatusOK, nil)
+}
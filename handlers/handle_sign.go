@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+// findSignKey checks the algorithm and the key usage policy of a sign or verify
+// request and returns the public key handle of the key pair, on failure it
+// writes the problem details and returns false
+func findSignKey(c *gin.Context, s pkcs11mgr.KeyStore, label, operation, algorithm string) (pkcs11mgr.SignAlgorithm, pkcs11.ObjectHandle, bool) {
+	if label == "" {
+		logger.AppLog.Error("Key label is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailKeyLabelRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return pkcs11mgr.SignAlgorithm{}, 0, false
+	}
+
+	// the SSM key pairs sign the JWTs and the audit log, signing with them
+	// through the API would forge both
+	if _, reserved := constants.LabelFamilyMap[label]; reserved {
+		logger.AppLog.Errorf("Key label %s is reserved", label)
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailReservedKeyLabel, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return pkcs11mgr.SignAlgorithm{}, 0, false
+	}
+
+	if !authorizeKeyOperation(c, label, operation, 0) {
+		return pkcs11mgr.SignAlgorithm{}, 0, false
+	}
+
+	alg, ok := pkcs11mgr.SignAlgorithms[algorithm]
+	if !ok {
+		logger.AppLog.Errorf("Unsupported signature algorithm: %s", algorithm)
+		sendProblemDetails(c, ErrorTitleBadRequest, "The specified signature algorithm is not supported", "UNSUPPORTED_ALGORITHM", http.StatusBadRequest, c.Request.URL.Path)
+		return pkcs11mgr.SignAlgorithm{}, 0, false
+	}
+
+	pubHandle, err := s.FindPublicKey(label)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to find public key by label '%s': %v", label, err)
+		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return pkcs11mgr.SignAlgorithm{}, 0, false
+	}
+	pub, err := s.GetPublicKey(pubHandle)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to read public key '%s': %v", label, err)
+		sendProblemDetails(c, ErrorTitleAttributesNotFound, ErrorDetailAttributesNotFound, ErrorCodeAttributesNotFound, http.StatusInternalServerError, c.Request.URL.Path)
+		return pkcs11mgr.SignAlgorithm{}, 0, false
+	}
+
+	keyType := keyTypeNames[alg.KeyType]
+	if publicKeyType(pub) != keyType {
+		logger.AppLog.Errorf("Algorithm %s needs an %s key, %s is %s", alg.Name, keyType, label, publicKeyType(pub))
+		sendProblemDetails(c, ErrorTitleValidationError, "The key type does not match the signature algorithm", ErrorCodeInvalidKeyType, http.StatusBadRequest, c.Request.URL.Path)
+		return pkcs11mgr.SignAlgorithm{}, 0, false
+	}
+	return alg, pubHandle, true
+}
+
+// isInvalidSignature reports whether a verify error means the signature does not match
+func isInvalidSignature(err error) bool {
+	var p11Err pkcs11.Error
+	return errors.As(err, &p11Err) && (p11Err == pkcs11.CKR_SIGNATURE_INVALID || p11Err == pkcs11.CKR_SIGNATURE_LEN_RANGE)
+}
+
+// HandleSign handles signing requests
+// @Summary Sign data
+// @Description Signs data with the private key of a key pair using RSA PKCS#1 v1.5, RSA-PSS or ECDSA
+// @Tags Encryption
+// @Accept json
+// @Produce json
+// @Param request body models.SignRequest true "Data to sign"
+// @Success 200 {object} models.SignResponse "Data signed successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/sign [post]
+func HandleSign(c *gin.Context) {
+	logger.AppLog.Info("Processing sign request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.SignRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.Data == "" {
+		logger.AppLog.Error("Data is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailDataRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	data, err := hex.DecodeString(req.Data)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to decode data hex: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidHexData, ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	alg, _, ok := findSignKey(c, s, req.KeyLabel, constants.KEY_OPERATION_SIGN, req.Algorithm)
+	if !ok {
+		return
+	}
+
+	privHandle, err := s.FindPrivateKey(req.KeyLabel)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to find private key by label '%s': %v", req.KeyLabel, err)
+		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return
+	}
+
+	signature, err := s.Sign(privHandle, alg.Mechanism, data)
+	if err != nil {
+		logger.AppLog.Errorf("Signing with %s failed: %v", alg.Name, err)
+		sendProblemDetails(c, ErrorTitleSigningFailed, ErrorDetailSigningError, ErrorCodeSigningError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("Data signed with key '%s' using %s", req.KeyLabel, alg.Name)
+
+	resp := models.SignResponse{
+		KeyLabel:  req.KeyLabel,
+		Algorithm: alg.Name,
+		Signature: hex.EncodeToString(signature),
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleVerify handles signature verification requests
+// @Summary Verify signature
+// @Description Verifies a signature with the public key of a key pair, an invalid signature is reported with valid set to false
+// @Tags Encryption
+// @Accept json
+// @Produce json
+// @Param request body models.VerifyRequest true "Signed data and signature"
+// @Success 200 {object} models.VerifyResponse "Signature checked"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/verify [post]
+func HandleVerify(c *gin.Context) {
+	logger.AppLog.Info("Processing verify request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.VerifyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.Data == "" {
+		logger.AppLog.Error("Data is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailDataRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.Signature == "" {
+		logger.AppLog.Error("Signature is required but was empty")
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailSignatureRequired, ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	data, err := hex.DecodeString(req.Data)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to decode data hex: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidHexData, ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	signature, err := hex.DecodeString(req.Signature)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to decode signature hex: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidHexSignature, ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	alg, pubHandle, ok := findSignKey(c, s, req.KeyLabel, constants.KEY_OPERATION_VERIFY, req.Algorithm)
+	if !ok {
+		return
+	}
+
+	valid := true
+	if err := s.Verify(pubHandle, alg.Mechanism, data, signature); err != nil {
+		if !isInvalidSignature(err) {
+			logger.AppLog.Errorf("Verification with %s failed: %v", alg.Name, err)
+			sendProblemDetails(c, ErrorTitleVerificationFailed, ErrorDetailVerificationError, ErrorCodeVerificationError, http.StatusInternalServerError, c.Request.URL.Path)
+			return
+		}
+		valid = false
+	}
+
+	logger.AppLog.Infof("Signature with key '%s' using %s checked, valid: %v", req.KeyLabel, alg.Name, valid)
+
+	resp := models.VerifyResponse{
+		KeyLabel:  req.KeyLabel,
+		Algorithm: alg.Name,
+		Valid:     valid,
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
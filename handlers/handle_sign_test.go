@@ -0,0 +1,50 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+func TestSignVerifyAndExportPublicKey(t *testing.T) {
+	f := ssmtest.New(t)
+
+	f.DoJSON("/crypto/generate-ec-key", models.GenECKeyRequest{KeyLabel: "NF_EC"}, http.StatusCreated, nil)
+	f.DoJSON("/crypto/generate-rsa-key", models.GenRSAKeyRequest{KeyLabel: "NF_RSA"}, http.StatusCreated, nil)
+	f.DoJSON("/crypto/generate-ec-key", models.GenECKeyRequest{KeyLabel: "NF_EC"}, http.StatusConflict, nil)
+	f.DoJSON("/crypto/generate-rsa-key", models.GenRSAKeyRequest{KeyLabel: constants.JWTKeyLabel}, http.StatusBadRequest, nil)
+
+	data := "48656c6c6f"
+	for _, tc := range []struct{ label, algorithm string }{{"NF_EC", "ES256"}, {"NF_RSA", "PS256"}, {"NF_RSA", "RS384"}} {
+		var signResp models.SignResponse
+		f.DoJSON("/crypto/sign", models.SignRequest{KeyLabel: tc.label, Algorithm: tc.algorithm, Data: data}, http.StatusOK, &signResp)
+
+		var verifyResp models.VerifyResponse
+		f.DoJSON("/crypto/verify", models.VerifyRequest{KeyLabel: tc.label, Algorithm: tc.algorithm, Data: data, Signature: signResp.Signature}, http.StatusOK, &verifyResp)
+		if !verifyResp.Valid {
+			t.Fatalf("%s signature of %s not valid", tc.algorithm, tc.label)
+		}
+		f.DoJSON("/crypto/verify", models.VerifyRequest{KeyLabel: tc.label, Algorithm: tc.algorithm, Data: "00", Signature: signResp.Signature}, http.StatusOK, &verifyResp)
+		if verifyResp.Valid {
+			t.Fatalf("%s signature of %s valid for other data", tc.algorithm, tc.label)
+		}
+	}
+	f.DoJSON("/crypto/sign", models.SignRequest{KeyLabel: "NF_EC", Algorithm: "PS256", Data: data}, http.StatusBadRequest, nil)
+	// the JWT and audit key pairs of SSM are not usable through the API
+	f.DoJSON("/crypto/sign", models.SignRequest{KeyLabel: constants.JWTKeyLabel, Algorithm: "RS256", Data: data}, http.StatusBadRequest, nil)
+	f.DoJSON("/crypto/verify", models.VerifyRequest{KeyLabel: constants.AuditKeyLabel, Algorithm: "RS256", Data: data, Signature: "00"}, http.StatusBadRequest, nil)
+	f.DoJSON("/crypto/public-key", models.ExportPublicKeyRequest{KeyLabel: constants.JWTKeyLabel}, http.StatusBadRequest, nil)
+
+	var exportResp models.ExportPublicKeyResponse
+	f.DoJSON("/crypto/public-key", models.ExportPublicKeyRequest{KeyLabel: "NF_EC"}, http.StatusOK, &exportResp)
+	if exportResp.KeyType != "EC" || exportResp.Jwk == nil || exportResp.Jwk.Crv != "P-256" || len(exportResp.Jwk.X) != 43 {
+		t.Fatalf("unexpected EC public key export: %+v", exportResp)
+	}
+	if !strings.HasPrefix(exportResp.Pem, "-----BEGIN PUBLIC KEY-----") {
+		t.Fatalf("unexpected PEM: %q", exportResp.Pem)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -15,13 +16,14 @@ import (
 
 // HandleStoreKey handles key storage requests
 // @Summary Store key
-// @Description Stores a key in the HSM and optionally encrypts it
+// @Description Stores a key in the HSM and optionally encrypts it, the key is rejected when it does not match the expected KCV
 // @Tags Key Management
 // @Accept json
 // @Produce json
 // @Param request body models.StoreKeyRequest true "Key data to store"
 // @Success 200 {object} models.StoreKeyResponse "Key stored successfully"
-// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 400 {object} models.ProblemDetails "Invalid request or KCV mismatch"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
 // @Failure 500 {object} models.ProblemDetails "Internal server error"
 // @Router /store-key [post]
 func HandleStoreKey(c *gin.Context) {
@@ -40,10 +42,11 @@ func HandleStoreKey(c *gin.Context) {
 func postStoreKey(c *gin.Context) {
 	logger.AppLog.Info("Processing store key request")
 	//// init the session
-	s := mgr.GetSession()
-	//
-
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	var req models.StoreKeyRequest
 
@@ -53,6 +56,10 @@ func postStoreKey(c *gin.Context) {
 		return
 	}
 
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_STORE, 0) {
+		return
+	}
+
 	label := req.KeyLabel
 	id := req.Id
 	key_type := req.KeyType
@@ -64,32 +71,65 @@ func postStoreKey(c *gin.Context) {
 		return
 	}
 
-	if req.KeyLabel != constants.LABEL_K4_KEY_AES &&
-		req.KeyLabel != constants.LABEL_K4_KEY_DES &&
-		req.KeyLabel != constants.LABEL_K4_KEY_DES3 {
+	if !storableLabel(req.KeyLabel) {
 		logger.AppLog.Errorf("Unsupported key type: %s", req.KeyLabel)
 		sendProblemDetails(c, ErrorTitleBadRequest, "The specified key type is not supported", "UNSUPPORTED_KEY_TYPE", http.StatusBadRequest, c.Request.URL.Path)
 		return
 	}
 
+	validity, ok := parseKeyValidity(c, req.StartDate, req.EndDate)
+	if !ok {
+		return
+	}
+
+	// The key check values are computed before the key reaches the HSM so a
+	// key that does not match the expected KCV is never stored
+	checkValues, err := pkcs11mgr.ComputeKeyCheckValues(key_type, key_value)
+	if err != nil {
+		logger.AppLog.Warnf("No key check values for label %s: %v", label, err)
+	}
+	if req.ExpectedKcv != "" {
+		expected, err := hex.DecodeString(req.ExpectedKcv)
+		if err != nil {
+			logger.AppLog.Errorf("Failed to decode HEX expected KCV: %v", err)
+			sendProblemDetails(c, ErrorTitleBadRequest, "The expected KCV in HEX is not valid", ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+			return
+		}
+		if err := checkValues.Check(expected); err != nil {
+			logger.AppLog.Errorf("Key rejected for label %s: %v", label, err)
+			code := "KCV_MISMATCH"
+			if errors.Is(err, pkcs11mgr.ErrInvalidKCV) {
+				code = "INVALID_EXPECTED_KCV"
+			}
+			sendProblemDetails(c, ErrorTitleBadRequest, err.Error(), code, http.StatusBadRequest, c.Request.URL.Path)
+			return
+		}
+	}
+
 	logger.AppLog.Infof("Storing key in HSM - Label: %s", label)
-	handle, err := pkcs11mgr.StoreKey(label, key_value, id, key_type, *s)
+	handle, err := s.StoreKey(label, key_value, id, key_type)
 	if err != nil {
 		logger.AppLog.Errorf("Failed to store key: %v", err)
 		sendProblemDetails(c, "Key Storage Failed", "Error storing key in HSM", "KEY_STORAGE_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
 		return
 	}
 
+	if !setKeyMetadata(c, s, handle, label, id, validity) {
+		return
+	}
+
 	logger.AppLog.Infof("Key stored successfully - Handle: %d", handle)
 
 	resp := models.StoreKeyResponse{
 		Handle:    int32(handle),
 		CipherKey: "", // Initially empty, will be assigned if encryption is possible
+		Kcv:       hex.EncodeToString(checkValues.KCV),
+		KcvCmac:   hex.EncodeToString(checkValues.CMAC),
 	}
 
 	// Try to find the encryption key to encrypt the stored value
 	logger.AppLog.Infof("Looking for encryption key: %s", constants.LABEL_ENCRYPTION_KEY)
-	findHandle, err := pkcs11mgr.FindKey(constants.LABEL_ENCRYPTION_KEY, 0, *s)
+	findHandle, err := s.FindKey(constants.LABEL_ENCRYPTION_KEY, 0)
 	if err != nil || findHandle == 0 {
 		logger.AppLog.Warnf("Encryption key not found or error: %v. Returning response without encrypted key", err)
 		c.JSON(http.StatusOK, resp)
@@ -98,7 +138,7 @@ func postStoreKey(c *gin.Context) {
 
 	// Encrypt the stored key value
 	logger.AppLog.Info("Encrypting stored key value")
-	cipher, err := pkcs11mgr.EncryptKey(findHandle, nil, key_value, pkcs11.CKM_AES_CBC_PAD, *s)
+	cipher, err := s.EncryptKey(findHandle, nil, key_value, pkcs11.CKM_AES_CBC_PAD)
 	if err != nil {
 		logger.AppLog.Errorf("Failed to encrypt key value: %v. Returning response without encrypted key", err)
 		resp.CipherKey = ""
@@ -113,10 +153,11 @@ func postStoreKey(c *gin.Context) {
 func deleteStoreKey(c *gin.Context) {
 	logger.AppLog.Info("Processing delete key request")
 	//// init the session
-	s := mgr.GetSession()
-	//
-
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	var req models.DeleteKeyRequest
 
@@ -126,12 +167,16 @@ func deleteStoreKey(c *gin.Context) {
 		return
 	}
 
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_DELETE, 0) {
+		return
+	}
+
 	label := req.KeyLabel
 	id := req.Id
 	logger.AppLog.Infof("Deleting key with label: %s, ID: %s", label, id)
 
 	// Delete the key from the HSM
-	if err := pkcs11mgr.DeleteKey(label, id, *s); err != nil {
+	if err := s.DeleteKey(label, id); err != nil {
 		logger.AppLog.Errorf("Failed to delete key: %v", err)
 		sendProblemDetails(c, "Key Deletion Failed", "Error deleting key from HSM", "KEY_DELETION_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
 		return
@@ -150,10 +195,11 @@ func deleteStoreKey(c *gin.Context) {
 func updateStoreKey(c *gin.Context) {
 	logger.AppLog.Info("Processing update key request")
 	//// init the session
-	s := mgr.GetSession()
-	//
-
-	defer mgr.LogoutSession(s)
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
 
 	var req models.UpdateKeyRequest
 
@@ -163,6 +209,10 @@ func updateStoreKey(c *gin.Context) {
 		return
 	}
 
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_UPDATE, 0) {
+		return
+	}
+
 	label := req.KeyLabel
 	id := req.Id
 	keyType := req.KeyType
@@ -177,7 +227,7 @@ func updateStoreKey(c *gin.Context) {
 	}
 
 	// Update the key in the HSM
-	handle, err := pkcs11mgr.UpdateKey(label, keyValue, req.Id, keyType, *s)
+	handle, err := s.UpdateKey(label, keyValue, req.Id, keyType)
 	if err != nil {
 		logger.AppLog.Errorf("Failed to update key: %v", err)
 		sendProblemDetails(c, "Key Update Failed", "Error updating key in HSM", "KEY_UPDATE_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
@@ -195,7 +245,7 @@ func updateStoreKey(c *gin.Context) {
 
 	// Try to find the encryption key to encrypt the new value
 	logger.AppLog.Infof("Looking for encryption key: %s", constants.LABEL_ENCRYPTION_KEY)
-	findHandle, err := pkcs11mgr.FindKey(constants.LABEL_ENCRYPTION_KEY, 0, *s)
+	findHandle, err := s.FindKey(constants.LABEL_ENCRYPTION_KEY, 0)
 	if err != nil || findHandle == 0 {
 		logger.AppLog.Warnf("Encryption key not found or error: %v. Returning response without encrypted key", err)
 		c.JSON(http.StatusOK, resp)
@@ -204,7 +254,7 @@ func updateStoreKey(c *gin.Context) {
 
 	// Encrypt the new key value
 	logger.AppLog.Info("Encrypting updated key value")
-	cipher, err := pkcs11mgr.EncryptKey(findHandle, nil, keyValue, pkcs11.CKM_AES_CBC_PAD, *s)
+	cipher, err := s.EncryptKey(findHandle, nil, keyValue, pkcs11.CKM_AES_CBC_PAD)
 	if err != nil {
 		logger.AppLog.Errorf("Failed to encrypt updated key value: %v. Returning response without encrypted key", err)
 		resp.CipherKey = ""
@@ -215,3 +265,12 @@ func updateStoreKey(c *gin.Context) {
 
 	c.JSON(http.StatusOK, resp)
 }
+
+// storableLabel reports whether keys may be imported under label
+func storableLabel(label string) bool {
+	switch label {
+	case constants.LABEL_K4_KEY_AES, constants.LABEL_K4_KEY_DES, constants.LABEL_K4_KEY_DES3:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/safe"
+	"github.com/networkgcorefullcode/ssm/server/middleware"
+	"github.com/networkgcorefullcode/ssm/suci"
+)
+
+// suciProfile is an ECIES protection scheme and the key pair label it uses
+type suciProfile struct {
+	label     string
+	curve     string
+	scheme    int
+	mechanism uint // generates the key pairs of the curve
+}
+
+var suciProfiles = map[string]suciProfile{
+	"A": {constants.LABEL_SUCI_PROFILE_A, pkcs11mgr.EC_CURVE_X25519, suci.SchemeProfileA, pkcs11mgr.CKM_EC_MONTGOMERY_KEY_PAIR_GEN},
+	"B": {constants.LABEL_SUCI_PROFILE_B, pkcs11mgr.EC_CURVE_P256, suci.SchemeProfileB, pkcs11.CKM_EC_KEY_PAIR_GEN},
+}
+
+// checkSuciMechanisms sends a 501 when the token does not support the curve
+// of the profile or, for the de-concealment, CKM_ECDH1_DERIVE. Profile A
+// needs a PKCS#11 3.0 token with CKK_EC_MONTGOMERY.
+func checkSuciMechanisms(c *gin.Context, s pkcs11mgr.KeyStore, profile suciProfile, derive bool) bool {
+	mechanisms := []uint{profile.mechanism}
+	if derive {
+		mechanisms = append(mechanisms, pkcs11.CKM_ECDH1_DERIVE)
+	}
+	for _, mechanism := range mechanisms {
+		if !s.SupportsMechanism(profile.label, mechanism) {
+			logger.AppLog.Errorf("The token of %s does not support mechanism 0x%x", profile.label, mechanism)
+			sendProblemDetails(c, ErrorTitleNotImplemented, fmt.Sprintf("The token does not support the %s curve or the ECDH of the profile", profile.curve), ErrorCodeMechanismUnsupported, http.StatusNotImplemented, c.Request.URL.Path)
+			return false
+		}
+	}
+	return true
+}
+
+// checkSuciProfile validates the profile and home network key id of a request,
+// on failure it writes the problem details and returns false
+func checkSuciProfile(c *gin.Context, name string, keyID int32) (suciProfile, bool) {
+	profile, ok := suciProfiles[strings.ToUpper(name)]
+	if !ok {
+		logger.AppLog.Errorf("Unsupported SUCI profile: %s", name)
+		sendProblemDetails(c, ErrorTitleValidationError, "The profile must be A or B", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return suciProfile{}, false
+	}
+	if keyID < 0 || keyID > 255 {
+		logger.AppLog.Errorf("Invalid home network key id: %d", keyID)
+		sendProblemDetails(c, ErrorTitleValidationError, "The home network key id must be between 0 and 255", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return suciProfile{}, false
+	}
+	return profile, true
+}
+
+// suciKeyResponse reads the public key of a home network key pair in the form
+// it is provisioned on the SIM: the raw X25519 key or the compressed P-256 point
+func suciKeyResponse(s pkcs11mgr.KeyStore, name string, keyID int32, profile suciProfile, pubHandle pkcs11.ObjectHandle) (models.SuciKeyResponse, error) {
+	pub, err := s.GetPublicKey(pubHandle)
+	if err != nil {
+		return models.SuciKeyResponse{}, err
+	}
+	var raw []byte
+	switch key := pub.(type) {
+	case *ecdh.PublicKey:
+		raw = key.Bytes()
+	case *ecdsa.PublicKey:
+		raw = elliptic.MarshalCompressed(key.Curve, key.X, key.Y)
+	default:
+		return models.SuciKeyResponse{}, fmt.Errorf("unexpected public key type %T", pub)
+	}
+	return models.SuciKeyResponse{
+		Profile:   strings.ToUpper(name),
+		HnKeyId:   keyID,
+		KeyLabel:  profile.label,
+		PublicKey: hex.EncodeToString(raw),
+	}, nil
+}
+
+// HandleGenerateSuciKey handles SUCI home network key pair generation requests
+// @Summary Generate SUCI home network key pair
+// @Description Generates an X25519 (Profile A) or secp256r1 (Profile B) home network key pair, the private key never leaves the HSM
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.GenSuciKeyRequest true "Profile and home network key id"
+// @Success 201 {object} models.SuciKeyResponse "Key pair generated successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 409 {object} models.ProblemDetails "Key already exists"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Failure 501 {object} models.ProblemDetails "The token does not support the curve of the profile"
+// @Router /crypto/generate-suci-key [post]
+func HandleGenerateSuciKey(c *gin.Context) {
+	logger.AppLog.Info("Processing SUCI key pair generation request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.GenSuciKeyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	profile, ok := checkSuciProfile(c, req.Profile, req.HnKeyId)
+	if !ok {
+		return
+	}
+	if !authorizeKeyOperation(c, profile.label, constants.KEY_OPERATION_GENERATE, 0) {
+		return
+	}
+	if !checkSuciMechanisms(c, s, profile, false) {
+		return
+	}
+
+	pub, _, err := s.GenerateECDHKeyPair(profile.label, req.HnKeyId, profile.curve)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to generate SUCI key pair: %v", err)
+		if err.Error() == constants.ERROR_STRING_KEY_EXISTS {
+			sendProblemDetails(c, ErrorTitleConflict, ErrorDetailKeyAlreadyExists, ErrorCodeKeyAlreadyExists, http.StatusConflict, c.Request.URL.Path)
+			return
+		}
+		sendProblemDetails(c, ErrorTitleKeyGenerationFailed, ErrorDetailKeyGenerationError, ErrorCodeKeyGenerationError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	resp, err := suciKeyResponse(s, req.Profile, req.HnKeyId, profile, pub)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to read SUCI public key: %v", err)
+		sendProblemDetails(c, ErrorTitleAttributesNotFound, ErrorDetailAttributesNotFound, ErrorCodeAttributesNotFound, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("SUCI profile %s key pair generated with home network key id %d", resp.Profile, req.HnKeyId)
+	c.JSON(http.StatusCreated, resp)
+}
+
+// HandleImportSuciKey handles SUCI home network private key imports
+// @Summary Import SUCI home network private key
+// @Description Imports an existing home network private key into the HSM and returns its public key
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.ImportSuciKeyRequest true "Profile, home network key id and private key"
+// @Success 201 {object} models.SuciKeyResponse "Key pair imported successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 409 {object} models.ProblemDetails "Key already exists"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Failure 501 {object} models.ProblemDetails "The token does not support the curve of the profile"
+// @Router /crypto/import-suci-key [post]
+func HandleImportSuciKey(c *gin.Context) {
+	logger.AppLog.Info("Processing SUCI key import request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.ImportSuciKeyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	profile, ok := checkSuciProfile(c, req.Profile, req.HnKeyId)
+	if !ok {
+		return
+	}
+	if !authorizeKeyOperation(c, profile.label, constants.KEY_OPERATION_STORE, 0) {
+		return
+	}
+	if !checkSuciMechanisms(c, s, profile, false) {
+		return
+	}
+
+	privateKey, err := decodeFixedHex("The private key", req.PrivateKey, 32)
+	if err != nil {
+		logger.AppLog.Errorf("Invalid SUCI private key: %v", err)
+		sendProblemDetails(c, ErrorTitleValidationError, err.Error(), ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	defer safe.Zero(privateKey)
+
+	pub, _, err := s.ImportECDHKeyPair(profile.label, req.HnKeyId, profile.curve, privateKey)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to import SUCI key pair: %v", err)
+		if err.Error() == constants.ERROR_STRING_KEY_EXISTS {
+			sendProblemDetails(c, ErrorTitleConflict, ErrorDetailKeyAlreadyExists, ErrorCodeKeyAlreadyExists, http.StatusConflict, c.Request.URL.Path)
+			return
+		}
+		sendProblemDetails(c, "Key Storage Failed", "Error storing key in HSM", "KEY_STORAGE_ERROR", http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	resp, err := suciKeyResponse(s, req.Profile, req.HnKeyId, profile, pub)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to read SUCI public key: %v", err)
+		sendProblemDetails(c, ErrorTitleAttributesNotFound, ErrorDetailAttributesNotFound, ErrorCodeAttributesNotFound, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("SUCI profile %s key pair imported with home network key id %d", resp.Profile, req.HnKeyId)
+	c.JSON(http.StatusCreated, resp)
+}
+
+// HandleExportSuciPublicKey handles SUCI home network public key exports
+// @Summary Export SUCI home network public key
+// @Description Returns the home network public key in the format provisioned on the SIM
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.SuciPublicKeyRequest true "Profile and home network key id"
+// @Success 200 {object} models.SuciKeyResponse "Public key exported successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/suci-public-key [post]
+func HandleExportSuciPublicKey(c *gin.Context) {
+	logger.AppLog.Info("Processing SUCI public key export request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.SuciPublicKeyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	profile, ok := checkSuciProfile(c, req.Profile, req.HnKeyId)
+	if !ok {
+		return
+	}
+	if !authorizeKeyOperation(c, profile.label, constants.KEY_OPERATION_READ, 0) {
+		return
+	}
+
+	pub, _, err := s.FindECDHKeyPair(profile.label, req.HnKeyId)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to find SUCI key pair '%s' (id %d): %v", profile.label, req.HnKeyId, err)
+		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return
+	}
+
+	resp, err := suciKeyResponse(s, req.Profile, req.HnKeyId, profile, pub)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to read SUCI public key: %v", err)
+		sendProblemDetails(c, ErrorTitleAttributesNotFound, ErrorDetailAttributesNotFound, ErrorCodeAttributesNotFound, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleSuciDeconceal handles SUCI de-concealment requests
+// @Summary De-conceal SUCI
+// @Description Returns the SUPI of a SUCI, the ECDH with the home network private key runs in the HSM
+// @Tags Encryption
+// @Accept json
+// @Produce json
+// @Param request body models.SuciDeconcealRequest true "SUCI to de-conceal"
+// @Success 200 {object} models.SuciDeconcealResponse "SUCI de-concealed successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Failure 501 {object} models.ProblemDetails "The token does not support the curve of the profile or CKM_ECDH1_DERIVE"
+// @Router /crypto/suci-deconceal [post]
+func HandleSuciDeconceal(c *gin.Context) {
+	logger.AppLog.Info("Processing SUCI de-concealment request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.SuciDeconcealRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	parsed, err := suci.Parse(req.Suci)
+	if err != nil {
+		logger.AppLog.Errorf("Invalid SUCI: %v", err)
+		sendProblemDetails(c, ErrorTitleValidationError, err.Error(), ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	middleware.SetSuciAudit(c, middleware.SuciAudit{Scheme: parsed.Scheme, HomeNetworkKeyID: parsed.HomeNetworkKeyID})
+
+	if parsed.SupiType != suci.SupiTypeIMSI {
+		logger.AppLog.Errorf("Unsupported SUPI type: %s", parsed.SupiType)
+		sendProblemDetails(c, ErrorTitleValidationError, "Only IMSI based SUCIs are supported", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	// the null scheme carries the MSIN in the clear
+	if parsed.Scheme == suci.SchemeNull {
+		if !isMSIN(parsed.SchemeOutput) {
+			logger.AppLog.Error("Invalid null scheme output")
+			sendProblemDetails(c, ErrorTitleValidationError, "The null scheme output must be the MSIN digits", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+			return
+		}
+		c.JSON(http.StatusOK, models.SuciDeconcealResponse{Supi: parsed.SUPI(parsed.SchemeOutput)})
+		return
+	}
+
+	var profile suciProfile
+	for _, p := range suciProfiles {
+		if p.scheme == parsed.Scheme {
+			profile = p
+		}
+	}
+	if profile.label == "" {
+		logger.AppLog.Errorf("Unsupported protection scheme: %d", parsed.Scheme)
+		sendProblemDetails(c, ErrorTitleValidationError, "The protection scheme must be null, Profile A or Profile B", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	ephemeral, ciphertext, mac, err := suci.Split(parsed.Scheme, parsed.SchemeOutput)
+	var peer []byte
+	if err == nil {
+		peer, err = suci.PeerPublicKey(parsed.Scheme, ephemeral)
+	}
+	if err != nil {
+		logger.AppLog.Errorf("Invalid SUCI scheme output: %v", err)
+		sendProblemDetails(c, ErrorTitleValidationError, err.Error(), ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if !authorizeKeyOperation(c, profile.label, constants.KEY_OPERATION_DERIVE, 0) {
+		return
+	}
+	if !checkSuciMechanisms(c, s, profile, true) {
+		return
+	}
+
+	_, priv, err := s.FindECDHKeyPair(profile.label, int32(parsed.HomeNetworkKeyID))
+	if err != nil {
+		logger.AppLog.Errorf("Failed to find SUCI key pair '%s' (id %d): %v", profile.label, parsed.HomeNetworkKeyID, err)
+		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return
+	}
+
+	shared, err := s.DeriveECDH(priv, peer)
+	if err != nil {
+		logger.AppLog.Errorf("ECDH with the home network key failed: %v", err)
+		sendProblemDetails(c, ErrorTitleDecryptionFailed, "Error de-concealing the SUCI", ErrorCodeDecryptionError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	// Decrypt zeroes the shared secret once the KDF has run
+	plain, err := suci.Decrypt(shared, ephemeral, ciphertext, mac)
+	if err != nil {
+		logger.AppLog.Errorf("SUCI de-concealment failed: %v", err)
+		if errors.Is(err, suci.ErrMacMismatch) {
+			sendProblemDetails(c, ErrorTitleDecryptionFailed, "The SUCI MAC tag does not match the home network key", "SUCI_MAC_MISMATCH", http.StatusBadRequest, c.Request.URL.Path)
+			return
+		}
+		sendProblemDetails(c, ErrorTitleDecryptionFailed, "Error de-concealing the SUCI", ErrorCodeDecryptionError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	msin, err := suci.DecodeMSIN(plain)
+	safe.Zero(plain)
+	if err != nil {
+		logger.AppLog.Errorf("SUCI de-concealment failed: %v", err)
+		sendProblemDetails(c, ErrorTitleValidationError, err.Error(), ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("SUCI de-concealed with profile scheme %d and home network key id %d", parsed.Scheme, parsed.HomeNetworkKeyID)
+	c.JSON(http.StatusOK, models.SuciDeconcealResponse{Supi: parsed.SUPI(msin)})
+}
+
+// isMSIN reports whether s holds the digits of an MSIN
+func isMSIN(s string) bool {
+	return len(s) > 0 && len(s) <= 10 && strings.Trim(s, "0123456789") == ""
+}
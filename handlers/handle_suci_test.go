@@ -0,0 +1,91 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/handlers"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+func TestSuciDeconcealAnnexC4(t *testing.T) {
+	f := ssmtest.New(t)
+
+	// TS 33.501 Annex C.4 home network keys
+	for _, tc := range []struct{ profile, private, public, suci string }{
+		{
+			"A",
+			"c53c22208b61860b06c62e5406a7b330c2b577aa5558981510d128247d38bd1d",
+			"5a8d38864820197c3394b92613b20b91633cbd897119273bf8e4a6f4eec0a650",
+			"suci-0-001-01-0000-1-0-b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa87",
+		},
+		{
+			"B",
+			"f1ab1074477ebcc7f554ea1c5fc368b1616730155e0041ac447d6301975fecda",
+			"0272da71976234ce833a6907425867b82e074d44ef907dfb4b3e21c1c2256ebcd1",
+			"suci-0-001-01-0000-2-0-039aab8376597021e855679a9778ea0b67396e68c66df32c0f41e9acca2da9b9d146a33fc2716ac7dae96aa30a4d",
+		},
+	} {
+		var key models.SuciKeyResponse
+		f.DoJSON("/crypto/import-suci-key", models.ImportSuciKeyRequest{Profile: tc.profile, PrivateKey: tc.private}, http.StatusCreated, &key)
+		if key.PublicKey != tc.public {
+			t.Fatalf("profile %s public key = %s", tc.profile, key.PublicKey)
+		}
+		f.DoJSON("/crypto/import-suci-key", models.ImportSuciKeyRequest{Profile: tc.profile, PrivateKey: tc.private}, http.StatusConflict, nil)
+
+		var resp models.SuciDeconcealResponse
+		f.DoJSON("/crypto/suci-deconceal", models.SuciDeconcealRequest{Suci: tc.suci}, http.StatusOK, &resp)
+		if resp.Supi != "imsi-00101001002086" {
+			t.Fatalf("profile %s SUPI = %s", tc.profile, resp.Supi)
+		}
+
+		// the MAC tag is the last byte of the SUCI
+		tampered := tc.suci[:len(tc.suci)-1] + "0"
+		f.DoJSON("/crypto/suci-deconceal", models.SuciDeconcealRequest{Suci: tampered}, http.StatusBadRequest, nil)
+	}
+
+	var generated, exported models.SuciKeyResponse
+	f.DoJSON("/crypto/generate-suci-key", models.GenSuciKeyRequest{Profile: "B", HnKeyId: 1}, http.StatusCreated, &generated)
+	f.DoJSON("/crypto/suci-public-key", models.SuciPublicKeyRequest{Profile: "B", HnKeyId: 1}, http.StatusOK, &exported)
+	if exported.PublicKey != generated.PublicKey || len(exported.PublicKey) != 66 {
+		t.Fatalf("exported %q, generated %q", exported.PublicKey, generated.PublicKey)
+	}
+	f.DoJSON("/crypto/suci-deconceal", models.SuciDeconcealRequest{Suci: "suci-0-001-01-0000-1-7-b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa87"}, http.StatusNotFound, nil)
+}
+
+func TestSuciKeyPolicy(t *testing.T) {
+	f := ssmtest.New(t)
+	f.DoJSON("/crypto/generate-suci-key", models.GenSuciKeyRequest{Profile: "B", HnKeyId: 1}, http.StatusCreated, nil)
+
+	restrictToUDM(constants.LABEL_FAMILY_SUCI, constants.KEY_OPERATION_GENERATE, constants.KEY_OPERATION_STORE, constants.KEY_OPERATION_READ)
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/generate-suci-key", handlers.HandleGenerateSuciKey, models.GenSuciKeyRequest{Profile: "B", HnKeyId: 2}), http.StatusForbidden)
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/import-suci-key", handlers.HandleImportSuciKey, models.ImportSuciKeyRequest{
+		Profile: "A", PrivateKey: "c53c22208b61860b06c62e5406a7b330c2b577aa5558981510d128247d38bd1d",
+	}), http.StatusForbidden)
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/suci-public-key", handlers.HandleExportSuciPublicKey, models.SuciPublicKeyRequest{Profile: "B", HnKeyId: 1}), http.StatusForbidden)
+
+	factory.SsmConfig.Configuration.KeyPolicies = nil
+	checkStatus(t, f.DoAs(constants.ROLE_WEBCONSOLE, "/crypto/suci-public-key", handlers.HandleExportSuciPublicKey, models.SuciPublicKeyRequest{Profile: "B", HnKeyId: 1}), http.StatusOK)
+}
+
+// TestSuciProfileAWithoutMontgomery checks that Profile A is refused on a token
+// without Curve25519 and that Profile B still works there
+func TestSuciProfileAWithoutMontgomery(t *testing.T) {
+	f := ssmtest.New(t)
+	profileA := "suci-0-001-01-0000-1-0-b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa87"
+	f.DoJSON("/crypto/import-suci-key", models.ImportSuciKeyRequest{Profile: "A", PrivateKey: "c53c22208b61860b06c62e5406a7b330c2b577aa5558981510d128247d38bd1d"}, http.StatusCreated, nil)
+
+	f.Provider.DisableMechanisms(pkcs11mgr.CKM_EC_MONTGOMERY_KEY_PAIR_GEN)
+	f.DoJSON("/crypto/generate-suci-key", models.GenSuciKeyRequest{Profile: "A", HnKeyId: 1}, http.StatusNotImplemented, nil)
+	f.DoJSON("/crypto/import-suci-key", models.ImportSuciKeyRequest{Profile: "A", HnKeyId: 2, PrivateKey: "c53c22208b61860b06c62e5406a7b330c2b577aa5558981510d128247d38bd1d"}, http.StatusNotImplemented, nil)
+	f.DoJSON("/crypto/suci-deconceal", models.SuciDeconcealRequest{Suci: profileA}, http.StatusNotImplemented, nil)
+	f.DoJSON("/crypto/generate-suci-key", models.GenSuciKeyRequest{Profile: "B", HnKeyId: 1}, http.StatusCreated, nil)
+
+	f.Provider.DisableMechanisms(pkcs11.CKM_ECDH1_DERIVE)
+	f.DoJSON("/crypto/suci-deconceal", models.SuciDeconcealRequest{Suci: "suci-0-001-01-0000-2-1-039aab8376597021e855679a9778ea0b67396e68c66df32c0f41e9acca2da9b9d146a33fc2716ac7dae96aa30a4d"}, http.StatusNotImplemented, nil)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/networkgcorefullcode/ssm/database"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/safe"
+	"github.com/networkgcorefullcode/ssm/server/middleware"
+)
+
+// TokenStore keeps the refresh tokens and the revoked access tokens
+type TokenStore interface {
+	SaveRefreshToken(token database.RefreshToken) error
+	ConsumeRefreshToken(tokenHash string) (database.RefreshToken, error)
+	DeleteRefreshTokens(accessJti string) error
+	RevokeToken(token database.RevokedToken) error
+	RevokeServiceTokens(serviceID string, now time.Time) ([]database.RevokedToken, error)
+}
+
+var tokenStore TokenStore = database.TokenStore{}
+
+// SetTokenStore replaces the MongoDB store of the tokens
+func SetTokenStore(s TokenStore) {
+	tokenStore = s
+}
+
+// hashRefreshToken returns the SHA-256 of a refresh token, the form it is
+// stored in
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens signs a short lived access token for the service account and
+// stores a new refresh token bound to it
+func issueTokens(ks pkcs11mgr.KeyStore, account database.UserSecret, message string) (models.LoginResponse, error) {
+	serviceID := account.ServiceID
+	jwtConfig := factory.SsmConfig.GetJWT()
+	lifetime := time.Duration(jwtConfig.AccessTokenTTL) * time.Minute
+	token, payload, err := pkcs11mgr.CreateRoleJWT(ks, pkcs11mgr.JWTIssuer, serviceID, account.ServiceRole(), pkcs11mgr.JWTAudience, lifetime)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+
+	raw := make([]byte, 32)
+	if err := safe.RandRead(raw); err != nil {
+		return models.LoginResponse{}, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now()
+	err = tokenStore.SaveRefreshToken(database.RefreshToken{
+		TokenHash:       hashRefreshToken(refreshToken),
+		ServiceID:       serviceID,
+		AccessJti:       payload.Jti,
+		AccessExpiresAt: time.Unix(payload.Exp, 0),
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(time.Duration(jwtConfig.RefreshTokenTTL) * time.Hour),
+	})
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+
+	return models.LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int32(lifetime.Seconds()),
+		Message:      message,
+	}, nil
+}
+
+// HandleRefreshToken handles refresh token requests
+// @Summary Refresh the access token
+// @Description Exchanges a refresh token for a new access token and a new refresh token, the refresh token can only be used once
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body models.RefreshTokenRequest true "Refresh token"
+// @Success 200 {object} models.LoginResponse "Token refreshed"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 401 {object} models.ProblemDetails "Invalid or expired refresh token"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /token/refresh [post]
+func HandleRefreshToken(c *gin.Context) {
+	logger.AppLog.Info("Processing token refresh request")
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		logger.AppLog.Errorf("Invalid JSON payload: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	// the refresh token is removed before the new one is issued, a replayed
+	// token is not found
+	stored, err := tokenStore.ConsumeRefreshToken(hashRefreshToken(req.RefreshToken))
+	if err != nil && !errors.Is(err, database.ErrTokenNotFound) {
+		logger.AppLog.Errorf("Failed to read the refresh token: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Token store error", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	if err != nil || !time.Now().Before(stored.ExpiresAt) {
+		logger.AppLog.Warn("Unknown, used or expired refresh token")
+		sendProblemDetails(c, ErrorTitleUnauthorized, "Invalid or expired refresh token", ErrorCodeUnauthorized, http.StatusUnauthorized, c.Request.URL.Path)
+		return
+	}
+
+	// a disabled account cannot extend its session
+	account, err := accountStore.GetAccount(stored.ServiceID)
+	if err != nil && !errors.Is(err, database.ErrAccountNotFound) {
+		logger.AppLog.Errorf("Failed to read the service account: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "User data processing error", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	if err != nil || account.Disabled {
+		logger.AppLog.Warnf("Refresh token of the unknown or disabled account %s", stored.ServiceID)
+		sendProblemDetails(c, ErrorTitleUnauthorized, "Invalid or expired refresh token", ErrorCodeUnauthorized, http.StatusUnauthorized, c.Request.URL.Path)
+		return
+	}
+
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	response, err := issueTokens(s, account, "Token refreshed")
+	if err != nil {
+		logger.AppLog.Errorf("Failed to issue the tokens: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Token generation failed", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("Tokens of %s refreshed", stored.ServiceID)
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleLogout handles logout requests
+// @Summary End a session
+// @Description Revokes the access token of the request until it expires and deletes its refresh token, a refresh token in the body is deleted as well
+// @Tags Authentication
+// @Accept json
+// @Security BearerAuth
+// @Param request body models.LogoutRequest false "Refresh token to delete"
+// @Success 204 "Logged out"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 401 {object} models.ProblemDetails "Missing or invalid access token"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /logout [post]
+func HandleLogout(c *gin.Context) {
+	logger.AppLog.Info("Processing logout request")
+	var req models.LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		logger.AppLog.Errorf("Invalid JSON payload: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	// /logout is outside of /crypto, the access token is verified here
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	payload, err := pkcs11mgr.VerifyJWT(s, token)
+	if err != nil || middleware.IsTokenRevoked(payload.Jti) {
+		logger.AppLog.Warnf("Logout with an invalid access token: %v", err)
+		sendProblemDetails(c, ErrorTitleUnauthorized, "Invalid or expired access token", ErrorCodeUnauthorized, http.StatusUnauthorized, c.Request.URL.Path)
+		return
+	}
+
+	expiresAt := time.Unix(payload.Exp, 0)
+	if err := tokenStore.RevokeToken(database.RevokedToken{Jti: payload.Jti, ServiceID: payload.Sub, ExpiresAt: expiresAt}); err != nil {
+		logger.AppLog.Errorf("Failed to revoke the access token: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Token store error", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	middleware.RevokeToken(payload.Jti, expiresAt)
+
+	if err := tokenStore.DeleteRefreshTokens(payload.Jti); err != nil {
+		logger.AppLog.Errorf("Failed to delete the refresh tokens: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Token store error", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	if req.RefreshToken != "" {
+		if _, err := tokenStore.ConsumeRefreshToken(hashRefreshToken(req.RefreshToken)); err != nil && !errors.Is(err, database.ErrTokenNotFound) {
+			logger.AppLog.Errorf("Failed to delete the refresh token: %v", err)
+			sendProblemDetails(c, ErrorTitleInternalServerError, "Token store error", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+			return
+		}
+	}
+
+	logger.AppLog.Infof("User %s logged out", payload.Sub)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package handlers_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/database"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/server/middleware"
+)
+
+func TestRefreshTokenAndLogout(t *testing.T) {
+	f := ssmtest.New(t)
+	f.InitJWT()
+	ks := f.KeyStore
+
+	_, store := f.UseAccounts(database.UserSecret{ServiceID: constants.USER_WEBCONSOLE})
+
+	// a refresh token issued by /login
+	seed := sha256.Sum256([]byte("login-refresh-token"))
+	store.Refresh[hex.EncodeToString(seed[:])] = database.RefreshToken{
+		TokenHash: hex.EncodeToString(seed[:]),
+		ServiceID: constants.USER_WEBCONSOLE,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	var first models.LoginResponse
+	f.DoJSON("/token/refresh", models.RefreshTokenRequest{RefreshToken: "login-refresh-token"}, http.StatusOK, &first)
+	if first.Token == "" || first.RefreshToken == "" || first.ExpiresIn != 15*60 {
+		t.Fatalf("unexpected refresh response %+v", first)
+	}
+	payload, err := pkcs11mgr.VerifyJWT(ks, first.Token)
+	if err != nil || payload.Sub != constants.USER_WEBCONSOLE || payload.Exp-payload.Iat != 15*60 {
+		t.Fatalf("VerifyJWT = %+v, %v", payload, err)
+	}
+	// a refresh token is used once
+	f.DoJSON("/token/refresh", models.RefreshTokenRequest{RefreshToken: "login-refresh-token"}, http.StatusUnauthorized, nil)
+	f.DoJSON("/token/refresh", models.RefreshTokenRequest{}, http.StatusBadRequest, nil)
+
+	var second models.LoginResponse
+	f.DoJSON("/token/refresh", models.RefreshTokenRequest{RefreshToken: first.RefreshToken}, http.StatusOK, &second)
+
+	// the tokens are accepted by the /crypto middleware until the logout
+	secure := gin.New()
+	secure.GET("/crypto/health-check", middleware.AuthenticateRequest(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	if w := ssmtest.DoBearer(secure, http.MethodGet, "/crypto/health-check", second.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("health-check before logout: status %d, body: %s", w.Code, w.Body.String())
+	}
+
+	if w := f.DoBearer(http.MethodPost, "/logout", second.Token, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("logout: status %d, body: %s", w.Code, w.Body.String())
+	}
+	if w := ssmtest.DoBearer(secure, http.MethodGet, "/crypto/health-check", second.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("health-check after logout: status %d", w.Code)
+	}
+	if w := f.DoBearer(http.MethodPost, "/logout", second.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("second logout: status %d", w.Code)
+	}
+	// the refresh token of the revoked access token is gone
+	f.DoJSON("/token/refresh", models.RefreshTokenRequest{RefreshToken: second.RefreshToken}, http.StatusUnauthorized, nil)
+
+	// a token revoked by another SSM is rejected after the sync
+	other, otherPayload, err := pkcs11mgr.CreateStandardJWT(ks, pkcs11mgr.JWTIssuer, constants.USER_WEBCONSOLE, pkcs11mgr.JWTAudience, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Revoked = append(store.Revoked, database.RevokedToken{Jti: otherPayload.Jti, ExpiresAt: time.Unix(otherPayload.Exp, 0)})
+	if w := ssmtest.DoBearer(secure, http.MethodGet, "/crypto/health-check", other, nil); w.Code != http.StatusOK {
+		t.Fatalf("health-check before sync: status %d, body: %s", w.Code, w.Body.String())
+	}
+	if err := middleware.SyncRevocations(); err != nil {
+		t.Fatal(err)
+	}
+	if w := ssmtest.DoBearer(secure, http.MethodGet, "/crypto/health-check", other, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("health-check after sync: status %d", w.Code)
+	}
+}
@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+// Label families whose keys can be exchanged wrapped under a transport key
+var wrappableFamilies = []string{
+	constants.LABEL_FAMILY_K4,
+	constants.LABEL_FAMILY_ENCRYPTION,
+}
+
+// checkWrapLabel checks the key label of a wrap or unwrap request: the AES
+// mechanisms carry the K4 and key encryption keys, RSA-OAEP only carries
+// the transport key. On failure it writes the problem details and returns false.
+func checkWrapLabel(c *gin.Context, label, mechanism string) (uint, bool) {
+	mech, ok := pkcs11mgr.WrapMechanisms[mechanism]
+	if !ok {
+		logger.AppLog.Errorf("Unsupported wrap mechanism: %s", mechanism)
+		sendProblemDetails(c, ErrorTitleBadRequest, "The mechanism must be AES-KEY-WRAP, AES-KEY-WRAP-PAD or RSA-OAEP", "UNSUPPORTED_ALGORITHM", http.StatusBadRequest, c.Request.URL.Path)
+		return 0, false
+	}
+	if mechanism == pkcs11mgr.WRAP_RSA_OAEP {
+		if label != constants.LABEL_TRANSPORT_KEY {
+			logger.AppLog.Errorf("Key label '%s' cannot be wrapped with RSA-OAEP", label)
+			sendProblemDetails(c, ErrorTitleValidationError, "RSA-OAEP only wraps the TRANSPORT_KEY", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+			return 0, false
+		}
+		return mech, true
+	}
+	if !slices.Contains(wrappableFamilies, constants.LabelFamilyMap[label]) {
+		logger.AppLog.Errorf("Key label '%s' cannot be wrapped under a transport key", label)
+		sendProblemDetails(c, ErrorTitleValidationError, "The key label must be a K4_* or KEY_ENCRYPTION_* label", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return 0, false
+	}
+	return mech, true
+}
+
+// pinnedTransportPeer returns the name of the configured transport peer whose
+// TRANSPORT_RSA public key is pub, a transport key is never wrapped under a
+// key that only comes from the request
+func pinnedTransportPeer(pub *rsa.PublicKey) (string, bool) {
+	for _, peer := range factory.SsmConfig.Configuration.TransportPeers {
+		data, err := os.ReadFile(peer.PublicKeyFile)
+		if err != nil {
+			logger.AppLog.Errorf("Failed to read the public key of the transport peer %s: %v", peer.Name, err)
+			continue
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			logger.AppLog.Errorf("The public key of the transport peer %s is not PEM encoded", peer.Name)
+			continue
+		}
+		pinned, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			logger.AppLog.Errorf("Invalid public key of the transport peer %s: %v", peer.Name, err)
+			continue
+		}
+		if pub.Equal(pinned) {
+			return peer.Name, true
+		}
+	}
+	return "", false
+}
+
+// HandleGenerateTransportKeyPair handles RSA transport key pair generation requests
+// @Summary Generate RSA transport key pair
+// @Description Generates the TRANSPORT_RSA key pair, a peer SSM wraps its TRANSPORT_KEY under the public key with RSA-OAEP
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.GenTransportKeyPairRequest true "RSA modulus size"
+// @Success 201 {object} models.TransportKeyPairResponse "Key pair generated successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 409 {object} models.ProblemDetails "Key pair already exists"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/generate-transport-key-pair [post]
+func HandleGenerateTransportKeyPair(c *gin.Context) {
+	logger.AppLog.Info("Processing transport key pair generation request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.GenTransportKeyPairRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.Bits == 0 {
+		req.Bits = 3072
+	}
+	if req.Bits != 3072 && req.Bits != 4096 {
+		logger.AppLog.Errorf("Invalid key size: %d bits", req.Bits)
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailInvalidKeySize, ErrorCodeInvalidKeySize, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if !authorizeKeyOperation(c, constants.LABEL_TRANSPORT_KEY_PAIR, constants.KEY_OPERATION_GENERATE, 0) {
+		return
+	}
+
+	pubHandle, _, err := s.GenerateRSAWrapKeyPair(constants.LABEL_TRANSPORT_KEY_PAIR, int(req.Bits))
+	if err != nil {
+		if err.Error() == constants.ERROR_STRING_KEY_EXISTS {
+			logger.AppLog.Errorf("Transport key pair already exists")
+			sendProblemDetails(c, ErrorTitleConflict, ErrorDetailKeyAlreadyExists, ErrorCodeKeyAlreadyExists, http.StatusConflict, c.Request.URL.Path)
+			return
+		}
+		logger.AppLog.Errorf("Transport key pair generation failed: %v", err)
+		sendProblemDetails(c, ErrorTitleKeyGenerationFailed, ErrorDetailKeyGenerationError, ErrorCodeKeyGenerationError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	pub, err := s.GetPublicKey(pubHandle)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to read the transport public key: %v", err)
+		sendProblemDetails(c, ErrorTitleAttributesNotFound, ErrorDetailAttributesNotFound, ErrorCodeAttributesNotFound, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to encode the transport public key: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error encoding the public key", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("Transport key pair generated (%d bits)", req.Bits)
+	c.JSON(http.StatusCreated, models.TransportKeyPairResponse{
+		KeyLabel: constants.LABEL_TRANSPORT_KEY_PAIR,
+		Pem:      string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	})
+}
+
+// HandleGenerateTransportKey handles AES transport key generation requests
+// @Summary Generate AES transport key
+// @Description Generates a TRANSPORT_KEY that can only wrap and unwrap keys, it can be sent to a peer SSM with RSA-OAEP
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.GenTransportKeyRequest true "Key identifier and size"
+// @Success 201 {object} models.GenAESKeyResponse "Transport key generated successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 409 {object} models.ProblemDetails "Key already exists"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/generate-transport-key [post]
+func HandleGenerateTransportKey(c *gin.Context) {
+	logger.AppLog.Info("Processing transport key generation request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.GenTransportKeyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if req.Id < 0 {
+		logger.AppLog.Errorf("Invalid key id: %d", req.Id)
+		sendProblemDetails(c, ErrorTitleValidationError, "The id cannot be negative", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	if req.Bits == 0 {
+		req.Bits = 256
+	}
+	if req.Bits != 128 && req.Bits != 256 {
+		logger.AppLog.Errorf("Invalid key size: %d bits", req.Bits)
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailInvalidKeySize, ErrorCodeInvalidKeySize, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	if !authorizeKeyOperation(c, constants.LABEL_TRANSPORT_KEY, constants.KEY_OPERATION_GENERATE, 0) {
+		return
+	}
+
+	handle, id, err := s.GenerateWrapKey(constants.LABEL_TRANSPORT_KEY, req.Id, int(req.Bits))
+	if err != nil {
+		if err.Error() == constants.ERROR_STRING_KEY_EXISTS {
+			logger.AppLog.Errorf("Transport key with id %d already exists", req.Id)
+			sendProblemDetails(c, ErrorTitleConflict, ErrorDetailKeyAlreadyExists, ErrorCodeKeyAlreadyExists, http.StatusConflict, c.Request.URL.Path)
+			return
+		}
+		logger.AppLog.Errorf("Transport key generation failed: %v", err)
+		sendProblemDetails(c, ErrorTitleKeyGenerationFailed, ErrorDetailKeyGenerationError, ErrorCodeKeyGenerationError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("Transport key generated - Handle: %d, Id: %d", handle, id)
+	c.JSON(http.StatusCreated, models.GenAESKeyResponse{
+		Handle: int32(handle),
+		Id:     id,
+		Bits:   req.Bits,
+	})
+}
+
+// HandleWrapKey handles wrapped key export requests
+// @Summary Export key wrapped
+// @Description Exports an exportable K4 or key encryption key wrapped under a TRANSPORT_KEY, or a TRANSPORT_KEY wrapped under the TRANSPORT_RSA public key of a peer SSM pinned in transportPeers.
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.WrapKeyRequest true "Key to export and wrap mechanism"
+// @Success 200 {object} models.WrapKeyResponse "Key wrapped successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request or key not exportable"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy or peer key not pinned"
+// @Failure 404 {object} models.ProblemDetails "Key not found"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/wrap-key [post]
+func HandleWrapKey(c *gin.Context) {
+	logger.AppLog.Info("Processing key wrap request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.WrapKeyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	mechanism, ok := checkWrapLabel(c, req.KeyLabel, req.Mechanism)
+	if !ok {
+		return
+	}
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_WRAP, 0) {
+		return
+	}
+
+	var peerKey *rsa.PublicKey
+	if req.Mechanism == pkcs11mgr.WRAP_RSA_OAEP {
+		block, _ := pem.Decode([]byte(req.PeerPublicKey))
+		if block == nil {
+			logger.AppLog.Error("The peer public key is not PEM encoded")
+			sendProblemDetails(c, ErrorTitleValidationError, "peer_public_key must be a PEM encoded RSA public key", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+			return
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if peerKey, ok = pub.(*rsa.PublicKey); err != nil || !ok || peerKey.N.BitLen() < 3072 {
+			logger.AppLog.Errorf("Invalid peer public key: %v", err)
+			sendProblemDetails(c, ErrorTitleValidationError, "peer_public_key must be an RSA public key of at least 3072 bits", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+			return
+		}
+		peer, pinned := pinnedTransportPeer(peerKey)
+		if !pinned {
+			logger.AppLog.Warn("Transport key export to a peer public key that is not pinned")
+			sendProblemDetails(c, ErrorTitleForbidden, "peer_public_key is not the key of a configured transport peer", ErrorCodeForbidden, http.StatusForbidden, c.Request.URL.Path)
+			return
+		}
+		logger.AppLog.Infof("Wrapping the transport key for the peer %s", peer)
+	}
+
+	handle, err := s.FindKey(req.KeyLabel, req.Id)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to find key '%s' (id %d): %v", req.KeyLabel, req.Id, err)
+		sendProblemDetails(c, ErrorTitleKeyNotFound, ErrorDetailKeyNotExist, ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return
+	}
+
+	resp := models.WrapKeyResponse{
+		KeyLabel:  req.KeyLabel,
+		Id:        req.Id,
+		Mechanism: req.Mechanism,
+	}
+	var wrapped []byte
+	if peerKey != nil {
+		resp.WrappingKeyLabel = constants.LABEL_TRANSPORT_KEY_PAIR
+		wrapped, err = s.WrapKeyRSA(peerKey, handle)
+	} else {
+		wrappingKey, findErr := s.FindKey(constants.LABEL_TRANSPORT_KEY, req.WrappingKeyId)
+		if findErr != nil {
+			logger.AppLog.Errorf("Failed to find transport key %d: %v", req.WrappingKeyId, findErr)
+			sendProblemDetails(c, ErrorTitleKeyNotFound, "The transport key does not exist in the HSM", ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+			return
+		}
+		resp.WrappingKeyLabel = constants.LABEL_TRANSPORT_KEY
+		resp.WrappingKeyId = req.WrappingKeyId
+		wrapped, err = s.WrapKey(wrappingKey, handle, mechanism)
+	}
+	if err != nil {
+		if errors.Is(err, pkcs11.Error(pkcs11.CKR_KEY_UNEXTRACTABLE)) {
+			logger.AppLog.Errorf("Key '%s' (id %d) is not exportable", req.KeyLabel, req.Id)
+			sendProblemDetails(c, ErrorTitleBadRequest, "The key is not exportable", "KEY_NOT_EXPORTABLE", http.StatusBadRequest, c.Request.URL.Path)
+			return
+		}
+		if errors.Is(err, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)) {
+			logger.AppLog.Errorf("Transport key %d can not wrap keys", req.WrappingKeyId)
+			sendProblemDetails(c, ErrorTitleBadRequest, "The transport key was received from a peer and only unwraps keys", "KEY_NOT_EXPORTABLE", http.StatusBadRequest, c.Request.URL.Path)
+			return
+		}
+		logger.AppLog.Errorf("Key wrap failed: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error wrapping the key", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+	resp.WrappedKey = hex.EncodeToString(wrapped)
+
+	logger.AppLog.Infof("Key '%s' (id %d) exported wrapped with %s under %s", req.KeyLabel, req.Id, req.Mechanism, resp.WrappingKeyLabel)
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleUnwrapKey handles wrapped key import requests
+// @Summary Import wrapped key
+// @Description Imports a key wrapped by a peer SSM, the key gets the same usage as a key stored with /crypto/store-key. A TRANSPORT_KEY received with RSA-OAEP only unwraps, it never exports keys
+// @Tags Key Management
+// @Accept json
+// @Produce json
+// @Param request body models.UnwrapKeyRequest true "Wrapped key and the label it is stored under"
+// @Success 201 {object} models.UnwrapKeyResponse "Key imported successfully"
+// @Failure 400 {object} models.ProblemDetails "Invalid request or wrapped key"
+// @Failure 403 {object} models.ProblemDetails "Denied by the key usage policy"
+// @Failure 404 {object} models.ProblemDetails "Unwrapping key not found"
+// @Failure 409 {object} models.ProblemDetails "Key already exists"
+// @Failure 500 {object} models.ProblemDetails "Internal server error"
+// @Router /crypto/unwrap-key [post]
+func HandleUnwrapKey(c *gin.Context) {
+	logger.AppLog.Info("Processing key unwrap request")
+
+	// init the session
+	s, ok := getKeyStore(c)
+	if !ok {
+		return
+	}
+	defer provider.ReleaseKeyStore(s)
+
+	var req models.UnwrapKeyRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		logger.AppLog.Errorf("Failed to decode request body: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, ErrorDetailInvalidJSON, ErrorCodeInvalidJSON, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	mechanism, ok := checkWrapLabel(c, req.KeyLabel, req.Mechanism)
+	if !ok {
+		return
+	}
+	if !authorizeKeyOperation(c, req.KeyLabel, constants.KEY_OPERATION_UNWRAP, 0) {
+		return
+	}
+	if !slices.Contains(constants.KeyTypeAllow[:], req.KeyType) {
+		logger.AppLog.Errorf("Invalid key type: %s", req.KeyType)
+		sendProblemDetails(c, ErrorTitleValidationError, ErrorDetailInvalidKeyType, ErrorCodeInvalidKeyType, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	if req.KeyLabel == constants.LABEL_TRANSPORT_KEY && req.KeyType != constants.TYPE_AES {
+		logger.AppLog.Errorf("Invalid transport key type: %s", req.KeyType)
+		sendProblemDetails(c, ErrorTitleValidationError, "The transport key must be an AES key", ErrorCodeInvalidKeyType, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	if req.Id <= 0 {
+		logger.AppLog.Errorf("Invalid key id: %d", req.Id)
+		sendProblemDetails(c, ErrorTitleValidationError, "The id must be a positive number", ErrorCodeValidationFailed, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+	wrapped, err := hex.DecodeString(req.WrappedKey)
+	if err != nil || len(wrapped) == 0 {
+		logger.AppLog.Errorf("Invalid wrapped key: %v", err)
+		sendProblemDetails(c, ErrorTitleBadRequest, "The wrapped key hex data is not valid", ErrorCodeInvalidHex, http.StatusBadRequest, c.Request.URL.Path)
+		return
+	}
+
+	var unwrappingKey pkcs11.ObjectHandle
+	if req.Mechanism == pkcs11mgr.WRAP_RSA_OAEP {
+		unwrappingKey, err = s.FindPrivateKey(constants.LABEL_TRANSPORT_KEY_PAIR)
+	} else {
+		unwrappingKey, err = s.FindKey(constants.LABEL_TRANSPORT_KEY, req.UnwrappingKeyId)
+	}
+	if err != nil {
+		logger.AppLog.Errorf("Failed to find the unwrapping key: %v", err)
+		sendProblemDetails(c, ErrorTitleKeyNotFound, "The transport key does not exist in the HSM", ErrorCodeKeyNotFound, http.StatusNotFound, c.Request.URL.Path)
+		return
+	}
+
+	handle, err := s.UnwrapKey(unwrappingKey, mechanism, wrapped, req.KeyLabel, req.Id, req.KeyType, req.Exportable)
+	if err != nil {
+		if err.Error() == constants.ERROR_STRING_KEY_EXISTS {
+			logger.AppLog.Errorf("Key '%s' with id %d already exists", req.KeyLabel, req.Id)
+			sendProblemDetails(c, ErrorTitleConflict, ErrorDetailKeyAlreadyExists, ErrorCodeKeyAlreadyExists, http.StatusConflict, c.Request.URL.Path)
+			return
+		}
+		if errors.Is(err, pkcs11.Error(pkcs11.CKR_WRAPPED_KEY_INVALID)) || errors.Is(err, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)) {
+			logger.AppLog.Errorf("Invalid wrapped key for '%s' (id %d): %v", req.KeyLabel, req.Id, err)
+			sendProblemDetails(c, ErrorTitleBadRequest, "The wrapped key does not unwrap under the transport key", "WRAPPED_KEY_INVALID", http.StatusBadRequest, c.Request.URL.Path)
+			return
+		}
+		logger.AppLog.Errorf("Key unwrap failed: %v", err)
+		sendProblemDetails(c, ErrorTitleInternalServerError, "Error unwrapping the key", ErrorCodeInternalError, http.StatusInternalServerError, c.Request.URL.Path)
+		return
+	}
+
+	logger.AppLog.Infof("Key '%s' (id %d) imported wrapped with %s, exportable=%t", req.KeyLabel, req.Id, req.Mechanism, req.Exportable)
+	c.JSON(http.StatusCreated, models.UnwrapKeyResponse{
+		Handle:   int32(handle),
+		KeyLabel: req.KeyLabel,
+		Id:       req.Id,
+	})
+}
@@ -0,0 +1,101 @@
+package handlers_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+// TestWrapKeyBetweenInstances agrees a transport key between two SSM instances
+// and moves a K4 key from one to the other without its value in clear
+func TestWrapKeyBetweenInstances(t *testing.T) {
+	f := ssmtest.New(t)
+	source, target := pkcs11mgr.NewMemoryProvider(), pkcs11mgr.NewMemoryProvider()
+	t.Cleanup(source.Finalize)
+	t.Cleanup(target.Finalize)
+
+	f.UseProvider(target)
+	var pair models.TransportKeyPairResponse
+	f.DoJSON("/crypto/generate-transport-key-pair", models.GenTransportKeyPairRequest{}, http.StatusCreated, &pair)
+	f.DoJSON("/crypto/generate-transport-key-pair", models.GenTransportKeyPairRequest{}, http.StatusConflict, nil)
+
+	f.UseProvider(source)
+	f.DoJSON("/crypto/generate-transport-key", models.GenTransportKeyRequest{Id: 1}, http.StatusCreated, nil)
+
+	// the transport key is only wrapped for the pinned peers
+	attacker, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&attacker.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attackerPem := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	f.DoJSON("/crypto/wrap-key", models.WrapKeyRequest{KeyLabel: constants.LABEL_TRANSPORT_KEY, Id: 1, Mechanism: pkcs11mgr.WRAP_RSA_OAEP, PeerPublicKey: pair.Pem}, http.StatusForbidden, nil)
+	peerFile := filepath.Join(t.TempDir(), "peer.pem")
+	if err := os.WriteFile(peerFile, []byte(pair.Pem), 0600); err != nil {
+		t.Fatal(err)
+	}
+	factory.SsmConfig.Configuration.TransportPeers = []factory.TransportPeer{{Name: "target", PublicKeyFile: peerFile}}
+	f.DoJSON("/crypto/wrap-key", models.WrapKeyRequest{KeyLabel: constants.LABEL_TRANSPORT_KEY, Id: 1, Mechanism: pkcs11mgr.WRAP_RSA_OAEP, PeerPublicKey: attackerPem}, http.StatusForbidden, nil)
+
+	var transport models.WrapKeyResponse
+	f.DoJSON("/crypto/wrap-key", models.WrapKeyRequest{
+		KeyLabel:      constants.LABEL_TRANSPORT_KEY,
+		Id:            1,
+		Mechanism:     pkcs11mgr.WRAP_RSA_OAEP,
+		PeerPublicKey: pair.Pem,
+	}, http.StatusOK, &transport)
+
+	// stored K4 keys are not exportable unless the key export is enabled
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 2, KeyValue: "00112233445566778899aabbccddeeff", KeyType: constants.TYPE_AES}, http.StatusOK, nil)
+	f.DoJSON("/crypto/wrap-key", models.WrapKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 2, Mechanism: pkcs11mgr.WRAP_AES_KEY_WRAP, WrappingKeyId: 1}, http.StatusBadRequest, nil)
+	pkcs11mgr.SetKeyExport(true)
+	t.Cleanup(func() { pkcs11mgr.SetKeyExport(false) })
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyValue: "000102030405060708090a0b0c0d0e0f", KeyType: constants.TYPE_AES}, http.StatusOK, nil)
+	var k4 models.WrapKeyResponse
+	f.DoJSON("/crypto/wrap-key", models.WrapKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, Mechanism: pkcs11mgr.WRAP_AES_KEY_WRAP, WrappingKeyId: 1}, http.StatusOK, &k4)
+
+	f.UseProvider(target)
+	f.DoJSON("/crypto/unwrap-key", models.UnwrapKeyRequest{
+		KeyLabel:   constants.LABEL_TRANSPORT_KEY,
+		Id:         1,
+		KeyType:    constants.TYPE_AES,
+		Mechanism:  pkcs11mgr.WRAP_RSA_OAEP,
+		WrappedKey: transport.WrappedKey,
+	}, http.StatusCreated, nil)
+	unwrapK4 := models.UnwrapKeyRequest{
+		KeyLabel:        constants.LABEL_K4_KEY_AES,
+		Id:              1,
+		KeyType:         constants.TYPE_AES,
+		Mechanism:       pkcs11mgr.WRAP_AES_KEY_WRAP,
+		UnwrappingKeyId: 1,
+		WrappedKey:      k4.WrappedKey,
+	}
+	f.DoJSON("/crypto/unwrap-key", unwrapK4, http.StatusCreated, nil)
+	f.DoJSON("/crypto/unwrap-key", unwrapK4, http.StatusConflict, nil)
+	// a received transport key can not export the keys of the target
+	f.DoJSON("/crypto/wrap-key", models.WrapKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, Mechanism: pkcs11mgr.WRAP_AES_KEY_WRAP, WrappingKeyId: 1}, http.StatusBadRequest, nil)
+	unwrapK4.Id, unwrapK4.WrappedKey = 2, strings.Repeat("00", 24)
+	f.DoJSON("/crypto/unwrap-key", unwrapK4, http.StatusBadRequest, nil)
+
+	// the unwrapped key is the one of the source
+	var info models.GetKeyResponse
+	f.DoJSON("/crypto/get-key", models.GetKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1}, http.StatusOK, &info)
+	if info.KeyInfo.Kcv != "c6a13b" {
+		t.Fatalf("unwrapped K4 KCV = %s", info.KeyInfo.Kcv)
+	}
+}
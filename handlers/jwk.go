@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+// publicKeyToJWK encodes an RSA or EC public key as a JSON Web Key (RFC 7517/7518)
+func publicKeyToJWK(pub crypto.PublicKey, kid, alg string) (*models.Jwk, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return &models.Jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the size of the curve (RFC 7518 section 6.2.1.2)
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return &models.Jwk{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			Crv: pkcs11mgr.CurveName(key),
+			X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// keyTypeNames maps the PKCS#11 key pair types to their JWK names
+var keyTypeNames = map[uint]string{
+	pkcs11.CKK_RSA: "RSA",
+	pkcs11.CKK_EC:  "EC",
+}
+
+// publicKeyType returns the key type name of a public key, RSA or EC
+func publicKeyType(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA"
+	case *ecdsa.PublicKey:
+		return "EC"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/server/middleware"
+)
+
+// checkKeyPolicy checks an operation on the keys of label against the key
+// usage policy of the label family. The labels outside of the label families
+// are checked against the policy of the application keys. algorithm is 0 when
+// the operation does not take one and role is empty when the API is not secured.
+func checkKeyPolicy(role, label, operation string, algorithm int) error {
+	family, ok := constants.LabelFamilyMap[label]
+	if !ok {
+		family = constants.LABEL_FAMILY_APPLICATION
+	}
+	policy := factory.SsmConfig.GetKeyPolicies()[family]
+
+	var allowed bool
+	switch operation {
+	case constants.KEY_OPERATION_DELETE:
+		allowed = policy.Deletable
+	case constants.KEY_OPERATION_UPDATE:
+		allowed = policy.Updatable
+	default:
+		allowed = slices.Contains(policy.Operations, operation)
+	}
+	if !allowed {
+		return fmt.Errorf("the %s keys do not allow the %s operation", family, operation)
+	}
+	if algorithm != 0 && len(policy.Algorithms) > 0 && !slices.Contains(policy.Algorithms, algorithm) {
+		return fmt.Errorf("the %s keys do not allow the algorithm %d", family, algorithm)
+	}
+	if role != "" && len(policy.Roles) > 0 && !slices.Contains(policy.Roles, role) {
+		return fmt.Errorf("the %s keys do not allow the role %s", family, role)
+	}
+	return nil
+}
+
+// authorizeKeyOperation checks an operation on the keys of label before the
+// key store is used, on failure it writes the problem details and returns false
+func authorizeKeyOperation(c *gin.Context, label, operation string, algorithm int) bool {
+	if err := checkKeyPolicy(middleware.ServiceRole(c), label, operation, algorithm); err != nil {
+		logger.AppLog.Warnf("Key usage policy denied %s on '%s': %v", operation, label, err)
+		sendProblemDetails(c, ErrorTitleForbidden, err.Error(), ErrorCodeKeyPolicyDenied, http.StatusForbidden, c.Request.URL.Path)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/internal/ssmtest"
+	"github.com/networkgcorefullcode/ssm/models"
+)
+
+func TestKeyUsagePolicy(t *testing.T) {
+	f := ssmtest.New(t)
+	plain := "00112233445566778899aabbccddeeff"
+	k4 := "000102030405060708090a0b0c0d0e0f"
+
+	f.DoJSON("/crypto/generate-aes-key", models.GenAESKeyRequest{Id: 1, Bits: 256}, http.StatusCreated, nil)
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1, KeyValue: k4, KeyType: constants.TYPE_AES}, http.StatusOK, nil)
+
+	// the internal and signing keys and the labels outside of the families are not usable through the API
+	for _, label := range []string{constants.JWTKeyLabel, constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256, "NF_EC"} {
+		f.DoJSON("/crypto/encrypt", models.EncryptRequest{KeyLabel: label, Plain: plain, EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers}, http.StatusForbidden, nil)
+		f.DoJSON("/crypto/decrypt", models.DecryptRequest{KeyLabel: label, Id: 1, Cipher: plain, EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers}, http.StatusForbidden, nil)
+	}
+	// the key encryption keys do not take the K4 algorithms
+	f.DoJSON("/crypto/encrypt", models.EncryptRequest{KeyLabel: constants.LABEL_ENCRYPTION_KEY_AES256, Plain: plain, EncryptionAlgorithm: constants.ALGORITHM_AES256}, http.StatusForbidden, nil)
+	f.DoJSON("/crypto/encrypt", models.EncryptRequest{KeyLabel: constants.LABEL_ENCRYPTION_KEY_AES256, Plain: plain, EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers}, http.StatusCreated, nil)
+	// the transport keys may not be deleted, the key encryption keys may not be updated
+	f.DoRequest(http.MethodDelete, "/crypto/store-key", models.DeleteKeyRequest{KeyLabel: constants.LABEL_TRANSPORT_KEY, Id: 1}, http.StatusForbidden, nil)
+	f.DoRequest(http.MethodPut, "/crypto/store-key", models.UpdateKeyRequest{KeyLabel: constants.LABEL_ENCRYPTION_KEY_AES256, Id: 1, KeyValue: k4, KeyType: constants.TYPE_AES}, http.StatusForbidden, nil)
+
+	// a configured family replaces its default policy
+	factory.SsmConfig.Configuration.KeyPolicies = map[string]factory.KeyPolicy{
+		constants.LABEL_FAMILY_K4: {Operations: []string{constants.KEY_OPERATION_STORE, constants.KEY_OPERATION_READ}},
+	}
+	f.DoJSON("/crypto/rotate-key", models.RotateKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES}, http.StatusForbidden, nil)
+	f.DoJSON("/crypto/purge-retired-keys", models.PurgeRetiredKeysRequest{KeyLabel: constants.LABEL_K4_KEY_AES}, http.StatusForbidden, nil)
+	f.DoRequest(http.MethodDelete, "/crypto/store-key", models.DeleteKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1}, http.StatusForbidden, nil)
+	f.DoJSON("/crypto/store-key", models.StoreKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 2, KeyValue: k4, KeyType: constants.TYPE_AES}, http.StatusOK, nil)
+
+	// the keys created through the API follow the policy of the application keys
+	f.DoJSON("/crypto/generate-mac-key", models.GenMACKeyRequest{KeyLabel: "NF_HMAC", Id: 1, Algorithm: "HMAC-SHA256"}, http.StatusCreated, nil)
+	factory.SsmConfig.Configuration.KeyPolicies = map[string]factory.KeyPolicy{
+		constants.LABEL_FAMILY_APPLICATION: {Operations: []string{constants.KEY_OPERATION_VERIFY}},
+		constants.LABEL_FAMILY_ENCRYPTION:  {Operations: []string{constants.KEY_OPERATION_ENCRYPT}},
+	}
+	f.DoJSON("/crypto/generate-mac-key", models.GenMACKeyRequest{KeyLabel: "NF_HMAC", Id: 2, Algorithm: "HMAC-SHA256"}, http.StatusForbidden, nil)
+	f.DoJSON("/crypto/mac", models.MACRequest{KeyLabel: "NF_HMAC", Algorithm: "HMAC-SHA256", Data: plain}, http.StatusForbidden, nil)
+	f.DoJSON("/crypto/sign", models.SignRequest{KeyLabel: "NF_EC", Algorithm: "ES256", Data: plain}, http.StatusForbidden, nil)
+	f.DoJSON("/crypto/public-key", models.ExportPublicKeyRequest{KeyLabel: "NF_EC"}, http.StatusForbidden, nil)
+	f.DoJSON("/crypto/auth-vector", models.AuthVectorRequest{
+		KeyLabel: constants.LABEL_ENCRYPTION_KEY_AES256, Id: 1, EncryptionAlgorithm: constants.ALGORITHM_AES256_OurUsers,
+		K: models.EncryptedSecret{Cipher: plain}, Opc: models.EncryptedSecret{Cipher: plain},
+		Sqn: "000000000001", Amf: "8000", ServingNetworkName: "5G:mnc093.mcc208.3gppnetwork.org",
+	}, http.StatusForbidden, nil)
+	// the SUCI keys only derive the SUPI of a SUCI
+	f.DoJSON("/crypto/suci-deconceal", models.SuciDeconcealRequest{Suci: "suci-0-001-01-0000-1-7-b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa87"}, http.StatusNotFound, nil)
+	factory.SsmConfig.Configuration.KeyPolicies[constants.LABEL_FAMILY_SUCI] = factory.KeyPolicy{}
+	f.DoJSON("/crypto/suci-deconceal", models.SuciDeconcealRequest{Suci: "suci-0-001-01-0000-1-7-b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa87"}, http.StatusForbidden, nil)
+
+	factory.SsmConfig.Configuration.KeyPolicies = nil
+	f.DoRequest(http.MethodDelete, "/crypto/store-key", models.DeleteKeyRequest{KeyLabel: constants.LABEL_K4_KEY_AES, Id: 1}, http.StatusOK, nil)
+}
+
+// restrictToUDM replaces the policy of family by one that allows operations to
+// the udm role only, the requests of the webconsole role are then refused by
+// the key usage policy and not by the role check of the middleware
+func restrictToUDM(family string, operations ...string) {
+	factory.SsmConfig.Configuration.KeyPolicies = map[string]factory.KeyPolicy{
+		family: {Operations: operations, Roles: []string{constants.ROLE_UDM}},
+	}
+}
+
+// checkStatus checks the status of a request sent with ssmtest.DoAs
+func checkStatus(t *testing.T, w *httptest.ResponseRecorder, wantStatus int) {
+	t.Helper()
+	if w.Code != wantStatus {
+		t.Fatalf("status %d, want %d, body: %s", w.Code, wantStatus, w.Body.String())
+	}
+}
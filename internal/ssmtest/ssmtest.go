@@ -0,0 +1,182 @@
+// Package ssmtest is the fixture of the API tests: the Gin router of SSM on top
+// of the in-memory crypto provider, with in-memory account, token and secret
+// stores in place of MongoDB.
+package ssmtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/networkgcorefullcode/ssm/database"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/handlers"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/networkgcorefullcode/ssm/server"
+	"github.com/networkgcorefullcode/ssm/server/middleware"
+)
+
+// Fixture is an SSM API with the security middlewares disabled, the routes
+// that need a service role are tested on a router of their own
+type Fixture struct {
+	t        *testing.T
+	Router   *gin.Engine
+	Provider *pkcs11mgr.MemoryProvider
+	KeyStore pkcs11mgr.KeyStore
+
+	jwt bool // the JWT signing key exists
+}
+
+// New builds the router on top of a new in-memory crypto provider, the
+// configuration is reset to its defaults with isSecure disabled
+func New(t *testing.T) *Fixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	factory.SsmConfig.Configuration = &factory.Configuration{IsSecure: false}
+	provider := pkcs11mgr.NewMemoryProvider()
+	t.Cleanup(provider.Finalize)
+	f := &Fixture{t: t, Provider: provider}
+	f.UseProvider(provider)
+
+	ks, err := provider.GetKeyStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { provider.ReleaseKeyStore(ks) })
+	f.KeyStore = ks
+
+	f.Router = server.CreateGinRouter()
+	return f
+}
+
+// UseProvider serves the next requests from another crypto provider
+func (f *Fixture) UseProvider(p pkcs11mgr.CryptoProvider) {
+	handlers.SetCryptoProvider(p)
+	pkcs11mgr.SetCryptoProvider(p)
+	middleware.SetCryptoProvider(p)
+	database.SetCryptoProvider(p)
+}
+
+// InitJWT creates the JWT signing key of the fixture provider
+func (f *Fixture) InitJWT() {
+	f.t.Helper()
+	if err := pkcs11mgr.InitJWTKey(f.KeyStore); err != nil {
+		f.t.Fatal(err)
+	}
+	f.jwt = true
+}
+
+// Token returns an access token of the service role
+func (f *Fixture) Token(role string) string {
+	f.t.Helper()
+	if !f.jwt {
+		f.InitJWT()
+	}
+	token, _, err := pkcs11mgr.CreateStandardJWT(f.KeyStore, pkcs11mgr.JWTIssuer, role, pkcs11mgr.JWTAudience, time.Hour)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return token
+}
+
+// DoAs posts body to path with an access token of role. The request is
+// authenticated like in the /crypto group of a secured SSM and then served by
+// handler, the audit middleware that needs MongoDB is left out.
+func (f *Fixture) DoAs(role, path string, handler gin.HandlerFunc, body any) *httptest.ResponseRecorder {
+	f.t.Helper()
+	secure := gin.New()
+	secure.POST(path, middleware.AuthenticateRequest(), handler)
+	return DoBearer(secure, http.MethodPost, path, f.Token(role), body)
+}
+
+// InitPasswords creates the password pepper key and lowers the Argon2id cost
+// so that the tests hash quickly
+func (f *Fixture) InitPasswords() {
+	f.t.Helper()
+	if err := pkcs11mgr.InitPasswordPepperKey(f.KeyStore); err != nil {
+		f.t.Fatal(err)
+	}
+	factory.SsmConfig.Configuration.PasswordHash = FastPasswordHash
+}
+
+// FastPasswordHash are the Argon2id parameters of the tests
+var FastPasswordHash = &factory.PasswordHash{Time: 1, Memory: 1024, Threads: 1}
+
+// UseAccounts replaces the MongoDB account and token stores by in-memory
+// stores holding accounts, they are restored when the test ends
+func (f *Fixture) UseAccounts(accounts ...database.UserSecret) (*AccountStore, *TokenStore) {
+	accountStore := &AccountStore{Accounts: make(map[string]database.UserSecret)}
+	for _, account := range accounts {
+		accountStore.Accounts[account.ServiceID] = account
+	}
+	tokenStore := &TokenStore{Refresh: make(map[string]database.RefreshToken)}
+
+	handlers.SetAccountStore(accountStore)
+	handlers.SetTokenStore(tokenStore)
+	middleware.SetRevocationStore(tokenStore)
+	f.t.Cleanup(func() {
+		handlers.SetAccountStore(database.AccountStore{})
+		handlers.SetTokenStore(database.TokenStore{})
+		middleware.SetRevocationStore(database.TokenStore{})
+	})
+	return accountStore, tokenStore
+}
+
+// UseSecrets replaces the MongoDB store of the user secrets of the backups
+func (f *Fixture) UseSecrets(secrets ...pkcs11mgr.BackupSecret) *SecretStore {
+	store := &SecretStore{Secrets: secrets}
+	handlers.SetSecretStore(store)
+	f.t.Cleanup(func() { handlers.SetSecretStore(database.SecretStore{}) })
+	return store
+}
+
+// DoJSON posts body to path and checks the status, the response is decoded
+// into out unless it is nil
+func (f *Fixture) DoJSON(path string, body any, wantStatus int, out any) {
+	f.t.Helper()
+	f.DoRequest(http.MethodPost, path, body, wantStatus, out)
+}
+
+// DoRequest sends body to path and checks the status, the response is
+// decoded into out unless it is nil
+func (f *Fixture) DoRequest(method, path string, body any, wantStatus int, out any) {
+	f.t.Helper()
+	raw, err := json.Marshal(body)
+	if err != nil {
+		f.t.Fatalf("marshal request for %s: %v", path, err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	f.Router.ServeHTTP(w, req)
+	if w.Code != wantStatus {
+		f.t.Fatalf("%s: status %d, want %d, body: %s", path, w.Code, wantStatus, w.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			f.t.Fatalf("%s: decode response: %v", path, err)
+		}
+	}
+}
+
+// DoBearer sends a request with an access token to the fixture router
+func (f *Fixture) DoBearer(method, path, token string, body any) *httptest.ResponseRecorder {
+	return DoBearer(f.Router, method, path, token, body)
+}
+
+// DoBearer sends a request with an access token
+func DoBearer(r http.Handler, method, path, token string, body any) *httptest.ResponseRecorder {
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,137 @@
+package ssmtest
+
+import (
+	"time"
+
+	"github.com/networkgcorefullcode/ssm/database"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+)
+
+// TokenStore keeps the refresh and the revoked tokens in memory
+type TokenStore struct {
+	Refresh map[string]database.RefreshToken
+	Revoked []database.RevokedToken
+}
+
+func (s *TokenStore) SaveRefreshToken(token database.RefreshToken) error {
+	s.Refresh[token.TokenHash] = token
+	return nil
+}
+
+func (s *TokenStore) ConsumeRefreshToken(tokenHash string) (database.RefreshToken, error) {
+	token, ok := s.Refresh[tokenHash]
+	if !ok {
+		return database.RefreshToken{}, database.ErrTokenNotFound
+	}
+	delete(s.Refresh, tokenHash)
+	return token, nil
+}
+
+func (s *TokenStore) DeleteRefreshTokens(accessJti string) error {
+	for hash, token := range s.Refresh {
+		if token.AccessJti == accessJti {
+			delete(s.Refresh, hash)
+		}
+	}
+	return nil
+}
+
+func (s *TokenStore) RevokeToken(token database.RevokedToken) error {
+	s.Revoked = append(s.Revoked, token)
+	return nil
+}
+
+func (s *TokenStore) RevokeServiceTokens(serviceID string, now time.Time) ([]database.RevokedToken, error) {
+	var revoked []database.RevokedToken
+	for hash, token := range s.Refresh {
+		if token.ServiceID != serviceID {
+			continue
+		}
+		delete(s.Refresh, hash)
+		if now.Before(token.AccessExpiresAt) {
+			revoked = append(revoked, database.RevokedToken{Jti: token.AccessJti, ServiceID: serviceID, ExpiresAt: token.AccessExpiresAt})
+		}
+	}
+	s.Revoked = append(s.Revoked, revoked...)
+	return revoked, nil
+}
+
+func (s *TokenStore) RevokedTokens(now time.Time) ([]database.RevokedToken, error) {
+	return s.Revoked, nil
+}
+
+// AccountStore keeps the service accounts in memory
+type AccountStore struct {
+	Accounts map[string]database.UserSecret
+}
+
+func (s *AccountStore) GetAccount(serviceID string) (database.UserSecret, error) {
+	account, ok := s.Accounts[serviceID]
+	if !ok {
+		return database.UserSecret{}, database.ErrAccountNotFound
+	}
+	return account, nil
+}
+
+func (s *AccountStore) ListAccounts() ([]database.UserSecret, error) {
+	accounts := make([]database.UserSecret, 0, len(s.Accounts))
+	for _, account := range s.Accounts {
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+func (s *AccountStore) CreateAccount(account database.UserSecret) error {
+	if _, ok := s.Accounts[account.ServiceID]; ok {
+		return database.ErrAccountExists
+	}
+	s.Accounts[account.ServiceID] = account
+	return nil
+}
+
+func (s *AccountStore) update(serviceID string, f func(account *database.UserSecret)) error {
+	account, ok := s.Accounts[serviceID]
+	if !ok {
+		return database.ErrAccountNotFound
+	}
+	f(&account)
+	s.Accounts[serviceID] = account
+	return nil
+}
+
+func (s *AccountStore) SetPassword(serviceID string, hash database.PasswordHash, now time.Time) error {
+	return s.update(serviceID, func(account *database.UserSecret) {
+		account.PasswordHash, account.PasswordSecret, account.PasswordChangedAt = &hash, nil, now
+	})
+}
+
+func (s *AccountStore) MigratePassword(serviceID string, hash database.PasswordHash) error {
+	return s.update(serviceID, func(account *database.UserSecret) {
+		account.PasswordHash, account.PasswordSecret = &hash, nil
+	})
+}
+
+func (s *AccountStore) DisableAccount(serviceID string, now time.Time) error {
+	return s.update(serviceID, func(account *database.UserSecret) {
+		account.Disabled, account.DisabledAt = true, now
+	})
+}
+
+func (s *AccountStore) RecordLogin(serviceID string, now time.Time) error {
+	return s.update(serviceID, func(account *database.UserSecret) { account.LastLoginAt = now })
+}
+
+// SecretStore keeps the user secrets of the backups in memory
+type SecretStore struct {
+	Secrets  []pkcs11mgr.BackupSecret
+	Restored []pkcs11mgr.BackupSecret
+}
+
+func (s *SecretStore) BackupSecrets() ([]pkcs11mgr.BackupSecret, error) {
+	return s.Secrets, nil
+}
+
+func (s *SecretStore) RestoreSecrets(secrets []pkcs11mgr.BackupSecret) error {
+	s.Restored = append(s.Restored, secrets...)
+	return nil
+}
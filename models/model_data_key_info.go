@@ -19,4 +19,28 @@ type DataKeyInfo struct {
 	Id int32 `json:"id"`
 	// Size of the key in bits
 	SizeBits int32 `json:"size_bits"`
+	// Key check value in hexadecimal, the first 3 bytes of the ECB encryption of a zero block
+	Kcv string `json:"kcv,omitempty"`
+	// AES-CMAC key check value in hexadecimal, the first 5 bytes of the AES-CMAC of a zero block
+	KcvCmac string `json:"kcv_cmac,omitempty"`
+	// Key type: AES, DES, DES3 or GENERIC_SECRET
+	KeyType string `json:"key_type,omitempty"`
+	// The key may encrypt (CKA_ENCRYPT)
+	Encrypt bool `json:"encrypt"`
+	// The key may decrypt (CKA_DECRYPT)
+	Decrypt bool `json:"decrypt"`
+	// The key may wrap other keys (CKA_WRAP)
+	Wrap bool `json:"wrap"`
+	// The key may unwrap other keys (CKA_UNWRAP)
+	Unwrap bool `json:"unwrap"`
+	// The key may leave the HSM wrapped (CKA_EXTRACTABLE)
+	Extractable bool `json:"extractable"`
+	// The key value can not be read in clear (CKA_SENSITIVE)
+	Sensitive bool `json:"sensitive"`
+	// First day the key may encrypt, YYYY-MM-DD in UTC (CKA_START_DATE)
+	StartDate string `json:"start_date,omitempty"`
+	// Last day the key may encrypt, YYYY-MM-DD in UTC (CKA_END_DATE)
+	EndDate string `json:"end_date,omitempty"`
+	// Creation time in RFC3339, only known for keys generated or stored through the SSM
+	CreatedAt string `json:"created_at,omitempty"`
 }
@@ -17,4 +17,8 @@ type GenAESKeyRequest struct {
 	Id int32 `json:"id"`
 	// Key size in bits
 	Bits int32 `json:"bits"`
+	// First day the key may encrypt, YYYY-MM-DD in UTC (CKA_START_DATE)
+	StartDate string `json:"start_date,omitempty"`
+	// Last day the key may encrypt, YYYY-MM-DD in UTC (CKA_END_DATE)
+	EndDate string `json:"end_date,omitempty"`
 }
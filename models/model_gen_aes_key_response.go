@@ -19,4 +19,8 @@ type GenAESKeyResponse struct {
 	Id int32 `json:"id"`
 	// Size of the generated key in bits
 	Bits int32 `json:"bits"`
+	// Key check value in hexadecimal, the first 3 bytes of the ECB encryption of a zero block
+	Kcv string `json:"kcv,omitempty"`
+	// AES-CMAC key check value in hexadecimal, the first 5 bytes of the AES-CMAC of a zero block
+	KcvCmac string `json:"kcv_cmac,omitempty"`
 }
@@ -15,4 +15,8 @@ package models
 type GenDES3KeyRequest struct {
 	// Unique key identifier
 	Id int32 `json:"id"`
+	// First day the key may encrypt, YYYY-MM-DD in UTC (CKA_START_DATE)
+	StartDate string `json:"start_date,omitempty"`
+	// Last day the key may encrypt, YYYY-MM-DD in UTC (CKA_END_DATE)
+	EndDate string `json:"end_date,omitempty"`
 }
@@ -17,4 +17,6 @@ type GenDES3KeyResponse struct {
 	Handle int32 `json:"handle"`
 	// Key identifier
 	Id int32 `json:"id"`
+	// Key check value in hexadecimal, the first 3 bytes of the ECB encryption of a zero block
+	Kcv string `json:"kcv,omitempty"`
 }
@@ -17,4 +17,6 @@ type HealthCheckResponse struct {
 	Status string `json:"status"`
 	// Additional information
 	Message string `json:"message"`
+	// PKCS#11 session pool metrics, only present with the pkcs11 provider
+	Sessions *SessionPoolStats `json:"sessions,omitempty"`
 }
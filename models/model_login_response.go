@@ -15,6 +15,10 @@ package models
 type LoginResponse struct {
 	// JWT access token
 	Token string `json:"token"`
+	// Refresh token for /token/refresh, it can only be used once
+	RefreshToken string `json:"refresh_token"`
+	// Lifetime of the access token in seconds
+	ExpiresIn int32 `json:"expires_in"`
 	// Token type
 	Message string `json:"message"`
 }
@@ -21,4 +21,10 @@ type StoreKeyRequest struct {
 	KeyValue string `json:"key_value"`
 	// Type of cryptographic key
 	KeyType string `json:"key_type"`
+	// Expected key check value in hexadecimal, 3 bytes for the KCV or 5 bytes for the AES-CMAC KCV. The key is not stored when it does not match
+	ExpectedKcv string `json:"expected_kcv,omitempty"`
+	// First day the key may encrypt, YYYY-MM-DD in UTC (CKA_START_DATE)
+	StartDate string `json:"start_date,omitempty"`
+	// Last day the key may encrypt, YYYY-MM-DD in UTC (CKA_END_DATE)
+	EndDate string `json:"end_date,omitempty"`
 }
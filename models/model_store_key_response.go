@@ -17,4 +17,8 @@ type StoreKeyResponse struct {
 	Handle int32 `json:"handle"`
 	// Stored encrypted key
 	CipherKey string `json:"cipher_key"`
+	// Key check value in hexadecimal, the first 3 bytes of the ECB encryption of a zero block
+	Kcv string `json:"kcv,omitempty"`
+	// AES-CMAC key check value in hexadecimal, the first 5 bytes of the AES-CMAC of a zero block
+	KcvCmac string `json:"kcv_cmac,omitempty"`
 }
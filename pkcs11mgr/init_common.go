@@ -1,37 +1,47 @@
 package pkcs11mgr
 
 import (
+	"context"
 	"time"
 
 	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
 )
 
-var mgr *Manager
+var provider CryptoProvider
 
-func SetPKCS11Manager(manager *Manager) {
-	mgr = manager
+func SetCryptoProvider(p CryptoProvider) {
+	provider = p
 }
 
 func InitPKCS11() {
-	session := mgr.GetSession()
-	defer mgr.LogoutSession(session)
+	ks, err := provider.GetKeyStore(context.Background())
+	if err != nil {
+		logger.AppLog.Errorf("Failed to get key store: %v", err)
+		return
+	}
+	defer provider.ReleaseKeyStore(ks)
 
-	if err := InitAuditKey(session); err != nil {
+	if err := InitAuditKey(ks); err != nil {
 		logger.AppLog.Errorf("Failed to initialize audit key: %v", err)
 		return
 	}
 
 	time.Sleep(time.Second * 2)
 
-	if err := InitJWTKey(session); err != nil {
+	if err := InitJWTKey(ks); err != nil {
 		logger.AppLog.Errorf("Failed to initialize jwt key: %v", err)
 		return
 	}
 
-	_, err := FindKey(constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256, 0, *session)
+	if err := InitPasswordPepperKey(ks); err != nil {
+		logger.AppLog.Errorf("Failed to initialize password pepper key: %v", err)
+		return
+	}
+
+	_, err = ks.FindKey(constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256, 0)
 	if err != nil && err.Error() == constants.ERROR_STRING_KEY_NOT_FOUND {
-		_, _, err = GenerateAESKey(constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256, 0, 256, *session)
+		_, _, err = ks.GenerateAESKey(constants.LABEL_ENCRYPTION_KEY_INTERNAL_AES256, 0, 256)
 		if err != nil {
 			logger.AppLog.Errorf("Failed to generate AES key: %v", err)
 			return
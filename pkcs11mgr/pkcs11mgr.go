@@ -9,189 +9,232 @@ import (
 	"github.com/networkgcorefullcode/ssm/logger"
 )
 
-// Manager manages PKCS#11 context without holding a specific session
+// Manager manages the PKCS#11 context and owns the pool of sessions opened on its slot
 type Manager struct {
 	ctx        *pkcs11.Ctx
-	slot       uint
+	api        moduleAPI // the calls of the pool on ctx
+	module     *loadedModule
+	modulePath string
+	token      TokenSelector
+	slot       uint         // resolved from token, it may change when the module is reset
+	mechanisms mechanismSet // of slot, read again with it
 	pin        string
 	createdAt  time.Time
 	lastUsed   time.Time
 	isLoggedIn bool       // ✅ Track login state
 	loginMutex sync.Mutex // ✅ Protect login operations
+
+	// Session pool, see pkcs11mgr_session_pool.go
+	poolMutex    sync.Mutex
+	idle         chan *Session
+	slotFreed    chan struct{}
+	maxSessions  int
+	openSessions int
+	generation   uint64 // bumped on every module reset, sessions of older generations are dropped
+	moduleEpoch  uint64 // epoch of the module the sessions of this generation were opened in
+	waitTimeout  time.Duration
+	stats        poolCounters
+	stopHealth   chan struct{}
+
+	keys *KeyCache // handles of the keys of the token, shared by the sessions
 }
 
 // Session represents an independent PKCS#11 session
 type Session struct {
-	Handle pkcs11.SessionHandle
-	Ctx    *pkcs11.Ctx
+	Handle     pkcs11.SessionHandle
+	Ctx        *pkcs11.Ctx
+	generation uint64
+	keys       *KeyCache    // nil for sessions that bypass the key cache
+	mechanisms mechanismSet // of the slot of the session
+}
+
+// moduleAPI is the part of the PKCS#11 library the session pool and the slot
+// lookup use, *pkcs11.Ctx implements it and the tests replace it
+type moduleAPI interface {
+	Initialize() error
+	Finalize() error
+	GetSlotList(tokenPresent bool) ([]uint, error)
+	GetSlotInfo(slotID uint) (pkcs11.SlotInfo, error)
+	GetTokenInfo(slotID uint) (pkcs11.TokenInfo, error)
+	GetMechanismList(slotID uint) ([]*pkcs11.Mechanism, error)
+	OpenSession(slotID uint, flags uint) (pkcs11.SessionHandle, error)
+	CloseSession(sh pkcs11.SessionHandle) error
+	CloseAllSessions(slotID uint) error
+	GetSessionInfo(sh pkcs11.SessionHandle) (pkcs11.SessionInfo, error)
+	Login(sh pkcs11.SessionHandle, userType uint, pin string) error
+	Logout(sh pkcs11.SessionHandle) error
 }
 
-func New(modulePath string, slot uint, pin string) (*Manager, error) {
+// loadedModule is a PKCS#11 library shared by the Managers of its tokens,
+// C_Initialize may only be called once per process for a given library
+type loadedModule struct {
+	ctx  *pkcs11.Ctx
+	api  moduleAPI // ctx outside of the tests
+	refs int
+
+	// resetMutex serializes the reconnects of the Managers, epoch counts the
+	// times the library was initialized again after a reset
+	resetMutex sync.Mutex
+	epoch      uint64
+	managers   map[*Manager]struct{}
+}
+
+var modules = map[string]*loadedModule{}
+var modulesMutex sync.Mutex
+
+// loadModule loads and initializes the library once and counts its users
+func loadModule(modulePath string) (*loadedModule, error) {
+	modulesMutex.Lock()
+	defer modulesMutex.Unlock()
+
+	if mod, ok := modules[modulePath]; ok {
+		mod.refs++
+		return mod, nil
+	}
+
 	ctx := pkcs11.New(modulePath)
 	if ctx == nil {
 		return nil, errors.New("pkcs11.New returned nil")
 	}
 	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
 		return nil, err
 	}
-
-	now := time.Now()
-	mgr := &Manager{
-		ctx:        ctx,
-		slot:       slot,
-		pin:        pin,
-		createdAt:  now,
-		lastUsed:   now,
-		isLoggedIn: false,
-	}
+	mod := &loadedModule{ctx: ctx, api: ctx, refs: 1, managers: make(map[*Manager]struct{})}
+	modules[modulePath] = mod
 	logger.AppLog.Infoln("PKCS#11 module initialized")
-	return mgr, nil
+	return mod, nil
 }
 
-// NewSession creates and returns a new independent PKCS#11 session
-var SessionPool chan *Session
-var maxSessions int
-var currentSessions int = 0
-var mutexPool sync.Mutex
+// unloadModule finalizes the library when its last user is gone
+func unloadModule(modulePath string) {
+	modulesMutex.Lock()
+	defer modulesMutex.Unlock()
 
-func SetChanMaxSessions(n int) {
-	SessionPool = make(chan *Session, n)
-	maxSessions = n
-}
-
-func (m *Manager) NewSession() {
-	mutexPool.Lock()
-	if currentSessions >= maxSessions {
-		mutexPool.Unlock()
-		logger.AppLog.Debugln("The sessions reached max limit")
+	mod, ok := modules[modulePath]
+	if !ok {
 		return
 	}
-	mutexPool.Unlock()
-
-	logger.AppLog.Debugln("Creating new PKCS#11 session")
-
-	handle, err := m.ctx.OpenSession(m.slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
-	if err != nil {
-		logger.AppLog.Errorf("Failed to open session: %v", err)
+	mod.refs--
+	if mod.refs > 0 {
 		return
 	}
 
-	// ✅ Login solo una vez por slot (thread-safe)
-	m.loginMutex.Lock()
-	if !m.isLoggedIn {
-		logger.AppLog.Debugf("Logging into slot %d with session: %d", m.slot, handle)
-		if err := m.ctx.Login(handle, pkcs11.CKU_USER, m.pin); err != nil {
-			// ✅ Verificar si el error es porque ya está logueado
-			if err.Error() == "pkcs11: 0x100: CKR_USER_ALREADY_LOGGED_IN" {
-				logger.AppLog.Debugf("Slot %d already logged in, continuing", m.slot)
-				m.isLoggedIn = true
-			} else {
-				// ❌ Error real de login
-				logger.AppLog.Errorf("Failed to login to session: %v", err)
-				m.loginMutex.Unlock()
-				_ = m.ctx.CloseSession(handle)
-				return
-			}
-		} else {
-			m.isLoggedIn = true
-			logger.AppLog.Debugf("Successfully logged into slot %d", m.slot)
-		}
-	}
-	m.loginMutex.Unlock()
-
-	m.lastUsed = time.Now()
-	session := &Session{
-		Handle: handle,
-		Ctx:    m.ctx,
-	}
-
-	logger.AppLog.Debugf("PKCS#11 session created: %d (total sessions: %d)", handle, currentSessions+1)
+	logger.AppLog.Infoln("Finalizing PKCS#11 context")
+	_ = mod.ctx.Finalize()
+	mod.ctx.Destroy()
+	delete(modules, modulePath)
+}
 
-	mutexPool.Lock()
-	currentSessions++
-	mutexPool.Unlock()
+// register adds a Manager to the ones told about the resets of the module
+func (mod *loadedModule) register(m *Manager) {
+	mod.resetMutex.Lock()
+	defer mod.resetMutex.Unlock()
+	mod.managers[m] = struct{}{}
+	m.moduleEpoch = mod.epoch
+}
 
-	SessionPool <- session
+func (mod *loadedModule) unregister(m *Manager) {
+	mod.resetMutex.Lock()
+	defer mod.resetMutex.Unlock()
+	delete(mod.managers, m)
 }
 
-func (m *Manager) GetSession() *Session {
-	// ✅ No usar lock aquí para evitar deadlock
-	if len(SessionPool) == 0 {
-		mutexPool.Lock()
-		needsNewSession := currentSessions < maxSessions
-		mutexPool.Unlock()
+// reconnect finalizes and initializes the library again after a reset seen by
+// a Manager whose sessions were opened in epoch. Only the first caller of an
+// epoch does the work, every Manager of the library then drops its sessions
+// and cached key handles, the reset closed the sessions of all of them.
+func (mod *loadedModule) reconnect(epoch uint64) error {
+	mod.resetMutex.Lock()
+	defer mod.resetMutex.Unlock()
 
-		if needsNewSession {
-			m.NewSession()
-		}
+	if epoch != mod.epoch {
+		return nil
 	}
 
-	session := <-SessionPool
-	logger.AppLog.Debugf("Got session from pool: %d", session.Handle)
-	return session
-}
+	logger.AppLog.Warnf("PKCS#11 module reset detected, initializing it again for %d managers", len(mod.managers))
+	_ = mod.api.Finalize()
+	if err := mod.api.Initialize(); err != nil && !isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return err
+	}
+	mod.epoch++
 
-// LogoutSession returns session to pool (NO hace logout)
-func (m *Manager) LogoutSession(session *Session) {
-	if session == nil || session.Handle == 0 {
-		return
+	var errs []error
+	for m := range mod.managers {
+		if err := m.moduleReset(mod.epoch); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	logger.AppLog.Debugf("Returning PKCS#11 session to pool: %d", session.Handle)
-	SessionPool <- session
+	return errors.Join(errs...)
 }
 
-// CloseSession closes a session definitively
-func (m *Manager) CloseSession(session *Session) {
-	if session == nil || session.Handle == 0 {
-		return
+// New opens a Manager for the selected token of the module, the token is looked
+// up by label or serial when they are set
+func New(modulePath string, token TokenSelector, pin string, maxSessions int) (*Manager, error) {
+	mod, err := loadModule(modulePath)
+	if err != nil {
+		return nil, err
 	}
 
-	logger.AppLog.Debugf("Closing PKCS#11 session: %d", session.Handle)
-
-	// ✅ Solo cerrar la sesión, NO hacer logout (afectaría otras sesiones)
-	_ = m.ctx.CloseSession(session.Handle)
-
-	mutexPool.Lock()
-	currentSessions--
-	logger.AppLog.Debugf("Session closed. Remaining sessions: %d", currentSessions)
-	mutexPool.Unlock()
+	mgr, err := newManager(mod, modulePath, token, pin, maxSessions)
+	if err != nil {
+		unloadModule(modulePath)
+		return nil, err
+	}
+	return mgr, nil
 }
 
-// CloseAllSessions closes all sessions and does logout
-func (m *Manager) CloseAllSessions() {
-	m.loginMutex.Lock()
-	defer m.loginMutex.Unlock()
-
-	logger.AppLog.Debugf("Closing all sessions for slot %d", m.slot)
+// newManager looks the token up in a loaded module and registers a Manager for it
+func newManager(mod *loadedModule, modulePath string, token TokenSelector, pin string, maxSessions int) (*Manager, error) {
+	slot, err := resolveSlot(mod.api, token)
+	if err != nil {
+		return nil, err
+	}
 
-	// ✅ Hacer logout antes de cerrar todas las sesiones
-	if m.isLoggedIn {
-		// Necesitamos una sesión válida para hacer logout
-		if len(SessionPool) > 0 {
-			session := <-SessionPool
-			_ = m.ctx.Logout(session.Handle)
-			SessionPool <- session
-		}
-		m.isLoggedIn = false
+	mechanisms, err := readMechanisms(mod.api, slot)
+	if err != nil {
+		return nil, err
 	}
 
-	_ = m.ctx.CloseAllSessions(m.slot)
+	if maxSessions <= 0 {
+		maxSessions = 1
+	}
 
-	mutexPool.Lock()
-	currentSessions = 0
-	mutexPool.Unlock()
+	now := time.Now()
+	mgr := &Manager{
+		ctx:         mod.ctx,
+		api:         mod.api,
+		module:      mod,
+		modulePath:  modulePath,
+		token:       token,
+		slot:        slot,
+		mechanisms:  mechanisms,
+		pin:         pin,
+		createdAt:   now,
+		lastUsed:    now,
+		isLoggedIn:  false,
+		idle:        make(chan *Session, maxSessions),
+		slotFreed:   make(chan struct{}, 1),
+		maxSessions: maxSessions,
+		keys:        NewKeyCache(),
+	}
+	mod.register(mgr)
+	logger.AppLog.Infof("PKCS#11 manager ready for slot %d", slot)
+	return mgr, nil
 }
 
-// Finalize cleans up the PKCS#11 context
+// Finalize closes the sessions of the slot and releases the PKCS#11 module
 func (m *Manager) Finalize() {
 	if m.ctx != nil {
-		logger.AppLog.Infoln("Finalizing PKCS#11 context")
+		logger.AppLog.Infof("Finalizing PKCS#11 manager for slot %d", m.slot)
+
+		m.StopHealthCheck()
 
 		// ✅ Cerrar todas las sesiones antes de finalizar
 		m.CloseAllSessions()
 
-		_ = m.ctx.Finalize()
-		m.ctx.Destroy()
+		m.module.unregister(m)
+		unloadModule(m.modulePath)
 		m.ctx = nil
 	}
 }
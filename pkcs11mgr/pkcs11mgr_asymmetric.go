@@ -0,0 +1,227 @@
+package pkcs11mgr
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+// Elliptic curves accepted by GenerateECKeyPair
+const (
+	EC_CURVE_P256 = "P-256"
+	EC_CURVE_P384 = "P-384"
+)
+
+// RSA key sizes accepted by the key pair API
+var RSAKeySizes = []int{2048, 3072, 4096}
+
+type ecCurve struct {
+	oid   asn1.ObjectIdentifier
+	curve elliptic.Curve
+	ecdh  ecdh.Curve
+}
+
+var ecCurves = map[string]ecCurve{
+	EC_CURVE_P256: {asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}, elliptic.P256(), ecdh.P256()},
+	EC_CURVE_P384: {asn1.ObjectIdentifier{1, 3, 132, 0, 34}, elliptic.P384(), ecdh.P384()},
+}
+
+// SignAlgorithm is a signature algorithm of the sign API, algorithms are named
+// after their JOSE identifiers
+type SignAlgorithm struct {
+	Name      string
+	Mechanism uint
+	KeyType   uint // CKK_RSA or CKK_EC
+	Hash      crypto.Hash
+}
+
+// PSS reports whether the algorithm is RSA-PSS
+func (a SignAlgorithm) PSS() bool {
+	_, ok := pssParams[a.Mechanism]
+	return ok
+}
+
+var SignAlgorithms = map[string]SignAlgorithm{
+	"RS256": {"RS256", pkcs11.CKM_SHA256_RSA_PKCS, pkcs11.CKK_RSA, crypto.SHA256},
+	"RS384": {"RS384", pkcs11.CKM_SHA384_RSA_PKCS, pkcs11.CKK_RSA, crypto.SHA384},
+	"RS512": {"RS512", pkcs11.CKM_SHA512_RSA_PKCS, pkcs11.CKK_RSA, crypto.SHA512},
+	"PS256": {"PS256", pkcs11.CKM_SHA256_RSA_PKCS_PSS, pkcs11.CKK_RSA, crypto.SHA256},
+	"PS384": {"PS384", pkcs11.CKM_SHA384_RSA_PKCS_PSS, pkcs11.CKK_RSA, crypto.SHA384},
+	"PS512": {"PS512", pkcs11.CKM_SHA512_RSA_PKCS_PSS, pkcs11.CKK_RSA, crypto.SHA512},
+	"ES256": {"ES256", pkcs11.CKM_ECDSA_SHA256, pkcs11.CKK_EC, crypto.SHA256},
+	"ES384": {"ES384", pkcs11.CKM_ECDSA_SHA384, pkcs11.CKK_EC, crypto.SHA384},
+}
+
+// pssParams holds the hash, MGF and salt length of the RSA-PSS mechanisms, the
+// salt is as long as the hash
+var pssParams = map[uint][3]uint{
+	pkcs11.CKM_SHA256_RSA_PKCS_PSS: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, 32},
+	pkcs11.CKM_SHA384_RSA_PKCS_PSS: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384, 48},
+	pkcs11.CKM_SHA512_RSA_PKCS_PSS: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512, 64},
+}
+
+// signMechanism builds the sign or verify mechanism, RSA-PSS needs its parameters
+func signMechanism(mechanism uint) []*pkcs11.Mechanism {
+	if params, ok := pssParams[mechanism]; ok {
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, pkcs11.NewPSSParams(params[0], params[1], params[2]))}
+	}
+	return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}
+}
+
+// GenerateECKeyPair creates an EC key pair inside SoftHSM for signing and returns the public and private handles
+func GenerateECKeyPair(label string, curve string, s Session) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	logger.AppLog.Infof("Generating EC key pair: label=%s, curve=%s", label, curve)
+
+	c, ok := ecCurves[curve]
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported curve: %s", curve)
+	}
+	ecParams, err := asn1.Marshal(c.oid)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	publicKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ecParams),
+	}
+
+	privateKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)}
+
+	pubKey, privKey, err := s.Ctx.GenerateKeyPair(s.Handle, mechanism, publicKeyTemplate, privateKeyTemplate)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to generate EC key pair: %v", err)
+		return 0, 0, err
+	}
+	logger.AppLog.Infof("EC key pair generated successfully - Public: %d, Private: %d", pubKey, privKey)
+	return pubKey, privKey, nil
+}
+
+// GetPublicKey reads an RSA, EC or X25519 public key object, the key material never
+// leaves the token for private keys so only public key handles are accepted
+func GetPublicKey(handle pkcs11.ObjectHandle, s Session) (crypto.PublicKey, error) {
+	attrs, err := s.Ctx.GetAttributeValue(s.Handle, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		logger.AppLog.Errorf("GetAttributeValue failed for handle %d: %v", handle, err)
+		return nil, err
+	}
+	if class := attributeUint(attrs[0]); class != pkcs11.CKO_PUBLIC_KEY {
+		return nil, fmt.Errorf("object %d is not a public key", handle)
+	}
+
+	switch keyType := attributeUint(attrs[1]); keyType {
+	case pkcs11.CKK_RSA:
+		attrs, err := s.Ctx.GetAttributeValue(s.Handle, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return rsaPublicKey(attrs[0].Value, attrs[1].Value)
+	case pkcs11.CKK_EC:
+		attrs, err := s.Ctx.GetAttributeValue(s.Handle, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ecPublicKey(attrs[0].Value, attrs[1].Value)
+	case CKK_EC_MONTGOMERY:
+		attrs, err := s.Ctx.GetAttributeValue(s.Handle, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return x25519PublicKey(attrs[0].Value)
+	default:
+		return nil, fmt.Errorf("unsupported public key type 0x%X", keyType)
+	}
+}
+
+// attributeUint decodes a CK_ULONG attribute value, it is in host byte order
+func attributeUint(attr *pkcs11.Attribute) uint {
+	switch len(attr.Value) {
+	case 8:
+		return uint(binary.NativeEndian.Uint64(attr.Value))
+	case 4:
+		return uint(binary.NativeEndian.Uint32(attr.Value))
+	default:
+		return 0
+	}
+}
+
+func rsaPublicKey(modulus, exponent []byte) (*rsa.PublicKey, error) {
+	e := new(big.Int).SetBytes(exponent)
+	if len(modulus) == 0 || !e.IsInt64() || e.Int64() > int64(^uint32(0)>>1) {
+		return nil, fmt.Errorf("invalid RSA public key")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(e.Int64())}, nil
+}
+
+// ecPublicKey decodes CKA_EC_PARAMS and CKA_EC_POINT, the point is a DER
+// OCTET STRING holding the uncompressed point
+func ecPublicKey(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, fmt.Errorf("invalid EC parameters: %w", err)
+	}
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err != nil || len(rest) > 0 {
+		raw = point // some tokens return the bare point
+	}
+	for _, c := range ecCurves {
+		if !c.oid.Equal(oid) {
+			continue
+		}
+		// ecdh validates that the point is on the curve
+		if _, err := c.ecdh.NewPublicKey(raw); err != nil {
+			return nil, fmt.Errorf("invalid EC point: %w", err)
+		}
+		size := (len(raw) - 1) / 2
+		return &ecdsa.PublicKey{
+			Curve: c.curve,
+			X:     new(big.Int).SetBytes(raw[1 : 1+size]),
+			Y:     new(big.Int).SetBytes(raw[1+size:]),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported EC curve %v", oid)
+}
+
+// CurveName returns the curve name of an EC public key
+func CurveName(pub *ecdsa.PublicKey) string {
+	for name, c := range ecCurves {
+		if c.curve == pub.Curve {
+			return name
+		}
+	}
+	return pub.Curve.Params().Name
+}
@@ -21,19 +21,19 @@ func GetAuditPublicKey() pkcs11.ObjectHandle {
 	return auditPublicKey
 }
 
-// InitAuditKey initializes the audit private key by finding it in the HSM
-func InitAuditKey(s *Session) error {
-	// Try to find the private key using the utility function
-	privateKeyHandle, err := findPrivateKeyByLabel(constants.AuditKeyLabel, *s)
+// InitAuditKey initializes the audit private key by finding it in the key store
+func InitAuditKey(ks KeyStore) error {
+	// Try to find the private key using the key store lookup
+	privateKeyHandle, err := ks.FindPrivateKey(constants.AuditKeyLabel)
 	if err != nil {
 		logger.AppLog.Warn("Audit private key not found, will generate new key pair")
-		return generateAuditKeyPair(s)
+		return generateAuditKeyPair(ks)
 	}
 
 	auditPrivateKey = privateKeyHandle
 
 	// Try to find the corresponding public key
-	publicKeyHandle, err := findPublicKeyByLabel(constants.AuditKeyLabel, *s)
+	publicKeyHandle, err := ks.FindPublicKey(constants.AuditKeyLabel)
 	if err != nil {
 		logger.AppLog.Warn("Audit public key not found")
 	} else {
@@ -101,36 +101,8 @@ func findPublicKeyByLabel(label string, s Session) (pkcs11.ObjectHandle, error)
 }
 
 // generateAuditKeyPair generates a new RSA key pair for audit signing
-func generateAuditKeyPair(s *Session) error {
-	publicKeyTemplate := []*pkcs11.Attribute{
-		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
-		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
-		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
-		pkcs11.NewAttribute(pkcs11.CKA_LABEL, constants.AuditKeyLabel),
-		pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048),
-		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
-	}
-
-	privateKeyTemplate := []*pkcs11.Attribute{
-		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
-		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
-		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
-		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
-		pkcs11.NewAttribute(pkcs11.CKA_LABEL, constants.AuditKeyLabel),
-	}
-
-	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)}
-
-	pubKey, privKey, err := s.Ctx.GenerateKeyPair(
-		s.Handle,
-		mechanism,
-		publicKeyTemplate,
-		privateKeyTemplate,
-	)
-
+func generateAuditKeyPair(ks KeyStore) error {
+	pubKey, privKey, err := ks.GenerateRSAKeyPair(constants.AuditKeyLabel, 2048)
 	if err != nil {
 		logger.AppLog.Errorf("Failed to generate audit key pair: %v", err)
 		return err
@@ -0,0 +1,523 @@
+package pkcs11mgr
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/safe"
+)
+
+// A backup bundle is a portable copy of the key inventory. Every extractable
+// secret key is wrapped inside the token under a bundle key derived from a
+// password or from the key components of several custodians, so the keys
+// never leave the token in clear. The bundle also carries the label, id,
+// type and check value of every key and the user secrets of the services.
+// It is signed with an HMAC under a second key derived from the same secret:
+// a restore checks the signature before it reads anything else.
+
+const (
+	BACKUP_BUNDLE_VERSION = 1
+
+	// How the bundle key is derived
+	BACKUP_PROTECTION_PASSWORD   = "password"   // PBKDF2-HMAC-SHA256 of a password
+	BACKUP_PROTECTION_COMPONENTS = "components" // XOR of the key components of the custodians
+
+	BACKUP_SIGNATURE_HMAC_SHA256 = "HMAC-SHA256"
+
+	backupKeySize           = 32
+	backupSaltSize          = 16
+	backupMinPasswordLength = 12
+	backupMinComponents     = 2
+	// The padded key wrap also wraps the 8 byte DES keys
+	backupMechanism = pkcs11.CKM_AES_KEY_WRAP_PAD
+)
+
+// Status of a bundle key in a restore plan
+const (
+	BACKUP_KEY_ADD          = "add"          // not on the token, the restore imports it
+	BACKUP_KEY_IDENTICAL    = "identical"    // on the token with the same check value
+	BACKUP_KEY_CONFLICT     = "conflict"     // on the token with another or no check value
+	BACKUP_KEY_NOT_EXPORTED = "not_exported" // not extractable, the bundle only lists it
+)
+
+var (
+	ErrInvalidBundle       = errors.New("invalid backup bundle")
+	ErrBackupSignature     = errors.New("the bundle signature is invalid or the backup credentials are wrong")
+	ErrBackupTokenNotEmpty = errors.New("a bundle is only restored into a token without secret keys")
+)
+
+// BackupBundle is the versioned backup of the key inventory, it is written as JSON
+type BackupBundle struct {
+	Version     int              `json:"version"`
+	CreatedAt   time.Time        `json:"created_at"`
+	Protection  BackupProtection `json:"protection"`
+	Mechanism   string           `json:"mechanism"`
+	Keys        []BackupKey      `json:"keys"`
+	UserSecrets []BackupSecret   `json:"user_secrets"`
+	Signature   BackupSignature  `json:"signature"`
+}
+
+// BackupProtection holds the parameters that derive the bundle keys from the credentials
+type BackupProtection struct {
+	Method     string `json:"method"`
+	Salt       string `json:"salt"`
+	Iterations int    `json:"iterations,omitempty"` // password only
+	Components int    `json:"components,omitempty"` // key components only
+}
+
+// BackupKey is a secret key of the bundle, WrappedKey is empty when the key
+// is not extractable
+type BackupKey struct {
+	Label      string `json:"label"`
+	Id         int32  `json:"id"`
+	KeyType    string `json:"key_type"`
+	CheckValue string `json:"kcv,omitempty"`
+	WrappedKey string `json:"wrapped_key,omitempty"`
+}
+
+// BackupSecret is the user secret of a service as stored in MongoDB, it stays
+// encrypted under the internal key of the bundle or hashed
+type BackupSecret struct {
+	ServiceId           string `json:"service_id"`
+	EncryptedData       string `json:"encrypted_data"`
+	IV                  string `json:"iv"`
+	KeyId               int32  `json:"key_id"`
+	KeyLabel            string `json:"key_label"`
+	EncryptionAlgorithm uint   `json:"encryption_algorithm"`
+	Role                string `json:"role,omitempty"`
+	Disabled            bool   `json:"disabled,omitempty"`
+	// PasswordHash replaces the encrypted data once the password is hashed,
+	// it is verified with the pepper key of the bundle, a pepper key created
+	// before the backups were enabled is not in it and a restore to another
+	// token then needs a password reset of the service
+	PasswordHash string `json:"password_hash,omitempty"`
+	PepperId     int32  `json:"pepper_id,omitempty"`
+	PepperCheck  string `json:"pepper_check,omitempty"`
+}
+
+type BackupSignature struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// BackupCredentials open a bundle: a password or the key components of the
+// custodians, never both
+type BackupCredentials struct {
+	Password   string
+	Components [][]byte
+}
+
+// BackupKeyStatus compares a bundle key with the key of the token
+type BackupKeyStatus struct {
+	Label      string
+	Id         int32
+	KeyType    string
+	CheckValue string
+	Status     string
+}
+
+// RestorePlan is the diff between a bundle and a token, EmptyToken tells
+// whether the bundle can be restored into the token
+type RestorePlan struct {
+	EmptyToken  bool
+	Keys        []BackupKeyStatus
+	UserSecrets int
+}
+
+// Validate checks the password or the key components without deriving any key
+func (c BackupCredentials) Validate() error {
+	_, err := c.method()
+	return err
+}
+
+func (c BackupCredentials) method() (string, error) {
+	switch {
+	case c.Password != "" && len(c.Components) > 0:
+		return "", errors.New("give either a password or key components, not both")
+	case c.Password != "":
+		if len(c.Password) < backupMinPasswordLength {
+			return "", fmt.Errorf("the backup password must have at least %d characters", backupMinPasswordLength)
+		}
+		return BACKUP_PROTECTION_PASSWORD, nil
+	case len(c.Components) > 0:
+		if len(c.Components) < backupMinComponents {
+			return "", fmt.Errorf("at least %d key components are needed", backupMinComponents)
+		}
+		for i, component := range c.Components {
+			if len(component) != backupKeySize {
+				return "", fmt.Errorf("key component %d must have %d bytes", i+1, backupKeySize)
+			}
+		}
+		return BACKUP_PROTECTION_COMPONENTS, nil
+	default:
+		return "", errors.New("a backup password or key components are required")
+	}
+}
+
+// bundleKeys derives the wrap key and the signature key of a bundle, the
+// caller must zero both
+func (c BackupCredentials) bundleKeys(protection BackupProtection) ([]byte, []byte, error) {
+	method, err := c.method()
+	if err != nil {
+		return nil, nil, err
+	}
+	if method != protection.Method {
+		return nil, nil, fmt.Errorf("the bundle is protected with %s", protection.Method)
+	}
+	salt, err := hex.DecodeString(protection.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, nil, errors.New("invalid bundle salt")
+	}
+
+	var secret []byte
+	switch method {
+	case BACKUP_PROTECTION_PASSWORD:
+		if protection.Iterations <= 0 {
+			return nil, nil, errors.New("invalid bundle iterations")
+		}
+		if secret, err = pbkdf2.Key(sha256.New, c.Password, salt, protection.Iterations, backupKeySize); err != nil {
+			return nil, nil, err
+		}
+	case BACKUP_PROTECTION_COMPONENTS:
+		if len(c.Components) != protection.Components {
+			return nil, nil, fmt.Errorf("the bundle needs %d key components", protection.Components)
+		}
+		secret = make([]byte, backupKeySize)
+		for _, component := range c.Components {
+			for i := range secret {
+				secret[i] ^= component[i]
+			}
+		}
+	}
+	defer safe.Zero(secret)
+
+	wrapKey, err := hkdf.Key(sha256.New, secret, salt, "ssm backup wrap key", backupKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	signatureKey, err := hkdf.Key(sha256.New, secret, salt, "ssm backup signature key", backupKeySize)
+	if err != nil {
+		safe.Zero(wrapKey)
+		return nil, nil, err
+	}
+	return wrapKey, signatureKey, nil
+}
+
+// signature computes the HMAC of the bundle without its signature
+func (b *BackupBundle) signature(signatureKey []byte) ([]byte, error) {
+	unsigned := *b
+	unsigned.Signature = BackupSignature{}
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, signatureKey)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// open checks the version and the signature of the bundle and returns its
+// wrap key, the caller must zero it. The errors of a bundle that cannot be
+// opened wrap ErrInvalidBundle or are ErrBackupSignature.
+func (b *BackupBundle) open(credentials BackupCredentials) ([]byte, error) {
+	if b.Version != BACKUP_BUNDLE_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidBundle, b.Version)
+	}
+	if b.Signature.Algorithm != BACKUP_SIGNATURE_HMAC_SHA256 {
+		return nil, fmt.Errorf("%w: unsupported signature %q", ErrInvalidBundle, b.Signature.Algorithm)
+	}
+	if b.Mechanism != WRAP_AES_KEY_WRAP_PAD {
+		return nil, fmt.Errorf("%w: unsupported wrap mechanism %q", ErrInvalidBundle, b.Mechanism)
+	}
+	wrapKey, signatureKey, err := credentials.bundleKeys(b.Protection)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	defer safe.Zero(signatureKey)
+
+	expected, err := b.signature(signatureKey)
+	if err != nil {
+		safe.Zero(wrapKey)
+		return nil, err
+	}
+	signature, err := hex.DecodeString(b.Signature.Value)
+	if err != nil || !hmac.Equal(signature, expected) {
+		safe.Zero(wrapKey)
+		return nil, ErrBackupSignature
+	}
+	return wrapKey, nil
+}
+
+// sessionWrapKeys creates the session wrap key of a bundle on the token of
+// each label the first time the label needs it
+type sessionWrapKeys struct {
+	ks      KeyStore
+	key     []byte
+	handles map[string]pkcs11.ObjectHandle
+}
+
+func newSessionWrapKeys(ks KeyStore, key []byte) *sessionWrapKeys {
+	return &sessionWrapKeys{ks: ks, key: key, handles: make(map[string]pkcs11.ObjectHandle)}
+}
+
+func (w *sessionWrapKeys) forLabel(label string) (pkcs11.ObjectHandle, error) {
+	if handle, ok := w.handles[label]; ok {
+		return handle, nil
+	}
+	handle, err := w.ks.CreateSessionWrapKey(label, w.key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create the bundle wrap key: %w", err)
+	}
+	w.handles[label] = handle
+	return handle, nil
+}
+
+// destroy destroys the session wrap keys and zeroes the bundle wrap key
+func (w *sessionWrapKeys) destroy() {
+	for _, handle := range w.handles {
+		if err := w.ks.DestroySessionKey(handle); err != nil {
+			logger.AppLog.Warnf("Failed to destroy the bundle wrap key: %v", err)
+		}
+	}
+	safe.Zero(w.key)
+}
+
+// CreateBackup wraps every extractable secret key of the token under a bundle
+// key derived from the credentials and signs the bundle. The keys that are
+// not extractable are listed without value. iterations is the PBKDF2 cost of
+// a password.
+func CreateBackup(ks KeyStore, credentials BackupCredentials, iterations int, secrets []BackupSecret) (*BackupBundle, error) {
+	method, err := credentials.method()
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, backupSaltSize)
+	if err := safe.RandRead(salt); err != nil {
+		return nil, err
+	}
+	bundle := &BackupBundle{
+		Version:   BACKUP_BUNDLE_VERSION,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Protection: BackupProtection{
+			Method: method,
+			Salt:   hex.EncodeToString(salt),
+		},
+		Mechanism:   WRAP_AES_KEY_WRAP_PAD,
+		Keys:        []BackupKey{},
+		UserSecrets: secrets,
+		Signature:   BackupSignature{Algorithm: BACKUP_SIGNATURE_HMAC_SHA256},
+	}
+	if bundle.UserSecrets == nil {
+		bundle.UserSecrets = []BackupSecret{}
+	}
+	if method == BACKUP_PROTECTION_PASSWORD {
+		bundle.Protection.Iterations = iterations
+	} else {
+		bundle.Protection.Components = len(credentials.Components)
+	}
+
+	wrapKey, signatureKey, err := credentials.bundleKeys(bundle.Protection)
+	if err != nil {
+		return nil, err
+	}
+	defer safe.Zero(signatureKey)
+	wrapKeys := newSessionWrapKeys(ks, wrapKey)
+	defer wrapKeys.destroy()
+
+	keys, err := tokenKeys(ks)
+	if err != nil {
+		return nil, err
+	}
+	for _, description := range keys {
+		key := BackupKey{
+			Label:      description.Label,
+			Id:         description.Id,
+			KeyType:    description.KeyType,
+			CheckValue: hex.EncodeToString(description.CheckValue),
+		}
+		if description.Extractable && description.KeyType != "" {
+			wrappingKey, err := wrapKeys.forLabel(description.Label)
+			if err != nil {
+				return nil, err
+			}
+			wrapped, err := ks.WrapKey(wrappingKey, description.handle, backupMechanism)
+			if err != nil {
+				return nil, fmt.Errorf("failed to wrap key %s/%d: %w", description.Label, description.Id, err)
+			}
+			key.WrappedKey = hex.EncodeToString(wrapped)
+		} else {
+			logger.AppLog.Warnf("Key %s/%d is not extractable, the bundle only lists it", description.Label, description.Id)
+		}
+		bundle.Keys = append(bundle.Keys, key)
+	}
+
+	signature, err := bundle.signature(signatureKey)
+	if err != nil {
+		return nil, err
+	}
+	bundle.Signature.Value = hex.EncodeToString(signature)
+	logger.AppLog.Infof("Backup bundle created: %d keys, %d user secrets", len(bundle.Keys), len(bundle.UserSecrets))
+	return bundle, nil
+}
+
+// PlanRestore checks the signature of the bundle and compares its keys with
+// the keys of the token, it changes nothing
+func PlanRestore(ks KeyStore, bundle *BackupBundle, credentials BackupCredentials) (RestorePlan, error) {
+	wrapKey, err := bundle.open(credentials)
+	if err != nil {
+		return RestorePlan{}, err
+	}
+	safe.Zero(wrapKey)
+	return planRestore(ks, bundle)
+}
+
+func planRestore(ks KeyStore, bundle *BackupBundle) (RestorePlan, error) {
+	keys, err := tokenKeys(ks)
+	if err != nil {
+		return RestorePlan{}, err
+	}
+	onToken := make(map[replicaKey]tokenKey, len(keys))
+	for _, key := range keys {
+		onToken[replicaKey{label: key.Label, id: key.Id}] = key
+	}
+
+	plan := RestorePlan{
+		EmptyToken:  len(keys) == 0,
+		Keys:        make([]BackupKeyStatus, 0, len(bundle.Keys)),
+		UserSecrets: len(bundle.UserSecrets),
+	}
+	for _, key := range bundle.Keys {
+		status := BackupKeyStatus{Label: key.Label, Id: key.Id, KeyType: key.KeyType, CheckValue: key.CheckValue}
+		existing, exists := onToken[replicaKey{label: key.Label, id: key.Id}]
+		switch {
+		case key.WrappedKey == "":
+			status.Status = BACKUP_KEY_NOT_EXPORTED
+		case !exists:
+			status.Status = BACKUP_KEY_ADD
+		case key.CheckValue != "" && key.CheckValue == hex.EncodeToString(existing.CheckValue):
+			status.Status = BACKUP_KEY_IDENTICAL
+		default:
+			status.Status = BACKUP_KEY_CONFLICT
+		}
+		plan.Keys = append(plan.Keys, status)
+	}
+	return plan, nil
+}
+
+// RestoreBackup checks the signature of the bundle and imports its keys into
+// a token that holds no secret key yet. The keys keep their label, id and
+// type and stay extractable. A failed restore leaves the keys imported so
+// far, the token must be emptied before trying again.
+func RestoreBackup(ks KeyStore, bundle *BackupBundle, credentials BackupCredentials) (RestorePlan, error) {
+	wrapKey, err := bundle.open(credentials)
+	if err != nil {
+		return RestorePlan{}, err
+	}
+	wrapKeys := newSessionWrapKeys(ks, wrapKey)
+	defer wrapKeys.destroy()
+
+	plan, err := planRestore(ks, bundle)
+	if err != nil {
+		return RestorePlan{}, err
+	}
+	if !plan.EmptyToken {
+		return plan, ErrBackupTokenNotEmpty
+	}
+
+	restored := 0
+	for _, key := range bundle.Keys {
+		if key.WrappedKey == "" {
+			continue
+		}
+		wrapped, err := hex.DecodeString(key.WrappedKey)
+		if err != nil {
+			return plan, fmt.Errorf("invalid wrapped key %s/%d", key.Label, key.Id)
+		}
+		unwrappingKey, err := wrapKeys.forLabel(key.Label)
+		if err != nil {
+			return plan, err
+		}
+		handle, err := ks.UnwrapKey(unwrappingKey, backupMechanism, wrapped, key.Label, key.Id, key.KeyType, true)
+		if err != nil {
+			return plan, fmt.Errorf("failed to restore key %s/%d: %w", key.Label, key.Id, err)
+		}
+		if err := checkRestoredKey(ks, handle, key); err != nil {
+			if deleteErr := ks.DeleteKey(key.Label, key.Id); deleteErr != nil {
+				logger.AppLog.Errorf("Failed to delete the restored key %s/%d: %v", key.Label, key.Id, deleteErr)
+			}
+			return plan, err
+		}
+		restored++
+	}
+	logger.AppLog.Infof("Backup bundle restored: %d keys", restored)
+	return plan, nil
+}
+
+// checkRestoredKey compares the check value of a restored key with the bundle
+func checkRestoredKey(ks KeyStore, handle pkcs11.ObjectHandle, key BackupKey) error {
+	if key.CheckValue == "" {
+		return nil
+	}
+	description, err := ks.DescribeKey(handle)
+	if err != nil {
+		return err
+	}
+	expected, err := hex.DecodeString(key.CheckValue)
+	if err != nil {
+		return fmt.Errorf("invalid check value of key %s/%d", key.Label, key.Id)
+	}
+	if len(description.CheckValue) > 0 && !bytes.Equal(description.CheckValue, expected) {
+		return fmt.Errorf("the restored key %s/%d does not match the check value of the bundle", key.Label, key.Id)
+	}
+	return nil
+}
+
+// tokenKey is a secret key of the token and its handle
+type tokenKey struct {
+	KeyDescription
+	handle pkcs11.ObjectHandle
+}
+
+// tokenKeys describes the secret keys of the token sorted by label and id,
+// the session keys without label are left out
+func tokenKeys(ks KeyStore) ([]tokenKey, error) {
+	keysByLabel, err := ks.FindAllKeys()
+	if err != nil && err.Error() == constants.ERROR_STRING_KEY_NOT_FOUND {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []tokenKey
+	for label, handles := range keysByLabel {
+		if label == "" {
+			continue
+		}
+		for _, handle := range handles {
+			description, err := ks.DescribeKey(handle)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, tokenKey{KeyDescription: description, handle: handle})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Label != keys[j].Label {
+			return keys[i].Label < keys[j].Label
+		}
+		return keys[i].Id < keys[j].Id
+	})
+	return keys, nil
+}
@@ -0,0 +1,250 @@
+package pkcs11mgr
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/utils"
+)
+
+// Curve25519 key type and key pair mechanism of PKCS#11 3.0, the pkcs11
+// package predates them
+const (
+	CKK_EC_MONTGOMERY              = 0x00000041
+	CKM_EC_MONTGOMERY_KEY_PAIR_GEN = 0x00001056
+)
+
+// EC_CURVE_X25519 is the Montgomery curve of the ECDH key pairs, P-256 is the
+// other curve they accept
+const EC_CURVE_X25519 = "X25519"
+
+type ecdhCurve struct {
+	keyType   uint
+	mechanism uint
+	oid       asn1.ObjectIdentifier
+	curve     ecdh.Curve
+}
+
+var ecdhCurves = map[string]ecdhCurve{
+	EC_CURVE_P256:   {pkcs11.CKK_EC, pkcs11.CKM_EC_KEY_PAIR_GEN, ecCurves[EC_CURVE_P256].oid, ecdh.P256()},
+	EC_CURVE_X25519: {CKK_EC_MONTGOMERY, CKM_EC_MONTGOMERY_KEY_PAIR_GEN, asn1.ObjectIdentifier{1, 3, 101, 110}, ecdh.X25519()},
+}
+
+// ecdhTemplates returns the public and private key templates of an ECDH key
+// pair, the private key can only derive shared secrets
+func ecdhTemplates(label string, id int32, c ecdhCurve) ([]*pkcs11.Attribute, []*pkcs11.Attribute, error) {
+	ecParams, err := asn1.Marshal(c.oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, c.keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ecParams),
+	}
+	privateKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, c.keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)),
+	}
+	return publicKeyTemplate, privateKeyTemplate, nil
+}
+
+// GenerateECDHKeyPair creates a P-256 or X25519 key agreement key pair inside
+// SoftHSM and returns the public and private handles
+func GenerateECDHKeyPair(label string, id int32, curve string, s Session) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	logger.AppLog.Infof("Generating ECDH key pair: label=%s, id=%d, curve=%s", label, id, curve)
+
+	c, ok := ecdhCurves[curve]
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported curve: %s", curve)
+	}
+	if !s.mechanisms.supports(c.mechanism) {
+		return 0, 0, unsupportedMechanism(c.mechanism)
+	}
+	if _, _, err := FindECDHKeyPair(label, id, s); err == nil {
+		return 0, 0, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+	}
+	publicKeyTemplate, privateKeyTemplate, err := ecdhTemplates(label, id, c)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(c.mechanism, nil)}
+	pubKey, privKey, err := s.Ctx.GenerateKeyPair(s.Handle, mechanism, publicKeyTemplate, privateKeyTemplate)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to generate ECDH key pair: %v", err)
+		return 0, 0, err
+	}
+	logger.AppLog.Infof("ECDH key pair generated successfully - Public: %d, Private: %d", pubKey, privKey)
+	return pubKey, privKey, nil
+}
+
+// ImportECDHKeyPair creates a key agreement key pair from a raw private key,
+// the big endian scalar for P-256 and the RFC 7748 key for X25519
+func ImportECDHKeyPair(label string, id int32, curve string, privateKey []byte, s Session) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	logger.AppLog.Infof("Importing ECDH key pair: label=%s, id=%d, curve=%s", label, id, curve)
+
+	c, ok := ecdhCurves[curve]
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported curve: %s", curve)
+	}
+	if !s.mechanisms.supports(c.mechanism) {
+		return 0, 0, unsupportedMechanism(c.mechanism)
+	}
+	key, err := c.curve.NewPrivateKey(privateKey)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid %s private key: %w", curve, err)
+	}
+	point, err := asn1.Marshal(key.PublicKey().Bytes())
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, _, err := FindECDHKeyPair(label, id, s); err == nil {
+		return 0, 0, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+	}
+	publicKeyTemplate, privateKeyTemplate, err := ecdhTemplates(label, id, c)
+	if err != nil {
+		return 0, 0, err
+	}
+	ecParams := publicKeyTemplate[len(publicKeyTemplate)-1] // CKA_EC_PARAMS
+	publicKeyTemplate = append(publicKeyTemplate, pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, point))
+	privateKeyTemplate = append(privateKeyTemplate, ecParams, pkcs11.NewAttribute(pkcs11.CKA_VALUE, privateKey))
+
+	privKey, err := s.Ctx.CreateObject(s.Handle, privateKeyTemplate)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to import ECDH private key: %v", err)
+		return 0, 0, err
+	}
+	pubKey, err := s.Ctx.CreateObject(s.Handle, publicKeyTemplate)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to import ECDH public key: %v", err)
+		if err := s.Ctx.DestroyObject(s.Handle, privKey); err != nil {
+			logger.AppLog.Warnf("Failed to remove the imported private key: %v", err)
+		}
+		return 0, 0, err
+	}
+	logger.AppLog.Infof("ECDH key pair imported successfully - Public: %d, Private: %d", pubKey, privKey)
+	return pubKey, privKey, nil
+}
+
+// FindECDHKeyPair finds the key agreement key pair of a label and id
+func FindECDHKeyPair(label string, id int32, s Session) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	find := func(template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+		if err := s.Ctx.FindObjectsInit(s.Handle, template); err != nil {
+			logger.AppLog.Errorf("FindObjectsInit failed: %v", err)
+			return 0, err
+		}
+		defer s.Ctx.FindObjectsFinal(s.Handle)
+		handles, _, err := s.Ctx.FindObjects(s.Handle, 1)
+		if err != nil {
+			logger.AppLog.Errorf("FindObjects failed: %v", err)
+			return 0, err
+		}
+		if len(handles) == 0 {
+			return 0, errors.New(constants.ERROR_STRING_KEY_NOT_FOUND)
+		}
+		return handles[0], nil
+	}
+
+	priv, err := find([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)),
+		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	pub, err := find([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return pub, priv, nil
+}
+
+// DeriveECDH computes the ECDH shared secret of a private key and a peer public
+// key (the uncompressed point for P-256).
+//
+// The shared secret leaves the token: SoftHSM only derives with CKD_NULL, so
+// the ANSI X9.63 KDF of the ECIES profiles runs in the SSM. The secret is
+// derived into a session object that is not sensitive and is extractable,
+// read once and destroyed. It is then in process memory until the caller
+// zeroes it, which must be right after the KDF; the buffer the PKCS#11 library
+// returned CKA_VALUE in is freed by miekg/pkcs11 without being zeroed. The
+// private key itself stays sensitive and never leaves the token.
+func DeriveECDH(privateKey pkcs11.ObjectHandle, peerPublic []byte, s Session) ([]byte, error) {
+	if !s.mechanisms.supports(pkcs11.CKM_ECDH1_DERIVE) {
+		return nil, unsupportedMechanism(pkcs11.CKM_ECDH1_DERIVE)
+	}
+	mechanism := []*pkcs11.Mechanism{
+		pkcs11.NewMechanism(pkcs11.CKM_ECDH1_DERIVE, pkcs11.NewECDH1DeriveParams(pkcs11.CKD_NULL, nil, peerPublic)),
+	}
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false), // session object only
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+	}
+	secret, err := s.Ctx.DeriveKey(s.Handle, mechanism, privateKey, template)
+	if err != nil {
+		logger.AppLog.Errorf("ECDH derivation failed: %v", err)
+		return nil, err
+	}
+	defer func() {
+		if err := s.Ctx.DestroyObject(s.Handle, secret); err != nil {
+			logger.AppLog.Warnf("Failed to destroy the ECDH shared secret: %v", err)
+		}
+	}()
+
+	attrs, err := s.Ctx.GetAttributeValue(s.Handle, secret, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)})
+	if err != nil {
+		logger.AppLog.Errorf("Failed to read the ECDH shared secret: %v", err)
+		return nil, err
+	}
+	return attrs[0].Value, nil
+}
+
+// p256PublicKey converts a P-256 key agreement public key to the form
+// GetPublicKey returns for CKK_EC keys
+func p256PublicKey(pub *ecdh.PublicKey) *ecdsa.PublicKey {
+	raw := pub.Bytes()
+	size := (len(raw) - 1) / 2
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(raw[1 : 1+size]),
+		Y:     new(big.Int).SetBytes(raw[1+size:]),
+	}
+}
+
+// x25519PublicKey decodes the CKA_EC_POINT of a Montgomery public key
+func x25519PublicKey(point []byte) (*ecdh.PublicKey, error) {
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err != nil || len(rest) > 0 {
+		raw = point // some tokens return the bare point
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
@@ -2,8 +2,10 @@ package pkcs11mgr
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
 	"github.com/networkgcorefullcode/ssm/utils"
 )
@@ -31,14 +33,14 @@ func GenerateAESKey(label string, id int32, bits int, s Session) (pkcs11.ObjectH
 		pkcs11.NewAttribute(pkcs11.CKA_UNWRAP, true),
 		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true), // store persistently in token
 		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
-		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, exportedByDefault(label)),
 	}
 
 	// Check if key already exists before creating it
 	existingHandle, err := FindKey(label, id, s)
 	if err == nil && existingHandle != 0 {
 		logger.AppLog.Infof("Key with label '%s' already exists, returning existing handle: %v", label, existingHandle)
-		return existingHandle, id, errors.New("the key is in the SSM")
+		return existingHandle, id, errors.New(constants.ERROR_STRING_KEY_EXISTS)
 	}
 
 	handle, err := s.Ctx.GenerateKey(s.Handle, []*pkcs11.Mechanism{mech}, template)
@@ -76,13 +78,13 @@ func GenerateDESKey(label string, id int32, s Session) (pkcs11.ObjectHandle, int
 		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
 		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
 		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
-		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, exportedByDefault(label)),
 	}
 	// Check if key already exists before creating it
 	existingHandle, err := FindKey(label, id, s)
 	if err == nil && existingHandle != 0 {
 		logger.AppLog.Infof("Key with label '%s' already exists, returning existing handle: %v", label, existingHandle)
-		return existingHandle, id, errors.New("the key is in the SSM")
+		return existingHandle, id, errors.New(constants.ERROR_STRING_KEY_EXISTS)
 	}
 
 	handle, err := s.Ctx.GenerateKey(s.Handle, []*pkcs11.Mechanism{mech}, template)
@@ -120,13 +122,13 @@ func GenerateDES3Key(label string, id int32, s Session) (pkcs11.ObjectHandle, in
 		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true), // store persistently in token
 		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
 		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
-		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, exportedByDefault(label)),
 	}
 	// Check if key already exists before creating it
 	existingHandle, err := FindKey(label, id, s)
 	if err == nil && existingHandle != 0 {
 		logger.AppLog.Infof("Key with label '%s' already exists, returning existing handle: %v", label, existingHandle)
-		return existingHandle, id, errors.New("the key is in the SSM")
+		return existingHandle, id, errors.New(constants.ERROR_STRING_KEY_EXISTS)
 	}
 
 	handle, err := s.Ctx.GenerateKey(s.Handle, []*pkcs11.Mechanism{mech}, template)
@@ -137,3 +139,94 @@ func GenerateDES3Key(label string, id int32, s Session) (pkcs11.ObjectHandle, in
 	logger.AppLog.Infof("DES3 key generated successfully: handle=%v", handle)
 	return handle, id, nil
 }
+
+// GenerateRSAKeyPair creates an RSA key pair inside SoftHSM for signing and returns the public and private handles
+func GenerateRSAKeyPair(label string, bits int, s Session) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	logger.AppLog.Infof("Generating RSA key pair: label=%s, bits=%d", label, bits)
+
+	publicKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+	}
+
+	privateKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)}
+
+	pubKey, privKey, err := s.Ctx.GenerateKeyPair(s.Handle, mechanism, publicKeyTemplate, privateKeyTemplate)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to generate RSA key pair: %v", err)
+		return 0, 0, err
+	}
+	logger.AppLog.Infof("RSA key pair generated successfully - Public: %d, Private: %d", pubKey, privKey)
+	return pubKey, privKey, nil
+}
+
+// GenerateMACKey creates a secret key that can only sign and verify MACs inside SoftHSM and returns its object handle.
+// keyType is CKK_GENERIC_SECRET for HMAC keys and CKK_AES for AES-CMAC keys. The keys of the replicated label
+// families are extractable when the key export is enabled, see SetKeyExport.
+func GenerateMACKey(label string, id int32, keyType uint, bits int, s Session) (pkcs11.ObjectHandle, int32, error) {
+	logger.AppLog.Infof("Generating MAC key: label=%s, keyType=0x%X, bits=%d", label, keyType, bits)
+
+	var mech *pkcs11.Mechanism
+	switch keyType {
+	case pkcs11.CKK_GENERIC_SECRET:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_GENERIC_SECRET_KEY_GEN, nil)
+	case pkcs11.CKK_AES:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)
+	default:
+		return 0, 0, fmt.Errorf("unsupported MAC key type 0x%X", keyType)
+	}
+	if id == 0 {
+		logger.AppLog.Info("The id is zero, return the last id + 1")
+		var err error
+		id, err = ReturnLastIDForLabel(label, s)
+		if err != nil {
+			logger.AppLog.Errorf("Error detect %s", err)
+			return 0, 0, err
+		}
+	}
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)),
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, bits/8),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, false),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, false),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true), // store persistently in token
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, exportedByDefault(label)),
+	}
+
+	// Check if key already exists before creating it
+	existingHandle, err := FindKey(label, id, s)
+	if err == nil && existingHandle != 0 {
+		logger.AppLog.Infof("Key with label '%s' already exists, returning existing handle: %v", label, existingHandle)
+		return existingHandle, id, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+	}
+
+	handle, err := s.Ctx.GenerateKey(s.Handle, []*pkcs11.Mechanism{mech}, template)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to generate MAC key: %v", err)
+		return 0, 0, err
+	}
+	logger.AppLog.Infof("MAC key generated successfully: handle=%v", handle)
+	return handle, id, nil
+}
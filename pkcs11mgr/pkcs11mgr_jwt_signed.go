@@ -1,24 +1,71 @@
 package pkcs11mgr
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/pkcs11"
 	constants "github.com/networkgcorefullcode/ssm/const"
 	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/safe"
 )
 
-var jwtPrivateKey, jwtPublicKey pkcs11.ObjectHandle
+// JWTAlgorithms are the JWS algorithms the JWTs can be signed with, the
+// JWT_SIGNING_KEY is an RSA key pair for RS256 and PS256 and a P-256 key pair
+// for ES256
+var JWTAlgorithms = []string{"RS256", "PS256", "ES256"}
+
+// The JWT_SIGNING_KEY label holds several key pair versions, see
+// KeyPairVersion. The highest version signs the new JWTs, an older version
+// still verifies the JWTs it signed until the grace period has passed since
+// the next version was created. RotateJWTKey creates a new version and
+// PurgeJWTKeys destroys the versions past their grace period.
+
+// JWTKey is a version of the JWT_SIGNING_KEY
+type JWTKey struct {
+	KeyPairVersion
+	Kid       string // JWK thumbprint of the public key
+	PublicKey crypto.PublicKey
+	Signing   bool      // the version signs the new JWTs
+	Accepted  bool      // the version verifies JWTs
+	ExpiresAt time.Time // end of the grace period, zero for the signing version
+}
+
+// jwtKeysRefresh is how long the versions are cached, a rotation made by
+// another SSM or by the CLI is seen after at most this long. A JWT with an
+// unknown kid reloads them after jwtKeysMinRefresh.
+const (
+	jwtKeysRefresh    = time.Minute
+	jwtKeysMinRefresh = 5 * time.Second
+)
+
+var (
+	jwtAlgorithm   = SignAlgorithms["RS256"]
+	jwtGracePeriod = 24 * time.Hour
 
-// JWTHeader represents the JWT header
+	jwtMu           sync.RWMutex
+	jwtKeys         []JWTKey // every version sorted by id, the last one signs
+	jwtKeysLoadedAt time.Time
+)
+
+// JWTHeader represents the JWT header (RFC 7515 section 4)
 type JWTHeader struct {
 	Alg string `json:"alg"`
 	Typ string `json:"typ"`
+	Kid string `json:"kid,omitempty"` // JWK thumbprint of the signing key
 }
 
 // JWTPayload represents the JWT payload/claims
@@ -30,85 +77,367 @@ type JWTPayload struct {
 	Nbf int64  `json:"nbf,omitempty"` // Not before
 	Iat int64  `json:"iat,omitempty"` // Issued at
 	Jti string `json:"jti,omitempty"` // JWT ID
+	// Role of the service account, the tokens issued before the roles have
+	// none and the subject is the role
+	Role string `json:"role,omitempty"`
 }
 
-// GetJWTPrivateKey returns the JWT private key handle
-func GetJWTPrivateKey() pkcs11.ObjectHandle {
-	return jwtPrivateKey
+// Issuer and audience of the access tokens of /login, VerifyJWT rejects any other
+const (
+	JWTIssuer   = "ssm-service"
+	JWTAudience = "ssm-clients"
+)
+
+// jwtEncoding is the base64url encoding without padding of the JWT parts
+var jwtEncoding = base64.RawURLEncoding
+
+// GetJWTKid returns the key id of the version that signs the JWTs
+func GetJWTKid() string {
+	jwtMu.RLock()
+	defer jwtMu.RUnlock()
+	if len(jwtKeys) == 0 {
+		return ""
+	}
+	return jwtKeys[len(jwtKeys)-1].Kid
 }
 
-// GetJWTPublicKey returns the JWT public key handle
-func GetJWTPublicKey() pkcs11.ObjectHandle {
-	return jwtPublicKey
+// GetJWTAlgorithm returns the JWS algorithm the JWTs are signed with
+func GetJWTAlgorithm() string {
+	return jwtAlgorithm.Name
 }
 
-// InitJWTKey initializes the JWT signing key by finding it in the HSM
-func InitJWTKey(s *Session) error {
-	// Try to find the private key using the shared utility within the package
-	privateKeyHandle, err := findPrivateKeyByLabel(constants.JWTKeyLabel, *s)
-	if err != nil {
-		logger.AppLog.Warn("JWT private key not found, will generate new key pair")
-		return generateJWTKeyPair(s)
+// SetJWTAlgorithm sets the JWS algorithm of the JWTs, one of JWTAlgorithms.
+// It is called before InitJWTKey.
+func SetJWTAlgorithm(name string) error {
+	if !slices.Contains(JWTAlgorithms, name) {
+		return fmt.Errorf("unsupported JWT algorithm %q, use one of %s", name, strings.Join(JWTAlgorithms, ", "))
 	}
+	jwtAlgorithm = SignAlgorithms[name]
+	return nil
+}
 
-	jwtPrivateKey = privateKeyHandle
+// SetJWTGracePeriod sets how long a version verifies JWTs once the next
+// version is created, it should not be shorter than the lifetime of the JWTs
+func SetJWTGracePeriod(gracePeriod time.Duration) {
+	jwtMu.Lock()
+	defer jwtMu.Unlock()
+	jwtGracePeriod = gracePeriod
+}
 
-	// Try to find the corresponding public key
-	publicKeyHandle, err := findPublicKeyByLabel(constants.JWTKeyLabel, *s)
+// InitJWTKey loads the versions of the JWT signing key from the key store,
+// the first version is generated when the label is empty
+func InitJWTKey(ks KeyStore) error {
+	versions, err := ks.GetKeyPairVersions(constants.JWTKeyLabel)
 	if err != nil {
-		logger.AppLog.Warn("JWT public key not found")
-	} else {
-		jwtPublicKey = publicKeyHandle
-		logger.AppLog.Info("JWT key pair loaded successfully")
+		return err
+	}
+	if len(versions) == 0 {
+		logger.AppLog.Warn("JWT signing key not found, will generate new key pair")
+		key, err := RotateJWTKey(ks, time.Now())
+		if err != nil {
+			return err
+		}
+		logger.AppLog.Infof("Generated new JWT key pair - Public: %d, Private: %d, kid %s", key.Public, key.Private, key.Kid)
+		return nil
 	}
 
+	if err := reloadJWTKeys(ks, time.Now()); err != nil {
+		return err
+	}
+	logger.AppLog.Infof("JWT key pair loaded successfully, kid %s", GetJWTKid())
 	return nil
 }
 
-// generateJWTKeyPair generates a new RSA key pair for JWT signing
-func generateJWTKeyPair(s *Session) error {
-	publicKeyTemplate := []*pkcs11.Attribute{
-		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
-		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
-		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
-		pkcs11.NewAttribute(pkcs11.CKA_LABEL, constants.JWTKeyLabel),
-		pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048),
-		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+// RotateJWTKey generates a new version of the JWT signing key, it signs the
+// new JWTs right away and the previous version is accepted for the grace period
+func RotateJWTKey(ks KeyStore, now time.Time) (JWTKey, error) {
+	versions, err := ks.GetKeyPairVersions(constants.JWTKeyLabel)
+	if err != nil {
+		return JWTKey{}, err
+	}
+	id := int32(1)
+	if len(versions) > 0 {
+		id = versions[len(versions)-1].Id + 1
+	}
+
+	pub, priv, err := ks.GenerateSigningKeyPair(constants.JWTKeyLabel, id, jwtAlgorithm.KeyType)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to generate JWT key pair: %v", err)
+		return JWTKey{}, err
+	}
+	if err := ks.SetKeyMetadata(priv, now, KeyValidity{}); err != nil {
+		logger.AppLog.Errorf("Failed to record the creation of the JWT key pair: %v", err)
+		if err := ks.DeleteKeyPair(constants.JWTKeyLabel, id); err != nil {
+			logger.AppLog.Warnf("Failed to remove the JWT key pair: %v", err)
+		}
+		return JWTKey{}, err
+	}
+	logger.AppLog.Infof("JWT signing key rotated to version %d - Public: %d, Private: %d", id, pub, priv)
+
+	if err := reloadJWTKeys(ks, now); err != nil {
+		return JWTKey{}, err
+	}
+	jwtMu.RLock()
+	defer jwtMu.RUnlock()
+	return jwtKeys[len(jwtKeys)-1], nil
+}
+
+// PurgeJWTKeys destroys the versions of the JWT signing key past their grace
+// period, or every version but the signing one when all is set. It returns
+// the ids of the destroyed versions.
+func PurgeJWTKeys(ks KeyStore, now time.Time, all bool) ([]int32, error) {
+	keys, err := ListJWTKeys(ks, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []int32
+	for _, key := range keys {
+		if key.Signing || (!all && (key.ExpiresAt.IsZero() || now.Before(key.ExpiresAt))) {
+			continue
+		}
+		if err := ks.DeleteKeyPair(constants.JWTKeyLabel, key.Id); err != nil {
+			logger.AppLog.Errorf("Failed to destroy version %d of the JWT signing key: %v", key.Id, err)
+			return purged, err
+		}
+		logger.AppLog.Infof("Version %d of the JWT signing key destroyed, kid %s", key.Id, key.Kid)
+		purged = append(purged, key.Id)
+	}
+	if len(purged) > 0 {
+		return purged, reloadJWTKeys(ks, now)
+	}
+	return purged, nil
+}
+
+// ListJWTKeys returns every version of the JWT signing key sorted by id
+func ListJWTKeys(ks KeyStore, now time.Time) ([]JWTKey, error) {
+	versions, err := ks.GetKeyPairVersions(constants.JWTKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, errors.New(constants.ERROR_STRING_KEY_NOT_FOUND)
+	}
+
+	jwtMu.RLock()
+	gracePeriod := jwtGracePeriod
+	jwtMu.RUnlock()
+
+	keys := make([]JWTKey, 0, len(versions))
+	for i, version := range versions {
+		key := JWTKey{KeyPairVersion: version, Signing: i == len(versions)-1}
+		pub, err := ks.GetPublicKey(version.Public)
+		if err != nil {
+			logger.AppLog.Errorf("Failed to read version %d of the JWT public key: %v", version.Id, err)
+			return nil, err
+		}
+		key.PublicKey = pub
+		if key.Kid, err = JWKThumbprint(pub); err != nil {
+			return nil, err
+		}
+
+		fits := checkJWTKey(pub)
+		if key.Signing {
+			if fits != nil {
+				return nil, fmt.Errorf("%w, run ssm jwt rotate to generate a new version", fits)
+			}
+			key.Accepted = true
+		} else if next := versions[i+1].CreatedAt; !next.IsZero() {
+			key.ExpiresAt = next.Add(gracePeriod)
+			key.Accepted = fits == nil && now.Before(key.ExpiresAt)
+		}
+		keys = append(keys, key)
 	}
+	return keys, nil
+}
 
-	privateKeyTemplate := []*pkcs11.Attribute{
-		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
-		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
-		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
-		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
-		pkcs11.NewAttribute(pkcs11.CKA_LABEL, constants.JWTKeyLabel),
+// RotateJWTKeyIfDue rotates the JWT signing key when the signing version is
+// older than interval and destroys the versions past their grace period. It
+// reports whether the key was rotated.
+func RotateJWTKeyIfDue(ks KeyStore, interval time.Duration, now time.Time) (bool, error) {
+	keys, err := ListJWTKeys(ks, now)
+	if err != nil {
+		return false, err
 	}
+	current := keys[len(keys)-1]
+	rotated := false
+	if current.CreatedAt.IsZero() || !now.Before(current.CreatedAt.Add(interval)) {
+		key, err := RotateJWTKey(ks, now)
+		if err != nil {
+			return false, err
+		}
+		logger.AppLog.Infof("Scheduled rotation of the JWT signing key, new kid %s", key.Kid)
+		rotated = true
+	}
+	_, err = PurgeJWTKeys(ks, now, false)
+	return rotated, err
+}
 
-	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)}
+// jwtRotationCheck is how often the scheduled rotation checks the age of the
+// signing version
+const jwtRotationCheck = time.Hour
 
-	pubKey, privKey, err := s.Ctx.GenerateKeyPair(
-		s.Handle,
-		mechanism,
-		publicKeyTemplate,
-		privateKeyTemplate,
-	)
+var stopJWTRotation chan struct{}
+
+// StartJWTKeyRotation runs RotateJWTKeyIfDue in the background until
+// StopJWTKeyRotation is called
+func StartJWTKeyRotation(interval time.Duration) {
+	if interval <= 0 || stopJWTRotation != nil {
+		return
+	}
 
+	logger.AppLog.Infof("Starting the scheduled rotation of the JWT signing key every %v", interval)
+	stop := make(chan struct{})
+	stopJWTRotation = stop
+	go func() {
+		ticker := time.NewTicker(min(interval, jwtRotationCheck))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rotateScheduledJWTKey(interval)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopJWTKeyRotation stops the background rotation started by StartJWTKeyRotation
+func StopJWTKeyRotation() {
+	if stopJWTRotation != nil {
+		close(stopJWTRotation)
+		stopJWTRotation = nil
+	}
+}
+
+func rotateScheduledJWTKey(interval time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ks, err := provider.GetKeyStore(ctx)
 	if err != nil {
-		logger.AppLog.Errorf("Failed to generate JWT key pair: %v", err)
-		return err
+		logger.AppLog.Errorf("Failed to get key store for the JWT key rotation: %v", err)
+		return
 	}
+	defer provider.ReleaseKeyStore(ks)
 
-	jwtPrivateKey = privKey
-	jwtPublicKey = pubKey
-	logger.AppLog.Infof("Generated new JWT key pair - Public: %d, Private: %d", pubKey, privKey)
+	if _, err := RotateJWTKeyIfDue(ks, interval, time.Now()); err != nil {
+		logger.AppLog.Errorf("Scheduled rotation of the JWT signing key failed: %v", err)
+	}
+}
+
+// checkJWTKey checks that a public key fits the JWT algorithm
+func checkJWTKey(pub crypto.PublicKey) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if jwtAlgorithm.KeyType != pkcs11.CKK_RSA {
+			return fmt.Errorf("the %s key pair is RSA, %s needs a P-256 key pair", constants.JWTKeyLabel, jwtAlgorithm.Name)
+		}
+	case *ecdsa.PublicKey:
+		if jwtAlgorithm.KeyType != pkcs11.CKK_EC {
+			return fmt.Errorf("the %s key pair is EC, %s needs an RSA key pair", constants.JWTKeyLabel, jwtAlgorithm.Name)
+		}
+		if CurveName(key) != EC_CURVE_P256 {
+			return fmt.Errorf("the %s key pair is on %s, %s needs a P-256 key pair", constants.JWTKeyLabel, CurveName(key), jwtAlgorithm.Name)
+		}
+	}
+	return nil
+}
+
+// reloadJWTKeys replaces the cached versions of the JWT signing key
+func reloadJWTKeys(ks KeyStore, now time.Time) error {
+	keys, err := ListJWTKeys(ks, now)
+	if err != nil {
+		return err
+	}
+	jwtMu.Lock()
+	defer jwtMu.Unlock()
+	jwtKeys, jwtKeysLoadedAt = keys, time.Now()
 	return nil
 }
 
-// SignJWT creates and signs a JWT token using the HSM private key
-func SignJWT(s *Session, payload JWTPayload) (string, error) {
+// cachedJWTKeys returns the cached versions of the JWT signing key, they are
+// reloaded when older than maxAge. The cached versions are kept when the
+// reload fails.
+func cachedJWTKeys(ks KeyStore, maxAge time.Duration) []JWTKey {
+	jwtMu.RLock()
+	keys, loadedAt := jwtKeys, jwtKeysLoadedAt
+	jwtMu.RUnlock()
+	if time.Since(loadedAt) < maxAge {
+		return keys
+	}
+
+	if err := reloadJWTKeys(ks, time.Now()); err != nil {
+		logger.AppLog.Warnf("Failed to reload the JWT signing keys: %v", err)
+		jwtMu.Lock()
+		jwtKeysLoadedAt = time.Now() // retry after maxAge
+		jwtMu.Unlock()
+		return keys
+	}
+	jwtMu.RLock()
+	defer jwtMu.RUnlock()
+	return jwtKeys
+}
+
+// GetJWTKeys returns the versions of the JWT signing key that verify JWTs,
+// they are published in the JWKS
+func GetJWTKeys(ks KeyStore) []JWTKey {
+	return acceptedJWTKeys(ks, jwtKeysRefresh)
+}
+
+func acceptedJWTKeys(ks KeyStore, maxAge time.Duration) []JWTKey {
+	var accepted []JWTKey
+	for _, key := range cachedJWTKeys(ks, maxAge) {
+		if key.Accepted && (key.Signing || time.Now().Before(key.ExpiresAt)) {
+			accepted = append(accepted, key)
+		}
+	}
+	return accepted
+}
+
+// findJWTKey returns the accepted version with the key id kid
+func findJWTKey(ks KeyStore, kid string, maxAge time.Duration) (JWTKey, bool) {
+	for _, key := range acceptedJWTKeys(ks, maxAge) {
+		if key.Kid == kid {
+			return key, true
+		}
+	}
+	return JWTKey{}, false
+}
+
+// JWKThumbprint computes the SHA-256 JWK thumbprint (RFC 7638) of an RSA or
+// EC public key, it is the key id of the JWT signing key
+func JWKThumbprint(pub crypto.PublicKey) (string, error) {
+	var members string
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		members = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			jwtEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			jwtEncoding.EncodeToString(key.N.Bytes()))
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		members = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, CurveName(key),
+			jwtEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			jwtEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))))
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", pub)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return jwtEncoding.EncodeToString(sum[:]), nil
+}
+
+// signingJWTKey returns the version that signs the JWTs
+func signingJWTKey(ks KeyStore, maxAge time.Duration) (JWTKey, error) {
+	keys := cachedJWTKeys(ks, maxAge)
+	if len(keys) == 0 {
+		return JWTKey{}, fmt.Errorf("the JWT signing key is not initialized")
+	}
+	return keys[len(keys)-1], nil
+}
+
+// SignJWT creates and signs a JWT token (RFC 7519) using the HSM private key
+// of the signing version
+func SignJWT(ks KeyStore, payload JWTPayload) (string, error) {
 	// Set default values if not provided
 	if payload.Iat == 0 {
 		payload.Iat = time.Now().Unix()
@@ -117,10 +446,30 @@ func SignJWT(s *Session, payload JWTPayload) (string, error) {
 		payload.Exp = time.Now().Add(time.Hour * 24).Unix() // 24 hours default
 	}
 
+	key, err := signingJWTKey(ks, jwtKeysRefresh)
+	if err != nil {
+		return "", err
+	}
+	jwt, err := signJWT(ks, key, payload)
+	if err != nil {
+		// the version may have been rotated and destroyed by another SSM
+		if latest, reloadErr := signingJWTKey(ks, jwtKeysMinRefresh); reloadErr == nil && latest.Id != key.Id {
+			jwt, err = signJWT(ks, latest, payload)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	logger.AppLog.Infof("JWT signed successfully, length: %d", len(jwt))
+	return jwt, nil
+}
+
+func signJWT(ks KeyStore, key JWTKey, payload JWTPayload) (string, error) {
 	// Create JWT header
 	header := JWTHeader{
-		Alg: "RS256",
+		Alg: jwtAlgorithm.Name,
 		Typ: "JWT",
+		Kid: key.Kid,
 	}
 
 	// Encode header
@@ -128,48 +477,29 @@ func SignJWT(s *Session, payload JWTPayload) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal header: %v", err)
 	}
-	headerEncoded := hex.EncodeToString(headerJSON)
 
 	// Encode payload
 	payloadJSON, err := json.Marshal(payload)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal payload: %v", err)
 	}
-	payloadEncoded := hex.EncodeToString(payloadJSON)
 
-	// Create signing input
-	signingInput := headerEncoded + "." + payloadEncoded
-
-	// Hash the signing input
-	hasher := sha256.New()
-	hasher.Write([]byte(signingInput))
-	hashed := hasher.Sum(nil)
+	// Create signing input, the HSM hashes it with the mechanism of the algorithm
+	signingInput := jwtEncoding.EncodeToString(headerJSON) + "." + jwtEncoding.EncodeToString(payloadJSON)
 
 	// Sign with HSM
-	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS, nil)}
-
-	err = s.Ctx.SignInit(s.Handle, mechanism, jwtPrivateKey)
-	if err != nil {
-		return "", fmt.Errorf("failed to init signing: %v", err)
-	}
-
-	signature, err := s.Ctx.Sign(s.Handle, hashed)
+	signature, err := ks.Sign(key.Private, jwtAlgorithm.Mechanism, []byte(signingInput))
 	if err != nil {
 		return "", fmt.Errorf("failed to sign JWT: %v", err)
 	}
 
-	// Encode signature
-	signatureEncoded := hex.EncodeToString(signature)
-
 	// Return complete JWT
-	jwt := signingInput + "." + signatureEncoded
-	logger.AppLog.Infof("JWT signed successfully, length: %d", len(jwt))
-
-	return jwt, nil
+	return signingInput + "." + jwtEncoding.EncodeToString(signature), nil
 }
 
-// VerifyJWT verifies a JWT token using the HSM public key
-func VerifyJWT(s *Session, token string) (*JWTPayload, error) {
+// VerifyJWT verifies a JWT token using the HSM public key. Only tokens signed
+// with the JWT algorithm and the key id of an accepted version are accepted.
+func VerifyJWT(ks KeyStore, token string) (*JWTPayload, error) {
 	// Split the token
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
@@ -178,35 +508,42 @@ func VerifyJWT(s *Session, token string) (*JWTPayload, error) {
 
 	headerEncoded, payloadEncoded, signatureEncoded := parts[0], parts[1], parts[2]
 
-	// Decode signature
-	signature, err := hex.DecodeString(signatureEncoded)
+	// Decode and check the header before the signature, alg is never taken from the token
+	headerJSON, err := jwtEncoding.DecodeString(headerEncoded)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode signature: %v", err)
+		return nil, fmt.Errorf("failed to decode header: %v", err)
+	}
+	var header JWTHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("failed to parse header: %v", err)
+	}
+	if header.Alg != jwtAlgorithm.Name {
+		return nil, fmt.Errorf("unexpected JWT algorithm %q", header.Alg)
+	}
+	key, ok := findJWTKey(ks, header.Kid, jwtKeysRefresh)
+	if !ok {
+		// the kid may come from a version created by another SSM
+		key, ok = findJWTKey(ks, header.Kid, jwtKeysMinRefresh)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown JWT key id %q", header.Kid)
 	}
 
-	// Create signing input for verification
-	signingInput := headerEncoded + "." + payloadEncoded
-
-	// Hash the signing input
-	hasher := sha256.New()
-	hasher.Write([]byte(signingInput))
-	hashed := hasher.Sum(nil)
-
-	// Verify signature with HSM
-	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS, nil)}
-
-	err = s.Ctx.VerifyInit(s.Handle, mechanism, jwtPublicKey)
+	// Decode signature
+	signature, err := jwtEncoding.DecodeString(signatureEncoded)
 	if err != nil {
-		return nil, fmt.Errorf("failed to init verification: %v", err)
+		return nil, fmt.Errorf("failed to decode signature: %v", err)
 	}
 
-	err = s.Ctx.Verify(s.Handle, hashed, signature)
+	// Verify signature with HSM
+	signingInput := headerEncoded + "." + payloadEncoded
+	err = ks.Verify(key.Public, jwtAlgorithm.Mechanism, []byte(signingInput), signature)
 	if err != nil {
 		return nil, fmt.Errorf("JWT signature verification failed: %v", err)
 	}
 
 	// Decode and parse payload
-	payloadJSON, err := hex.DecodeString(payloadEncoded)
+	payloadJSON, err := jwtEncoding.DecodeString(payloadEncoded)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode payload: %v", err)
 	}
@@ -217,8 +554,17 @@ func VerifyJWT(s *Session, token string) (*JWTPayload, error) {
 		return nil, fmt.Errorf("failed to parse payload: %v", err)
 	}
 
+	// a token without exp would never expire and one without jti could never
+	// be revoked
+	if payload.Exp == 0 || payload.Jti == "" {
+		return nil, fmt.Errorf("JWT token without exp or jti")
+	}
+	if payload.Iss != JWTIssuer || payload.Aud != JWTAudience {
+		return nil, fmt.Errorf("unexpected JWT issuer %q or audience %q", payload.Iss, payload.Aud)
+	}
+
 	// Check expiration
-	if payload.Exp > 0 && time.Now().Unix() > payload.Exp {
+	if time.Now().Unix() > payload.Exp {
 		return nil, fmt.Errorf("JWT token has expired")
 	}
 
@@ -231,17 +577,30 @@ func VerifyJWT(s *Session, token string) (*JWTPayload, error) {
 	return &payload, nil
 }
 
-// CreateStandardJWT creates a JWT with standard claims
-func CreateStandardJWT(s *Session, issuer, subject, audience string, expirationHours int) (string, error) {
+// CreateStandardJWT creates a JWT with standard claims valid for lifetime, the
+// jti is random so the token can be revoked. It returns the token and its claims.
+func CreateStandardJWT(ks KeyStore, issuer, subject, audience string, lifetime time.Duration) (string, JWTPayload, error) {
+	return CreateRoleJWT(ks, issuer, subject, "", audience, lifetime)
+}
+
+// CreateRoleJWT creates a JWT like CreateStandardJWT with the role claim of
+// the service account
+func CreateRoleJWT(ks KeyStore, issuer, subject, role, audience string, lifetime time.Duration) (string, JWTPayload, error) {
+	jti := make([]byte, 16)
+	if err := safe.RandRead(jti); err != nil {
+		return "", JWTPayload{}, err
+	}
 	now := time.Now()
 	payload := JWTPayload{
-		Iss: issuer,
-		Sub: subject,
-		Aud: audience,
-		Iat: now.Unix(),
-		Exp: now.Add(time.Duration(expirationHours) * time.Hour).Unix(),
-		Jti: fmt.Sprintf("%d", now.UnixNano()), // Simple unique ID
+		Iss:  issuer,
+		Sub:  subject,
+		Aud:  audience,
+		Iat:  now.Unix(),
+		Exp:  now.Add(lifetime).Unix(),
+		Jti:  hex.EncodeToString(jti),
+		Role: role,
 	}
 
-	return SignJWT(s, payload)
+	token, err := SignJWT(ks, payload)
+	return token, payload, err
 }
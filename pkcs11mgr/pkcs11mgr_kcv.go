@@ -0,0 +1,133 @@
+package pkcs11mgr
+
+import (
+	"bytes"
+	"crypto/aes"
+	"errors"
+	"fmt"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+const (
+	// KCV_LENGTH is the length of the KCV, the first bytes of the ECB
+	// encryption of a zero block
+	KCV_LENGTH = 3
+	// KCV_CMAC_LENGTH is the length of the AES-CMAC KCV, the first bytes of
+	// the AES-CMAC of a zero block as in ANSI X9.24-1
+	KCV_CMAC_LENGTH = 5
+)
+
+var (
+	// ErrKCVMismatch is returned when a key does not match its expected KCV
+	ErrKCVMismatch = errors.New("the key does not match the expected KCV")
+	// ErrInvalidKCV is returned for an expected KCV that cannot be checked
+	ErrInvalidKCV = errors.New("invalid expected KCV")
+)
+
+// KeyCheckValues identify a secret key without revealing it. CMAC is only set
+// for AES keys.
+type KeyCheckValues struct {
+	KCV  []byte
+	CMAC []byte
+}
+
+// Check compares an expected KCV with the key check values, an expected value
+// of KCV_LENGTH bytes is compared with the KCV and one of KCV_CMAC_LENGTH
+// bytes with the AES-CMAC KCV
+func (v KeyCheckValues) Check(expected []byte) error {
+	var actual []byte
+	switch len(expected) {
+	case KCV_LENGTH:
+		actual = v.KCV
+	case KCV_CMAC_LENGTH:
+		actual = v.CMAC
+	default:
+		return fmt.Errorf("%w: it must be 3 bytes, or 5 bytes for an AES-CMAC KCV", ErrInvalidKCV)
+	}
+	if actual == nil {
+		return fmt.Errorf("%w: the key check value is not available for this key type", ErrInvalidKCV)
+	}
+	if !bytes.Equal(actual, expected) {
+		return ErrKCVMismatch
+	}
+	return nil
+}
+
+// ComputeKeyCheckValues computes the key check values of a clear key value,
+// used when a key is imported before it reaches the token
+func ComputeKeyCheckValues(keyType string, key []byte) (KeyCheckValues, error) {
+	keyTypeUint, err := secretKeyType(keyType)
+	if err != nil {
+		return KeyCheckValues{}, err
+	}
+	block, err := newBlockCipher(keyTypeUint, key)
+	if err != nil {
+		return KeyCheckValues{}, err
+	}
+
+	zero := make([]byte, block.BlockSize())
+	block.Encrypt(zero, zero)
+	values := KeyCheckValues{KCV: zero[:KCV_LENGTH]}
+	if keyType == constants.TYPE_AES {
+		mac, err := aesCMAC(key, make([]byte, aes.BlockSize))
+		if err != nil {
+			return KeyCheckValues{}, err
+		}
+		values.CMAC = mac[:KCV_CMAC_LENGTH]
+	}
+	return values, nil
+}
+
+// GetKeyCheckValues computes the key check values of a key in the token. The
+// KCV is the CKA_CHECK_VALUE of the key. The AES-CMAC KCV needs two ECB
+// encryptions, since the CMAC of a zero block is AES(K, K1) with the subkey
+// K1 derived from AES(K, 0), so it is only returned for keys allowed to
+// encrypt. Keys that are not AES, DES or DES3 keys have no check values.
+func GetKeyCheckValues(ks KeyStore, handle pkcs11.ObjectHandle) (KeyCheckValues, error) {
+	desc, err := ks.DescribeKey(handle)
+	if err != nil {
+		return KeyCheckValues{}, err
+	}
+
+	var mechanism uint
+	blockSize := 8
+	switch desc.KeyType {
+	case constants.TYPE_AES:
+		mechanism, blockSize = pkcs11.CKM_AES_ECB, aes.BlockSize
+	case constants.TYPE_DES3:
+		mechanism = pkcs11.CKM_DES3_ECB
+	case constants.TYPE_DES:
+		mechanism = pkcs11.CKM_DES_ECB
+	default:
+		return KeyCheckValues{}, nil
+	}
+
+	var values KeyCheckValues
+	if len(desc.CheckValue) >= KCV_LENGTH {
+		values.KCV = desc.CheckValue[:KCV_LENGTH]
+	}
+	if !desc.Encrypt || (values.KCV != nil && desc.KeyType != constants.TYPE_AES) {
+		return values, nil
+	}
+
+	l, err := ks.EncryptKey(handle, nil, make([]byte, blockSize), mechanism)
+	if err != nil {
+		logger.AppLog.Warnf("No key check value for handle %d: %v", handle, err)
+		return values, nil
+	}
+	if values.KCV == nil {
+		values.KCV = l[:KCV_LENGTH]
+	}
+	if desc.KeyType == constants.TYPE_AES {
+		mac, err := ks.EncryptKey(handle, nil, cmacDouble(l), mechanism)
+		if err != nil {
+			logger.AppLog.Warnf("No AES-CMAC key check value for handle %d: %v", handle, err)
+			return values, nil
+		}
+		values.CMAC = mac[:KCV_CMAC_LENGTH]
+	}
+	return values, nil
+}
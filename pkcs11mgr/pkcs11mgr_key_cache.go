@@ -0,0 +1,256 @@
+package pkcs11mgr
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/pkcs11"
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+// KeyCache keeps the object handles and attributes of the keys of one token so
+// the hot paths skip C_FindObjects and C_GetAttributeValue. Entries are filled
+// lazily by the Session lookups, dropped for a label whenever a key of that
+// label is created, changed or destroyed, and dropped entirely when the module
+// is reset because the handles of an older session generation are invalid.
+// Changes made to the token by another process are not seen until the label
+// is invalidated here, a handle the token rejects is looked up again by its
+// label and CKA_ID.
+type KeyCache struct {
+	mutex      sync.RWMutex
+	generation uint64
+	handles    map[keyCacheKey]pkcs11.ObjectHandle // FindKey, id 0 is any key of the label
+	labels     map[string][]pkcs11.ObjectHandle    // FindKeysLabel
+	versions   map[string][]KeyVersion             // GetKeyVersions
+	attributes map[pkcs11.ObjectHandle]ObjectAttributes
+	owners     map[pkcs11.ObjectHandle]keyCacheKey // label and CKA_ID of a cached handle, id 0 when the lookup was by label only
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+}
+
+type keyCacheKey struct {
+	label string
+	id    int32
+}
+
+// KeyCacheStats is a snapshot of the key cache metrics
+type KeyCacheStats struct {
+	Entries int
+	Hits    uint64
+	Misses  uint64
+}
+
+// NewKeyCache returns an empty key cache
+func NewKeyCache() *KeyCache {
+	c := &KeyCache{}
+	c.reset()
+	return c
+}
+
+func (c *KeyCache) reset() {
+	c.handles = make(map[keyCacheKey]pkcs11.ObjectHandle)
+	c.labels = make(map[string][]pkcs11.ObjectHandle)
+	c.versions = make(map[string][]KeyVersion)
+	c.attributes = make(map[pkcs11.ObjectHandle]ObjectAttributes)
+	c.owners = make(map[pkcs11.ObjectHandle]keyCacheKey)
+}
+
+// usable reports whether a session of the given generation may use the cache,
+// a newer generation empties it. Must be called with the write lock held.
+func (c *KeyCache) usable(generation uint64) bool {
+	if generation > c.generation {
+		logger.AppLog.Infof("Dropping %d cached key handles after a PKCS#11 module reset", len(c.owners))
+		c.reset()
+		c.generation = generation
+	}
+	return generation == c.generation
+}
+
+// lookup runs read under the read lock and counts the hit or miss
+func (c *KeyCache) lookup(generation uint64, read func() bool) bool {
+	c.mutex.RLock()
+	found := generation == c.generation && read()
+	c.mutex.RUnlock()
+
+	if found {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return found
+}
+
+// update runs write under the write lock unless the session is from an older generation
+func (c *KeyCache) update(generation uint64, write func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.usable(generation) {
+		write()
+	}
+}
+
+func (c *KeyCache) findKey(generation uint64, label string, id int32) (pkcs11.ObjectHandle, bool) {
+	var handle pkcs11.ObjectHandle
+	found := c.lookup(generation, func() bool {
+		var ok bool
+		handle, ok = c.handles[keyCacheKey{label, id}]
+		return ok
+	})
+	return handle, found
+}
+
+func (c *KeyCache) storeKey(generation uint64, label string, id int32, handle pkcs11.ObjectHandle) {
+	c.update(generation, func() {
+		key := keyCacheKey{label, id}
+		c.handles[key] = handle
+		// a lookup by label does not tell the version, it must not hide the
+		// id a lookup by version recorded
+		if owner, ok := c.owners[handle]; !ok || id != 0 || owner.label != label {
+			c.owners[handle] = key
+		}
+	})
+}
+
+func (c *KeyCache) findLabel(generation uint64, label string) ([]pkcs11.ObjectHandle, bool) {
+	var handles []pkcs11.ObjectHandle
+	found := c.lookup(generation, func() bool {
+		cached, ok := c.labels[label]
+		handles = append([]pkcs11.ObjectHandle(nil), cached...)
+		return ok
+	})
+	return handles, found
+}
+
+func (c *KeyCache) storeLabel(generation uint64, label string, handles []pkcs11.ObjectHandle) {
+	c.update(generation, func() {
+		c.labels[label] = append([]pkcs11.ObjectHandle(nil), handles...)
+		for _, handle := range handles {
+			if _, ok := c.owners[handle]; !ok {
+				c.owners[handle] = keyCacheKey{label: label}
+			}
+		}
+	})
+}
+
+func (c *KeyCache) findVersions(generation uint64, label string) ([]KeyVersion, bool) {
+	var versions []KeyVersion
+	found := c.lookup(generation, func() bool {
+		cached, ok := c.versions[label]
+		versions = append([]KeyVersion(nil), cached...)
+		return ok
+	})
+	return versions, found
+}
+
+func (c *KeyCache) storeVersions(generation uint64, label string, versions []KeyVersion) {
+	c.update(generation, func() {
+		c.versions[label] = append([]KeyVersion(nil), versions...)
+		for _, version := range versions {
+			c.owners[version.Handle] = keyCacheKey{label, version.Id}
+		}
+	})
+}
+
+func (c *KeyCache) findAttributes(generation uint64, handle pkcs11.ObjectHandle) (ObjectAttributes, bool) {
+	var attributes ObjectAttributes
+	found := c.lookup(generation, func() bool {
+		var ok bool
+		attributes, ok = c.attributes[handle]
+		return ok
+	})
+	return attributes, found
+}
+
+func (c *KeyCache) storeAttributes(generation uint64, handle pkcs11.ObjectHandle, attributes ObjectAttributes) {
+	c.update(generation, func() {
+		c.attributes[handle] = attributes
+	})
+}
+
+// owner returns the label and id a cached handle was found for
+func (c *KeyCache) owner(handle pkcs11.ObjectHandle) (keyCacheKey, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	key, ok := c.owners[handle]
+	return key, ok
+}
+
+// InvalidateLabel drops every cached entry of a label
+func (c *KeyCache) InvalidateLabel(label string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, handle := range c.handles {
+		if key.label == label {
+			delete(c.handles, key)
+			delete(c.attributes, handle)
+		}
+	}
+	for handle, key := range c.owners {
+		if key.label == label {
+			delete(c.owners, handle)
+			delete(c.attributes, handle)
+		}
+	}
+	delete(c.labels, label)
+	delete(c.versions, label)
+}
+
+// InvalidateHandle drops the cached entries of the label that owns a handle
+func (c *KeyCache) InvalidateHandle(handle pkcs11.ObjectHandle) {
+	if key, ok := c.owner(handle); ok {
+		c.InvalidateLabel(key.label)
+		return
+	}
+	c.mutex.Lock()
+	delete(c.attributes, handle)
+	c.mutex.Unlock()
+}
+
+// Clear drops every cached entry
+func (c *KeyCache) Clear() {
+	c.mutex.Lock()
+	c.reset()
+	c.mutex.Unlock()
+}
+
+// Stats returns the number of cached handles and the lookup counters
+func (c *KeyCache) Stats() KeyCacheStats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return KeyCacheStats{
+		Entries: len(c.owners),
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+	}
+}
+
+// isStaleHandleError reports whether the token no longer knows an object handle
+func isStaleHandleError(err error) bool {
+	return isPKCS11Error(err, pkcs11.CKR_OBJECT_HANDLE_INVALID) ||
+		isPKCS11Error(err, pkcs11.CKR_KEY_HANDLE_INVALID)
+}
+
+// refreshHandle looks up again with find the key behind a handle the token
+// rejected and returns its new handle. It only knows the handles that came from
+// the cache, and only retries when their CKA_ID is known: a lookup by label
+// alone may return another version of the key after a rotation.
+func (c *KeyCache) refreshHandle(handle pkcs11.ObjectHandle, generation uint64, find func(label string, id int32) (pkcs11.ObjectHandle, error)) (pkcs11.ObjectHandle, bool) {
+	key, ok := c.owner(handle)
+	if !ok {
+		return 0, false
+	}
+	c.InvalidateLabel(key.label)
+	if key.id == 0 {
+		logger.AppLog.Warnf("Cached handle %v of key %s is no longer valid, its version is unknown so the operation is not retried", handle, key.label)
+		return 0, false
+	}
+	logger.AppLog.Warnf("Cached handle %v of key %s (id %d) is no longer valid, looking it up again", handle, key.label, key.id)
+
+	fresh, err := find(key.label, key.id)
+	if err != nil {
+		return 0, false
+	}
+	c.storeKey(generation, key.label, key.id, fresh)
+	return fresh, true
+}
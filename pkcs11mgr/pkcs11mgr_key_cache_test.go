@@ -0,0 +1,89 @@
+package pkcs11mgr
+
+import (
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestKeyCacheInvalidateLabel(t *testing.T) {
+	c := NewKeyCache()
+	c.storeKey(0, "K4_AES", 1, 10)
+	c.storeKey(0, "K4_DES", 1, 20)
+	c.storeVersions(0, "K4_AES", []KeyVersion{{Handle: 10, Id: 1, Encrypt: true}})
+
+	if handle, ok := c.findKey(0, "K4_AES", 1); !ok || handle != 10 {
+		t.Fatalf("findKey = %v, %v, want 10, true", handle, ok)
+	}
+
+	c.InvalidateHandle(10)
+	if _, ok := c.findKey(0, "K4_AES", 1); ok {
+		t.Fatal("K4_AES still cached after invalidating its handle")
+	}
+	if _, ok := c.findVersions(0, "K4_AES"); ok {
+		t.Fatal("K4_AES versions still cached after invalidating its handle")
+	}
+	if _, ok := c.findKey(0, "K4_DES", 1); !ok {
+		t.Fatal("K4_DES dropped when invalidating K4_AES")
+	}
+}
+
+func TestKeyCacheDropsOlderGenerations(t *testing.T) {
+	c := NewKeyCache()
+	c.storeKey(1, "K4_AES", 1, 10)
+
+	// A session opened after a module reset empties the cache
+	c.storeKey(2, "K4_DES", 1, 20)
+	if _, ok := c.findKey(2, "K4_AES", 1); ok {
+		t.Fatal("handle of an older generation survived a module reset")
+	}
+
+	// A session left over from before the reset neither reads nor fills it
+	c.storeKey(1, "K4_AES", 1, 10)
+	if _, ok := c.findKey(1, "K4_DES", 1); ok {
+		t.Fatal("older generation read the cache")
+	}
+	if stats := c.Stats(); stats.Entries != 1 {
+		t.Fatalf("cache has %d entries, want 1", stats.Entries)
+	}
+}
+
+func TestKeyCacheRefreshAfterRotation(t *testing.T) {
+	c := NewKeyCache()
+	// the token after a rotation of K4_AES: version 1 only decrypts, version 2 is new
+	token := map[int32]pkcs11.ObjectHandle{1: 31, 2: 32}
+	find := func(label string, id int32) (pkcs11.ObjectHandle, error) {
+		if id == 0 {
+			t.Fatalf("%s looked up again without its id", label)
+		}
+		return token[id], nil
+	}
+
+	// a handle of a known version is looked up again with its CKA_ID
+	c.storeVersions(0, "K4_AES", []KeyVersion{{Handle: 11, Id: 1}, {Handle: 12, Id: 2}})
+	c.storeKey(0, "K4_AES", 0, 11) // a lookup by label does not forget the version
+	if fresh, ok := c.refreshHandle(11, 0, find); !ok || fresh != 31 {
+		t.Fatalf("refreshHandle = %v, %v, want 31, true", fresh, ok)
+	}
+	if handle, ok := c.findKey(0, "K4_AES", 1); !ok || handle != 31 {
+		t.Fatalf("findKey = %v, %v, want 31, true", handle, ok)
+	}
+	if _, ok := c.findVersions(0, "K4_AES"); ok {
+		t.Fatal("the versions of K4_AES survived a stale handle")
+	}
+
+	// a handle found by label alone may be any version, it is not retried
+	c.storeKey(0, "K4_AES", 0, 13)
+	c.storeLabel(0, "K4_AES", []pkcs11.ObjectHandle{13, 14})
+	for _, stale := range []pkcs11.ObjectHandle{13, 14} {
+		if fresh, ok := c.refreshHandle(stale, 0, find); ok {
+			t.Fatalf("refreshHandle(%v) retried with %v", stale, fresh)
+		}
+	}
+	if _, ok := c.findKey(0, "K4_AES", 0); ok {
+		t.Fatal("the stale handle of K4_AES is still cached")
+	}
+	if fresh, ok := c.refreshHandle(99, 0, find); ok {
+		t.Fatalf("refreshHandle of an unknown handle = %v", fresh)
+	}
+}
@@ -0,0 +1,349 @@
+package pkcs11mgr
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/utils"
+)
+
+const (
+	MIN_KEY_COMPONENTS = 2
+	MAX_KEY_COMPONENTS = 5
+	// COMPONENT_IMPORT_TIMEOUT is how long the custodians have to enter every
+	// component, the components entered so far are then discarded
+	COMPONENT_IMPORT_TIMEOUT = 30 * time.Minute
+)
+
+var (
+	ErrComponentImportNotFound = errors.New("no component import with this id, it may have expired")
+	ErrComponentSubmitted      = errors.New("the component was already submitted")
+	ErrCustodianSubmitted      = errors.New("the custodian already submitted a component of this import")
+	ErrComponentsMissing       = errors.New("not every component was submitted")
+	ErrInvalidComponent        = errors.New("invalid key component")
+)
+
+// ComponentImport is a split knowledge import in progress. The custodians
+// enter the clear components one by one, they are XORed inside the token into
+// a session key and only the combined key is stored.
+type ComponentImport struct {
+	Id         string
+	Label      string
+	KeyId      int32
+	KeyType    string
+	Components int
+	Custodians []string // service identity that entered each component
+	Submitted  int
+	ExpiresAt  time.Time
+
+	submitted []bool
+	base      pkcs11.ObjectHandle // session key holding the XOR of the submitted components
+	keyLen    int
+}
+
+var componentImports = struct {
+	sync.Mutex
+	imports map[string]*ComponentImport
+}{imports: make(map[string]*ComponentImport)}
+
+// StartComponentImport opens the import of a key entered as components
+func StartComponentImport(ks KeyStore, label string, id int32, keyType string, components int) (ComponentImport, error) {
+	if _, err := secretKeyType(keyType); err != nil {
+		return ComponentImport{}, err
+	}
+	if components < MIN_KEY_COMPONENTS || components > MAX_KEY_COMPONENTS {
+		return ComponentImport{}, fmt.Errorf("a key is entered as %d to %d components", MIN_KEY_COMPONENTS, MAX_KEY_COMPONENTS)
+	}
+	if handle, err := ks.FindKey(label, id); err == nil && handle != 0 {
+		return ComponentImport{}, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+	}
+	// the custodians would enter their components for nothing
+	if !ks.SupportsMechanism(label, pkcs11.CKM_XOR_BASE_AND_DATA) {
+		return ComponentImport{}, unsupportedMechanism(pkcs11.CKM_XOR_BASE_AND_DATA)
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return ComponentImport{}, err
+	}
+	imp := &ComponentImport{
+		Id:         hex.EncodeToString(raw),
+		Label:      label,
+		KeyId:      id,
+		KeyType:    keyType,
+		Components: components,
+		Custodians: make([]string, components),
+		ExpiresAt:  time.Now().Add(COMPONENT_IMPORT_TIMEOUT),
+		submitted:  make([]bool, components),
+	}
+
+	componentImports.Lock()
+	defer componentImports.Unlock()
+	expireComponentImports(ks)
+	componentImports.imports[imp.Id] = imp
+	logger.AppLog.Infof("Component import %s started for label %s, id %d: %d components", imp.Id, label, id, components)
+	return imp.snapshot(), nil
+}
+
+// GetComponentImport returns the import importId, the caller checks the key
+// usage policy of its label before a component is entered or the key stored
+func GetComponentImport(ks KeyStore, importId string) (ComponentImport, error) {
+	componentImports.Lock()
+	defer componentImports.Unlock()
+	expireComponentImports(ks)
+
+	imp, ok := componentImports.imports[importId]
+	if !ok {
+		return ComponentImport{}, ErrComponentImportNotFound
+	}
+	return imp.snapshot(), nil
+}
+
+// SubmitKeyComponent XORs component number index (from 1) into the import
+// after checking it against its KCV. custodian is the service identity that
+// entered it, a custodian enters at most one component of an import.
+func SubmitKeyComponent(ks KeyStore, importId string, index int, component, kcv []byte, custodian string) (ComponentImport, error) {
+	componentImports.Lock()
+	defer componentImports.Unlock()
+	expireComponentImports(ks)
+
+	imp, ok := componentImports.imports[importId]
+	if !ok {
+		return ComponentImport{}, ErrComponentImportNotFound
+	}
+	if index < 1 || index > imp.Components {
+		return ComponentImport{}, fmt.Errorf("%w: the component number must be between 1 and %d", ErrInvalidComponent, imp.Components)
+	}
+	if imp.submitted[index-1] {
+		return ComponentImport{}, ErrComponentSubmitted
+	}
+	for i, other := range imp.Custodians {
+		if imp.submitted[i] && custodian != "" && other == custodian {
+			logger.AppLog.Warnf("Component import %s: %s already entered component %d, refusing component %d", imp.Id, custodian, i+1, index)
+			return ComponentImport{}, ErrCustodianSubmitted
+		}
+	}
+	if imp.keyLen != 0 && len(component) != imp.keyLen {
+		return ComponentImport{}, fmt.Errorf("%w: every component must be %d bytes long", ErrInvalidComponent, imp.keyLen)
+	}
+	values, err := ComputeKeyCheckValues(imp.KeyType, component)
+	if err != nil {
+		return ComponentImport{}, fmt.Errorf("%w: it is not a %s key: %v", ErrInvalidComponent, imp.KeyType, err)
+	}
+	if err := values.Check(kcv); err != nil {
+		return ComponentImport{}, err
+	}
+
+	var handle pkcs11.ObjectHandle
+	if imp.Submitted == 0 {
+		handle, err = ks.CreateSessionComponentKey(imp.Label, component)
+	} else {
+		handle, err = ks.XORKeyComponent(imp.base, component)
+	}
+	if err != nil {
+		return ComponentImport{}, err
+	}
+	if imp.Submitted > 0 {
+		if err := ks.DestroySessionKey(imp.base); err != nil {
+			logger.AppLog.Warnf("Failed to destroy the previous component key of import %s: %v", imp.Id, err)
+		}
+	}
+	imp.base, imp.keyLen = handle, len(component)
+	imp.Custodians[index-1] = custodian
+	imp.submitted[index-1] = true
+	imp.Submitted++
+	logger.AppLog.Infof("Component import %s: component %d of %d submitted", imp.Id, index, imp.Components)
+	return imp.snapshot(), nil
+}
+
+// FinishComponentImport stores the XOR of the components under the label and
+// id of the import. The check values of the combined key are computed first,
+// a key that does not match expectedKCV is discarded with the import.
+func FinishComponentImport(ks KeyStore, importId string, expectedKCV []byte) (pkcs11.ObjectHandle, KeyCheckValues, ComponentImport, error) {
+	componentImports.Lock()
+	defer componentImports.Unlock()
+	expireComponentImports(ks)
+
+	imp, ok := componentImports.imports[importId]
+	if !ok {
+		return 0, KeyCheckValues{}, ComponentImport{}, ErrComponentImportNotFound
+	}
+	if imp.Submitted != imp.Components {
+		return 0, KeyCheckValues{}, imp.snapshot(), ErrComponentsMissing
+	}
+	// the import ends here whatever the outcome, the custodians start again
+	// after a mismatch
+	defer imp.discard(ks)
+
+	checkKey, err := ks.DeriveComponentKey(imp.base, imp.Label, imp.KeyId, imp.KeyType, imp.keyLen, false)
+	if err != nil {
+		return 0, KeyCheckValues{}, imp.snapshot(), err
+	}
+	values, err := GetKeyCheckValues(ks, checkKey)
+	if destroyErr := ks.DestroySessionKey(checkKey); destroyErr != nil {
+		logger.AppLog.Warnf("Failed to destroy the check key of import %s: %v", imp.Id, destroyErr)
+	}
+	if err != nil {
+		return 0, KeyCheckValues{}, imp.snapshot(), err
+	}
+	if len(expectedKCV) > 0 {
+		if err := values.Check(expectedKCV); err != nil {
+			return 0, values, imp.snapshot(), err
+		}
+	}
+
+	handle, err := ks.DeriveComponentKey(imp.base, imp.Label, imp.KeyId, imp.KeyType, imp.keyLen, true)
+	if err != nil {
+		return 0, values, imp.snapshot(), err
+	}
+	logger.AppLog.Infof("Component import %s stored label %s, id %d: handle %d", imp.Id, imp.Label, imp.KeyId, handle)
+	return handle, values, imp.snapshot(), nil
+}
+
+// snapshot copies the import for the caller
+func (imp *ComponentImport) snapshot() ComponentImport {
+	out := *imp
+	out.Custodians = append([]string(nil), imp.Custodians...)
+	out.submitted = nil
+	return out
+}
+
+// discard destroys the session key of the import and forgets it, the caller
+// holds the lock
+func (imp *ComponentImport) discard(ks KeyStore) {
+	if imp.Submitted > 0 {
+		if err := ks.DestroySessionKey(imp.base); err != nil {
+			logger.AppLog.Warnf("Failed to destroy the component key of import %s: %v", imp.Id, err)
+		}
+	}
+	delete(componentImports.imports, imp.Id)
+}
+
+// expireComponentImports discards the imports past their deadline, the caller
+// holds the lock
+func expireComponentImports(ks KeyStore) {
+	now := time.Now()
+	for _, imp := range componentImports.imports {
+		if now.After(imp.ExpiresAt) {
+			logger.AppLog.Warnf("Component import %s for label %s expired", imp.Id, imp.Label)
+			imp.discard(ks)
+		}
+	}
+}
+
+// CreateSessionComponentKey imports the first key component as a session
+// generic secret that can only derive, see XORKeyComponent
+func CreateSessionComponentKey(component []byte, s Session) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, component),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false), // session object only
+		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	}
+	handle, err := s.Ctx.CreateObject(s.Handle, template)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to create the component key: %v", err)
+		return 0, err
+	}
+	return handle, nil
+}
+
+// XORKeyComponent derives the XOR of the base key and a component with
+// CKM_XOR_BASE_AND_DATA into a new session key that can only derive
+func XORKeyComponent(base pkcs11.ObjectHandle, component []byte, s Session) (pkcs11.ObjectHandle, error) {
+	return deriveXOR(base, component, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, len(component)),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	}, s)
+}
+
+// DeriveComponentKey derives the combined components of base as a key of
+// keyType. With store the key is stored under label and id with the usage
+// StoreKey gives the label, otherwise it is a session key that can only
+// encrypt, used to compute the check values of the combined key.
+func DeriveComponentKey(base pkcs11.ObjectHandle, label string, id int32, keyType string, keyLen int, store bool, s Session) (pkcs11.ObjectHandle, error) {
+	keyTypeuint, err := secretKeyType(keyType)
+	if err != nil {
+		return 0, err
+	}
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyTypeuint),
+	}
+	if keyTypeuint == pkcs11.CKK_AES {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, keyLen))
+	}
+	if store {
+		if existingHandle, err := FindKey(label, id, s); err == nil && existingHandle != 0 {
+			return 0, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+		}
+		template = append(template,
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)))
+		template = append(template, storedKeyUsage(label, false).attributes()...)
+	} else {
+		template = append(template,
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, false),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false))
+	}
+
+	// XOR with a zero block keeps the value of the base key
+	zero := make([]byte, keyLen)
+	return deriveXOR(base, zero, template, s)
+}
+
+// deriveXOR runs CKM_XOR_BASE_AND_DATA. Its parameter is a
+// CK_KEY_DERIVATION_STRING_DATA, a pointer to the data and its length, which
+// miekg/pkcs11 cannot build: the structure is encoded here with the data
+// pinned until the token has read it. CK_ULONG has the size of a pointer on
+// the platforms SoftHSM runs on.
+func deriveXOR(base pkcs11.ObjectHandle, data []byte, template []*pkcs11.Attribute, s Session) (pkcs11.ObjectHandle, error) {
+	if !s.mechanisms.supports(pkcs11.CKM_XOR_BASE_AND_DATA) {
+		return 0, unsupportedMechanism(pkcs11.CKM_XOR_BASE_AND_DATA)
+	}
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	pinner.Pin(&data[0])
+
+	size := int(unsafe.Sizeof(uintptr(0)))
+	params := make([]byte, 2*size)
+	putNativeUint(params[:size], uint64(uintptr(unsafe.Pointer(&data[0]))))
+	putNativeUint(params[size:], uint64(len(data)))
+
+	mech := pkcs11.NewMechanism(pkcs11.CKM_XOR_BASE_AND_DATA, params)
+	handle, err := s.Ctx.DeriveKey(s.Handle, []*pkcs11.Mechanism{mech}, base, template)
+	if err != nil {
+		logger.AppLog.Errorf("CKM_XOR_BASE_AND_DATA failed for key %d: %v", base, err)
+		return 0, err
+	}
+	return handle, nil
+}
+
+func putNativeUint(b []byte, v uint64) {
+	if len(b) == 8 {
+		binary.NativeEndian.PutUint64(b, v)
+	} else {
+		binary.NativeEndian.PutUint32(b, uint32(v))
+	}
+}
@@ -0,0 +1,285 @@
+package pkcs11mgr
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/utils"
+)
+
+// PKCS#11 has no creation time for key objects, the SSM records it in a data
+// object next to the key: CKA_LABEL is the label of the key, CKA_APPLICATION
+// is KEY_CREATION_APPLICATION followed by the id of the key and CKA_VALUE is
+// the RFC 3339 creation time. DeleteKey destroys the record with the key.
+const KEY_CREATION_APPLICATION = "ssm-key-created/"
+
+// The retirement date of a key version is recorded the same way under
+// KEY_RETIREMENT_APPLICATION, CKA_END_DATE stays the end of the validity
+// period set by SetKeyMetadata. See RetireKey.
+const KEY_RETIREMENT_APPLICATION = "ssm-key-retired/"
+
+var (
+	// ErrKeyNotYetValid is returned when a key is used before its CKA_START_DATE
+	ErrKeyNotYetValid = errors.New("the key is not valid yet")
+	// ErrKeyExpired is returned when a key is used after its CKA_END_DATE
+	ErrKeyExpired = errors.New("the key validity period has ended")
+)
+
+// KeyValidity is the validity period of a key, CKA_START_DATE and
+// CKA_END_DATE. The dates have day precision and both days are included, a
+// zero date leaves that side of the period open.
+type KeyValidity struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Check returns ErrKeyNotYetValid or ErrKeyExpired when now is outside the
+// validity period
+func (v KeyValidity) Check(now time.Time) error {
+	if !v.Start.IsZero() && now.Before(v.Start) {
+		return fmt.Errorf("%w, it is valid from %s", ErrKeyNotYetValid, v.Start.Format(time.DateOnly))
+	}
+	if !v.End.IsZero() && !now.Before(v.End.AddDate(0, 0, 1)) {
+		return fmt.Errorf("%w, it was valid until %s", ErrKeyExpired, v.End.Format(time.DateOnly))
+	}
+	return nil
+}
+
+// KeyMetadata describes a secret key for the key listings
+type KeyMetadata struct {
+	Handle      pkcs11.ObjectHandle
+	Label       string
+	Id          int32
+	KeyType     string // TYPE_AES, TYPE_DES, TYPE_DES3 or TYPE_GENERIC_SECRET
+	SizeBits    int
+	Encrypt     bool
+	Decrypt     bool
+	Wrap        bool
+	Unwrap      bool
+	Extractable bool
+	Sensitive   bool
+	Validity    KeyValidity
+	CreatedAt   time.Time // zero for keys created without a creation record
+}
+
+// GetKeyMetadata reads the type, size, usage flags, validity and creation time
+// of a secret key
+func GetKeyMetadata(handle pkcs11.ObjectHandle, s Session) (KeyMetadata, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_WRAP, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_UNWRAP, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_START_DATE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_END_DATE, nil),
+	}
+	attrs, err := s.Ctx.GetAttributeValue(s.Handle, handle, template)
+	if err != nil {
+		logger.AppLog.Errorf("GetAttributeValue failed for handle %d: %v", handle, err)
+		return KeyMetadata{}, err
+	}
+
+	result := KeyMetadata{Handle: handle}
+	var keyType uint
+	for _, attr := range attrs {
+		flag := len(attr.Value) > 0 && attr.Value[0] != 0
+		switch attr.Type {
+		case pkcs11.CKA_LABEL:
+			result.Label = string(attr.Value)
+		case pkcs11.CKA_ID:
+			if len(attr.Value) > 0 {
+				result.Id = utils.ByteToInt32(attr.Value)
+			}
+		case pkcs11.CKA_KEY_TYPE:
+			keyType = attributeUint(attr)
+		case pkcs11.CKA_ENCRYPT:
+			result.Encrypt = flag
+		case pkcs11.CKA_DECRYPT:
+			result.Decrypt = flag
+		case pkcs11.CKA_WRAP:
+			result.Wrap = flag
+		case pkcs11.CKA_UNWRAP:
+			result.Unwrap = flag
+		case pkcs11.CKA_EXTRACTABLE:
+			result.Extractable = flag
+		case pkcs11.CKA_SENSITIVE:
+			result.Sensitive = flag
+		case pkcs11.CKA_START_DATE:
+			result.Validity.Start = parseCKDate(attr.Value)
+		case pkcs11.CKA_END_DATE:
+			result.Validity.End = parseCKDate(attr.Value)
+		}
+	}
+	result.KeyType = metadataKeyType(keyType)
+
+	// DES keys have no CKA_VALUE_LEN, it is read on its own
+	switch keyType {
+	case pkcs11.CKK_DES:
+		result.SizeBits = 64
+	case pkcs11.CKK_DES3:
+		result.SizeBits = 192
+	default:
+		attrs, err = s.Ctx.GetAttributeValue(s.Handle, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, nil),
+		})
+		if err != nil {
+			logger.AppLog.Warnf("No key length for handle %d: %v", handle, err)
+		} else if len(attrs) > 0 {
+			result.SizeBits = int(attributeUint(attrs[0])) * 8
+		}
+	}
+
+	record, err := findKeyCreationRecord(result.Label, result.Id, s)
+	if err != nil {
+		logger.AppLog.Warnf("No creation record for key %s id %d: %v", result.Label, result.Id, err)
+		return result, nil
+	}
+	if record != 0 {
+		result.CreatedAt = readKeyCreationRecord(record, s)
+	}
+	return result, nil
+}
+
+// metadataKeyType names the secret key types of the key listings
+func metadataKeyType(keyType uint) string {
+	if name := keyTypeName(keyType); name != "" {
+		return name
+	}
+	return fmt.Sprintf("0x%x", keyType)
+}
+
+// SetKeyMetadata sets the validity period of a key and records its creation
+// time. It is called right after a key is generated or stored.
+func SetKeyMetadata(handle pkcs11.ObjectHandle, createdAt time.Time, validity KeyValidity, s Session) error {
+	attr, err := GetObjectAttributes(handle, s)
+	if err != nil {
+		return err
+	}
+	label, err := GetObjectLabel(handle, s)
+	if err != nil {
+		return err
+	}
+
+	var template []*pkcs11.Attribute
+	if !validity.Start.IsZero() {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_START_DATE, validity.Start.UTC()))
+	}
+	if !validity.End.IsZero() {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_END_DATE, validity.End.UTC()))
+	}
+	if len(template) > 0 {
+		if err := s.Ctx.SetAttributeValue(s.Handle, handle, template); err != nil {
+			logger.AppLog.Errorf("Failed to set the validity of key handle %v: %v", handle, err)
+			return err
+		}
+	}
+
+	if err := writeKeyRecord(KEY_CREATION_APPLICATION, label, attr.Id, createdAt.UTC().Format(time.RFC3339), s); err != nil {
+		logger.AppLog.Errorf("Failed to record the creation of key %s id %d: %v", label, attr.Id, err)
+		return err
+	}
+	return nil
+}
+
+func keyRecordApplication(application string, id int32) string {
+	return fmt.Sprintf("%s%d", application, id)
+}
+
+// writeKeyRecord replaces the record of a key under an application prefix
+func writeKeyRecord(application, label string, id int32, value string, s Session) error {
+	if err := destroyKeyRecord(application, label, id, s); err != nil {
+		return err
+	}
+	_, err := s.Ctx.CreateObject(s.Handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_APPLICATION, keyRecordApplication(application, id)),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, value),
+	})
+	return err
+}
+
+// findKeyCreationRecord returns the creation record of a key, or 0 when the
+// key has none
+func findKeyCreationRecord(label string, id int32, s Session) (pkcs11.ObjectHandle, error) {
+	return findKeyRecord(KEY_CREATION_APPLICATION, label, id, s)
+}
+
+// findKeyRecord returns the record of a key under an application prefix, or 0
+// when the key has none
+func findKeyRecord(application, label string, id int32, s Session) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_APPLICATION, keyRecordApplication(application, id)),
+	}
+	if err := s.Ctx.FindObjectsInit(s.Handle, template); err != nil {
+		return 0, err
+	}
+	defer s.Ctx.FindObjectsFinal(s.Handle)
+
+	handles, _, err := s.Ctx.FindObjects(s.Handle, 1)
+	if err != nil || len(handles) == 0 {
+		return 0, err
+	}
+	return handles[0], nil
+}
+
+func readKeyCreationRecord(record pkcs11.ObjectHandle, s Session) time.Time {
+	return readKeyRecord(record, time.RFC3339, s)
+}
+
+// readKeyRecord parses the time of a key record, a record that can not be
+// read is the zero time
+func readKeyRecord(record pkcs11.ObjectHandle, layout string, s Session) time.Time {
+	attrs, err := s.Ctx.GetAttributeValue(s.Handle, record, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil),
+	})
+	if err != nil || len(attrs) == 0 {
+		return time.Time{}
+	}
+	value, err := time.Parse(layout, string(attrs[0].Value))
+	if err != nil {
+		return time.Time{}
+	}
+	return value
+}
+
+// destroyKeyRecords destroys the creation and retirement records of a
+// key if it has them
+func destroyKeyRecords(label string, id int32, s Session) error {
+	if err := destroyKeyRecord(KEY_RETIREMENT_APPLICATION, label, id, s); err != nil {
+		return err
+	}
+	return destroyKeyRecord(KEY_CREATION_APPLICATION, label, id, s)
+}
+
+// destroyKeyRecord destroys the record of a key under an application prefix
+// if it has one
+func destroyKeyRecord(application, label string, id int32, s Session) error {
+	record, err := findKeyRecord(application, label, id, s)
+	if err != nil || record == 0 {
+		return err
+	}
+	return s.Ctx.DestroyObject(s.Handle, record)
+}
+
+// CheckKeyValidity returns ErrKeyNotYetValid or ErrKeyExpired when a key may
+// not be used at now, it is checked before a key encrypts
+func CheckKeyValidity(ks KeyStore, handle pkcs11.ObjectHandle, now time.Time) error {
+	attr, err := ks.GetObjectAttributes(handle)
+	if err != nil {
+		return err
+	}
+	return attr.Validity.Check(now)
+}
@@ -0,0 +1,202 @@
+package pkcs11mgr
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/utils"
+)
+
+// A label of signing key pairs holds several versions, the public and the
+// private key of a version share its CKA_ID and the creation record of the
+// version is kept under that id, see SetKeyMetadata. The key pairs generated
+// before the versions existed have no CKA_ID and are version 0.
+
+// KeyPairVersion describes one version of a signing key pair
+type KeyPairVersion struct {
+	Id        int32
+	Public    pkcs11.ObjectHandle
+	Private   pkcs11.ObjectHandle
+	CreatedAt time.Time // zero for key pairs created without a creation record
+}
+
+// GenerateSigningKeyPair creates a sign only key pair with the given id inside
+// SoftHSM, an RSA 2048 key pair for CKK_RSA and a P-256 key pair for CKK_EC
+func GenerateSigningKeyPair(label string, id int32, keyType uint, s Session) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	logger.AppLog.Infof("Generating signing key pair: label=%s, id=%d, keyType=0x%X", label, id, keyType)
+
+	versions, err := GetKeyPairVersions(label, s)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, version := range versions {
+		if version.Id == id {
+			return 0, 0, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+		}
+	}
+
+	publicKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)),
+	}
+	privateKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)),
+	}
+
+	var mechanism []*pkcs11.Mechanism
+	switch keyType {
+	case pkcs11.CKK_RSA:
+		mechanism = []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)}
+		publicKeyTemplate = append(publicKeyTemplate,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}))
+	case pkcs11.CKK_EC:
+		ecParams, err := asn1.Marshal(ecCurves[EC_CURVE_P256].oid)
+		if err != nil {
+			return 0, 0, err
+		}
+		mechanism = []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)}
+		publicKeyTemplate = append(publicKeyTemplate, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ecParams))
+	default:
+		return 0, 0, fmt.Errorf("unsupported signing key type 0x%X", keyType)
+	}
+
+	pubKey, privKey, err := s.Ctx.GenerateKeyPair(s.Handle, mechanism, publicKeyTemplate, privateKeyTemplate)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to generate signing key pair: %v", err)
+		return 0, 0, err
+	}
+	logger.AppLog.Infof("Signing key pair generated successfully - Public: %d, Private: %d", pubKey, privKey)
+	return pubKey, privKey, nil
+}
+
+// GetKeyPairVersions returns every version of a label of key pairs sorted by
+// id, a version is listed once both of its keys are found
+func GetKeyPairVersions(label string, s Session) ([]KeyPairVersion, error) {
+	privateKeys, err := findKeyPairObjects(pkcs11.CKO_PRIVATE_KEY, label, s)
+	if err != nil {
+		return nil, err
+	}
+	publicKeys, err := findKeyPairObjects(pkcs11.CKO_PUBLIC_KEY, label, s)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]KeyPairVersion, 0, len(privateKeys))
+	for id, private := range privateKeys {
+		public, ok := publicKeys[id]
+		if !ok {
+			logger.AppLog.Warnf("Key pair %s id %d has no public key", label, id)
+			continue
+		}
+		version := KeyPairVersion{Id: id, Public: public, Private: private}
+		record, err := findKeyCreationRecord(label, id, s)
+		if err != nil {
+			logger.AppLog.Warnf("No creation record for key pair %s id %d: %v", label, id, err)
+		} else if record != 0 {
+			version.CreatedAt = readKeyCreationRecord(record, s)
+		}
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Id < versions[j].Id })
+	return versions, nil
+}
+
+// findKeyPairObjects returns the public or private keys of a label by id, the
+// keys without CKA_ID are id 0
+func findKeyPairObjects(class uint, label string, s Session) (map[int32]pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	handles, err := findAllObjects(template, s)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make(map[int32]pkcs11.ObjectHandle, len(handles))
+	for _, handle := range handles {
+		attrs, err := s.Ctx.GetAttributeValue(s.Handle, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+		})
+		if err != nil {
+			logger.AppLog.Errorf("GetAttributeValue failed for handle %d: %v", handle, err)
+			return nil, err
+		}
+		var id int32
+		if len(attrs) > 0 && len(attrs[0].Value) > 0 {
+			id = utils.ByteToInt32(attrs[0].Value)
+		}
+		objects[id] = handle
+	}
+	return objects, nil
+}
+
+// findAllObjects returns every object matching template
+func findAllObjects(template []*pkcs11.Attribute, s Session) ([]pkcs11.ObjectHandle, error) {
+	if err := s.Ctx.FindObjectsInit(s.Handle, template); err != nil {
+		logger.AppLog.Errorf("FindObjectsInit failed: %v", err)
+		return nil, err
+	}
+	defer s.Ctx.FindObjectsFinal(s.Handle)
+
+	var handles []pkcs11.ObjectHandle
+	for {
+		found, _, err := s.Ctx.FindObjects(s.Handle, 20)
+		if err != nil {
+			logger.AppLog.Errorf("FindObjects failed: %v", err)
+			return nil, err
+		}
+		if len(found) == 0 {
+			return handles, nil
+		}
+		handles = append(handles, found...)
+	}
+}
+
+// DeleteKeyPair destroys both keys of a key pair version and its creation record
+func DeleteKeyPair(label string, id int32, s Session) error {
+	logger.AppLog.Infof("Deleting key pair: label=%s, id=%d", label, id)
+
+	versions, err := GetKeyPairVersions(label, s)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if version.Id != id {
+			continue
+		}
+		if err := s.Ctx.DestroyObject(s.Handle, version.Private); err != nil {
+			logger.AppLog.Errorf("Failed to delete the private key of %s id %d: %v", label, id, err)
+			return err
+		}
+		if err := s.Ctx.DestroyObject(s.Handle, version.Public); err != nil {
+			logger.AppLog.Errorf("Failed to delete the public key of %s id %d: %v", label, id, err)
+			return err
+		}
+		if err := destroyKeyRecords(label, id, s); err != nil {
+			logger.AppLog.Warnf("Failed to delete the records of key pair %s id %d: %v", label, id, err)
+		}
+		return nil
+	}
+	return errors.New(constants.ERROR_STRING_KEY_NOT_FOUND)
+}
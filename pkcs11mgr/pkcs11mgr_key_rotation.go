@@ -0,0 +1,232 @@
+package pkcs11mgr
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/utils"
+)
+
+// A label holds several versions of a key, each one with its own CKA_ID. The
+// current version is the highest id that can still encrypt, older versions are
+// decrypt only (CKA_ENCRYPT=false) and carry their retirement date in a data
+// object, see KEY_RETIREMENT_APPLICATION. The retirement date has day
+// precision like CKA_END_DATE, a retired version is destroyed from the day
+// after its retirement date.
+
+// KeyVersion describes one version of a label
+type KeyVersion struct {
+	Handle   pkcs11.ObjectHandle
+	Id       int32
+	Encrypt  bool
+	RetireAt time.Time // zero until the version is retired
+}
+
+// Retired reports whether the version can only decrypt
+func (v KeyVersion) Retired() bool {
+	return !v.Encrypt && !v.RetireAt.IsZero()
+}
+
+// Expired reports whether a retired version is past its retirement date at now
+func (v KeyVersion) Expired(now time.Time) bool {
+	return v.Retired() && !now.Before(v.RetireAt.AddDate(0, 0, 1))
+}
+
+// RotationRequest describes the new version created by RotateKey
+type RotationRequest struct {
+	Label    string
+	KeyType  string
+	Key      []byte // new key material, a random key is generated when empty
+	Bits     int    // AES key size of a generated key
+	RetireAt time.Time
+}
+
+// KeyRotation is the result of RotateKey
+type KeyRotation struct {
+	Current KeyVersion
+	Retired []KeyVersion
+}
+
+// GetKeyVersions returns every version of a label sorted by id
+func GetKeyVersions(label string, s Session) ([]KeyVersion, error) {
+	handles, err := FindKeysLabel(label, s)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]KeyVersion, 0, len(handles))
+	for _, handle := range handles {
+		template := []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, nil),
+		}
+		attrs, err := s.Ctx.GetAttributeValue(s.Handle, handle, template)
+		if err != nil {
+			logger.AppLog.Errorf("GetAttributeValue failed for handle %d: %v", handle, err)
+			return nil, err
+		}
+
+		version := KeyVersion{Handle: handle}
+		for _, attr := range attrs {
+			switch attr.Type {
+			case pkcs11.CKA_ID:
+				if len(attr.Value) > 0 {
+					version.Id = utils.ByteToInt32(attr.Value)
+				}
+			case pkcs11.CKA_ENCRYPT:
+				version.Encrypt = len(attr.Value) > 0 && attr.Value[0] != 0
+			}
+		}
+		if !version.Encrypt {
+			record, err := findKeyRecord(KEY_RETIREMENT_APPLICATION, label, version.Id, s)
+			if err != nil {
+				logger.AppLog.Warnf("No retirement record for key %s id %d: %v", label, version.Id, err)
+			} else if record != 0 {
+				version.RetireAt = readKeyRecord(record, time.DateOnly, s)
+			}
+		}
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Id < versions[j].Id })
+	return versions, nil
+}
+
+// RetireKey makes a version decrypt only and records its retirement date, the
+// validity period of the version is left as it is
+func RetireKey(handle pkcs11.ObjectHandle, retireAt time.Time, s Session) error {
+	logger.AppLog.Infof("Retiring key handle=%v, retire at %s", handle, retireAt.Format(time.DateOnly))
+	attr, err := GetObjectAttributes(handle, s)
+	if err != nil {
+		return err
+	}
+	label, err := GetObjectLabel(handle, s)
+	if err != nil {
+		return err
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, false),
+	}
+	if err := s.Ctx.SetAttributeValue(s.Handle, handle, template); err != nil {
+		logger.AppLog.Errorf("Failed to retire key handle %v: %v", handle, err)
+		return err
+	}
+	if err := writeKeyRecord(KEY_RETIREMENT_APPLICATION, label, attr.Id, retireAt.UTC().Format(time.DateOnly), s); err != nil {
+		logger.AppLog.Errorf("Failed to record the retirement of key %s id %d: %v", label, attr.Id, err)
+		return err
+	}
+	return nil
+}
+
+// parseCKDate reads a CK_DATE value (YYYYMMDD), an empty date is the zero time
+func parseCKDate(value []byte) time.Time {
+	if len(value) != 8 {
+		return time.Time{}
+	}
+	date, err := time.ParseInLocation("20060102", string(value), time.UTC)
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+// FindCurrentKey returns the version of a label used to encrypt
+func FindCurrentKey(ks KeyStore, label string) (KeyVersion, error) {
+	versions, err := ks.GetKeyVersions(label)
+	if err != nil {
+		return KeyVersion{}, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Encrypt {
+			return versions[i], nil
+		}
+	}
+	logger.AppLog.Warnf("No key of label %s can encrypt", label)
+	return KeyVersion{}, errors.New(constants.ERROR_STRING_KEY_NOT_FOUND)
+}
+
+// RotateKey creates a new current version of a label next to the old ones and
+// retires the versions that could still encrypt. Data encrypted with a retired
+// version is still decrypted by its id until PurgeRetiredKeys destroys it.
+func RotateKey(ks KeyStore, req RotationRequest) (KeyRotation, error) {
+	versions, err := ks.GetKeyVersions(req.Label)
+	if err != nil && err.Error() != constants.ERROR_STRING_KEY_NOT_FOUND {
+		return KeyRotation{}, err
+	}
+
+	var newID int32 = 1
+	if len(versions) > 0 {
+		newID = versions[len(versions)-1].Id + 1
+	}
+	logger.AppLog.Infof("Rotating key %s, new version id %d", req.Label, newID)
+
+	var handle pkcs11.ObjectHandle
+	if len(req.Key) > 0 {
+		handle, err = ks.StoreKeyVersion(req.Label, req.Key, newID, req.KeyType)
+	} else {
+		switch req.KeyType {
+		case constants.TYPE_AES:
+			handle, _, err = ks.GenerateAESKey(req.Label, newID, req.Bits)
+		case constants.TYPE_DES:
+			handle, _, err = ks.GenerateDESKey(req.Label, newID)
+		case constants.TYPE_DES3:
+			handle, _, err = ks.GenerateDES3Key(req.Label, newID)
+		default:
+			err = fmt.Errorf("unsupported key type: %s", req.KeyType)
+		}
+	}
+	if err != nil {
+		logger.AppLog.Errorf("Failed to create version %d of key %s: %v", newID, req.Label, err)
+		return KeyRotation{}, err
+	}
+
+	rotation := KeyRotation{
+		Current: KeyVersion{Handle: handle, Id: newID, Encrypt: true},
+	}
+	for _, version := range versions {
+		if !version.Encrypt {
+			continue
+		}
+		if err := ks.RetireKey(version.Handle, req.RetireAt); err != nil {
+			return rotation, fmt.Errorf("version %d of key %s was created but version %d could not be retired: %w", newID, req.Label, version.Id, err)
+		}
+		version.Encrypt = false
+		version.RetireAt = req.RetireAt.UTC().Truncate(24 * time.Hour)
+		rotation.Retired = append(rotation.Retired, version)
+	}
+
+	logger.AppLog.Infof("Key %s rotated to version %d, %d versions retired", req.Label, newID, len(rotation.Retired))
+	return rotation, nil
+}
+
+// PurgeRetiredKeys destroys the versions of a label that are past their retirement date
+func PurgeRetiredKeys(ks KeyStore, label string, now time.Time) ([]KeyVersion, error) {
+	versions, err := ks.GetKeyVersions(label)
+	if err != nil {
+		return nil, err
+	}
+
+	var destroyed []KeyVersion
+	for _, version := range versions {
+		if !version.Expired(now) {
+			continue
+		}
+		if version.Id == 0 {
+			// DeleteKey would take any version of the label
+			logger.AppLog.Warnf("Skipping retired key %s without id, handle=%v", label, version.Handle)
+			continue
+		}
+		if err := ks.DeleteKey(label, version.Id); err != nil {
+			return destroyed, fmt.Errorf("destroying version %d of key %s: %w", version.Id, label, err)
+		}
+		logger.AppLog.Infof("Destroyed version %d of key %s, retired at %s", version.Id, label, version.RetireAt.Format(time.DateOnly))
+		destroyed = append(destroyed, version)
+	}
+	return destroyed, nil
+}
@@ -0,0 +1,27 @@
+package pkcs11mgr
+
+import "github.com/miekg/pkcs11"
+
+// MACAlgorithm is a message authentication algorithm of the MAC API
+type MACAlgorithm struct {
+	Name        string
+	Mechanism   uint
+	KeyType     uint // CKK_GENERIC_SECRET or CKK_AES
+	DefaultBits int  // key size used when the request does not set one
+	Size        int  // MAC length in bytes
+}
+
+// ValidKeySize reports whether a key of the given size can be used with the algorithm
+func (a MACAlgorithm) ValidKeySize(bits int) bool {
+	if a.KeyType == pkcs11.CKK_AES {
+		return bits == 128 || bits == 192 || bits == 256
+	}
+	// HMAC keys shorter than the hash are weak (RFC 2104 section 3)
+	return bits%8 == 0 && bits >= a.Size*8 && bits <= 1024
+}
+
+var MACAlgorithms = map[string]MACAlgorithm{
+	"HMAC-SHA256": {"HMAC-SHA256", pkcs11.CKM_SHA256_HMAC, pkcs11.CKK_GENERIC_SECRET, 256, 32},
+	"HMAC-SHA512": {"HMAC-SHA512", pkcs11.CKM_SHA512_HMAC, pkcs11.CKK_GENERIC_SECRET, 512, 64},
+	"AES-CMAC":    {"AES-CMAC", pkcs11.CKM_AES_CMAC, pkcs11.CKK_AES, 256, 16},
+}
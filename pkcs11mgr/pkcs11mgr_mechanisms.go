@@ -0,0 +1,63 @@
+package pkcs11mgr
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/miekg/pkcs11"
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+// ErrMechanismNotSupported is returned when the token lacks the mechanism of an operation
+var ErrMechanismNotSupported = errors.New("the token does not support the mechanism")
+
+// optionalMechanisms are the mechanisms of the features a token may lack, the
+// features are off on a token without them
+var optionalMechanisms = []struct {
+	mechanism uint
+	name      string
+	feature   string
+}{
+	{pkcs11.CKM_XOR_BASE_AND_DATA, "CKM_XOR_BASE_AND_DATA", "the key component import"},
+	{pkcs11.CKM_ECDH1_DERIVE, "CKM_ECDH1_DERIVE", "the SUCI de-concealment"},
+	// a token that generates Curve25519 key pairs also knows CKK_EC_MONTGOMERY
+	{CKM_EC_MONTGOMERY_KEY_PAIR_GEN, "CKM_EC_MONTGOMERY_KEY_PAIR_GEN", "SUCI Profile A (X25519)"},
+}
+
+// mechanismSet holds the mechanisms of a slot as C_GetMechanismList lists them
+type mechanismSet map[uint]struct{}
+
+// readMechanisms lists the mechanisms of a slot and logs the features its
+// token lacks a mechanism for
+func readMechanisms(api moduleAPI, slot uint) (mechanismSet, error) {
+	list, err := api.GetMechanismList(slot)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to list the mechanisms of slot %d: %v", slot, err)
+		return nil, err
+	}
+	set := make(mechanismSet, len(list))
+	for _, mechanism := range list {
+		set[mechanism.Mechanism] = struct{}{}
+	}
+	for _, optional := range optionalMechanisms {
+		if !set.supports(optional.mechanism) {
+			logger.AppLog.Warnf("The token of slot %d does not support %s, %s is disabled", slot, optional.name, optional.feature)
+		}
+	}
+	return set, nil
+}
+
+func (set mechanismSet) supports(mechanism uint) bool {
+	_, ok := set[mechanism]
+	return ok
+}
+
+// unsupportedMechanism names the mechanism in ErrMechanismNotSupported
+func unsupportedMechanism(mechanism uint) error {
+	for _, optional := range optionalMechanisms {
+		if optional.mechanism == mechanism {
+			return fmt.Errorf("%w %s, %s is disabled", ErrMechanismNotSupported, optional.name, optional.feature)
+		}
+	}
+	return fmt.Errorf("%w 0x%x", ErrMechanismNotSupported, mechanism)
+}
@@ -0,0 +1,77 @@
+package pkcs11mgr
+
+import (
+	"bytes"
+	"crypto/subtle"
+
+	"github.com/miekg/pkcs11"
+)
+
+// CreateSessionComponentKey adds the first key component as a generic secret
+// without label that can only derive
+func (p *MemoryProvider) CreateSessionComponentKey(label string, component []byte) (pkcs11.ObjectHandle, error) {
+	if len(component) == 0 {
+		return 0, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addObject(&memoryObject{class: pkcs11.CKO_SECRET_KEY, keyType: pkcs11.CKK_GENERIC_SECRET, value: bytes.Clone(component), derive: true}), nil
+}
+
+func (p *MemoryProvider) XORKeyComponent(base pkcs11.ObjectHandle, component []byte) (pkcs11.ObjectHandle, error) {
+	if !p.SupportsMechanism("", pkcs11.CKM_XOR_BASE_AND_DATA) {
+		return 0, unsupportedMechanism(pkcs11.CKM_XOR_BASE_AND_DATA)
+	}
+	obj, err := p.componentKey(base, len(component))
+	if err != nil {
+		return 0, err
+	}
+	value := make([]byte, len(component))
+	subtle.XORBytes(value, obj.value, component)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addObject(&memoryObject{class: pkcs11.CKO_SECRET_KEY, keyType: pkcs11.CKK_GENERIC_SECRET, value: value, derive: true}), nil
+}
+
+func (p *MemoryProvider) DeriveComponentKey(base pkcs11.ObjectHandle, label string, id int32, keyType string, keyLen int, store bool) (pkcs11.ObjectHandle, error) {
+	if !p.SupportsMechanism(label, pkcs11.CKM_XOR_BASE_AND_DATA) {
+		return 0, unsupportedMechanism(pkcs11.CKM_XOR_BASE_AND_DATA)
+	}
+	obj, err := p.componentKey(base, keyLen)
+	if err != nil {
+		return 0, err
+	}
+	if store {
+		return p.addSecretKey(label, bytes.Clone(obj.value), id, keyType, storedKeyUsage(label, false))
+	}
+
+	keyTypeuint, err := secretKeyType(keyType)
+	if err != nil {
+		return 0, err
+	}
+	check := &memoryObject{class: pkcs11.CKO_SECRET_KEY, keyType: keyTypeuint, value: bytes.Clone(obj.value), encrypt: true}
+	if _, err := blockCipher(check); err != nil {
+		return 0, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addObject(check), nil
+}
+
+// componentKey fetches a key that can derive and checks the length of the
+// data XORed with it, as CKM_XOR_BASE_AND_DATA the memory backend only XORs
+// values of the same length
+func (p *MemoryProvider) componentKey(handle pkcs11.ObjectHandle, length int) (*memoryObject, error) {
+	obj, err := p.getObject(handle)
+	if err != nil {
+		return nil, err
+	}
+	if !obj.derive {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+	if len(obj.value) != length {
+		return nil, pkcs11.Error(pkcs11.CKR_DATA_LEN_RANGE)
+	}
+	return obj, nil
+}
@@ -0,0 +1,264 @@
+package pkcs11mgr
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+)
+
+// Initial values of the AES key wrap (RFC 3394) and of the key wrap with
+// padding (RFC 5649)
+var (
+	keyWrapIV    = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+	keyWrapPadIV = []byte{0xA6, 0x59, 0x59, 0xA6}
+)
+
+func (p *MemoryProvider) GenerateWrapKey(label string, id int32, bits int) (pkcs11.ObjectHandle, int32, error) {
+	if bits != 128 && bits != 192 && bits != 256 {
+		return 0, 0, pkcs11.Error(pkcs11.CKR_KEY_SIZE_RANGE)
+	}
+	if id == 0 {
+		id = p.nextID(label)
+	}
+	value := make([]byte, bits/8)
+	if _, err := rand.Read(value); err != nil {
+		return 0, 0, err
+	}
+	handle, err := p.addSecretKey(label, value, id, constants.TYPE_AES, keyUsage{wrap: true, unwrap: true, extractable: true})
+	return handle, id, err
+}
+
+func (p *MemoryProvider) GenerateRSAWrapKeyPair(label string, bits int) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	if _, err := p.FindPrivateKey(label); err == nil {
+		return 0, 0, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pub := p.addObject(&memoryObject{class: pkcs11.CKO_PUBLIC_KEY, keyType: pkcs11.CKK_RSA, label: label, rsaPub: &key.PublicKey, wrap: true})
+	priv := p.addObject(&memoryObject{class: pkcs11.CKO_PRIVATE_KEY, keyType: pkcs11.CKK_RSA, label: label, rsaKey: key, unwrap: true})
+	return pub, priv, nil
+}
+
+// extractableKey fetches a secret key that may leave the provider wrapped
+func (p *MemoryProvider) extractableKey(key pkcs11.ObjectHandle) (*memoryObject, error) {
+	obj, err := p.getObject(key)
+	if err != nil {
+		return nil, err
+	}
+	if obj.class != pkcs11.CKO_SECRET_KEY {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_NOT_WRAPPABLE)
+	}
+	if !obj.extractable {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_UNEXTRACTABLE)
+	}
+	return obj, nil
+}
+
+// wrappingKey fetches an AES wrap key for the key wrap mechanisms, unwrap
+// selects CKA_UNWRAP instead of CKA_WRAP
+func (p *MemoryProvider) wrappingKey(handle pkcs11.ObjectHandle, mechanism uint, unwrap bool) (cipher.Block, error) {
+	if mechanism != pkcs11.CKM_AES_KEY_WRAP && mechanism != pkcs11.CKM_AES_KEY_WRAP_PAD {
+		return nil, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+	}
+	obj, err := p.getObject(handle)
+	if err != nil {
+		return nil, err
+	}
+	if obj.class != pkcs11.CKO_SECRET_KEY || obj.keyType != pkcs11.CKK_AES {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+	if (unwrap && !obj.unwrap) || (!unwrap && !obj.wrap) {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+	return aes.NewCipher(obj.value)
+}
+
+func (p *MemoryProvider) WrapKey(wrappingKey, key pkcs11.ObjectHandle, mechanism uint) ([]byte, error) {
+	block, err := p.wrappingKey(wrappingKey, mechanism, false)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := p.extractableKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if mechanism == pkcs11.CKM_AES_KEY_WRAP_PAD {
+		return aesKeyWrapPad(block, obj.value), nil
+	}
+	if len(obj.value) < 16 || len(obj.value)%8 != 0 {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_SIZE_RANGE)
+	}
+	return aesKeyWrap(block, keyWrapIV, obj.value), nil
+}
+
+func (p *MemoryProvider) WrapKeyRSA(pub *rsa.PublicKey, key pkcs11.ObjectHandle) ([]byte, error) {
+	obj, err := p.extractableKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, obj.value, nil)
+}
+
+func (p *MemoryProvider) UnwrapKey(unwrappingKey pkcs11.ObjectHandle, mechanism uint, wrapped []byte, label string, id int32, keyType string, exportable bool) (pkcs11.ObjectHandle, error) {
+	var key []byte
+	if mechanism == pkcs11.CKM_RSA_PKCS_OAEP {
+		obj, err := p.getObject(unwrappingKey)
+		if err != nil {
+			return 0, err
+		}
+		if obj.rsaKey == nil || !obj.unwrap {
+			return 0, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+		}
+		if key, err = rsa.DecryptOAEP(sha256.New(), nil, obj.rsaKey, wrapped, nil); err != nil {
+			return 0, pkcs11.Error(pkcs11.CKR_WRAPPED_KEY_INVALID)
+		}
+	} else {
+		block, err := p.wrappingKey(unwrappingKey, mechanism, true)
+		if err != nil {
+			return 0, err
+		}
+		if mechanism == pkcs11.CKM_AES_KEY_WRAP_PAD {
+			key, err = aesKeyUnwrapPad(block, wrapped)
+		} else {
+			key, err = aesKeyUnwrap(block, keyWrapIV, wrapped)
+		}
+		if err != nil {
+			return 0, pkcs11.Error(pkcs11.CKR_WRAPPED_KEY_INVALID)
+		}
+	}
+	defer clear(key)
+	return p.addSecretKey(label, key, id, keyType, unwrappedKeyUsage(label, exportable))
+}
+
+// CreateSessionWrapKey adds a wrap key without label, the memory backend has
+// no sessions and keeps it until DestroySessionKey
+func (p *MemoryProvider) CreateSessionWrapKey(label string, key []byte) (pkcs11.ObjectHandle, error) {
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return 0, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addObject(&memoryObject{class: pkcs11.CKO_SECRET_KEY, keyType: pkcs11.CKK_AES, value: bytes.Clone(key), wrap: true, unwrap: true}), nil
+}
+
+func (p *MemoryProvider) DestroySessionKey(handle pkcs11.ObjectHandle) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	obj, ok := p.objects[handle]
+	if !ok || obj.label != "" {
+		return pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	clear(obj.value)
+	delete(p.objects, handle)
+	return nil
+}
+
+// aesKeyWrap is the AES key wrap of RFC 3394 section 2.2.1 with the initial
+// value iv, plaintext is a multiple of 8 bytes
+func aesKeyWrap(block cipher.Block, iv, plaintext []byte) []byte {
+	n := len(plaintext) / 8
+	out := make([]byte, 8+len(plaintext))
+	a := out[:8]
+	copy(a, iv)
+	copy(out[8:], plaintext)
+
+	b := make([]byte, aes.BlockSize)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			r := out[8*i : 8*i+8]
+			copy(b, a)
+			copy(b[8:], r)
+			block.Encrypt(b, b)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(b[:8])^uint64(n*j+i))
+			copy(r, b[8:])
+		}
+	}
+	return out
+}
+
+// aesKeyUnwrapRaw reverses aesKeyWrap and returns the plaintext and the integrity
+// check register, which the caller checks
+func aesKeyUnwrapRaw(block cipher.Block, wrapped []byte) ([]byte, []byte, error) {
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, nil, errors.New("invalid wrapped key length")
+	}
+	n := len(wrapped)/8 - 1
+	a := bytes.Clone(wrapped[:8])
+	out := bytes.Clone(wrapped[8:])
+
+	b := make([]byte, aes.BlockSize)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			r := out[8*(i-1) : 8*i]
+			binary.BigEndian.PutUint64(b, binary.BigEndian.Uint64(a)^uint64(n*j+i))
+			copy(b[8:], r)
+			block.Decrypt(b, b)
+			copy(a, b[:8])
+			copy(r, b[8:])
+		}
+	}
+	return out, a, nil
+}
+
+func aesKeyUnwrap(block cipher.Block, iv, wrapped []byte) ([]byte, error) {
+	out, a, err := aesKeyUnwrapRaw(block, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(a, iv) != 1 {
+		clear(out)
+		return nil, errors.New("key wrap integrity check failed")
+	}
+	return out, nil
+}
+
+// aesKeyWrapPad is the AES key wrap with padding of RFC 5649
+func aesKeyWrapPad(block cipher.Block, plaintext []byte) []byte {
+	iv := binary.BigEndian.AppendUint32(bytes.Clone(keyWrapPadIV), uint32(len(plaintext)))
+	padded := make([]byte, (len(plaintext)+7)/8*8)
+	copy(padded, plaintext)
+	defer clear(padded)
+	if len(padded) == 8 {
+		out := append(iv, padded...)
+		block.Encrypt(out, out)
+		return out
+	}
+	return aesKeyWrap(block, iv, padded)
+}
+
+func aesKeyUnwrapPad(block cipher.Block, wrapped []byte) ([]byte, error) {
+	var out, a []byte
+	if len(wrapped) == 16 {
+		b := make([]byte, aes.BlockSize)
+		block.Decrypt(b, wrapped)
+		a, out = b[:8], b[8:]
+	} else {
+		var err error
+		if out, a, err = aesKeyUnwrapRaw(block, wrapped); err != nil {
+			return nil, err
+		}
+	}
+	size := int(binary.BigEndian.Uint32(a[4:]))
+	valid := subtle.ConstantTimeCompare(a[:4], keyWrapPadIV) == 1 &&
+		size > len(out)-8 && size <= len(out) &&
+		subtle.ConstantTimeCompare(out[size:], make([]byte, len(out)-size)) == 1
+	if !valid {
+		clear(out)
+		return nil, errors.New("key wrap integrity check failed")
+	}
+	return out[:size], nil
+}
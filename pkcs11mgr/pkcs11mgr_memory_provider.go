@@ -0,0 +1,1092 @@
+package pkcs11mgr
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+// memoryObject is a key object held by the MemoryProvider, it mirrors the
+// PKCS#11 attributes the SSM relies on
+type memoryObject struct {
+	class       uint
+	keyType     uint
+	label       string
+	id          int32
+	value       []byte
+	encrypt     bool
+	decrypt     bool
+	sign        bool // MAC keys
+	wrap        bool // wraps other keys
+	unwrap      bool // unwraps other keys
+	derive      bool // key components, see CreateSessionComponentKey
+	extractable bool // may leave the provider wrapped
+	startDate   time.Time
+	endDate     time.Time
+	createdAt   time.Time // see SetKeyMetadata
+	retireAt    time.Time // see RetireKey
+	rsaKey      *rsa.PrivateKey
+	rsaPub      *rsa.PublicKey
+	ecKey       *ecdsa.PrivateKey
+	ecPub       *ecdsa.PublicKey
+	ecdhKey     *ecdh.PrivateKey // key agreement key pairs
+	ecdhPub     *ecdh.PublicKey
+}
+
+// MemoryProvider is a pure Go CryptoProvider that keeps every key in process memory.
+// It is meant for tests and CI, keys are lost when the process exits.
+type MemoryProvider struct {
+	mu         sync.RWMutex
+	objects    map[pkcs11.ObjectHandle]*memoryObject
+	nextHandle pkcs11.ObjectHandle
+	disabled   map[uint]bool // see DisableMechanisms
+}
+
+// NewMemoryProvider returns an empty in-memory provider
+func NewMemoryProvider() *MemoryProvider {
+	logger.AppLog.Warnln("Using the in-memory crypto provider, keys are not persisted and are not HSM protected")
+	return &MemoryProvider{
+		objects:    make(map[pkcs11.ObjectHandle]*memoryObject),
+		nextHandle: 1,
+	}
+}
+
+// GetKeyStore returns the provider itself, the memory backend has no sessions
+func (p *MemoryProvider) GetKeyStore(ctx context.Context) (KeyStore, error) {
+	return p, nil
+}
+
+// ReleaseKeyStore is a no-op for the memory backend
+func (p *MemoryProvider) ReleaseKeyStore(ks KeyStore) {}
+
+// Finalize drops and zeroes every key held by the provider
+func (p *MemoryProvider) Finalize() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for handle, obj := range p.objects {
+		for i := range obj.value {
+			obj.value[i] = 0
+		}
+		delete(p.objects, handle)
+	}
+}
+
+// addObject stores obj and returns its new handle, the caller must hold the write lock
+func (p *MemoryProvider) addObject(obj *memoryObject) pkcs11.ObjectHandle {
+	handle := p.nextHandle
+	p.nextHandle++
+	p.objects[handle] = obj
+	return handle
+}
+
+// findObjects returns the sorted handles matching class, label and id (id 0 matches any id)
+func (p *MemoryProvider) findObjects(class uint, label string, id int32) []pkcs11.ObjectHandle {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var handles []pkcs11.ObjectHandle
+	for handle, obj := range p.objects {
+		if obj.class != class {
+			continue
+		}
+		if label != "" && obj.label != label {
+			continue
+		}
+		if id != 0 && obj.id != id {
+			continue
+		}
+		handles = append(handles, handle)
+	}
+	sort.Slice(handles, func(i, j int) bool { return handles[i] < handles[j] })
+	return handles
+}
+
+func (p *MemoryProvider) getObject(handle pkcs11.ObjectHandle) (*memoryObject, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	obj, ok := p.objects[handle]
+	if !ok {
+		return nil, pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	return obj, nil
+}
+
+func (p *MemoryProvider) FindKey(label string, id int32) (pkcs11.ObjectHandle, error) {
+	handles := p.findObjects(pkcs11.CKO_SECRET_KEY, label, id)
+	if len(handles) == 0 {
+		return 0, errors.New(constants.ERROR_STRING_KEY_NOT_FOUND)
+	}
+	return handles[0], nil
+}
+
+func (p *MemoryProvider) FindKeysLabel(label string) ([]pkcs11.ObjectHandle, error) {
+	handles := p.findObjects(pkcs11.CKO_SECRET_KEY, label, 0)
+	if len(handles) == 0 {
+		return nil, errors.New(constants.ERROR_STRING_KEY_NOT_FOUND)
+	}
+	return handles, nil
+}
+
+func (p *MemoryProvider) FindKeyLabelReturnRandom(label string) (pkcs11.ObjectHandle, error) {
+	handles, err := p.FindKeysLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(handles))))
+	if err != nil {
+		return 0, err
+	}
+	return handles[n.Int64()], nil
+}
+
+func (p *MemoryProvider) FindAllKeys() (map[string][]pkcs11.ObjectHandle, error) {
+	handles := p.findObjects(pkcs11.CKO_SECRET_KEY, "", 0)
+	if len(handles) == 0 {
+		return map[string][]pkcs11.ObjectHandle{}, errors.New(constants.ERROR_STRING_KEY_NOT_FOUND)
+	}
+
+	keysByLabel := make(map[string][]pkcs11.ObjectHandle)
+	for _, handle := range handles {
+		obj, err := p.getObject(handle)
+		if err != nil {
+			continue
+		}
+		keysByLabel[obj.label] = append(keysByLabel[obj.label], handle)
+	}
+	return keysByLabel, nil
+}
+
+func (p *MemoryProvider) FindPrivateKey(label string) (pkcs11.ObjectHandle, error) {
+	handles := p.findObjects(pkcs11.CKO_PRIVATE_KEY, label, 0)
+	if len(handles) == 0 {
+		return 0, errors.New("error Private Key With The Label Not Found")
+	}
+	return handles[0], nil
+}
+
+func (p *MemoryProvider) FindPublicKey(label string) (pkcs11.ObjectHandle, error) {
+	handles := p.findObjects(pkcs11.CKO_PUBLIC_KEY, label, 0)
+	if len(handles) == 0 {
+		return 0, errors.New("error Public Key With The Label Not Found")
+	}
+	return handles[0], nil
+}
+
+func (p *MemoryProvider) GetObjectAttributes(handle pkcs11.ObjectHandle) (ObjectAttributes, error) {
+	obj, err := p.getObject(handle)
+	if err != nil {
+		return ObjectAttributes{}, err
+	}
+	return ObjectAttributes{Handle: int32(handle), Id: obj.id, Validity: KeyValidity{Start: obj.startDate, End: obj.endDate}}, nil
+}
+
+func (p *MemoryProvider) GetValuesForObjects(handles []pkcs11.ObjectHandle) ([]ObjectAttributes, error) {
+	var result []ObjectAttributes
+	for _, handle := range handles {
+		attr, err := p.GetObjectAttributes(handle)
+		if err != nil {
+			logger.AppLog.Errorf("Failed to get attributes for handle %d: %v", handle, err)
+			continue
+		}
+		result = append(result, attr)
+	}
+	return result, nil
+}
+
+func (p *MemoryProvider) DescribeKey(handle pkcs11.ObjectHandle) (KeyDescription, error) {
+	obj, err := p.getObject(handle)
+	if err != nil {
+		return KeyDescription{}, err
+	}
+	if obj.class != pkcs11.CKO_SECRET_KEY {
+		return KeyDescription{}, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+	return KeyDescription{
+		Label:       obj.label,
+		Id:          obj.id,
+		KeyType:     keyTypeName(obj.keyType),
+		CheckValue:  checkValue(obj),
+		Extractable: obj.extractable,
+		Encrypt:     obj.encrypt,
+	}, nil
+}
+
+func (p *MemoryProvider) GetKeyMetadata(handle pkcs11.ObjectHandle) (KeyMetadata, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	obj, ok := p.objects[handle]
+	if !ok {
+		return KeyMetadata{}, pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	if obj.class != pkcs11.CKO_SECRET_KEY {
+		return KeyMetadata{}, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+	return KeyMetadata{
+		Handle:      handle,
+		Label:       obj.label,
+		Id:          obj.id,
+		KeyType:     metadataKeyType(obj.keyType),
+		SizeBits:    len(obj.value) * 8,
+		Encrypt:     obj.encrypt,
+		Decrypt:     obj.decrypt,
+		Wrap:        obj.wrap,
+		Unwrap:      obj.unwrap,
+		Extractable: obj.extractable,
+		Sensitive:   true,
+		Validity:    KeyValidity{Start: obj.startDate, End: obj.endDate},
+		CreatedAt:   obj.createdAt,
+	}, nil
+}
+
+// SetKeyMetadata keeps the dates with day precision as CK_DATE does
+func (p *MemoryProvider) SetKeyMetadata(handle pkcs11.ObjectHandle, createdAt time.Time, validity KeyValidity) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	obj, ok := p.objects[handle]
+	if !ok {
+		return pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	if !validity.Start.IsZero() {
+		obj.startDate = validity.Start.UTC().Truncate(24 * time.Hour)
+	}
+	if !validity.End.IsZero() {
+		obj.endDate = validity.End.UTC().Truncate(24 * time.Hour)
+	}
+	obj.createdAt = createdAt.UTC().Truncate(time.Second)
+	return nil
+}
+
+func (p *MemoryProvider) SetKeyId(handle pkcs11.ObjectHandle, id int32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	obj, ok := p.objects[handle]
+	if !ok {
+		return pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	obj.id = id
+	return nil
+}
+
+// checkValue computes CKA_CHECK_VALUE as SoftHSM does: the first 3 bytes of
+// the ECB encryption of a zero block, or of the SHA-1 of a generic secret
+func checkValue(obj *memoryObject) []byte {
+	if obj.keyType == pkcs11.CKK_GENERIC_SECRET {
+		sum := sha1.Sum(obj.value)
+		return sum[:3]
+	}
+	block, err := blockCipher(obj)
+	if err != nil {
+		return nil
+	}
+	out := make([]byte, block.BlockSize())
+	block.Encrypt(out, out)
+	return out[:3]
+}
+
+// nextID mirrors ReturnLastIDForLabel for the memory backend
+func (p *MemoryProvider) nextID(label string) int32 {
+	var maxID int32
+	for _, handle := range p.findObjects(pkcs11.CKO_SECRET_KEY, label, 0) {
+		obj, err := p.getObject(handle)
+		if err == nil && obj.id > maxID {
+			maxID = obj.id
+		}
+	}
+	return maxID + 1
+}
+
+// generateSecretKey creates a random encryption key, it keeps the same "already exists" semantics as the PKCS#11 backend
+func (p *MemoryProvider) generateSecretKey(label string, id int32, keyType uint, size int) (pkcs11.ObjectHandle, int32, error) {
+	return p.generateKey(label, id, keyType, size, false)
+}
+
+// generateKey creates a random secret key that either encrypts or computes MACs
+func (p *MemoryProvider) generateKey(label string, id int32, keyType uint, size int, mac bool) (pkcs11.ObjectHandle, int32, error) {
+	if id == 0 {
+		id = p.nextID(label)
+	}
+	if existingHandle, err := p.FindKey(label, id); err == nil && existingHandle != 0 {
+		return existingHandle, id, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+	}
+
+	value := make([]byte, size)
+	if _, err := rand.Read(value); err != nil {
+		return 0, 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	handle := p.addObject(&memoryObject{
+		class:       pkcs11.CKO_SECRET_KEY,
+		keyType:     keyType,
+		label:       label,
+		id:          id,
+		value:       value,
+		encrypt:     !mac,
+		decrypt:     !mac,
+		sign:        mac,
+		extractable: exportedByDefault(label),
+	})
+	return handle, id, nil
+}
+
+func (p *MemoryProvider) GenerateAESKey(label string, id int32, bits int) (pkcs11.ObjectHandle, int32, error) {
+	if bits != 128 && bits != 192 && bits != 256 {
+		return 0, 0, pkcs11.Error(pkcs11.CKR_KEY_SIZE_RANGE)
+	}
+	return p.generateSecretKey(label, id, pkcs11.CKK_AES, bits/8)
+}
+
+func (p *MemoryProvider) GenerateDESKey(label string, id int32) (pkcs11.ObjectHandle, int32, error) {
+	return p.generateSecretKey(label, id, pkcs11.CKK_DES, 8)
+}
+
+func (p *MemoryProvider) GenerateDES3Key(label string, id int32) (pkcs11.ObjectHandle, int32, error) {
+	return p.generateSecretKey(label, id, pkcs11.CKK_DES3, 24)
+}
+
+func (p *MemoryProvider) GenerateMACKey(label string, id int32, keyType uint, bits int) (pkcs11.ObjectHandle, int32, error) {
+	if keyType != pkcs11.CKK_GENERIC_SECRET && keyType != pkcs11.CKK_AES {
+		return 0, 0, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+	return p.generateKey(label, id, keyType, bits/8, true)
+}
+
+func (p *MemoryProvider) GenerateRSAKeyPair(label string, bits int) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pub := p.addObject(&memoryObject{class: pkcs11.CKO_PUBLIC_KEY, keyType: pkcs11.CKK_RSA, label: label, rsaPub: &key.PublicKey})
+	priv := p.addObject(&memoryObject{class: pkcs11.CKO_PRIVATE_KEY, keyType: pkcs11.CKK_RSA, label: label, rsaKey: key})
+	return pub, priv, nil
+}
+
+func (p *MemoryProvider) GenerateECKeyPair(label string, curve string) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	c, ok := ecCurves[curve]
+	if !ok {
+		return 0, 0, pkcs11.Error(pkcs11.CKR_DOMAIN_PARAMS_INVALID)
+	}
+	key, err := ecdsa.GenerateKey(c.curve, rand.Reader)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pub := p.addObject(&memoryObject{class: pkcs11.CKO_PUBLIC_KEY, keyType: pkcs11.CKK_EC, label: label, ecPub: &key.PublicKey})
+	priv := p.addObject(&memoryObject{class: pkcs11.CKO_PRIVATE_KEY, keyType: pkcs11.CKK_EC, label: label, ecKey: key})
+	return pub, priv, nil
+}
+
+func (p *MemoryProvider) GenerateECDHKeyPair(label string, id int32, curve string) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	c, ok := ecdhCurves[curve]
+	if !ok {
+		return 0, 0, pkcs11.Error(pkcs11.CKR_DOMAIN_PARAMS_INVALID)
+	}
+	key, err := c.curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return 0, 0, err
+	}
+	return p.addECDHKeyPair(label, id, c, key)
+}
+
+func (p *MemoryProvider) ImportECDHKeyPair(label string, id int32, curve string, privateKey []byte) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	c, ok := ecdhCurves[curve]
+	if !ok {
+		return 0, 0, pkcs11.Error(pkcs11.CKR_DOMAIN_PARAMS_INVALID)
+	}
+	key, err := c.curve.NewPrivateKey(privateKey)
+	if err != nil {
+		return 0, 0, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	return p.addECDHKeyPair(label, id, c, key)
+}
+
+func (p *MemoryProvider) addECDHKeyPair(label string, id int32, c ecdhCurve, key *ecdh.PrivateKey) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	if !p.SupportsMechanism(label, c.mechanism) {
+		return 0, 0, unsupportedMechanism(c.mechanism)
+	}
+	if _, _, err := p.FindECDHKeyPair(label, id); err == nil {
+		return 0, 0, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pub := p.addObject(&memoryObject{class: pkcs11.CKO_PUBLIC_KEY, keyType: c.keyType, label: label, id: id, ecdhPub: key.PublicKey()})
+	priv := p.addObject(&memoryObject{class: pkcs11.CKO_PRIVATE_KEY, keyType: c.keyType, label: label, id: id, ecdhKey: key})
+	return pub, priv, nil
+}
+
+// FindECDHKeyPair matches the id exactly, id 0 is a valid key pair id
+func (p *MemoryProvider) FindECDHKeyPair(label string, id int32) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	var pub, priv pkcs11.ObjectHandle
+	for _, handle := range p.findObjects(pkcs11.CKO_PRIVATE_KEY, label, id) {
+		if obj, err := p.getObject(handle); err == nil && obj.ecdhKey != nil && obj.id == id {
+			priv = handle
+			break
+		}
+	}
+	for _, handle := range p.findObjects(pkcs11.CKO_PUBLIC_KEY, label, id) {
+		if obj, err := p.getObject(handle); err == nil && obj.ecdhPub != nil && obj.id == id {
+			pub = handle
+			break
+		}
+	}
+	if pub == 0 || priv == 0 {
+		return 0, 0, errors.New(constants.ERROR_STRING_KEY_NOT_FOUND)
+	}
+	return pub, priv, nil
+}
+
+// SupportsMechanism reports the mechanisms the memory backend implements as
+// supported, except the ones turned off with DisableMechanisms
+func (p *MemoryProvider) SupportsMechanism(label string, mechanism uint) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return !p.disabled[mechanism]
+}
+
+// DisableMechanisms makes the provider behave as a token without the
+// mechanisms, for the tests of the features that depend on them
+func (p *MemoryProvider) DisableMechanisms(mechanisms ...uint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.disabled == nil {
+		p.disabled = make(map[uint]bool)
+	}
+	for _, mechanism := range mechanisms {
+		p.disabled[mechanism] = true
+	}
+}
+
+func (p *MemoryProvider) GetPublicKey(handle pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	obj, err := p.getObject(handle)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case obj.rsaPub != nil:
+		return obj.rsaPub, nil
+	case obj.ecPub != nil:
+		return obj.ecPub, nil
+	case obj.ecdhPub != nil && obj.keyType == pkcs11.CKK_EC:
+		return p256PublicKey(obj.ecdhPub), nil
+	case obj.ecdhPub != nil:
+		return obj.ecdhPub, nil
+	default:
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+}
+
+func (p *MemoryProvider) StoreKey(label string, key []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	return p.storeKey(label, key, id, keyType, false)
+}
+
+func (p *MemoryProvider) StoreKeyVersion(label string, key []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	return p.storeKey(label, key, id, keyType, true)
+}
+
+func (p *MemoryProvider) storeKey(label string, key []byte, id int32, keyType string, encrypt bool) (pkcs11.ObjectHandle, error) {
+	return p.addSecretKey(label, key, id, keyType, storedKeyUsage(label, encrypt))
+}
+
+// addSecretKey checks the key length of the key type and adds the key with
+// the given usage
+func (p *MemoryProvider) addSecretKey(label string, key []byte, id int32, keyType string, usage keyUsage) (pkcs11.ObjectHandle, error) {
+	var keyTypeuint uint
+	switch keyType {
+	case constants.TYPE_AES:
+		keyTypeuint = pkcs11.CKK_AES
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return 0, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+		}
+	case constants.TYPE_DES3:
+		keyTypeuint = pkcs11.CKK_DES3
+		if len(key) != 16 && len(key) != 24 {
+			return 0, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+		}
+	case constants.TYPE_DES:
+		keyTypeuint = pkcs11.CKK_DES
+		if len(key) != 8 {
+			return 0, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+		}
+	case constants.TYPE_GENERIC_SECRET:
+		keyTypeuint = pkcs11.CKK_GENERIC_SECRET
+		if len(key) == 0 {
+			return 0, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+		}
+	default:
+		return 0, errors.New("unsupported key type")
+	}
+
+	if existingHandle, err := p.FindKey(label, id); err == nil && existingHandle != 0 {
+		return existingHandle, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	handle := p.addObject(&memoryObject{
+		class:       pkcs11.CKO_SECRET_KEY,
+		keyType:     keyTypeuint,
+		label:       label,
+		id:          id,
+		value:       bytes.Clone(key),
+		encrypt:     usage.encrypt,
+		decrypt:     usage.decrypt,
+		wrap:        usage.wrap,
+		unwrap:      usage.unwrap,
+		sign:        usage.sign,
+		extractable: usage.extractable,
+	})
+	return handle, nil
+}
+
+func (p *MemoryProvider) UpdateKey(label string, newKeyValue []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	if err := p.DeleteKey(label, id); err != nil {
+		return 0, err
+	}
+	return p.StoreKey(label, newKeyValue, id, keyType)
+}
+
+func (p *MemoryProvider) DeleteKey(label string, id int32) error {
+	handle, err := p.FindKey(label, id)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	obj := p.objects[handle]
+	for i := range obj.value {
+		obj.value[i] = 0
+	}
+	delete(p.objects, handle)
+	return nil
+}
+
+func (p *MemoryProvider) GetKeyVersions(label string) ([]KeyVersion, error) {
+	handles, err := p.FindKeysLabel(label)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	versions := make([]KeyVersion, 0, len(handles))
+	for _, handle := range handles {
+		obj := p.objects[handle]
+		versions = append(versions, KeyVersion{Handle: handle, Id: obj.id, Encrypt: obj.encrypt, RetireAt: obj.retireAt})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Id < versions[j].Id })
+	return versions, nil
+}
+
+func (p *MemoryProvider) RetireKey(handle pkcs11.ObjectHandle, retireAt time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	obj, ok := p.objects[handle]
+	if !ok {
+		return pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	obj.encrypt = false
+	// the retirement record only keeps the day
+	obj.retireAt = retireAt.UTC().Truncate(24 * time.Hour)
+	return nil
+}
+
+func (p *MemoryProvider) GenerateSigningKeyPair(label string, id int32, keyType uint) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	versions, err := p.GetKeyPairVersions(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, version := range versions {
+		if version.Id == id {
+			return 0, 0, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+		}
+	}
+
+	var pub, priv *memoryObject
+	switch keyType {
+	case pkcs11.CKK_RSA:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return 0, 0, err
+		}
+		pub = &memoryObject{rsaPub: &key.PublicKey}
+		priv = &memoryObject{rsaKey: key}
+	case pkcs11.CKK_EC:
+		key, err := ecdsa.GenerateKey(ecCurves[EC_CURVE_P256].curve, rand.Reader)
+		if err != nil {
+			return 0, 0, err
+		}
+		pub = &memoryObject{ecPub: &key.PublicKey}
+		priv = &memoryObject{ecKey: key}
+	default:
+		return 0, 0, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+	pub.class, pub.keyType, pub.label, pub.id = pkcs11.CKO_PUBLIC_KEY, keyType, label, id
+	priv.class, priv.keyType, priv.label, priv.id = pkcs11.CKO_PRIVATE_KEY, keyType, label, id
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addObject(pub), p.addObject(priv), nil
+}
+
+// GetKeyPairVersions pairs the signing keys of a label by id, the creation
+// time is kept on the private key
+func (p *MemoryProvider) GetKeyPairVersions(label string) ([]KeyPairVersion, error) {
+	publicKeys := make(map[int32]pkcs11.ObjectHandle)
+	for _, handle := range p.findObjects(pkcs11.CKO_PUBLIC_KEY, label, 0) {
+		if obj, err := p.getObject(handle); err == nil && (obj.rsaPub != nil || obj.ecPub != nil) {
+			publicKeys[obj.id] = handle
+		}
+	}
+
+	var versions []KeyPairVersion
+	for _, handle := range p.findObjects(pkcs11.CKO_PRIVATE_KEY, label, 0) {
+		obj, err := p.getObject(handle)
+		if err != nil || (obj.rsaKey == nil && obj.ecKey == nil) {
+			continue
+		}
+		if public, ok := publicKeys[obj.id]; ok {
+			versions = append(versions, KeyPairVersion{Id: obj.id, Public: public, Private: handle, CreatedAt: obj.createdAt})
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Id < versions[j].Id })
+	return versions, nil
+}
+
+func (p *MemoryProvider) DeleteKeyPair(label string, id int32) error {
+	versions, err := p.GetKeyPairVersions(label)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if version.Id == id {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			delete(p.objects, version.Public)
+			delete(p.objects, version.Private)
+			return nil
+		}
+	}
+	return errors.New(constants.ERROR_STRING_KEY_NOT_FOUND)
+}
+
+// blockCipher builds the cipher.Block for a secret key object
+func blockCipher(obj *memoryObject) (cipher.Block, error) {
+	return newBlockCipher(obj.keyType, obj.value)
+}
+
+// newBlockCipher returns the AES, DES or triple DES cipher of a key value
+func newBlockCipher(keyType uint, key []byte) (cipher.Block, error) {
+	switch keyType {
+	case pkcs11.CKK_AES:
+		return aes.NewCipher(key)
+	case pkcs11.CKK_DES:
+		return des.NewCipher(key)
+	case pkcs11.CKK_DES3:
+		if len(key) == 16 {
+			// two key triple DES: K1 K2 K1
+			key = append(bytes.Clone(key), key[:8]...)
+		}
+		return des.NewTripleDESCipher(key)
+	default:
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+}
+
+// mechanismMode maps a PKCS#11 block cipher mechanism to its key type, chaining mode and padding
+func mechanismMode(mechanism uint) (keyType uint, cbc bool, pad bool, err error) {
+	switch mechanism {
+	case pkcs11.CKM_AES_ECB:
+		return pkcs11.CKK_AES, false, false, nil
+	case pkcs11.CKM_AES_CBC:
+		return pkcs11.CKK_AES, true, false, nil
+	case pkcs11.CKM_AES_CBC_PAD:
+		return pkcs11.CKK_AES, true, true, nil
+	case pkcs11.CKM_DES_ECB:
+		return pkcs11.CKK_DES, false, false, nil
+	case pkcs11.CKM_DES_CBC:
+		return pkcs11.CKK_DES, true, false, nil
+	case pkcs11.CKM_DES_CBC_PAD:
+		return pkcs11.CKK_DES, true, true, nil
+	case pkcs11.CKM_DES3_ECB:
+		return pkcs11.CKK_DES3, false, false, nil
+	case pkcs11.CKM_DES3_CBC:
+		return pkcs11.CKK_DES3, true, false, nil
+	case pkcs11.CKM_DES3_CBC_PAD:
+		return pkcs11.CKK_DES3, true, true, nil
+	default:
+		return 0, false, false, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+	}
+}
+
+// secretKeyFor fetches a secret key object and checks it can be used with the mechanism
+func (p *MemoryProvider) secretKeyFor(keyHandle pkcs11.ObjectHandle, mechanism uint) (*memoryObject, bool, bool, error) {
+	obj, err := p.getObject(keyHandle)
+	if err != nil {
+		return nil, false, false, err
+	}
+	keyType, cbc, pad, err := mechanismMode(mechanism)
+	if err != nil {
+		return nil, false, false, err
+	}
+	if obj.class != pkcs11.CKO_SECRET_KEY || obj.keyType != keyType {
+		return nil, false, false, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+	return obj, cbc, pad, nil
+}
+
+func (p *MemoryProvider) EncryptKey(keyHandle pkcs11.ObjectHandle, iv, plaintext []byte, mechanism uint) ([]byte, error) {
+	obj, cbc, pad, err := p.secretKeyFor(keyHandle, mechanism)
+	if err != nil {
+		return nil, err
+	}
+	if !obj.encrypt {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+	block, err := blockCipher(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := block.BlockSize()
+	data := bytes.Clone(plaintext)
+	if pad {
+		padLen := bs - len(data)%bs
+		data = append(data, bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+	}
+	if len(data)%bs != 0 {
+		return nil, pkcs11.Error(pkcs11.CKR_DATA_LEN_RANGE)
+	}
+
+	out := make([]byte, len(data))
+	if cbc {
+		if len(iv) != bs {
+			return nil, pkcs11.Error(pkcs11.CKR_MECHANISM_PARAM_INVALID)
+		}
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, data)
+	} else {
+		for i := 0; i < len(data); i += bs {
+			block.Encrypt(out[i:i+bs], data[i:i+bs])
+		}
+	}
+	return out, nil
+}
+
+func (p *MemoryProvider) DecryptKey(keyHandle pkcs11.ObjectHandle, iv, ciphertext []byte, mechanism uint) ([]byte, error) {
+	obj, cbc, pad, err := p.secretKeyFor(keyHandle, mechanism)
+	if err != nil {
+		return nil, err
+	}
+	if !obj.decrypt {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+	block, err := blockCipher(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := block.BlockSize()
+	if len(ciphertext) == 0 || len(ciphertext)%bs != 0 {
+		return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_LEN_RANGE)
+	}
+
+	out := make([]byte, len(ciphertext))
+	if cbc {
+		if len(iv) != bs {
+			return nil, pkcs11.Error(pkcs11.CKR_MECHANISM_PARAM_INVALID)
+		}
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, ciphertext)
+	} else {
+		for i := 0; i < len(ciphertext); i += bs {
+			block.Decrypt(out[i:i+bs], ciphertext[i:i+bs])
+		}
+	}
+
+	if pad {
+		padLen := int(out[len(out)-1])
+		if padLen == 0 || padLen > bs {
+			return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_INVALID)
+		}
+		for _, b := range out[len(out)-padLen:] {
+			if int(b) != padLen {
+				return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_INVALID)
+			}
+		}
+		out = out[:len(out)-padLen]
+	}
+	return out, nil
+}
+
+// gcmFor builds the AES-GCM AEAD for a key handle, honouring non standard nonce sizes
+func (p *MemoryProvider) gcmFor(keyHandle pkcs11.ObjectHandle, iv []byte, encrypt bool) (cipher.AEAD, error) {
+	obj, err := p.getObject(keyHandle)
+	if err != nil {
+		return nil, err
+	}
+	if obj.class != pkcs11.CKO_SECRET_KEY || obj.keyType != pkcs11.CKK_AES {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+	if (encrypt && !obj.encrypt) || (!encrypt && !obj.decrypt) {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+	block, err := aes.NewCipher(obj.value)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) == 12 {
+		return cipher.NewGCM(block)
+	}
+	if len(iv) == 0 {
+		return nil, pkcs11.Error(pkcs11.CKR_MECHANISM_PARAM_INVALID)
+	}
+	return cipher.NewGCMWithNonceSize(block, len(iv))
+}
+
+func (p *MemoryProvider) EncryptKeyAesGCM(keyHandle pkcs11.ObjectHandle, iv, plaintext, aad []byte) ([]byte, error) {
+	aead, err := p.gcmFor(keyHandle, iv, true)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, iv, plaintext, aad), nil
+}
+
+func (p *MemoryProvider) DecryptKeyAesGCM(keyHandle pkcs11.ObjectHandle, iv, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < 16 {
+		return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_INVALID)
+	}
+	aead, err := p.gcmFor(keyHandle, iv, false)
+	if err != nil {
+		return nil, err
+	}
+	out, err := aead.Open(nil, iv, ciphertext, aad)
+	if err != nil {
+		return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_INVALID)
+	}
+	return out, nil
+}
+
+func (p *MemoryProvider) DeriveECDH(privateKey pkcs11.ObjectHandle, peerPublic []byte) ([]byte, error) {
+	if !p.SupportsMechanism("", pkcs11.CKM_ECDH1_DERIVE) {
+		return nil, unsupportedMechanism(pkcs11.CKM_ECDH1_DERIVE)
+	}
+	obj, err := p.getObject(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if obj.ecdhKey == nil {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+	peer, err := obj.ecdhKey.Curve().NewPublicKey(peerPublic)
+	if err != nil {
+		return nil, pkcs11.Error(pkcs11.CKR_MECHANISM_PARAM_INVALID)
+	}
+	return obj.ecdhKey.ECDH(peer)
+}
+
+// signDigest returns the hash and digest a signature mechanism signs over,
+// CKM_RSA_PKCS signs the raw input
+func signDigest(mechanism uint, data []byte) (crypto.Hash, []byte, error) {
+	var hash crypto.Hash
+	switch mechanism {
+	case pkcs11.CKM_RSA_PKCS:
+		return crypto.Hash(0), data, nil
+	case pkcs11.CKM_SHA256_RSA_PKCS, pkcs11.CKM_SHA256_RSA_PKCS_PSS, pkcs11.CKM_ECDSA_SHA256:
+		hash = crypto.SHA256
+	case pkcs11.CKM_SHA384_RSA_PKCS, pkcs11.CKM_SHA384_RSA_PKCS_PSS, pkcs11.CKM_ECDSA_SHA384:
+		hash = crypto.SHA384
+	case pkcs11.CKM_SHA512_RSA_PKCS, pkcs11.CKM_SHA512_RSA_PKCS_PSS:
+		hash = crypto.SHA512
+	default:
+		return 0, nil, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+	}
+	h := hash.New()
+	h.Write(data)
+	return hash, h.Sum(nil), nil
+}
+
+func isECDSAMechanism(mechanism uint) bool {
+	return mechanism == pkcs11.CKM_ECDSA_SHA256 || mechanism == pkcs11.CKM_ECDSA_SHA384
+}
+
+// computeMAC computes an HMAC or AES-CMAC with a MAC key object
+func computeMAC(obj *memoryObject, mechanism uint, data []byte) ([]byte, error) {
+	if !obj.sign {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+	switch {
+	case mechanism == pkcs11.CKM_SHA256_HMAC && obj.keyType == pkcs11.CKK_GENERIC_SECRET:
+		mac := hmac.New(sha256.New, obj.value)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case mechanism == pkcs11.CKM_SHA512_HMAC && obj.keyType == pkcs11.CKK_GENERIC_SECRET:
+		mac := hmac.New(sha512.New, obj.value)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case mechanism == pkcs11.CKM_AES_CMAC && obj.keyType == pkcs11.CKK_AES:
+		return aesCMAC(obj.value, data)
+	default:
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+}
+
+// aesCMAC computes an AES-CMAC (RFC 4493)
+func aesCMAC(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// subkeys K1 and K2 are L = AES(K, 0) doubled once and twice in GF(2^128)
+	k1 := make([]byte, aes.BlockSize)
+	block.Encrypt(k1, k1)
+	k1 = cmacDouble(k1)
+	k2 := cmacDouble(k1)
+
+	n := (len(data) + aes.BlockSize - 1) / aes.BlockSize
+	last := make([]byte, aes.BlockSize)
+	if n > 0 && len(data)%aes.BlockSize == 0 {
+		subtle.XORBytes(last, data[(n-1)*aes.BlockSize:], k1)
+	} else {
+		n = max(n, 1)
+		rest := data[(n-1)*aes.BlockSize:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+
+	mac := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(mac, mac, data[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(mac, mac)
+	}
+	subtle.XORBytes(mac, mac, last)
+	block.Encrypt(mac, mac)
+	return mac, nil
+}
+
+// cmacDouble multiplies a block by x in GF(2^128)
+func cmacDouble(in []byte) []byte {
+	out := make([]byte, len(in))
+	var carry byte
+	for i := len(in) - 1; i >= 0; i-- {
+		out[i] = in[i]<<1 | carry
+		carry = in[i] >> 7
+	}
+	if in[0]&0x80 != 0 {
+		out[len(out)-1] ^= 0x87
+	}
+	return out
+}
+
+func (p *MemoryProvider) Sign(keyHandle pkcs11.ObjectHandle, mechanism uint, data []byte) ([]byte, error) {
+	obj, err := p.getObject(keyHandle)
+	if err != nil {
+		return nil, err
+	}
+	if obj.class == pkcs11.CKO_SECRET_KEY {
+		return computeMAC(obj, mechanism, data)
+	}
+	if obj.wrap || obj.unwrap {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+	hash, digest, err := signDigest(mechanism, data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case obj.rsaKey != nil && !isECDSAMechanism(mechanism):
+		if _, ok := pssParams[mechanism]; ok {
+			return rsa.SignPSS(rand.Reader, obj.rsaKey, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.SignPKCS1v15(rand.Reader, obj.rsaKey, hash, digest)
+	case obj.ecKey != nil && isECDSAMechanism(mechanism):
+		r, sig, err := ecdsa.Sign(rand.Reader, obj.ecKey, digest)
+		if err != nil {
+			return nil, err
+		}
+		// PKCS#11 ECDSA signatures are r || s, each as long as the curve order
+		size := (obj.ecKey.Curve.Params().BitSize + 7) / 8
+		out := make([]byte, 2*size)
+		r.FillBytes(out[:size])
+		sig.FillBytes(out[size:])
+		return out, nil
+	default:
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+}
+
+func (p *MemoryProvider) Verify(keyHandle pkcs11.ObjectHandle, mechanism uint, data, signature []byte) error {
+	obj, err := p.getObject(keyHandle)
+	if err != nil {
+		return err
+	}
+	if obj.class == pkcs11.CKO_SECRET_KEY {
+		mac, err := computeMAC(obj, mechanism, data)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal(mac, signature) {
+			return pkcs11.Error(pkcs11.CKR_SIGNATURE_INVALID)
+		}
+		return nil
+	}
+	hash, digest, err := signDigest(mechanism, data)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case obj.rsaPub != nil && !isECDSAMechanism(mechanism):
+		if _, ok := pssParams[mechanism]; ok {
+			err = rsa.VerifyPSS(obj.rsaPub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			err = rsa.VerifyPKCS1v15(obj.rsaPub, hash, digest, signature)
+		}
+		if err != nil {
+			return pkcs11.Error(pkcs11.CKR_SIGNATURE_INVALID)
+		}
+		return nil
+	case obj.ecPub != nil && isECDSAMechanism(mechanism):
+		size := (obj.ecPub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return pkcs11.Error(pkcs11.CKR_SIGNATURE_LEN_RANGE)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		sig := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(obj.ecPub, digest, r, sig) {
+			return pkcs11.Error(pkcs11.CKR_SIGNATURE_INVALID)
+		}
+		return nil
+	default:
+		return pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+}
@@ -0,0 +1,205 @@
+package pkcs11mgr
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+)
+
+// TestAESCMAC checks the memory backend CMAC against the RFC 4493 test vectors
+func TestAESCMAC(t *testing.T) {
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	for _, tc := range []struct{ msg, mac string }{
+		{"", "bb1d6929e95937287fa37d129b756746"},
+		{"6bc1bee22e409f96e93d7e117393172a", "070a16b46b4d4144f79bdd9dd04a287c"},
+		{"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411", "dfa66747de9ae63030ca32611497c827"},
+		{"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710", "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		msg, _ := hex.DecodeString(tc.msg)
+		mac, err := aesCMAC(key, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(mac); got != tc.mac {
+			t.Errorf("CMAC of %d bytes = %s, want %s", len(msg), got, tc.mac)
+		}
+	}
+}
+
+// TestKeyCheckValues checks the KCV of clear keys against known values and
+// that the token side AES-CMAC KCV of a key matches the one of its value
+func TestKeyCheckValues(t *testing.T) {
+	for _, tc := range []struct{ keyType, key, kcv string }{
+		{constants.TYPE_AES, "000102030405060708090a0b0c0d0e0f", "c6a13b"},
+		{constants.TYPE_DES, "0123456789abcdef", "d5d44f"},
+	} {
+		key, _ := hex.DecodeString(tc.key)
+		values, err := ComputeKeyCheckValues(tc.keyType, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(values.KCV); got != tc.kcv {
+			t.Errorf("%s KCV = %s, want %s", tc.keyType, got, tc.kcv)
+		}
+	}
+
+	key, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100")
+	want, err := ComputeKeyCheckValues(constants.TYPE_AES, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewMemoryProvider()
+	defer p.Finalize()
+	handle, err := p.StoreKeyVersion(constants.LABEL_K4_KEY_AES, key, 1, constants.TYPE_AES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetKeyCheckValues(p, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.KCV, want.KCV) || !bytes.Equal(got.CMAC, want.CMAC) {
+		t.Errorf("token check values = %x %x, want %x %x", got.KCV, got.CMAC, want.KCV, want.CMAC)
+	}
+	if err := got.Check(want.CMAC); err != nil {
+		t.Errorf("check of the AES-CMAC KCV: %v", err)
+	}
+	if err := got.Check([]byte{0, 0, 0}); !errors.Is(err, ErrKCVMismatch) {
+		t.Errorf("check of a wrong KCV = %v, want %v", err, ErrKCVMismatch)
+	}
+}
+
+// TestAESKeyWrap checks the memory backend key wrap against the RFC 3394 and
+// RFC 5649 test vectors
+func TestAESKeyWrap(t *testing.T) {
+	for _, tc := range []struct {
+		kek, key, wrapped string
+		pad               bool
+	}{
+		{"000102030405060708090a0b0c0d0e0f", "00112233445566778899aabbccddeeff", "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5", false},
+		{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", "00112233445566778899aabbccddeeff000102030405060708090a0b0c0d0e0f", "28c9f404c4b810f4cbccb35cfb87f8263f5786e2d80ed326cbc7f0e71a99f43bfb988b9b7a02dd21", false},
+		{"5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8", "c37b7e6492584340bed12207808941155068f738", "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a", true},
+		{"5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8", "466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f", true},
+	} {
+		kek, _ := hex.DecodeString(tc.kek)
+		key, _ := hex.DecodeString(tc.key)
+		block, err := aes.NewCipher(kek)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wrapped, unwrapped []byte
+		if tc.pad {
+			wrapped = aesKeyWrapPad(block, key)
+			unwrapped, err = aesKeyUnwrapPad(block, wrapped)
+		} else {
+			wrapped = aesKeyWrap(block, keyWrapIV, key)
+			unwrapped, err = aesKeyUnwrap(block, keyWrapIV, wrapped)
+		}
+		if got := hex.EncodeToString(wrapped); got != tc.wrapped {
+			t.Errorf("wrap of %d bytes = %s, want %s", len(key), got, tc.wrapped)
+		}
+		if err != nil || !bytes.Equal(unwrapped, key) {
+			t.Errorf("unwrap of %d bytes = %x, %v", len(key), unwrapped, err)
+		}
+
+		wrapped[len(wrapped)-1] ^= 1
+		if tc.pad {
+			_, err = aesKeyUnwrapPad(block, wrapped)
+		} else {
+			_, err = aesKeyUnwrap(block, keyWrapIV, wrapped)
+		}
+		if err == nil {
+			t.Errorf("unwrap of a tampered %d bytes key succeeded", len(key))
+		}
+	}
+}
+
+// TestKeyValidity checks the day boundaries of the validity period and that the
+// memory backend keeps the dates with day precision
+func TestKeyValidity(t *testing.T) {
+	day := func(date string) time.Time {
+		d, err := time.ParseInLocation(time.DateOnly, date, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	validity := KeyValidity{Start: day("2026-03-01"), End: day("2026-03-31")}
+	for _, tc := range []struct {
+		now  time.Time
+		want error
+	}{
+		{day("2026-02-28").Add(23 * time.Hour), ErrKeyNotYetValid},
+		{day("2026-03-01"), nil},
+		{day("2026-03-31").Add(23*time.Hour + 59*time.Minute), nil},
+		{day("2026-04-01"), ErrKeyExpired},
+	} {
+		if err := validity.Check(tc.now); !errors.Is(err, tc.want) {
+			t.Errorf("Check(%s) = %v, want %v", tc.now, err, tc.want)
+		}
+	}
+	if err := (KeyValidity{}).Check(time.Now()); err != nil {
+		t.Errorf("open validity: %v", err)
+	}
+
+	p := NewMemoryProvider()
+	defer p.Finalize()
+	handle, _, err := p.GenerateAESKey("metadata", 1, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdAt := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+	if err := p.SetKeyMetadata(handle, createdAt, KeyValidity{End: validity.End.Add(5 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	attr, err := p.GetObjectAttributes(handle)
+	if err != nil || !attr.Validity.End.Equal(validity.End) || !attr.Validity.Start.IsZero() {
+		t.Fatalf("validity = %+v, %v", attr.Validity, err)
+	}
+	metadata, err := p.GetKeyMetadata(handle)
+	if err != nil || !metadata.CreatedAt.Equal(createdAt) || metadata.SizeBits != 128 || metadata.KeyType != constants.TYPE_AES {
+		t.Fatalf("metadata = %+v, %v", metadata, err)
+	}
+}
+
+// TestComponentCustodians checks that a custodian can not enter two components
+// of the same import
+func TestComponentCustodians(t *testing.T) {
+	p := NewMemoryProvider()
+	defer p.Finalize()
+	components := [][]byte{
+		bytes.Repeat([]byte{0x11}, 16),
+		bytes.Repeat([]byte{0x22}, 16),
+	}
+	kcvs := make([][]byte, len(components))
+	for i, component := range components {
+		values, err := ComputeKeyCheckValues(constants.TYPE_AES, component)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kcvs[i] = values.KCV
+	}
+
+	imp, err := StartComponentImport(p, constants.LABEL_K4_KEY_AES, 1, constants.TYPE_AES, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SubmitKeyComponent(p, imp.Id, 1, components[0], kcvs[0], "custodian-a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SubmitKeyComponent(p, imp.Id, 2, components[1], kcvs[1], "custodian-a"); !errors.Is(err, ErrCustodianSubmitted) {
+		t.Fatalf("second component of the same custodian: %v, want %v", err, ErrCustodianSubmitted)
+	}
+	if _, err := SubmitKeyComponent(p, imp.Id, 2, components[1], kcvs[1], "custodian-b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, finished, err := FinishComponentImport(p, imp.Id, nil); err != nil || finished.Custodians[0] != "custodian-a" || finished.Custodians[1] != "custodian-b" {
+		t.Fatalf("finish = %+v, %v", finished, err)
+	}
+}
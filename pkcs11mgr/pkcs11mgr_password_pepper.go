@@ -0,0 +1,71 @@
+package pkcs11mgr
+
+import (
+	"errors"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+// ErrPepperMismatch is returned when the pepper key of a password hash is not
+// the one the hash was created with, e.g. after a failover to a token the key
+// was never replicated to
+var ErrPepperMismatch = errors.New("the password pepper key does not match the password hash, restore it from the primary token or a backup")
+
+// InitPasswordPepperKey creates the HMAC key peppering the service passwords
+// when the token has none. The key only leaves the token wrapped for the
+// replica and the backups, a password hash can only be verified with it.
+func InitPasswordPepperKey(ks KeyStore) error {
+	versions, err := ks.GetKeyVersions(constants.LABEL_PASSWORD_PEPPER)
+	if err != nil && err.Error() != constants.ERROR_STRING_KEY_NOT_FOUND {
+		return err
+	}
+	if len(versions) > 0 {
+		return nil
+	}
+
+	logger.AppLog.Warn("Password pepper key not found, will generate a new key")
+	_, _, err = ks.GenerateMACKey(constants.LABEL_PASSWORD_PEPPER, 1, pkcs11.CKK_GENERIC_SECRET, 256)
+	return err
+}
+
+// PepperPassword returns the HMAC-SHA256 of a password under a version of the
+// pepper key and the id of that version, id 0 selects the newest version
+func PepperPassword(ks KeyStore, id int32, password []byte) ([]byte, int32, error) {
+	var handle pkcs11.ObjectHandle
+	if id != 0 {
+		h, err := ks.FindKey(constants.LABEL_PASSWORD_PEPPER, id)
+		if err != nil {
+			return nil, 0, err
+		}
+		handle = h
+	} else {
+		versions, err := ks.GetKeyVersions(constants.LABEL_PASSWORD_PEPPER)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(versions) == 0 {
+			return nil, 0, errors.New(constants.ERROR_STRING_KEY_NOT_FOUND)
+		}
+		newest := versions[len(versions)-1]
+		handle, id = newest.Handle, newest.Id
+	}
+
+	mac, err := ks.Sign(handle, pkcs11.CKM_SHA256_HMAC, password)
+	if err != nil {
+		return nil, 0, err
+	}
+	return mac, id, nil
+}
+
+// PepperCheckValue returns the check value of a version of the pepper key,
+// the first bytes of the HMAC of a zero block. It is stored with the password
+// hashes so that a different key under the same id fails loudly.
+func PepperCheckValue(ks KeyStore, id int32) ([]byte, error) {
+	mac, _, err := PepperPassword(ks, id, make([]byte, 16))
+	if err != nil {
+		return nil, err
+	}
+	return mac[:KCV_LENGTH], nil
+}
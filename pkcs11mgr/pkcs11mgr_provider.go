@@ -0,0 +1,420 @@
+package pkcs11mgr
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"math/rand/v2"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// KeyStore is the set of key operations the SSM needs from a crypto backend.
+// A KeyStore is bound to a single backend session and must be returned to its
+// CryptoProvider with ReleaseKeyStore once the caller is done with it.
+type KeyStore interface {
+	// Key lookup
+	FindKey(label string, id int32) (pkcs11.ObjectHandle, error)
+	FindKeysLabel(label string) ([]pkcs11.ObjectHandle, error)
+	FindKeyLabelReturnRandom(label string) (pkcs11.ObjectHandle, error)
+	FindAllKeys() (map[string][]pkcs11.ObjectHandle, error)
+	FindPrivateKey(label string) (pkcs11.ObjectHandle, error)
+	FindPublicKey(label string) (pkcs11.ObjectHandle, error)
+	GetObjectAttributes(handle pkcs11.ObjectHandle) (ObjectAttributes, error)
+	GetValuesForObjects(handles []pkcs11.ObjectHandle) ([]ObjectAttributes, error)
+	DescribeKey(handle pkcs11.ObjectHandle) (KeyDescription, error)
+	GetKeyMetadata(handle pkcs11.ObjectHandle) (KeyMetadata, error)
+	GetPublicKey(handle pkcs11.ObjectHandle) (crypto.PublicKey, error)
+	FindECDHKeyPair(label string, id int32) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error)
+	// Reports whether the token of label supports a mechanism, see optionalMechanisms
+	SupportsMechanism(label string, mechanism uint) bool
+
+	// Key generation and import
+	GenerateAESKey(label string, id int32, bits int) (pkcs11.ObjectHandle, int32, error)
+	GenerateDESKey(label string, id int32) (pkcs11.ObjectHandle, int32, error)
+	GenerateDES3Key(label string, id int32) (pkcs11.ObjectHandle, int32, error)
+	GenerateMACKey(label string, id int32, keyType uint, bits int) (pkcs11.ObjectHandle, int32, error)
+	GenerateRSAKeyPair(label string, bits int) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error)
+	GenerateECKeyPair(label string, curve string) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error)
+	GenerateECDHKeyPair(label string, id int32, curve string) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error)
+	GenerateWrapKey(label string, id int32, bits int) (pkcs11.ObjectHandle, int32, error)
+	GenerateRSAWrapKeyPair(label string, bits int) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error)
+	ImportECDHKeyPair(label string, id int32, curve string, privateKey []byte) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error)
+	StoreKey(label string, key []byte, id int32, keyType string) (pkcs11.ObjectHandle, error)
+	UpdateKey(label string, newKeyValue []byte, id int32, keyType string) (pkcs11.ObjectHandle, error)
+	DeleteKey(label string, id int32) error
+	// Sets the validity dates of a new key and records its creation time
+	SetKeyMetadata(handle pkcs11.ObjectHandle, createdAt time.Time, validity KeyValidity) error
+	// Changes the CKA_ID of a key that has no records yet, see syncKey
+	SetKeyId(handle pkcs11.ObjectHandle, id int32) error
+
+	// Key versions, see RotateKey
+	GetKeyVersions(label string) ([]KeyVersion, error)
+	StoreKeyVersion(label string, key []byte, id int32, keyType string) (pkcs11.ObjectHandle, error)
+	RetireKey(handle pkcs11.ObjectHandle, retireAt time.Time) error
+
+	// Signing key pair versions, see RotateJWTKey
+	GenerateSigningKeyPair(label string, id int32, keyType uint) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error)
+	GetKeyPairVersions(label string) ([]KeyPairVersion, error)
+	DeleteKeyPair(label string, id int32) error
+
+	// Key transport, only extractable keys can be wrapped
+	WrapKey(wrappingKey, key pkcs11.ObjectHandle, mechanism uint) ([]byte, error)
+	WrapKeyRSA(pub *rsa.PublicKey, key pkcs11.ObjectHandle) ([]byte, error)
+	UnwrapKey(unwrappingKey pkcs11.ObjectHandle, mechanism uint, wrapped []byte, label string, id int32, keyType string, exportable bool) (pkcs11.ObjectHandle, error)
+	// The session wrap key is created on the token of label, it has no label itself
+	CreateSessionWrapKey(label string, key []byte) (pkcs11.ObjectHandle, error)
+	DestroySessionKey(handle pkcs11.ObjectHandle) error
+	// Key components are XORed into session keys on the token of label, see
+	// StartComponentImport
+	CreateSessionComponentKey(label string, component []byte) (pkcs11.ObjectHandle, error)
+	XORKeyComponent(base pkcs11.ObjectHandle, component []byte) (pkcs11.ObjectHandle, error)
+	DeriveComponentKey(base pkcs11.ObjectHandle, label string, id int32, keyType string, keyLen int, store bool) (pkcs11.ObjectHandle, error)
+
+	// Encryption and decryption
+	EncryptKey(keyHandle pkcs11.ObjectHandle, iv, plaintext []byte, mechanism uint) ([]byte, error)
+	DecryptKey(keyHandle pkcs11.ObjectHandle, iv, ciphertext []byte, mechanism uint) ([]byte, error)
+	EncryptKeyAesGCM(keyHandle pkcs11.ObjectHandle, iv, plaintext, aad []byte) ([]byte, error)
+	DecryptKeyAesGCM(keyHandle pkcs11.ObjectHandle, iv, ciphertext, aad []byte) ([]byte, error)
+
+	// Key derivation, the caller must zero the returned secrets
+	DeriveECDH(privateKey pkcs11.ObjectHandle, peerPublic []byte) ([]byte, error)
+
+	// Signatures
+	Sign(keyHandle pkcs11.ObjectHandle, mechanism uint, data []byte) ([]byte, error)
+	Verify(keyHandle pkcs11.ObjectHandle, mechanism uint, data, signature []byte) error
+}
+
+// CryptoProvider hands out KeyStores backed by a concrete crypto backend
+// (a PKCS#11 token or the in-memory software provider)
+type CryptoProvider interface {
+	// GetKeyStore waits for a free KeyStore until ctx is done
+	GetKeyStore(ctx context.Context) (KeyStore, error)
+	ReleaseKeyStore(ks KeyStore)
+	Finalize()
+}
+
+// Provider names accepted in the configuration file
+const (
+	PROVIDER_PKCS11 = "pkcs11"
+	PROVIDER_MEMORY = "memory"
+)
+
+// GetKeyStore takes a session from the pool and exposes it as a KeyStore
+func (m *Manager) GetKeyStore(ctx context.Context) (KeyStore, error) {
+	session, err := m.GetSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// ReleaseKeyStore returns the session behind the KeyStore to the pool
+func (m *Manager) ReleaseKeyStore(ks KeyStore) {
+	if session, ok := ks.(*Session); ok {
+		m.LogoutSession(session)
+	}
+}
+
+// The methods below adapt the package level PKCS#11 functions to the KeyStore
+// interface. Lookups go through the key cache of the Manager, the operations
+// that create, change or destroy keys invalidate the label they touched.
+
+func (s *Session) FindKey(label string, id int32) (pkcs11.ObjectHandle, error) {
+	if s.keys == nil {
+		return FindKey(label, id, *s)
+	}
+	if handle, ok := s.keys.findKey(s.generation, label, id); ok {
+		return handle, nil
+	}
+	handle, err := FindKey(label, id, *s)
+	if err == nil {
+		s.keys.storeKey(s.generation, label, id, handle)
+	}
+	return handle, err
+}
+
+func (s *Session) FindKeysLabel(label string) ([]pkcs11.ObjectHandle, error) {
+	if s.keys == nil {
+		return FindKeysLabel(label, *s)
+	}
+	if handles, ok := s.keys.findLabel(s.generation, label); ok {
+		return handles, nil
+	}
+	handles, err := FindKeysLabel(label, *s)
+	if err == nil {
+		s.keys.storeLabel(s.generation, label, handles)
+	}
+	return handles, err
+}
+
+func (s *Session) FindKeyLabelReturnRandom(label string) (pkcs11.ObjectHandle, error) {
+	handles, err := s.FindKeysLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	return handles[rand.IntN(len(handles))], nil
+}
+
+func (s *Session) FindAllKeys() (map[string][]pkcs11.ObjectHandle, error) {
+	return FindAllKeys(*s)
+}
+
+func (s *Session) FindPrivateKey(label string) (pkcs11.ObjectHandle, error) {
+	return findPrivateKeyByLabel(label, *s)
+}
+
+func (s *Session) FindPublicKey(label string) (pkcs11.ObjectHandle, error) {
+	return findPublicKeyByLabel(label, *s)
+}
+
+func (s *Session) GetObjectAttributes(handle pkcs11.ObjectHandle) (ObjectAttributes, error) {
+	if s.keys == nil {
+		return GetObjectAttributes(handle, *s)
+	}
+	if attributes, ok := s.keys.findAttributes(s.generation, handle); ok {
+		return attributes, nil
+	}
+	attributes, err := GetObjectAttributes(handle, *s)
+	if err == nil {
+		s.keys.storeAttributes(s.generation, handle, attributes)
+	}
+	return attributes, err
+}
+
+func (s *Session) GetValuesForObjects(handles []pkcs11.ObjectHandle) ([]ObjectAttributes, error) {
+	return GetValuesForObjects(handles, *s)
+}
+
+func (s *Session) DescribeKey(handle pkcs11.ObjectHandle) (KeyDescription, error) {
+	return DescribeKey(handle, *s)
+}
+
+func (s *Session) GetKeyMetadata(handle pkcs11.ObjectHandle) (KeyMetadata, error) {
+	return GetKeyMetadata(handle, *s)
+}
+
+func (s *Session) GetPublicKey(handle pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	return GetPublicKey(handle, *s)
+}
+
+func (s *Session) GenerateAESKey(label string, id int32, bits int) (pkcs11.ObjectHandle, int32, error) {
+	defer s.invalidateLabel(label)
+	return GenerateAESKey(label, id, bits, *s)
+}
+
+func (s *Session) GenerateDESKey(label string, id int32) (pkcs11.ObjectHandle, int32, error) {
+	defer s.invalidateLabel(label)
+	return GenerateDESKey(label, id, *s)
+}
+
+func (s *Session) GenerateDES3Key(label string, id int32) (pkcs11.ObjectHandle, int32, error) {
+	defer s.invalidateLabel(label)
+	return GenerateDES3Key(label, id, *s)
+}
+
+func (s *Session) GenerateMACKey(label string, id int32, keyType uint, bits int) (pkcs11.ObjectHandle, int32, error) {
+	defer s.invalidateLabel(label)
+	return GenerateMACKey(label, id, keyType, bits, *s)
+}
+
+func (s *Session) GenerateRSAKeyPair(label string, bits int) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	return GenerateRSAKeyPair(label, bits, *s)
+}
+
+func (s *Session) GenerateECKeyPair(label string, curve string) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	return GenerateECKeyPair(label, curve, *s)
+}
+
+func (s *Session) GenerateECDHKeyPair(label string, id int32, curve string) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	return GenerateECDHKeyPair(label, id, curve, *s)
+}
+
+func (s *Session) ImportECDHKeyPair(label string, id int32, curve string, privateKey []byte) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	return ImportECDHKeyPair(label, id, curve, privateKey, *s)
+}
+
+func (s *Session) FindECDHKeyPair(label string, id int32) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	return FindECDHKeyPair(label, id, *s)
+}
+
+func (s *Session) SupportsMechanism(label string, mechanism uint) bool {
+	return s.mechanisms.supports(mechanism)
+}
+
+func (s *Session) GenerateWrapKey(label string, id int32, bits int) (pkcs11.ObjectHandle, int32, error) {
+	defer s.invalidateLabel(label)
+	return GenerateWrapKey(label, id, bits, *s)
+}
+
+func (s *Session) GenerateRSAWrapKeyPair(label string, bits int) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	return GenerateRSAWrapKeyPair(label, bits, *s)
+}
+
+func (s *Session) StoreKey(label string, key []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	defer s.invalidateLabel(label)
+	return StoreKey(label, key, id, keyType, *s)
+}
+
+func (s *Session) UpdateKey(label string, newKeyValue []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	defer s.invalidateLabel(label)
+	return UpdateKey(label, newKeyValue, id, keyType, *s)
+}
+
+func (s *Session) DeleteKey(label string, id int32) error {
+	defer s.invalidateLabel(label)
+	return DeleteKey(label, id, *s)
+}
+
+func (s *Session) SetKeyMetadata(handle pkcs11.ObjectHandle, createdAt time.Time, validity KeyValidity) error {
+	if s.keys != nil {
+		defer s.keys.InvalidateHandle(handle)
+	}
+	return SetKeyMetadata(handle, createdAt, validity, *s)
+}
+
+func (s *Session) SetKeyId(handle pkcs11.ObjectHandle, id int32) error {
+	// the cached lookups of both ids of the label are stale
+	label, err := GetObjectLabel(handle, *s)
+	if err != nil {
+		return err
+	}
+	defer s.invalidateLabel(label)
+	return SetKeyId(handle, id, *s)
+}
+
+func (s *Session) GetKeyVersions(label string) ([]KeyVersion, error) {
+	if s.keys == nil {
+		return GetKeyVersions(label, *s)
+	}
+	if versions, ok := s.keys.findVersions(s.generation, label); ok {
+		return versions, nil
+	}
+	versions, err := GetKeyVersions(label, *s)
+	if err == nil {
+		s.keys.storeVersions(s.generation, label, versions)
+	}
+	return versions, err
+}
+
+func (s *Session) StoreKeyVersion(label string, key []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	defer s.invalidateLabel(label)
+	return StoreKeyVersion(label, key, id, keyType, *s)
+}
+
+func (s *Session) RetireKey(handle pkcs11.ObjectHandle, retireAt time.Time) error {
+	if s.keys != nil {
+		defer s.keys.InvalidateHandle(handle)
+	}
+	return RetireKey(handle, retireAt, *s)
+}
+
+func (s *Session) GenerateSigningKeyPair(label string, id int32, keyType uint) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	return GenerateSigningKeyPair(label, id, keyType, *s)
+}
+
+func (s *Session) GetKeyPairVersions(label string) ([]KeyPairVersion, error) {
+	return GetKeyPairVersions(label, *s)
+}
+
+func (s *Session) DeleteKeyPair(label string, id int32) error {
+	return DeleteKeyPair(label, id, *s)
+}
+
+func (s *Session) WrapKey(wrappingKey, key pkcs11.ObjectHandle, mechanism uint) ([]byte, error) {
+	return WrapKey(wrappingKey, key, mechanism, *s)
+}
+
+func (s *Session) WrapKeyRSA(pub *rsa.PublicKey, key pkcs11.ObjectHandle) ([]byte, error) {
+	return WrapKeyRSA(pub, key, *s)
+}
+
+func (s *Session) UnwrapKey(unwrappingKey pkcs11.ObjectHandle, mechanism uint, wrapped []byte, label string, id int32, keyType string, exportable bool) (pkcs11.ObjectHandle, error) {
+	defer s.invalidateLabel(label)
+	return UnwrapKey(unwrappingKey, mechanism, wrapped, label, id, keyType, exportable, *s)
+}
+
+func (s *Session) CreateSessionWrapKey(label string, key []byte) (pkcs11.ObjectHandle, error) {
+	return CreateSessionWrapKey(key, *s)
+}
+
+func (s *Session) DestroySessionKey(handle pkcs11.ObjectHandle) error {
+	return DestroySessionKey(handle, *s)
+}
+
+func (s *Session) CreateSessionComponentKey(label string, component []byte) (pkcs11.ObjectHandle, error) {
+	return CreateSessionComponentKey(component, *s)
+}
+
+func (s *Session) XORKeyComponent(base pkcs11.ObjectHandle, component []byte) (pkcs11.ObjectHandle, error) {
+	return XORKeyComponent(base, component, *s)
+}
+
+func (s *Session) DeriveComponentKey(base pkcs11.ObjectHandle, label string, id int32, keyType string, keyLen int, store bool) (pkcs11.ObjectHandle, error) {
+	if store {
+		defer s.invalidateLabel(label)
+	}
+	return DeriveComponentKey(base, label, id, keyType, keyLen, store, *s)
+}
+
+func (s *Session) EncryptKey(keyHandle pkcs11.ObjectHandle, iv, plaintext []byte, mechanism uint) ([]byte, error) {
+	return s.withKey(keyHandle, func(h pkcs11.ObjectHandle) ([]byte, error) {
+		return EncryptKey(h, iv, plaintext, mechanism, *s)
+	})
+}
+
+func (s *Session) DecryptKey(keyHandle pkcs11.ObjectHandle, iv, ciphertext []byte, mechanism uint) ([]byte, error) {
+	return s.withKey(keyHandle, func(h pkcs11.ObjectHandle) ([]byte, error) {
+		return DecryptKey(h, iv, ciphertext, mechanism, *s)
+	})
+}
+
+func (s *Session) EncryptKeyAesGCM(keyHandle pkcs11.ObjectHandle, iv, plaintext, aad []byte) ([]byte, error) {
+	return s.withKey(keyHandle, func(h pkcs11.ObjectHandle) ([]byte, error) {
+		return EncryptKeyAesGCM(h, iv, plaintext, aad, *s)
+	})
+}
+
+func (s *Session) DecryptKeyAesGCM(keyHandle pkcs11.ObjectHandle, iv, ciphertext, aad []byte) ([]byte, error) {
+	return s.withKey(keyHandle, func(h pkcs11.ObjectHandle) ([]byte, error) {
+		return DecryptKeyAesGCM(h, iv, ciphertext, aad, *s)
+	})
+}
+
+func (s *Session) DeriveECDH(privateKey pkcs11.ObjectHandle, peerPublic []byte) ([]byte, error) {
+	return DeriveECDH(privateKey, peerPublic, *s)
+}
+
+func (s *Session) Sign(keyHandle pkcs11.ObjectHandle, mechanism uint, data []byte) ([]byte, error) {
+	return s.withKey(keyHandle, func(h pkcs11.ObjectHandle) ([]byte, error) {
+		return SignData(h, mechanism, data, *s)
+	})
+}
+
+func (s *Session) Verify(keyHandle pkcs11.ObjectHandle, mechanism uint, data, signature []byte) error {
+	return VerifyData(keyHandle, mechanism, data, signature, *s)
+}
+
+// invalidateLabel drops the cached handles of a label after it was modified
+func (s *Session) invalidateLabel(label string) {
+	if s.keys != nil {
+		s.keys.InvalidateLabel(label)
+	}
+}
+
+// withKey runs op with a key handle, when the token rejects a handle that came
+// from the key cache the key is looked up again and op is retried once
+func (s *Session) withKey(handle pkcs11.ObjectHandle, op func(pkcs11.ObjectHandle) ([]byte, error)) ([]byte, error) {
+	out, err := op(handle)
+	if err == nil || s.keys == nil || !isStaleHandleError(err) {
+		return out, err
+	}
+	fresh, ok := s.keys.refreshHandle(handle, s.generation, func(label string, id int32) (pkcs11.ObjectHandle, error) {
+		return FindKey(label, id, *s)
+	})
+	if !ok {
+		return out, err
+	}
+	return op(fresh)
+}
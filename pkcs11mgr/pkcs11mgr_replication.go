@@ -0,0 +1,769 @@
+package pkcs11mgr
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+// Key replication copies the keys of the replicated label families to a
+// replica token, a second SoftHSM instance kept as a secure backup. The keys
+// never leave the tokens in clear: both tokens share the replication key, the
+// primary token wraps every key under it and the replica unwraps it.
+
+const (
+	replicationKeyId   = 1
+	replicationKeyBits = 256
+	replicationRSABits = 3072
+	// The padded key wrap also wraps the 8 byte DES keys
+	replicationMechanism = pkcs11.CKM_AES_KEY_WRAP_PAD
+)
+
+// Status of a key in a replication report
+const (
+	REPLICA_KEY_OK             = "ok"
+	REPLICA_KEY_MISSING        = "missing"        // only on the primary token
+	REPLICA_KEY_STALE          = "stale"          // only on the replica
+	REPLICA_KEY_MISMATCH       = "mismatch"       // the check values differ
+	REPLICA_KEY_STATE_MISMATCH = "state_mismatch" // same value, the retirement or the validity dates differ
+	REPLICA_KEY_UNVERIFIED     = "unverified"     // a token gives no check value
+	REPLICA_KEY_NOT_REPLICABLE = "not_replicable" // not extractable, created before the replication was enabled
+)
+
+// What syncKey did to the replica copy of a key
+const (
+	syncCopied    = "copied"
+	syncUpdated   = "updated" // only the retirement or the validity dates changed
+	syncDeleted   = "deleted"
+	syncUnchanged = "unchanged"
+)
+
+// keyExport is set when a replica or the backups are configured, the keys of
+// the replicated label families are then created extractable so that the
+// primary token can wrap them for the replica or for a backup bundle
+var keyExport atomic.Bool
+
+// SetKeyExport must be called before any key is created, keys created while
+// it is off are not extractable and are never replicated nor backed up
+func SetKeyExport(enabled bool) {
+	keyExport.Store(enabled)
+}
+
+// IsReplicatedLabel reports whether the keys of a label belong to a replicated family
+func IsReplicatedLabel(label string) bool {
+	return slices.Contains(constants.ReplicatedLabelFamilies[:], constants.LabelFamilyMap[label])
+}
+
+func exportedByDefault(label string) bool {
+	return keyExport.Load() && IsReplicatedLabel(label)
+}
+
+// ReplicaKeyStatus compares a key on the primary token and on the replica
+type ReplicaKeyStatus struct {
+	Label             string
+	Id                int32
+	PrimaryCheckValue []byte
+	ReplicaCheckValue []byte
+	Status            string
+	Error             string
+}
+
+// ReplicationReport is the consistency report of the replica
+type ReplicationReport struct {
+	Consistent bool
+	Keys       []ReplicaKeyStatus
+}
+
+// SyncResult counts what a full sync did, Failed lists the keys it could not copy
+type SyncResult struct {
+	Copied    int
+	Updated   int
+	Deleted   int
+	Unchanged int
+	Failed    []ReplicaKeyStatus
+}
+
+type replicaKey struct {
+	label string
+	id    int32
+}
+
+// replicationKeys are the handles of the replication key on both tokens
+type replicationKeys struct {
+	primary pkcs11.ObjectHandle
+	replica pkcs11.ObjectHandle
+}
+
+// Replicator copies the replicated keys of the primary provider to the replica.
+// The keys changed through a ReplicatingProvider are queued and copied in the
+// background, FullSync copies every key.
+type Replicator struct {
+	primary CryptoProvider
+	replica CryptoProvider
+	timeout time.Duration
+
+	pending  chan replicaKey
+	started  atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewReplicator returns a Replicator with room for queueSize pending keys
+func NewReplicator(primary, replica CryptoProvider, queueSize int) *Replicator {
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	return &Replicator{
+		primary: primary,
+		replica: replica,
+		timeout: 30 * time.Second,
+		pending: make(chan replicaKey, queueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start runs the incremental sync until Stop is called
+func (r *Replicator) Start() {
+	r.started.Store(true)
+	go func() {
+		defer close(r.done)
+		for {
+			select {
+			case <-r.stop:
+				return
+			case key := <-r.pending:
+				r.syncPending(key)
+			}
+		}
+	}()
+}
+
+// Stop ends the incremental sync and waits for the key being copied, keys
+// still queued are left to the next full sync
+func (r *Replicator) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	if r.started.Load() {
+		<-r.done
+	}
+}
+
+// Close stops the incremental sync and finalizes the replica token
+func (r *Replicator) Close() {
+	r.Stop()
+	r.replica.Finalize()
+}
+
+// Enqueue schedules the copy of a key to the replica. It never blocks: when the
+// queue is full the key is dropped and the replica waits for a full sync.
+func (r *Replicator) Enqueue(label string, id int32) {
+	if !IsReplicatedLabel(label) {
+		return
+	}
+	select {
+	case r.pending <- replicaKey{label: label, id: id}:
+	default:
+		logger.AppLog.Warnf("Replication queue is full, key %s/%d waits for a full sync", label, id)
+	}
+}
+
+func (r *Replicator) syncPending(key replicaKey) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	err := r.withStores(ctx, func(p, q KeyStore) error {
+		keys, err := r.pair(p, q)
+		if err != nil {
+			return err
+		}
+		_, err = syncKey(p, q, keys, key.label, key.id)
+		return err
+	})
+	if err != nil {
+		logger.AppLog.Errorf("Failed to replicate key %s/%d: %v", key.label, key.id, err)
+		return
+	}
+	logger.AppLog.Infof("Key %s/%d replicated", key.label, key.id)
+}
+
+// withStores runs f with a KeyStore of each token
+func (r *Replicator) withStores(ctx context.Context, f func(p, q KeyStore) error) error {
+	p, err := r.primary.GetKeyStore(ctx)
+	if err != nil {
+		return fmt.Errorf("primary token: %w", err)
+	}
+	defer r.primary.ReleaseKeyStore(p)
+
+	q, err := r.replica.GetKeyStore(ctx)
+	if err != nil {
+		return fmt.Errorf("replica token: %w", err)
+	}
+	defer r.replica.ReleaseKeyStore(q)
+
+	return f(p, q)
+}
+
+// Pair makes sure both tokens share the replication key
+func (r *Replicator) Pair(ctx context.Context) error {
+	return r.withStores(ctx, func(p, q KeyStore) error {
+		_, err := r.pair(p, q)
+		return err
+	})
+}
+
+// pair returns the replication key of both tokens. The first time it creates
+// the key on the primary token and sends it to the replica under the
+// replication RSA key pair of the replica, as a peer SSM sends a transport key.
+func (r *Replicator) pair(p, q KeyStore) (replicationKeys, error) {
+	var keys replicationKeys
+	primaryHandle, primaryErr := p.FindKey(constants.LABEL_REPLICATION_KEY, replicationKeyId)
+	replicaHandle, replicaErr := q.FindKey(constants.LABEL_REPLICATION_KEY, replicationKeyId)
+	switch {
+	case primaryErr == nil && replicaErr == nil:
+		primary, err := p.DescribeKey(primaryHandle)
+		if err != nil {
+			return keys, err
+		}
+		replica, err := q.DescribeKey(replicaHandle)
+		if err != nil {
+			return keys, err
+		}
+		if !bytes.Equal(primary.CheckValue, replica.CheckValue) {
+			return keys, errors.New("the replica holds the replication key of another primary token")
+		}
+		return replicationKeys{primary: primaryHandle, replica: replicaHandle}, nil
+	case replicaErr == nil:
+		return keys, errors.New("the replica holds the replication key of another primary token")
+	}
+
+	logger.AppLog.Infoln("Pairing the replica token")
+	if primaryErr != nil {
+		var err error
+		primaryHandle, _, err = p.GenerateWrapKey(constants.LABEL_REPLICATION_KEY, replicationKeyId, replicationKeyBits)
+		if err != nil {
+			return keys, fmt.Errorf("failed to generate the replication key: %w", err)
+		}
+	}
+
+	privateKey, err := q.FindPrivateKey(constants.LABEL_REPLICATION_KEY_PAIR)
+	if err != nil {
+		if _, privateKey, err = q.GenerateRSAWrapKeyPair(constants.LABEL_REPLICATION_KEY_PAIR, replicationRSABits); err != nil {
+			return keys, fmt.Errorf("failed to generate the replica RSA key pair: %w", err)
+		}
+	}
+	publicHandle, err := q.FindPublicKey(constants.LABEL_REPLICATION_KEY_PAIR)
+	if err != nil {
+		return keys, err
+	}
+	publicKey, err := q.GetPublicKey(publicHandle)
+	if err != nil {
+		return keys, err
+	}
+	rsaKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return keys, errors.New("the replica replication key pair is not an RSA key pair")
+	}
+
+	wrapped, err := p.WrapKeyRSA(rsaKey, primaryHandle)
+	if err != nil {
+		return keys, fmt.Errorf("failed to wrap the replication key: %w", err)
+	}
+	replicaHandle, err = q.UnwrapKey(privateKey, pkcs11.CKM_RSA_PKCS_OAEP, wrapped, constants.LABEL_REPLICATION_KEY, replicationKeyId, constants.TYPE_AES, false)
+	if err != nil {
+		return keys, fmt.Errorf("failed to unwrap the replication key: %w", err)
+	}
+	logger.AppLog.Infoln("Replica token paired")
+	return replicationKeys{primary: primaryHandle, replica: replicaHandle}, nil
+}
+
+// replicatedKey is what the replica copy of a key must match: the key value,
+// seen through its check value, the retirement of the version and the
+// validity period
+type replicatedKey struct {
+	KeyDescription
+	RetireAt  time.Time // zero unless the version is retired
+	Validity  KeyValidity
+	CreatedAt time.Time
+}
+
+func (k replicatedKey) retired() bool {
+	return !k.Encrypt && !k.RetireAt.IsZero()
+}
+
+// describeReplicatedKey reads the description, retirement and validity of a key
+func describeReplicatedKey(ks KeyStore, handle pkcs11.ObjectHandle) (replicatedKey, error) {
+	description, err := ks.DescribeKey(handle)
+	if err != nil {
+		return replicatedKey{}, err
+	}
+	metadata, err := ks.GetKeyMetadata(handle)
+	if err != nil {
+		return replicatedKey{}, err
+	}
+	key := replicatedKey{KeyDescription: description, Validity: metadata.Validity, CreatedAt: metadata.CreatedAt}
+	if !description.Encrypt {
+		versions, err := ks.GetKeyVersions(description.Label)
+		if err != nil {
+			return replicatedKey{}, err
+		}
+		for _, version := range versions {
+			if version.Id == description.Id {
+				key.RetireAt = version.RetireAt
+			}
+		}
+	}
+	return key, nil
+}
+
+// stateDifferences names what differs between two copies of the same key value
+func stateDifferences(primary, replica replicatedKey) []string {
+	var differences []string
+	if primary.retired() != replica.retired() || !primary.RetireAt.Equal(replica.RetireAt) {
+		differences = append(differences, "retirement")
+	}
+	if !primary.Validity.Start.Equal(replica.Validity.Start) || !primary.Validity.End.Equal(replica.Validity.End) {
+		differences = append(differences, "validity")
+	}
+	return differences
+}
+
+// updatableState reports whether the replica copy can be brought to the state
+// of the primary token in place: a retirement can not be undone and a
+// validity date can not be removed, the key is copied again instead
+func updatableState(primary, replica replicatedKey) bool {
+	return (!replica.retired() || primary.retired()) &&
+		(replica.Validity.Start.IsZero() || !primary.Validity.Start.IsZero()) &&
+		(replica.Validity.End.IsZero() || !primary.Validity.End.IsZero())
+}
+
+// applyKeyState gives the replica copy of a key the validity, creation time
+// and retirement of the primary token
+func applyKeyState(q KeyStore, handle pkcs11.ObjectHandle, primary replicatedKey) error {
+	createdAt := primary.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	if err := q.SetKeyMetadata(handle, createdAt, primary.Validity); err != nil {
+		return fmt.Errorf("failed to set the validity on the replica: %w", err)
+	}
+	if primary.retired() {
+		if err := q.RetireKey(handle, primary.RetireAt); err != nil {
+			return fmt.Errorf("failed to retire the key on the replica: %w", err)
+		}
+	}
+	return nil
+}
+
+// stagingId is the id a new replica copy is unwrapped under before it
+// replaces the old copy, the API only creates keys with positive ids
+func stagingId(id int32) int32 {
+	return -id
+}
+
+// syncKey makes the replica copy of a key match the primary token and returns
+// what it did: copied, updated, deleted or unchanged. A copy whose check value
+// differs is replaced, a copy without a check value is always replaced. A copy
+// whose retirement or validity dates differ is updated in place when it can be.
+// The new copy is unwrapped under the staging id first, the old copy is only
+// destroyed once the new one is on the replica.
+func syncKey(p, q KeyStore, keys replicationKeys, label string, id int32) (string, error) {
+	replicaHandle, replicaErr := q.FindKey(label, id)
+	handle, err := p.FindKey(label, id)
+	if err != nil {
+		if err.Error() != constants.ERROR_STRING_KEY_NOT_FOUND {
+			return "", err
+		}
+		if replicaErr != nil {
+			return syncUnchanged, nil
+		}
+		// staged copies left by an interrupted sync go this way too
+		if err := q.DeleteKey(label, id); err != nil {
+			return "", err
+		}
+		return syncDeleted, nil
+	}
+	if id <= 0 {
+		return "", errors.New("only the keys with a positive id are replicated")
+	}
+
+	primary, err := describeReplicatedKey(p, handle)
+	if err != nil {
+		return "", err
+	}
+	if replicaErr == nil {
+		replica, err := describeReplicatedKey(q, replicaHandle)
+		if err != nil {
+			return "", err
+		}
+		if len(primary.CheckValue) > 0 && bytes.Equal(primary.CheckValue, replica.CheckValue) {
+			if len(stateDifferences(primary, replica)) == 0 {
+				return syncUnchanged, nil
+			}
+			if updatableState(primary, replica) {
+				if err := applyKeyState(q, replicaHandle, primary); err != nil {
+					return "", err
+				}
+				return syncUpdated, nil
+			}
+		}
+	}
+	if !primary.Extractable {
+		return "", errors.New("the key is not extractable, it was created before the replication was enabled")
+	}
+
+	wrapped, err := p.WrapKey(keys.primary, handle, replicationMechanism)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap the key: %w", err)
+	}
+	staging := stagingId(id)
+	if _, err := q.FindKey(label, staging); err == nil {
+		if err := q.DeleteKey(label, staging); err != nil {
+			return "", fmt.Errorf("failed to delete an old staged copy on the replica: %w", err)
+		}
+	}
+	staged, err := q.UnwrapKey(keys.replica, replicationMechanism, wrapped, label, staging, primary.KeyType, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap the key on the replica: %w", err)
+	}
+	if replicaErr == nil {
+		if err := q.DeleteKey(label, id); err != nil {
+			if err := q.DeleteKey(label, staging); err != nil {
+				logger.AppLog.Warnf("Failed to delete the staged copy of key %s/%d: %v", label, id, err)
+			}
+			return "", fmt.Errorf("failed to delete the old copy on the replica: %w", err)
+		}
+	}
+	// a staged copy left here is deleted by the next full sync
+	if err := q.SetKeyId(staged, id); err != nil {
+		return "", fmt.Errorf("failed to move the staged copy to its id on the replica: %w", err)
+	}
+	if err := applyKeyState(q, staged, primary); err != nil {
+		return "", err
+	}
+	return syncCopied, nil
+}
+
+// FullSync pairs the tokens, copies every replicated key of the primary token
+// to the replica and deletes the replica keys the primary token no longer has
+func (r *Replicator) FullSync(ctx context.Context) (SyncResult, error) {
+	var result SyncResult
+	err := r.withStores(ctx, func(p, q KeyStore) error {
+		keys, err := r.pair(p, q)
+		if err != nil {
+			return err
+		}
+		primary, err := replicatedKeys(p)
+		if err != nil {
+			return fmt.Errorf("primary token: %w", err)
+		}
+		replica, err := replicatedKeys(q)
+		if err != nil {
+			return fmt.Errorf("replica token: %w", err)
+		}
+
+		for key := range replica {
+			if _, ok := primary[key]; !ok {
+				primary[key] = replicatedKey{}
+			}
+		}
+		for _, key := range sortedKeys(primary) {
+			action, err := syncKey(p, q, keys, key.label, key.id)
+			if err != nil {
+				logger.AppLog.Errorf("Failed to replicate key %s/%d: %v", key.label, key.id, err)
+				status := ReplicaKeyStatus{Label: key.label, Id: key.id, Status: REPLICA_KEY_MISSING, Error: err.Error()}
+				if description := primary[key]; description.Label != "" && !description.Extractable {
+					status.Status = REPLICA_KEY_NOT_REPLICABLE
+				}
+				result.Failed = append(result.Failed, status)
+				continue
+			}
+			switch action {
+			case syncCopied:
+				result.Copied++
+			case syncUpdated:
+				result.Updated++
+			case syncDeleted:
+				result.Deleted++
+			default:
+				result.Unchanged++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return SyncResult{}, err
+	}
+	logger.AppLog.Infof("Full sync done: %d copied, %d updated, %d deleted, %d unchanged, %d failed",
+		result.Copied, result.Updated, result.Deleted, result.Unchanged, len(result.Failed))
+	return result, nil
+}
+
+// Report compares the labels, ids, check values, retirement and validity dates
+// of the replicated keys of both tokens
+func (r *Replicator) Report(ctx context.Context) (ReplicationReport, error) {
+	var report ReplicationReport
+	err := r.withStores(ctx, func(p, q KeyStore) error {
+		primary, err := replicatedKeys(p)
+		if err != nil {
+			return fmt.Errorf("primary token: %w", err)
+		}
+		replica, err := replicatedKeys(q)
+		if err != nil {
+			return fmt.Errorf("replica token: %w", err)
+		}
+		report = compareKeys(primary, replica)
+		return nil
+	})
+	return report, err
+}
+
+func compareKeys(primary, replica map[replicaKey]replicatedKey) ReplicationReport {
+	all := make(map[replicaKey]replicatedKey, len(primary)+len(replica))
+	for key, description := range replica {
+		all[key] = description
+	}
+	for key, description := range primary {
+		all[key] = description
+	}
+
+	report := ReplicationReport{Consistent: true, Keys: make([]ReplicaKeyStatus, 0, len(all))}
+	for _, key := range sortedKeys(all) {
+		p, onPrimary := primary[key]
+		q, onReplica := replica[key]
+		status := ReplicaKeyStatus{Label: key.label, Id: key.id, PrimaryCheckValue: p.CheckValue, ReplicaCheckValue: q.CheckValue}
+		switch {
+		case onPrimary && !onReplica && !p.Extractable:
+			status.Status = REPLICA_KEY_NOT_REPLICABLE
+		case onPrimary && !onReplica:
+			status.Status = REPLICA_KEY_MISSING
+		case !onPrimary:
+			status.Status = REPLICA_KEY_STALE
+		case len(p.CheckValue) == 0 || len(q.CheckValue) == 0:
+			status.Status = REPLICA_KEY_UNVERIFIED
+		case !bytes.Equal(p.CheckValue, q.CheckValue):
+			status.Status = REPLICA_KEY_MISMATCH
+		case len(stateDifferences(p, q)) > 0:
+			status.Status = REPLICA_KEY_STATE_MISMATCH
+			status.Error = "different " + strings.Join(stateDifferences(p, q), " and ")
+		default:
+			status.Status = REPLICA_KEY_OK
+		}
+		if status.Status != REPLICA_KEY_OK {
+			report.Consistent = false
+		}
+		report.Keys = append(report.Keys, status)
+	}
+	return report
+}
+
+// replicatedKeys describes the keys of the replicated families held by a KeyStore
+func replicatedKeys(ks KeyStore) (map[replicaKey]replicatedKey, error) {
+	result := make(map[replicaKey]replicatedKey)
+	keysByLabel, err := ks.FindAllKeys()
+	if err != nil && err.Error() == constants.ERROR_STRING_KEY_NOT_FOUND {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for label, handles := range keysByLabel {
+		if !IsReplicatedLabel(label) {
+			continue
+		}
+		for _, handle := range handles {
+			key, err := describeReplicatedKey(ks, handle)
+			if err != nil {
+				return nil, err
+			}
+			result[replicaKey{label: label, id: key.Id}] = key
+		}
+	}
+	return result, nil
+}
+
+func sortedKeys(keys map[replicaKey]replicatedKey) []replicaKey {
+	sorted := make([]replicaKey, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].label != sorted[j].label {
+			return sorted[i].label < sorted[j].label
+		}
+		return sorted[i].id < sorted[j].id
+	})
+	return sorted
+}
+
+// ReplicatingProvider is a CryptoProvider whose KeyStores queue the replicated
+// keys they create, change or delete for the incremental sync of the Replicator
+type ReplicatingProvider struct {
+	CryptoProvider
+	replicator *Replicator
+}
+
+func NewReplicatingProvider(primary CryptoProvider, replicator *Replicator) *ReplicatingProvider {
+	return &ReplicatingProvider{CryptoProvider: primary, replicator: replicator}
+}
+
+// Replicator returns the Replicator fed by the provider
+func (p *ReplicatingProvider) Replicator() *Replicator {
+	return p.replicator
+}
+
+func (p *ReplicatingProvider) GetKeyStore(ctx context.Context) (KeyStore, error) {
+	ks, err := p.CryptoProvider.GetKeyStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &replicatingKeyStore{KeyStore: ks, replicator: p.replicator}, nil
+}
+
+func (p *ReplicatingProvider) ReleaseKeyStore(ks KeyStore) {
+	if replicating, ok := ks.(*replicatingKeyStore); ok {
+		ks = replicating.KeyStore
+	}
+	p.CryptoProvider.ReleaseKeyStore(ks)
+}
+
+// Finalize stops the incremental sync and finalizes both tokens
+func (p *ReplicatingProvider) Finalize() {
+	p.replicator.Close()
+	p.CryptoProvider.Finalize()
+}
+
+// Stats reports the session pool of the primary token
+func (p *ReplicatingProvider) Stats() PoolStats {
+	if reporter, ok := p.CryptoProvider.(SessionPoolReporter); ok {
+		return reporter.Stats()
+	}
+	return PoolStats{}
+}
+
+// replicatingKeyStore queues the keys changed through it once the change succeeded
+type replicatingKeyStore struct {
+	KeyStore
+	replicator *Replicator
+}
+
+func (ks *replicatingKeyStore) GenerateAESKey(label string, id int32, bits int) (pkcs11.ObjectHandle, int32, error) {
+	handle, newID, err := ks.KeyStore.GenerateAESKey(label, id, bits)
+	if err == nil {
+		ks.replicator.Enqueue(label, newID)
+	}
+	return handle, newID, err
+}
+
+func (ks *replicatingKeyStore) GenerateDESKey(label string, id int32) (pkcs11.ObjectHandle, int32, error) {
+	handle, newID, err := ks.KeyStore.GenerateDESKey(label, id)
+	if err == nil {
+		ks.replicator.Enqueue(label, newID)
+	}
+	return handle, newID, err
+}
+
+func (ks *replicatingKeyStore) GenerateDES3Key(label string, id int32) (pkcs11.ObjectHandle, int32, error) {
+	handle, newID, err := ks.KeyStore.GenerateDES3Key(label, id)
+	if err == nil {
+		ks.replicator.Enqueue(label, newID)
+	}
+	return handle, newID, err
+}
+
+func (ks *replicatingKeyStore) GenerateMACKey(label string, id int32, keyType uint, bits int) (pkcs11.ObjectHandle, int32, error) {
+	handle, newID, err := ks.KeyStore.GenerateMACKey(label, id, keyType, bits)
+	if err == nil {
+		ks.replicator.Enqueue(label, newID)
+	}
+	return handle, newID, err
+}
+
+func (ks *replicatingKeyStore) StoreKey(label string, key []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	handle, err := ks.KeyStore.StoreKey(label, key, id, keyType)
+	if err == nil {
+		ks.replicator.Enqueue(label, id)
+	}
+	return handle, err
+}
+
+func (ks *replicatingKeyStore) StoreKeyVersion(label string, key []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	handle, err := ks.KeyStore.StoreKeyVersion(label, key, id, keyType)
+	if err == nil {
+		ks.replicator.Enqueue(label, id)
+	}
+	return handle, err
+}
+
+func (ks *replicatingKeyStore) UpdateKey(label string, newKeyValue []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	handle, err := ks.KeyStore.UpdateKey(label, newKeyValue, id, keyType)
+	if err == nil {
+		ks.replicator.Enqueue(label, id)
+	}
+	return handle, err
+}
+
+func (ks *replicatingKeyStore) UnwrapKey(unwrappingKey pkcs11.ObjectHandle, mechanism uint, wrapped []byte, label string, id int32, keyType string, exportable bool) (pkcs11.ObjectHandle, error) {
+	handle, err := ks.KeyStore.UnwrapKey(unwrappingKey, mechanism, wrapped, label, id, keyType, exportable)
+	if err == nil {
+		ks.replicator.Enqueue(label, id)
+	}
+	return handle, err
+}
+
+func (ks *replicatingKeyStore) DeriveComponentKey(base pkcs11.ObjectHandle, label string, id int32, keyType string, keyLen int, store bool) (pkcs11.ObjectHandle, error) {
+	handle, err := ks.KeyStore.DeriveComponentKey(base, label, id, keyType, keyLen, store)
+	if err == nil && store {
+		ks.replicator.Enqueue(label, id)
+	}
+	return handle, err
+}
+
+func (ks *replicatingKeyStore) SetKeyMetadata(handle pkcs11.ObjectHandle, createdAt time.Time, validity KeyValidity) error {
+	err := ks.KeyStore.SetKeyMetadata(handle, createdAt, validity)
+	if err == nil {
+		ks.enqueueHandle(handle)
+	}
+	return err
+}
+
+func (ks *replicatingKeyStore) RetireKey(handle pkcs11.ObjectHandle, retireAt time.Time) error {
+	err := ks.KeyStore.RetireKey(handle, retireAt)
+	if err == nil {
+		ks.enqueueHandle(handle)
+	}
+	return err
+}
+
+// enqueueHandle queues the key behind a handle, the changes made by handle
+// do not name the label and id of the key
+func (ks *replicatingKeyStore) enqueueHandle(handle pkcs11.ObjectHandle) {
+	description, err := ks.KeyStore.DescribeKey(handle)
+	if err != nil {
+		logger.AppLog.Warnf("Key handle %d is not replicated, it waits for a full sync: %v", handle, err)
+		return
+	}
+	ks.replicator.Enqueue(description.Label, description.Id)
+}
+
+func (ks *replicatingKeyStore) DeleteKey(label string, id int32) error {
+	err := ks.KeyStore.DeleteKey(label, id)
+	if err == nil {
+		ks.replicator.Enqueue(label, id)
+	}
+	return err
+}
@@ -0,0 +1,168 @@
+package pkcs11mgr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+)
+
+// replicationStores returns the KeyStores of two paired memory tokens
+func replicationStores(t *testing.T) (KeyStore, KeyStore, replicationKeys) {
+	t.Helper()
+	SetKeyExport(true)
+	t.Cleanup(func() { SetKeyExport(false) })
+
+	primary, replica := NewMemoryProvider(), NewMemoryProvider()
+	p, err := primary.GetKeyStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := replica.GetKeyStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewReplicator(primary, replica, 1).pair(p, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, q, keys
+}
+
+func mustStoreKey(t *testing.T, ks KeyStore, label string, value string, id int32) pkcs11.ObjectHandle {
+	t.Helper()
+	handle, err := ks.StoreKey(label, []byte(value), id, constants.TYPE_AES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handle
+}
+
+func mustDescribe(t *testing.T, ks KeyStore, label string, id int32) replicatedKey {
+	t.Helper()
+	handle, err := ks.FindKey(label, id)
+	if err != nil {
+		t.Fatalf("key %s/%d: %v", label, id, err)
+	}
+	key, err := describeReplicatedKey(ks, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// TestSyncKeyState checks that the retirement and the validity dates follow
+// the key to the replica and that the report tells them apart
+func TestSyncKeyState(t *testing.T) {
+	p, q, keys := replicationStores(t)
+	label := constants.LABEL_ENCRYPTION_KEY_AES128
+	handle := mustStoreKey(t, p, label, "0123456789abcdef", 1)
+	validity := KeyValidity{Start: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := p.SetKeyMetadata(handle, time.Now(), validity); err != nil {
+		t.Fatal(err)
+	}
+
+	if action, err := syncKey(p, q, keys, label, 1); err != nil || action != syncCopied {
+		t.Fatalf("first sync = %q, %v", action, err)
+	}
+	replica := mustDescribe(t, q, label, 1)
+	if !replica.Validity.Start.Equal(validity.Start) || !replica.Validity.End.Equal(validity.End) || replica.retired() {
+		t.Fatalf("replica copy = %+v", replica)
+	}
+
+	retireAt := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := p.RetireKey(handle, retireAt); err != nil {
+		t.Fatal(err)
+	}
+	primaryKeys, err := replicatedKeys(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicaKeys, err := replicatedKeys(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := compareKeys(primaryKeys, replicaKeys)
+	if report.Consistent || len(report.Keys) != 1 || report.Keys[0].Status != REPLICA_KEY_STATE_MISMATCH || report.Keys[0].Error != "different retirement" {
+		t.Fatalf("report = %+v", report)
+	}
+
+	if action, err := syncKey(p, q, keys, label, 1); err != nil || action != syncUpdated {
+		t.Fatalf("sync after the retirement = %q, %v", action, err)
+	}
+	replica = mustDescribe(t, q, label, 1)
+	if !replica.retired() || !replica.RetireAt.Equal(retireAt) {
+		t.Fatalf("replica copy not retired: %+v", replica)
+	}
+	if action, err := syncKey(p, q, keys, label, 1); err != nil || action != syncUnchanged {
+		t.Fatalf("sync of a retired key = %q, %v", action, err)
+	}
+}
+
+// TestSyncKeyRecopiesRetiredReplica checks that a retirement the primary token
+// does not have is undone by copying the key again
+func TestSyncKeyRecopiesRetiredReplica(t *testing.T) {
+	p, q, keys := replicationStores(t)
+	label := constants.LABEL_ENCRYPTION_KEY_AES128
+	mustStoreKey(t, p, label, "0123456789abcdef", 1)
+	if _, err := syncKey(p, q, keys, label, 1); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := q.FindKey(label, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.RetireKey(handle, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if action, err := syncKey(p, q, keys, label, 1); err != nil || action != syncCopied {
+		t.Fatalf("sync = %q, %v", action, err)
+	}
+	if replica := mustDescribe(t, q, label, 1); replica.retired() {
+		t.Fatalf("replica copy still retired: %+v", replica)
+	}
+}
+
+// failingUnwrap is a replica whose unwraps fail
+type failingUnwrap struct {
+	KeyStore
+}
+
+func (ks failingUnwrap) UnwrapKey(pkcs11.ObjectHandle, uint, []byte, string, int32, string, bool) (pkcs11.ObjectHandle, error) {
+	return 0, pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)
+}
+
+// TestSyncKeyStagesTheCopy checks that the old replica copy survives an unwrap
+// that fails and that the staged copy takes its id once it is on the replica
+func TestSyncKeyStagesTheCopy(t *testing.T) {
+	p, q, keys := replicationStores(t)
+	label := constants.LABEL_ENCRYPTION_KEY_AES128
+	mustStoreKey(t, p, label, "0123456789abcdef", 1)
+	mustStoreKey(t, q, label, "fedcba9876543210", 1)
+	old := mustDescribe(t, q, label, 1)
+
+	_, err := syncKey(p, failingUnwrap{q}, keys, label, 1)
+	var pkcsErr pkcs11.Error
+	if !errors.As(err, &pkcsErr) || pkcsErr != pkcs11.CKR_DEVICE_ERROR {
+		t.Fatalf("sync with a failing unwrap = %v", err)
+	}
+	if replica := mustDescribe(t, q, label, 1); !bytes.Equal(replica.CheckValue, old.CheckValue) {
+		t.Fatal("the old replica copy was replaced by a failed sync")
+	}
+
+	if action, err := syncKey(p, q, keys, label, 1); err != nil || action != syncCopied {
+		t.Fatalf("sync = %q, %v", action, err)
+	}
+	primary, replica := mustDescribe(t, p, label, 1), mustDescribe(t, q, label, 1)
+	if !bytes.Equal(replica.CheckValue, primary.CheckValue) {
+		t.Fatal("the replica copy was not replaced")
+	}
+	if _, err := q.FindKey(label, stagingId(1)); err == nil {
+		t.Fatal("the staged copy is still on the replica")
+	}
+}
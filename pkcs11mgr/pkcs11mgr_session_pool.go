@@ -0,0 +1,517 @@
+package pkcs11mgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+// PoolStats is a snapshot of the session pool metrics
+type PoolStats struct {
+	MaxSessions     int
+	Open            int
+	InUse           int
+	Idle            int
+	Waiters         int
+	Acquired        uint64
+	Timeouts        uint64
+	InvalidSessions uint64
+	Reconnects      uint64
+	TotalWaitTime   time.Duration
+	MaxWaitTime     time.Duration
+}
+
+// SessionPoolReporter is implemented by providers that keep a session pool
+type SessionPoolReporter interface {
+	Stats() PoolStats
+}
+
+type poolCounters struct {
+	inUse           int
+	waiters         int
+	acquired        uint64
+	timeouts        uint64
+	invalidSessions uint64
+	reconnects      uint64
+	totalWaitTime   time.Duration
+	maxWaitTime     time.Duration
+}
+
+// SetWaitTimeout bounds how long GetSession waits for a free session when the
+// caller context has no deadline of its own, zero waits until the context is done
+func (m *Manager) SetWaitTimeout(d time.Duration) {
+	m.poolMutex.Lock()
+	m.waitTimeout = d
+	m.poolMutex.Unlock()
+}
+
+// GetSession takes a session from the pool, opening a new one while the pool is
+// below maxSessions. When the pool is exhausted it waits for a session to be
+// returned until ctx is done.
+func (m *Manager) GetSession(ctx context.Context) (*Session, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	m.poolMutex.Lock()
+	waitTimeout := m.waitTimeout
+	m.poolMutex.Unlock()
+	if _, ok := ctx.Deadline(); !ok && waitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waitTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	reconnected := false
+	for {
+		// Reuse an idle session if there is one
+		select {
+		case session := <-m.idle:
+			if !m.validSession(session) {
+				continue
+			}
+			m.acquired(session, start)
+			return session, nil
+		default:
+		}
+
+		// Open a new session while the pool is below its limit
+		if m.reserveSlot() {
+			generation := m.currentGeneration()
+			session, err := m.openSession(generation)
+			if err != nil {
+				m.releaseSlot()
+				if isModuleResetError(err) && !reconnected {
+					// Open the session again once the module is back
+					if rerr := m.reconnect(generation); rerr != nil {
+						logger.AppLog.Errorf("Failed to reconnect to the PKCS#11 module: %v", rerr)
+						return nil, err
+					}
+					reconnected = true
+					continue
+				}
+				return nil, err
+			}
+			m.acquired(session, start)
+			return session, nil
+		}
+
+		// The pool is exhausted, wait for a session or a free slot
+		m.poolMutex.Lock()
+		m.stats.waiters++
+		m.poolMutex.Unlock()
+
+		select {
+		case session := <-m.idle:
+			m.stopWaiting()
+			if !m.validSession(session) {
+				continue
+			}
+			m.acquired(session, start)
+			return session, nil
+		case <-m.slotFreed:
+			m.stopWaiting()
+		case <-ctx.Done():
+			m.poolMutex.Lock()
+			m.stats.waiters--
+			m.stats.timeouts++
+			m.poolMutex.Unlock()
+			logger.AppLog.Warnf("Gave up waiting for a PKCS#11 session after %v: %v", time.Since(start), ctx.Err())
+			return nil, fmt.Errorf("waiting for a PKCS#11 session: %w", ctx.Err())
+		}
+	}
+}
+
+// LogoutSession returns session to pool (NO hace logout)
+func (m *Manager) LogoutSession(session *Session) {
+	if session == nil || session.Handle == 0 {
+		return
+	}
+
+	m.poolMutex.Lock()
+	m.stats.inUse--
+	stale := session.generation != m.generation
+	m.poolMutex.Unlock()
+
+	if stale {
+		// The module was reset while the session was in use, its handle is gone
+		logger.AppLog.Debugf("Dropping PKCS#11 session %d opened before the module reset", session.Handle)
+		return
+	}
+
+	logger.AppLog.Debugf("Returning PKCS#11 session to pool: %d", session.Handle)
+	select {
+	case m.idle <- session:
+	default:
+		// Should not happen, the channel holds maxSessions sessions
+		m.discardSession(session)
+	}
+}
+
+// CloseSession closes a session taken with GetSession definitively
+func (m *Manager) CloseSession(session *Session) {
+	if session == nil || session.Handle == 0 {
+		return
+	}
+
+	m.poolMutex.Lock()
+	m.stats.inUse--
+	m.poolMutex.Unlock()
+
+	m.discardSession(session)
+}
+
+// CloseAllSessions closes all sessions and does logout
+func (m *Manager) CloseAllSessions() {
+	m.loginMutex.Lock()
+	defer m.loginMutex.Unlock()
+
+	logger.AppLog.Debugf("Closing all sessions for slot %d", m.slot)
+
+	m.poolMutex.Lock()
+	defer m.poolMutex.Unlock()
+
+	// ✅ Hacer logout antes de cerrar todas las sesiones
+	if m.isLoggedIn {
+		// Necesitamos una sesión válida para hacer logout
+		select {
+		case session := <-m.idle:
+			_ = m.api.Logout(session.Handle)
+		default:
+		}
+		m.isLoggedIn = false
+	}
+
+	m.drainIdle()
+	_ = m.api.CloseAllSessions(m.slot)
+
+	// Sessions still in use are dropped when they come back
+	m.generation++
+	m.openSessions = 0
+	m.signalSlotFreed()
+}
+
+// Stats returns a snapshot of the pool metrics
+func (m *Manager) Stats() PoolStats {
+	m.poolMutex.Lock()
+	defer m.poolMutex.Unlock()
+
+	return PoolStats{
+		MaxSessions:     m.maxSessions,
+		Open:            m.openSessions,
+		InUse:           m.stats.inUse,
+		Idle:            len(m.idle),
+		Waiters:         m.stats.waiters,
+		Acquired:        m.stats.acquired,
+		Timeouts:        m.stats.timeouts,
+		InvalidSessions: m.stats.invalidSessions,
+		Reconnects:      m.stats.reconnects,
+		TotalWaitTime:   m.stats.totalWaitTime,
+		MaxWaitTime:     m.stats.maxWaitTime,
+	}
+}
+
+// StartHealthCheck validates the idle sessions every interval in the background
+func (m *Manager) StartHealthCheck(interval time.Duration) {
+	if interval <= 0 || m.stopHealth != nil {
+		return
+	}
+
+	logger.AppLog.Infof("Starting PKCS#11 session health check every %v", interval)
+	stop := make(chan struct{})
+	m.stopHealth = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.checkIdleSessions()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopHealthCheck stops the background validation started by StartHealthCheck
+func (m *Manager) StopHealthCheck() {
+	if m.stopHealth != nil {
+		close(m.stopHealth)
+		m.stopHealth = nil
+	}
+}
+
+// checkIdleSessions runs C_GetSessionInfo on every idle session, broken sessions
+// are closed and a module reset triggers a reconnect
+func (m *Manager) checkIdleSessions() {
+	slot := m.currentSlot()
+	if _, err := m.api.GetSlotInfo(slot); err != nil {
+		logger.AppLog.Warnf("PKCS#11 slot %d is not reachable: %v", slot, err)
+		if isModuleResetError(err) {
+			if err := m.reconnect(m.currentGeneration()); err != nil {
+				logger.AppLog.Errorf("Failed to reconnect to the PKCS#11 module: %v", err)
+			}
+		}
+		return
+	}
+
+idleLoop:
+	for n := len(m.idle); n > 0; n-- {
+		select {
+		case session := <-m.idle:
+			if !m.validSession(session) {
+				continue
+			}
+			select {
+			case m.idle <- session:
+			default:
+				m.discardSession(session)
+			}
+		default:
+			break idleLoop
+		}
+	}
+
+	stats := m.Stats()
+	logger.AppLog.Debugf("PKCS#11 session pool: open=%d in_use=%d idle=%d waiters=%d timeouts=%d reconnects=%d",
+		stats.Open, stats.InUse, stats.Idle, stats.Waiters, stats.Timeouts, stats.Reconnects)
+	keyStats := m.keys.Stats()
+	logger.AppLog.Debugf("PKCS#11 key cache: entries=%d hits=%d misses=%d", keyStats.Entries, keyStats.Hits, keyStats.Misses)
+}
+
+// openSession opens and logs in a new session, the caller must have reserved a slot
+func (m *Manager) openSession(generation uint64) (*Session, error) {
+	logger.AppLog.Debugln("Creating new PKCS#11 session")
+
+	m.poolMutex.Lock()
+	slot, mechanisms := m.slot, m.mechanisms
+	m.poolMutex.Unlock()
+
+	handle, err := m.api.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to open session: %v", err)
+		return nil, err
+	}
+
+	if err := m.ensureLogin(handle); err != nil {
+		logger.AppLog.Errorf("Failed to login to session: %v", err)
+		_ = m.api.CloseSession(handle)
+		return nil, err
+	}
+
+	logger.AppLog.Debugf("PKCS#11 session created: %d", handle)
+	return &Session{
+		Handle:     handle,
+		Ctx:        m.ctx,
+		generation: generation,
+		keys:       m.keys,
+		mechanisms: mechanisms,
+	}, nil
+}
+
+// ensureLogin logs the user in unless the session already sees a logged in token.
+// The login state is read from the token so a reset token is logged in again.
+func (m *Manager) ensureLogin(handle pkcs11.SessionHandle) error {
+	m.loginMutex.Lock()
+	defer m.loginMutex.Unlock()
+
+	info, err := m.api.GetSessionInfo(handle)
+	if err != nil {
+		return err
+	}
+	if info.State == pkcs11.CKS_RW_USER_FUNCTIONS || info.State == pkcs11.CKS_RO_USER_FUNCTIONS {
+		m.isLoggedIn = true
+		return nil
+	}
+
+	logger.AppLog.Debugf("Logging into slot %d with session: %d", m.slot, handle)
+	if err := m.api.Login(handle, pkcs11.CKU_USER, m.pin); err != nil && !isPKCS11Error(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		m.isLoggedIn = false
+		return err
+	}
+	m.isLoggedIn = true
+	logger.AppLog.Debugf("Successfully logged into slot %d", m.slot)
+	return nil
+}
+
+// validSession reports whether an idle session can still be used, broken
+// sessions are closed and their slot is released
+func (m *Manager) validSession(session *Session) bool {
+	if session.generation != m.currentGeneration() {
+		return false
+	}
+
+	err := m.ensureLogin(session.Handle)
+	if err == nil {
+		return true
+	}
+
+	logger.AppLog.Warnf("PKCS#11 session %d is no longer valid: %v", session.Handle, err)
+	m.poolMutex.Lock()
+	m.stats.invalidSessions++
+	m.poolMutex.Unlock()
+
+	if isModuleResetError(err) {
+		if rerr := m.reconnect(session.generation); rerr != nil {
+			logger.AppLog.Errorf("Failed to reconnect to the PKCS#11 module: %v", rerr)
+		}
+		return false
+	}
+
+	m.discardSession(session)
+	return false
+}
+
+// reconnect initializes the module again after a reset seen by a session of
+// the given generation, see loadedModule.reconnect. Nothing is done when the
+// Manager already moved to a newer generation.
+func (m *Manager) reconnect(generation uint64) error {
+	m.poolMutex.Lock()
+	current, epoch := m.generation, m.moduleEpoch
+	m.poolMutex.Unlock()
+
+	if generation != current {
+		return nil
+	}
+	return m.module.reconnect(epoch)
+}
+
+// moduleReset drops the sessions and the cached key handles after the module
+// was initialized again and looks the slot of the token up again
+func (m *Manager) moduleReset(epoch uint64) error {
+	m.loginMutex.Lock()
+	defer m.loginMutex.Unlock()
+	m.poolMutex.Lock()
+	defer m.poolMutex.Unlock()
+
+	m.moduleEpoch = epoch
+	m.generation++
+	m.stats.reconnects++
+	m.drainIdle()
+	m.openSessions = 0
+	m.isLoggedIn = false
+	m.signalSlotFreed()
+	m.keys.Clear()
+
+	// The token may be in another slot after it was initialised again
+	slot, err := resolveSlot(m.api, m.token)
+	if err != nil {
+		return err
+	}
+	if slot != m.slot {
+		logger.AppLog.Infof("The %s moved from slot %d to slot %d", m.token, m.slot, slot)
+		m.slot = slot
+	}
+	mechanisms, err := readMechanisms(m.api, m.slot)
+	if err != nil {
+		return err
+	}
+	m.mechanisms = mechanisms
+	logger.AppLog.Infof("PKCS#11 module initialized again for slot %d", m.slot)
+	return nil
+}
+
+// discardSession closes a session that is not in use and releases its slot
+func (m *Manager) discardSession(session *Session) {
+	logger.AppLog.Debugf("Closing PKCS#11 session: %d", session.Handle)
+
+	if session.generation != m.currentGeneration() {
+		return
+	}
+
+	// ✅ Solo cerrar la sesión, NO hacer logout (afectaría otras sesiones)
+	_ = m.api.CloseSession(session.Handle)
+	m.releaseSlot()
+}
+
+func (m *Manager) currentSlot() uint {
+	m.poolMutex.Lock()
+	defer m.poolMutex.Unlock()
+	return m.slot
+}
+
+func (m *Manager) currentGeneration() uint64 {
+	m.poolMutex.Lock()
+	defer m.poolMutex.Unlock()
+	return m.generation
+}
+
+func (m *Manager) reserveSlot() bool {
+	m.poolMutex.Lock()
+	defer m.poolMutex.Unlock()
+	if m.openSessions >= m.maxSessions {
+		logger.AppLog.Debugln("The sessions reached max limit")
+		return false
+	}
+	m.openSessions++
+	return true
+}
+
+func (m *Manager) releaseSlot() {
+	m.poolMutex.Lock()
+	defer m.poolMutex.Unlock()
+	if m.openSessions > 0 {
+		m.openSessions--
+	}
+	logger.AppLog.Debugf("Session closed. Remaining sessions: %d", m.openSessions)
+	m.signalSlotFreed()
+}
+
+func (m *Manager) acquired(session *Session, start time.Time) {
+	wait := time.Since(start)
+
+	m.poolMutex.Lock()
+	m.lastUsed = time.Now()
+	m.stats.inUse++
+	m.stats.acquired++
+	m.stats.totalWaitTime += wait
+	if wait > m.stats.maxWaitTime {
+		m.stats.maxWaitTime = wait
+	}
+	m.poolMutex.Unlock()
+
+	logger.AppLog.Debugf("Got session from pool: %d", session.Handle)
+}
+
+func (m *Manager) stopWaiting() {
+	m.poolMutex.Lock()
+	m.stats.waiters--
+	m.poolMutex.Unlock()
+}
+
+// signalSlotFreed wakes up one waiter, poolMutex must be held
+func (m *Manager) signalSlotFreed() {
+	select {
+	case m.slotFreed <- struct{}{}:
+	default:
+	}
+}
+
+// drainIdle empties the idle channel without closing the sessions, poolMutex must be held
+func (m *Manager) drainIdle() {
+	for {
+		select {
+		case <-m.idle:
+		default:
+			return
+		}
+	}
+}
+
+func isPKCS11Error(err error, code uint) bool {
+	var p11Err pkcs11.Error
+	return errors.As(err, &p11Err) && uint(p11Err) == code
+}
+
+// isModuleResetError reports errors after which every session of the module is gone
+func isModuleResetError(err error) bool {
+	return isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED) ||
+		isPKCS11Error(err, pkcs11.CKR_DEVICE_REMOVED) ||
+		isPKCS11Error(err, pkcs11.CKR_TOKEN_NOT_PRESENT) ||
+		isPKCS11Error(err, pkcs11.CKR_DEVICE_ERROR)
+}
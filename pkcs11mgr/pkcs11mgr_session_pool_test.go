@@ -0,0 +1,407 @@
+package pkcs11mgr
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// fakeModule is a PKCS#11 library with tokens in slots and a login state per
+// token. Removing the device fails every call with CKR_DEVICE_REMOVED until the
+// library is initialized again.
+type fakeModule struct {
+	mu          sync.Mutex
+	tokens      map[uint]pkcs11.TokenInfo
+	sessions    map[pkcs11.SessionHandle]uint // slot of the open sessions
+	loggedIn    map[uint]bool
+	next        pkcs11.SessionHandle
+	removed     bool
+	initialized int
+	logins      int
+}
+
+func newFakeModule(tokens map[uint]pkcs11.TokenInfo) *fakeModule {
+	return &fakeModule{
+		tokens:   tokens,
+		sessions: make(map[pkcs11.SessionHandle]uint),
+		loggedIn: make(map[uint]bool),
+	}
+}
+
+// removeDevice drops the sessions and the logins, the tokens are in the slots
+// of tokens once the library is initialized again
+func (f *fakeModule) removeDevice(tokens map[uint]pkcs11.TokenInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = true
+	f.tokens = tokens
+	f.sessions = make(map[pkcs11.SessionHandle]uint)
+	f.loggedIn = make(map[uint]bool)
+}
+
+func (f *fakeModule) check() error {
+	if f.removed {
+		return pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED)
+	}
+	return nil
+}
+
+func (f *fakeModule) Initialize() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = false
+	f.initialized++
+	return nil
+}
+
+func (f *fakeModule) Finalize() error { return nil }
+
+func (f *fakeModule) GetSlotList(tokenPresent bool) ([]uint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	var slots []uint
+	for slot := range f.tokens {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	return slots, nil
+}
+
+func (f *fakeModule) GetSlotInfo(slotID uint) (pkcs11.SlotInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(); err != nil {
+		return pkcs11.SlotInfo{}, err
+	}
+	if _, ok := f.tokens[slotID]; !ok {
+		return pkcs11.SlotInfo{}, pkcs11.Error(pkcs11.CKR_SLOT_ID_INVALID)
+	}
+	return pkcs11.SlotInfo{Flags: pkcs11.CKF_TOKEN_PRESENT}, nil
+}
+
+func (f *fakeModule) GetTokenInfo(slotID uint) (pkcs11.TokenInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(); err != nil {
+		return pkcs11.TokenInfo{}, err
+	}
+	info, ok := f.tokens[slotID]
+	if !ok {
+		return pkcs11.TokenInfo{}, pkcs11.Error(pkcs11.CKR_SLOT_ID_INVALID)
+	}
+	return info, nil
+}
+
+func (f *fakeModule) GetMechanismList(slotID uint) ([]*pkcs11.Mechanism, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	var list []*pkcs11.Mechanism
+	for _, optional := range optionalMechanisms {
+		list = append(list, pkcs11.NewMechanism(optional.mechanism, nil))
+	}
+	return list, nil
+}
+
+func (f *fakeModule) OpenSession(slotID uint, flags uint) (pkcs11.SessionHandle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+	if _, ok := f.tokens[slotID]; !ok {
+		return 0, pkcs11.Error(pkcs11.CKR_SLOT_ID_INVALID)
+	}
+	f.next++
+	f.sessions[f.next] = slotID
+	return f.next, nil
+}
+
+func (f *fakeModule) CloseSession(sh pkcs11.SessionHandle) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(); err != nil {
+		return err
+	}
+	if _, ok := f.sessions[sh]; !ok {
+		return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	}
+	delete(f.sessions, sh)
+	return nil
+}
+
+func (f *fakeModule) CloseAllSessions(slotID uint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(); err != nil {
+		return err
+	}
+	for sh, slot := range f.sessions {
+		if slot == slotID {
+			delete(f.sessions, sh)
+		}
+	}
+	f.loggedIn[slotID] = false
+	return nil
+}
+
+func (f *fakeModule) GetSessionInfo(sh pkcs11.SessionHandle) (pkcs11.SessionInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(); err != nil {
+		return pkcs11.SessionInfo{}, err
+	}
+	slot, ok := f.sessions[sh]
+	if !ok {
+		return pkcs11.SessionInfo{}, pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	}
+	state := uint(pkcs11.CKS_RW_PUBLIC_SESSION)
+	if f.loggedIn[slot] {
+		state = pkcs11.CKS_RW_USER_FUNCTIONS
+	}
+	return pkcs11.SessionInfo{SlotID: slot, State: state}, nil
+}
+
+func (f *fakeModule) Login(sh pkcs11.SessionHandle, userType uint, pin string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(); err != nil {
+		return err
+	}
+	slot, ok := f.sessions[sh]
+	if !ok {
+		return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	}
+	if f.loggedIn[slot] {
+		return pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)
+	}
+	f.loggedIn[slot] = true
+	f.logins++
+	return nil
+}
+
+func (f *fakeModule) Logout(sh pkcs11.SessionHandle) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check(); err != nil {
+		return err
+	}
+	slot, ok := f.sessions[sh]
+	if !ok {
+		return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	}
+	f.loggedIn[slot] = false
+	return nil
+}
+
+func (f *fakeModule) sessionOpen(sh pkcs11.SessionHandle) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.sessions[sh]
+	return ok
+}
+
+func fakeToken(label, serial string) pkcs11.TokenInfo {
+	return pkcs11.TokenInfo{Label: label, SerialNumber: serial}
+}
+
+// loadFakeModule loads f the way loadModule loads a library
+func loadFakeModule(f *fakeModule) *loadedModule {
+	return &loadedModule{api: f, refs: 1, managers: make(map[*Manager]struct{})}
+}
+
+func newFakeManager(t *testing.T, mod *loadedModule, label string, maxSessions int) *Manager {
+	t.Helper()
+	m, err := newManager(mod, "", TokenSelector{Label: label}, "1234", maxSessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func getSession(t *testing.T, m *Manager) *Session {
+	t.Helper()
+	session, err := m.GetSession(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// waitForWaiters blocks until n callers wait for a session of m
+func waitForWaiters(t *testing.T, m *Manager, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Stats().Waiters != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d waiters, want %d", m.Stats().Waiters, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSessionPoolExhaustion(t *testing.T) {
+	f := newFakeModule(map[uint]pkcs11.TokenInfo{1: fakeToken("ssm", "1")})
+	m := newFakeManager(t, loadFakeModule(f), "ssm", 2)
+
+	first, second := getSession(t, m), getSession(t, m)
+	if stats := m.Stats(); stats.Open != 2 || stats.InUse != 2 || stats.Idle != 0 {
+		t.Fatalf("stats after two sessions = %+v", stats)
+	}
+	if f.logins != 1 {
+		t.Fatalf("%d logins, the token is logged in once for all its sessions", f.logins)
+	}
+
+	// the wait timeout bounds a caller without a deadline
+	m.SetWaitTimeout(20 * time.Millisecond)
+	if _, err := m.GetSession(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("exhausted pool: %v, want %v", err, context.DeadlineExceeded)
+	}
+	// the deadline of the caller wins over the wait timeout
+	m.SetWaitTimeout(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.GetSession(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("exhausted pool with a deadline: %v, want %v", err, context.DeadlineExceeded)
+	}
+	if stats := m.Stats(); stats.Timeouts != 2 || stats.Waiters != 0 || stats.Open != 2 {
+		t.Fatalf("stats after the timeouts = %+v", stats)
+	}
+
+	// a waiter takes the session that is returned
+	got := make(chan *Session)
+	go func() {
+		session, err := m.GetSession(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		got <- session
+	}()
+	waitForWaiters(t, m, 1)
+	m.LogoutSession(first)
+	if session := <-got; session == nil || session.Handle != first.Handle {
+		t.Fatalf("waiter got %+v, want session %d", session, first.Handle)
+	}
+
+	// a waiter opens a session in the slot of a closed one
+	go func() {
+		session, err := m.GetSession(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		got <- session
+	}()
+	waitForWaiters(t, m, 1)
+	m.CloseSession(second)
+	session := <-got
+	if session == nil || session.Handle == second.Handle || f.sessionOpen(second.Handle) {
+		t.Fatalf("waiter got %+v after session %d was closed", session, second.Handle)
+	}
+	if stats := m.Stats(); stats.Open != 2 || stats.InUse != 2 || stats.Acquired != 4 {
+		t.Fatalf("stats after the waiters = %+v", stats)
+	}
+}
+
+func TestSessionPoolDropsStaleSessions(t *testing.T) {
+	f := newFakeModule(map[uint]pkcs11.TokenInfo{1: fakeToken("ssm", "1")})
+	m := newFakeManager(t, loadFakeModule(f), "ssm", 2)
+
+	// a session in use while the sessions are closed is not put back
+	stale := getSession(t, m)
+	m.CloseAllSessions()
+	m.LogoutSession(stale)
+	if stats := m.Stats(); stats.Open != 0 || stats.InUse != 0 || stats.Idle != 0 {
+		t.Fatalf("stats after the stale release = %+v", stats)
+	}
+	session := getSession(t, m)
+	if session.Handle == stale.Handle || session.generation == stale.generation {
+		t.Fatalf("got session %d of generation %d again", session.Handle, session.generation)
+	}
+
+	// an idle session the token closed is replaced
+	m.LogoutSession(session)
+	if err := f.CloseSession(session.Handle); err != nil {
+		t.Fatal(err)
+	}
+	replaced := getSession(t, m)
+	if replaced.Handle == session.Handle {
+		t.Fatalf("got the closed session %d", session.Handle)
+	}
+	if stats := m.Stats(); stats.Open != 1 || stats.InUse != 1 || stats.InvalidSessions != 1 {
+		t.Fatalf("stats after the invalid session = %+v", stats)
+	}
+}
+
+// TestSessionPoolReconnect removes the device under two Managers of a module,
+// the module is initialized once and both Managers start over
+func TestSessionPoolReconnect(t *testing.T) {
+	tokenA, tokenB := fakeToken("a", "1"), fakeToken("b", "2")
+	f := newFakeModule(map[uint]pkcs11.TokenInfo{1: tokenA, 2: tokenB})
+	mod := loadFakeModule(f)
+	a, b := newFakeManager(t, mod, "a", 2), newFakeManager(t, mod, "b", 2)
+
+	idle := getSession(t, a)
+	a.LogoutSession(idle)
+	inUse := getSession(t, b)
+	epoch := mod.epoch
+
+	// the token of a comes back in another slot
+	f.removeDevice(map[uint]pkcs11.TokenInfo{3: tokenA, 2: tokenB})
+	session := getSession(t, a)
+	if f.initialized != 1 {
+		t.Fatalf("module initialized %d times, want once", f.initialized)
+	}
+	if session.Handle == idle.Handle || !f.sessionOpen(session.Handle) {
+		t.Fatalf("got session %d after the reset", session.Handle)
+	}
+	if slot := a.currentSlot(); slot != 3 {
+		t.Fatalf("token a in slot %d, want 3", slot)
+	}
+	for name, m := range map[string]*Manager{"a": a, "b": b} {
+		if stats := m.Stats(); stats.Reconnects != 1 {
+			t.Fatalf("manager %s: %d reconnects, want 1", name, stats.Reconnects)
+		}
+	}
+
+	// a reset seen late by another Manager does not initialize the module again
+	if err := mod.reconnect(epoch); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.reconnect(inUse.generation); err != nil {
+		t.Fatal(err)
+	}
+	if f.initialized != 1 {
+		t.Fatalf("module initialized %d times after the late reconnects, want once", f.initialized)
+	}
+
+	// the session b held through the reset is dropped, b opens and logs in a new one
+	b.LogoutSession(inUse)
+	if stats := b.Stats(); stats.Open != 0 || stats.InUse != 0 || stats.Idle != 0 {
+		t.Fatalf("manager b stats after the stale release = %+v", stats)
+	}
+	replaced := getSession(t, b)
+	if info, err := f.GetSessionInfo(replaced.Handle); err != nil || info.SlotID != 2 || info.State != pkcs11.CKS_RW_USER_FUNCTIONS {
+		t.Fatalf("session of b after the reset = %+v, %v", info, err)
+	}
+
+	// the health check notices a removal with no session in use
+	a.LogoutSession(session)
+	f.removeDevice(map[uint]pkcs11.TokenInfo{3: tokenA, 2: tokenB})
+	a.checkIdleSessions()
+	if f.initialized != 2 {
+		t.Fatalf("module initialized %d times after the health check, want 2", f.initialized)
+	}
+	if stats := a.Stats(); stats.Reconnects != 2 || stats.Idle != 0 || stats.Open != 0 {
+		t.Fatalf("manager a stats after the health check = %+v", stats)
+	}
+}
@@ -0,0 +1,37 @@
+package pkcs11mgr
+
+import (
+	"github.com/miekg/pkcs11"
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+// SignData signs data with a private key object already in the token using the given mechanism
+func SignData(keyHandle pkcs11.ObjectHandle, mechanism uint, data []byte, s Session) ([]byte, error) {
+	logger.AppLog.Debugf("Signing data with key handle=%v, mechanism=0x%X, data length=%d", keyHandle, mechanism, len(data))
+	mech := signMechanism(mechanism)
+	if err := s.Ctx.SignInit(s.Handle, mech, keyHandle); err != nil {
+		logger.AppLog.Errorf("SignInit failed for mechanism 0x%X: %v", mechanism, err)
+		return nil, err
+	}
+	signature, err := s.Ctx.Sign(s.Handle, data)
+	if err != nil {
+		logger.AppLog.Errorf("Sign failed: %v", err)
+		return nil, err
+	}
+	return signature, nil
+}
+
+// VerifyData verifies a signature with a public key object already in the token using the given mechanism
+func VerifyData(keyHandle pkcs11.ObjectHandle, mechanism uint, data, signature []byte, s Session) error {
+	logger.AppLog.Debugf("Verifying signature with key handle=%v, mechanism=0x%X", keyHandle, mechanism)
+	mech := signMechanism(mechanism)
+	if err := s.Ctx.VerifyInit(s.Handle, mech, keyHandle); err != nil {
+		logger.AppLog.Errorf("VerifyInit failed for mechanism 0x%X: %v", mechanism, err)
+		return err
+	}
+	if err := s.Ctx.Verify(s.Handle, data, signature); err != nil {
+		logger.AppLog.Debugf("Verify failed: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package pkcs11mgr
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+)
+
+// softHSMKeyStore opens the token named by SSM_TEST_SOFTHSM_TOKEN in the
+// module SSM_TEST_SOFTHSM_MODULE with the user PIN SSM_TEST_SOFTHSM_PIN, the
+// test is skipped when the module is not set
+func softHSMKeyStore(t *testing.T) KeyStore {
+	t.Helper()
+	module := os.Getenv("SSM_TEST_SOFTHSM_MODULE")
+	if module == "" {
+		t.Skip("SSM_TEST_SOFTHSM_MODULE is not set")
+	}
+	m, err := New(module, TokenSelector{Label: os.Getenv("SSM_TEST_SOFTHSM_TOKEN")}, os.Getenv("SSM_TEST_SOFTHSM_PIN"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Finalize)
+	ks, err := m.GetKeyStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.ReleaseKeyStore(ks) })
+	return ks
+}
+
+// TestSoftHSMComponentImport combines two key components on SoftHSM with
+// CKM_XOR_BASE_AND_DATA, or checks that the import is refused up front on a
+// token without it
+func TestSoftHSMComponentImport(t *testing.T) {
+	ks := softHSMKeyStore(t)
+	label, id := constants.LABEL_K4_KEY_AES, int32(time.Now().Unix()%1000000+1000000)
+
+	if !ks.SupportsMechanism(label, pkcs11.CKM_XOR_BASE_AND_DATA) {
+		if _, err := StartComponentImport(ks, label, id, constants.TYPE_AES, 2); !errors.Is(err, ErrMechanismNotSupported) {
+			t.Fatalf("import without CKM_XOR_BASE_AND_DATA: %v, want %v", err, ErrMechanismNotSupported)
+		}
+		return
+	}
+
+	components := [][]byte{
+		bytes.Repeat([]byte{0x5a}, 16),
+		bytes.Repeat([]byte{0xc3}, 16),
+	}
+	imp, err := StartComponentImport(ks, label, id, constants.TYPE_AES, len(components))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, component := range components {
+		values, err := ComputeKeyCheckValues(constants.TYPE_AES, component)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := SubmitKeyComponent(ks, imp.Id, i+1, component, values.KCV, fmt.Sprintf("custodian-%d", i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, kcvs, _, err := FinishComponentImport(ks, imp.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ks.DeleteKey(label, id) })
+
+	combined := make([]byte, 16)
+	subtle.XORBytes(combined, components[0], components[1])
+	want, err := ComputeKeyCheckValues(constants.TYPE_AES, combined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kcvs.KCV, want.KCV) {
+		t.Fatalf("KCV of the combined key = %x, want %x", kcvs.KCV, want.KCV)
+	}
+}
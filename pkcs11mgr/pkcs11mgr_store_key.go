@@ -11,32 +11,68 @@ import (
 
 // StoreKey creates a key object inside SoftHSM from raw key bytes and returns its object handle
 func StoreKey(label string, key []byte, id int32, keyType string, s Session) (pkcs11.ObjectHandle, error) {
+	return storeKey(label, key, id, keyType, false, s)
+}
+
+// StoreKeyVersion creates a new version of a label that can encrypt and decrypt, see RotateKey
+func StoreKeyVersion(label string, key []byte, id int32, keyType string, s Session) (pkcs11.ObjectHandle, error) {
+	return storeKey(label, key, id, keyType, true, s)
+}
+
+// keyUsage is the set of operations a stored or unwrapped secret key allows
+type keyUsage struct {
+	encrypt     bool
+	decrypt     bool
+	wrap        bool // CKA_WRAP
+	unwrap      bool // CKA_UNWRAP
+	sign        bool // CKA_SIGN and CKA_VERIFY of the HMAC keys
+	extractable bool // may leave the token wrapped
+}
+
+// storedKeyUsage returns the usage of a key imported under label, encrypt is
+// set for key versions that may still encrypt, see RotateKey
+func storedKeyUsage(label string, encrypt bool) keyUsage {
+	switch label {
+	// The transport and replication keys only wrap, they are extractable so
+	// they can be sent to the peer SSM or the replica under its RSA key pair
+	case ssm_consts.LABEL_TRANSPORT_KEY, ssm_consts.LABEL_REPLICATION_KEY:
+		return keyUsage{wrap: true, unwrap: true, extractable: true}
+	// The password pepper only computes HMACs, it is replicated and backed up
+	// like the other internal keys
+	case ssm_consts.LABEL_PASSWORD_PEPPER:
+		return keyUsage{sign: true, extractable: exportedByDefault(label)}
+	}
+	return keyUsage{encrypt: encrypt, decrypt: true, extractable: exportedByDefault(label)}
+}
+
+func (u keyUsage) attributes() []*pkcs11.Attribute {
+	return []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, u.encrypt),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, u.decrypt),
+		pkcs11.NewAttribute(pkcs11.CKA_WRAP, u.wrap),
+		pkcs11.NewAttribute(pkcs11.CKA_UNWRAP, u.unwrap),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, u.sign),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, u.sign),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, u.extractable),
+	}
+}
+
+func storeKey(label string, key []byte, id int32, keyType string, encrypt bool, s Session) (pkcs11.ObjectHandle, error) {
 	logger.AppLog.Infof("Storing key: label=%s, keyType=%s, keyLen=%d", label, keyType, len(key))
-	var keyTypeuint uint
-	switch keyType {
-	case ssm_consts.TYPE_AES:
-		keyTypeuint = pkcs11.CKK_AES
-	case ssm_consts.TYPE_DES3:
-		keyTypeuint = pkcs11.CKK_DES3
-	case ssm_consts.TYPE_DES:
-		keyTypeuint = pkcs11.CKK_DES
-	default:
-		return 0, errors.New("unsupported key type")
+	keyTypeuint, err := secretKeyType(keyType)
+	if err != nil {
+		return 0, err
 	}
-	template := []*pkcs11.Attribute{
+
+	template := append([]*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
 		pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)),
 		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
 		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyTypeuint),
 		pkcs11.NewAttribute(pkcs11.CKA_VALUE, key),
-		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, false),
-		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
-		pkcs11.NewAttribute(pkcs11.CKA_WRAP, false),
-		pkcs11.NewAttribute(pkcs11.CKA_UNWRAP, false),
-		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
-		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
-	}
+	}, storedKeyUsage(label, encrypt).attributes()...)
 
 	// Check if key already exists before creating it
 	existingHandle, err := FindKey(label, id, s)
@@ -71,16 +107,38 @@ func DeleteKey(label string, id int32, s Session) error {
 		return errors.New("key not found")
 	}
 
+	// The id of the key names its creation and retirement records, see SetKeyMetadata
+	attr, err := GetObjectAttributes(handle, s)
+	if err != nil {
+		return err
+	}
+
 	// Delete the key object
 	if err := s.Ctx.DestroyObject(s.Handle, handle); err != nil {
 		logger.AppLog.Errorf("Failed to delete key with handle %v: %v", handle, err)
 		return err
 	}
+	if err := destroyKeyRecords(label, attr.Id, s); err != nil {
+		logger.AppLog.Warnf("Failed to delete the records of key %s id %d: %v", label, attr.Id, err)
+	}
 
 	logger.AppLog.Infof("Key successfully deleted: label=%s, handle=%v", label, handle)
 	return nil
 }
 
+// SetKeyId changes the CKA_ID of a key. The creation and retirement records
+// are named after the id and are not moved, the key must not have any yet.
+func SetKeyId(handle pkcs11.ObjectHandle, id int32, s Session) error {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)),
+	}
+	if err := s.Ctx.SetAttributeValue(s.Handle, handle, template); err != nil {
+		logger.AppLog.Errorf("Failed to change the id of key handle %v: %v", handle, err)
+		return err
+	}
+	return nil
+}
+
 // DeleteAllKeys removes all secret key objects from the current s.Handle/slot
 // WARNING: This will delete ALL keys in the token - use with caution!
 func DeleteAllKeys(s Session) error {
@@ -133,7 +191,8 @@ func DeleteAllKeys(s Session) error {
 }
 
 // UpdateKey updates an existing key by deleting the old one and creating a new one with the updated value
-// This function combines delete and store operations to effectively "update" a key
+// This function combines delete and store operations to effectively "update" a key, data encrypted
+// with the old value can not be decrypted anymore. Use RotateKey to keep the old value as a retired version.
 func UpdateKey(label string, newKeyValue []byte, id int32, keyType string, s Session) (pkcs11.ObjectHandle, error) {
 	logger.AppLog.Infof("Updating key: label=%s, keyType=%s, newKeyLen=%d", label, keyType, len(newKeyValue))
 
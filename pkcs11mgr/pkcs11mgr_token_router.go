@@ -0,0 +1,786 @@
+package pkcs11mgr
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+// Object handles of every token share one handle space, the token index is
+// kept in the bits above tokenHandleShift. Handles stay positive int32 values
+// because the API reports them as int32.
+const (
+	tokenHandleShift = 24
+	tokenHandleMask  = 1<<tokenHandleShift - 1
+	maxTokens        = 1 << (31 - tokenHandleShift)
+)
+
+// ErrHandleNotRoutable is returned for a token handle that does not fit below
+// tokenHandleShift and for a routed handle of a token that is not configured
+var ErrHandleNotRoutable = errors.New("the object handle can not be routed")
+
+// TokenRouter is a CryptoProvider spread over several named tokens. Each label
+// family is routed to one token, labels without a routed family go to the first
+// token added.
+type TokenRouter struct {
+	names    []string
+	tokens   []CryptoProvider
+	families map[string]int // family -> token index
+}
+
+func NewTokenRouter() *TokenRouter {
+	return &TokenRouter{
+		families: make(map[string]int),
+	}
+}
+
+// AddToken registers a token and the label families it holds
+func (r *TokenRouter) AddToken(name string, p CryptoProvider, families []string) error {
+	if slices.Contains(r.names, name) {
+		return fmt.Errorf("token %q is configured twice", name)
+	}
+	if len(r.tokens) >= maxTokens {
+		return fmt.Errorf("too many tokens, at most %d are supported", maxTokens)
+	}
+
+	index := len(r.tokens)
+	for _, family := range families {
+		if !slices.Contains(constants.LabelFamilies[:], family) {
+			return fmt.Errorf("token %q: unknown label family %q", name, family)
+		}
+		if other, ok := r.families[family]; ok {
+			return fmt.Errorf("label family %q is routed to tokens %q and %q", family, r.names[other], name)
+		}
+		r.families[family] = index
+	}
+
+	r.names = append(r.names, name)
+	r.tokens = append(r.tokens, p)
+	logger.AppLog.Infof("Token %q added with label families %v", name, families)
+	return nil
+}
+
+// TokenFor returns the name of the token that holds the label
+func (r *TokenRouter) TokenFor(label string) string {
+	return r.names[r.tokenIndex(label)]
+}
+
+func (r *TokenRouter) tokenIndex(label string) int {
+	if index, ok := r.families[constants.LabelFamilyMap[label]]; ok {
+		return index
+	}
+	return 0
+}
+
+// GetKeyStore returns a KeyStore that takes the KeyStores of the tokens on
+// first use, see routedKeyStore.store
+func (r *TokenRouter) GetKeyStore(ctx context.Context) (KeyStore, error) {
+	if len(r.tokens) == 0 {
+		return nil, fmt.Errorf("no tokens configured")
+	}
+	return &routedKeyStore{
+		router: r,
+		ctx:    ctx,
+		stores: make([]KeyStore, len(r.tokens)),
+	}, nil
+}
+
+// ReleaseKeyStore returns every token KeyStore taken by ks
+func (r *TokenRouter) ReleaseKeyStore(ks KeyStore) {
+	routed, ok := ks.(*routedKeyStore)
+	if !ok {
+		return
+	}
+	for i, store := range routed.stores[:routed.taken] {
+		r.tokens[i].ReleaseKeyStore(store)
+		routed.stores[i] = nil
+	}
+	routed.taken = 0
+}
+
+// Finalize finalizes every token
+func (r *TokenRouter) Finalize() {
+	for i, token := range r.tokens {
+		logger.AppLog.Infof("Finalizing token %q", r.names[i])
+		token.Finalize()
+	}
+}
+
+// Stats adds up the session pool metrics of the tokens that keep a pool
+func (r *TokenRouter) Stats() PoolStats {
+	var total PoolStats
+	for _, token := range r.tokens {
+		reporter, ok := token.(SessionPoolReporter)
+		if !ok {
+			continue
+		}
+		stats := reporter.Stats()
+		total.MaxSessions += stats.MaxSessions
+		total.Open += stats.Open
+		total.InUse += stats.InUse
+		total.Idle += stats.Idle
+		total.Waiters += stats.Waiters
+		total.Acquired += stats.Acquired
+		total.Timeouts += stats.Timeouts
+		total.InvalidSessions += stats.InvalidSessions
+		total.Reconnects += stats.Reconnects
+		total.TotalWaitTime += stats.TotalWaitTime
+		total.MaxWaitTime = max(total.MaxWaitTime, stats.MaxWaitTime)
+	}
+	return total
+}
+
+// routedKeyStore sends each call to the KeyStore of the token that holds the key
+type routedKeyStore struct {
+	router *TokenRouter
+	ctx    context.Context
+	stores []KeyStore
+	taken  int // stores[:taken] are held
+}
+
+// store returns the KeyStore of a token. The tokens are always taken in index
+// order: the tokens before index the request does not hold yet are taken
+// first. A request never waits for a token while it holds a later one, so two
+// requests that use the same tokens in opposite orders can not deadlock on
+// full session pools.
+func (ks *routedKeyStore) store(index int) (KeyStore, error) {
+	if index < 0 || index >= len(ks.stores) {
+		return nil, fmt.Errorf("%w: unknown token %d", ErrHandleNotRoutable, index)
+	}
+	for ks.taken <= index {
+		store, err := ks.router.tokens[ks.taken].GetKeyStore(ks.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("token %q: %w", ks.router.names[ks.taken], err)
+		}
+		ks.stores[ks.taken] = store
+		ks.taken++
+	}
+	return ks.stores[index], nil
+}
+
+func (ks *routedKeyStore) storeForLabel(label string) (KeyStore, int, error) {
+	index := ks.router.tokenIndex(label)
+	store, err := ks.store(index)
+	return store, index, err
+}
+
+// storeForHandle returns the token KeyStore of a routed handle and the token handle
+func (ks *routedKeyStore) storeForHandle(handle pkcs11.ObjectHandle) (KeyStore, pkcs11.ObjectHandle, error) {
+	store, err := ks.store(int(uint(handle) >> tokenHandleShift))
+	return store, handle & tokenHandleMask, err
+}
+
+// routeHandle adds the token index to a token handle, a handle above
+// tokenHandleMask would be taken for a handle of another token
+func routeHandle(index int, handle pkcs11.ObjectHandle) (pkcs11.ObjectHandle, error) {
+	if handle > tokenHandleMask {
+		return 0, fmt.Errorf("%w: handle %d of token %d is too large", ErrHandleNotRoutable, handle, index)
+	}
+	return pkcs11.ObjectHandle(uint(index)<<tokenHandleShift) | handle, nil
+}
+
+func routeKeyPair(index int, pub, priv pkcs11.ObjectHandle) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	pub, err := routeHandle(index, pub)
+	if err != nil {
+		return 0, 0, err
+	}
+	priv, err = routeHandle(index, priv)
+	if err != nil {
+		return 0, 0, err
+	}
+	return pub, priv, nil
+}
+
+func routeHandles(index int, handles []pkcs11.ObjectHandle) ([]pkcs11.ObjectHandle, error) {
+	routed := make([]pkcs11.ObjectHandle, 0, len(handles))
+	for _, handle := range handles {
+		h, err := routeHandle(index, handle)
+		if err != nil {
+			return nil, err
+		}
+		routed = append(routed, h)
+	}
+	return routed, nil
+}
+
+func (ks *routedKeyStore) FindKey(label string, id int32) (pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := store.FindKey(label, id)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(index, handle)
+}
+
+func (ks *routedKeyStore) FindKeysLabel(label string) ([]pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return nil, err
+	}
+	handles, err := store.FindKeysLabel(label)
+	if err != nil {
+		return nil, err
+	}
+	return routeHandles(index, handles)
+}
+
+func (ks *routedKeyStore) FindKeyLabelReturnRandom(label string) (pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := store.FindKeyLabelReturnRandom(label)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(index, handle)
+}
+
+// FindAllKeys merges the keys of every token
+func (ks *routedKeyStore) FindAllKeys() (map[string][]pkcs11.ObjectHandle, error) {
+	result := make(map[string][]pkcs11.ObjectHandle)
+	for index := range ks.stores {
+		store, err := ks.store(index)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := store.FindAllKeys()
+		if err != nil && err.Error() == constants.ERROR_STRING_KEY_NOT_FOUND {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for label, handles := range keys {
+			routed, err := routeHandles(index, handles)
+			if err != nil {
+				return nil, err
+			}
+			result[label] = append(result[label], routed...)
+		}
+	}
+	if len(result) == 0 {
+		return result, errors.New(constants.ERROR_STRING_KEY_NOT_FOUND)
+	}
+	return result, nil
+}
+
+func (ks *routedKeyStore) FindPrivateKey(label string) (pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := store.FindPrivateKey(label)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(index, handle)
+}
+
+func (ks *routedKeyStore) FindPublicKey(label string) (pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := store.FindPublicKey(label)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(index, handle)
+}
+
+func (ks *routedKeyStore) GetObjectAttributes(handle pkcs11.ObjectHandle) (ObjectAttributes, error) {
+	store, tokenHandle, err := ks.storeForHandle(handle)
+	if err != nil {
+		return ObjectAttributes{}, err
+	}
+	attr, err := store.GetObjectAttributes(tokenHandle)
+	if err != nil {
+		return ObjectAttributes{}, err
+	}
+	attr.Handle = int32(handle)
+	return attr, nil
+}
+
+func (ks *routedKeyStore) GetValuesForObjects(handles []pkcs11.ObjectHandle) ([]ObjectAttributes, error) {
+	var result []ObjectAttributes
+	for _, handle := range handles {
+		attr, err := ks.GetObjectAttributes(handle)
+		if err != nil {
+			logger.AppLog.Errorf("Failed to get attributes for handle %d: %v", handle, err)
+			continue
+		}
+		result = append(result, attr)
+	}
+	return result, nil
+}
+
+func (ks *routedKeyStore) DescribeKey(handle pkcs11.ObjectHandle) (KeyDescription, error) {
+	store, tokenHandle, err := ks.storeForHandle(handle)
+	if err != nil {
+		return KeyDescription{}, err
+	}
+	return store.DescribeKey(tokenHandle)
+}
+
+func (ks *routedKeyStore) GetKeyMetadata(handle pkcs11.ObjectHandle) (KeyMetadata, error) {
+	store, tokenHandle, err := ks.storeForHandle(handle)
+	if err != nil {
+		return KeyMetadata{}, err
+	}
+	metadata, err := store.GetKeyMetadata(tokenHandle)
+	if err != nil {
+		return KeyMetadata{}, err
+	}
+	metadata.Handle = handle
+	return metadata, nil
+}
+
+func (ks *routedKeyStore) GetPublicKey(handle pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	store, tokenHandle, err := ks.storeForHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetPublicKey(tokenHandle)
+}
+
+func (ks *routedKeyStore) SupportsMechanism(label string, mechanism uint) bool {
+	store, _, err := ks.storeForLabel(label)
+	if err != nil {
+		return false
+	}
+	return store.SupportsMechanism(label, mechanism)
+}
+
+func (ks *routedKeyStore) GenerateAESKey(label string, id int32, bits int) (pkcs11.ObjectHandle, int32, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	handle, newID, err := store.GenerateAESKey(label, id, bits)
+	if err != nil {
+		return 0, 0, err
+	}
+	handle, err = routeHandle(index, handle)
+	return handle, newID, err
+}
+
+func (ks *routedKeyStore) GenerateDESKey(label string, id int32) (pkcs11.ObjectHandle, int32, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	handle, newID, err := store.GenerateDESKey(label, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	handle, err = routeHandle(index, handle)
+	return handle, newID, err
+}
+
+func (ks *routedKeyStore) GenerateDES3Key(label string, id int32) (pkcs11.ObjectHandle, int32, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	handle, newID, err := store.GenerateDES3Key(label, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	handle, err = routeHandle(index, handle)
+	return handle, newID, err
+}
+
+func (ks *routedKeyStore) GenerateMACKey(label string, id int32, keyType uint, bits int) (pkcs11.ObjectHandle, int32, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	handle, newID, err := store.GenerateMACKey(label, id, keyType, bits)
+	if err != nil {
+		return 0, 0, err
+	}
+	handle, err = routeHandle(index, handle)
+	return handle, newID, err
+}
+
+func (ks *routedKeyStore) GenerateRSAKeyPair(label string, bits int) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	pub, priv, err := store.GenerateRSAKeyPair(label, bits)
+	if err != nil {
+		return 0, 0, err
+	}
+	if pub, err = routeHandle(index, pub); err != nil {
+		return 0, 0, err
+	}
+	if priv, err = routeHandle(index, priv); err != nil {
+		return 0, 0, err
+	}
+	return pub, priv, nil
+}
+
+func (ks *routedKeyStore) GenerateECKeyPair(label string, curve string) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	pub, priv, err := store.GenerateECKeyPair(label, curve)
+	if err != nil {
+		return 0, 0, err
+	}
+	if pub, err = routeHandle(index, pub); err != nil {
+		return 0, 0, err
+	}
+	if priv, err = routeHandle(index, priv); err != nil {
+		return 0, 0, err
+	}
+	return pub, priv, nil
+}
+
+func (ks *routedKeyStore) GenerateECDHKeyPair(label string, id int32, curve string) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	pub, priv, err := store.GenerateECDHKeyPair(label, id, curve)
+	if err != nil {
+		return 0, 0, err
+	}
+	return routeKeyPair(index, pub, priv)
+}
+
+func (ks *routedKeyStore) ImportECDHKeyPair(label string, id int32, curve string, privateKey []byte) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	pub, priv, err := store.ImportECDHKeyPair(label, id, curve, privateKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	return routeKeyPair(index, pub, priv)
+}
+
+func (ks *routedKeyStore) FindECDHKeyPair(label string, id int32) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	pub, priv, err := store.FindECDHKeyPair(label, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	return routeKeyPair(index, pub, priv)
+}
+
+func (ks *routedKeyStore) GenerateWrapKey(label string, id int32, bits int) (pkcs11.ObjectHandle, int32, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	handle, newID, err := store.GenerateWrapKey(label, id, bits)
+	if err != nil {
+		return 0, 0, err
+	}
+	handle, err = routeHandle(index, handle)
+	return handle, newID, err
+}
+
+func (ks *routedKeyStore) GenerateRSAWrapKeyPair(label string, bits int) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	pub, priv, err := store.GenerateRSAWrapKeyPair(label, bits)
+	if err != nil {
+		return 0, 0, err
+	}
+	return routeKeyPair(index, pub, priv)
+}
+
+func (ks *routedKeyStore) StoreKey(label string, key []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := store.StoreKey(label, key, id, keyType)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(index, handle)
+}
+
+func (ks *routedKeyStore) UpdateKey(label string, newKeyValue []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := store.UpdateKey(label, newKeyValue, id, keyType)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(index, handle)
+}
+
+func (ks *routedKeyStore) DeleteKey(label string, id int32) error {
+	store, _, err := ks.storeForLabel(label)
+	if err != nil {
+		return err
+	}
+	return store.DeleteKey(label, id)
+}
+
+func (ks *routedKeyStore) SetKeyMetadata(handle pkcs11.ObjectHandle, createdAt time.Time, validity KeyValidity) error {
+	store, tokenHandle, err := ks.storeForHandle(handle)
+	if err != nil {
+		return err
+	}
+	return store.SetKeyMetadata(tokenHandle, createdAt, validity)
+}
+
+func (ks *routedKeyStore) SetKeyId(handle pkcs11.ObjectHandle, id int32) error {
+	store, tokenHandle, err := ks.storeForHandle(handle)
+	if err != nil {
+		return err
+	}
+	return store.SetKeyId(tokenHandle, id)
+}
+
+func (ks *routedKeyStore) GenerateSigningKeyPair(label string, id int32, keyType uint) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	pub, priv, err := store.GenerateSigningKeyPair(label, id, keyType)
+	if err != nil {
+		return 0, 0, err
+	}
+	return routeKeyPair(index, pub, priv)
+}
+
+func (ks *routedKeyStore) GetKeyPairVersions(label string) ([]KeyPairVersion, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := store.GetKeyPairVersions(label)
+	if err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		if versions[i].Public, versions[i].Private, err = routeKeyPair(index, versions[i].Public, versions[i].Private); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+func (ks *routedKeyStore) DeleteKeyPair(label string, id int32) error {
+	store, _, err := ks.storeForLabel(label)
+	if err != nil {
+		return err
+	}
+	return store.DeleteKeyPair(label, id)
+}
+
+func (ks *routedKeyStore) GetKeyVersions(label string) ([]KeyVersion, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := store.GetKeyVersions(label)
+	if err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		if versions[i].Handle, err = routeHandle(index, versions[i].Handle); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+func (ks *routedKeyStore) StoreKeyVersion(label string, key []byte, id int32, keyType string) (pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := store.StoreKeyVersion(label, key, id, keyType)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(index, handle)
+}
+
+func (ks *routedKeyStore) RetireKey(handle pkcs11.ObjectHandle, retireAt time.Time) error {
+	store, tokenHandle, err := ks.storeForHandle(handle)
+	if err != nil {
+		return err
+	}
+	return store.RetireKey(tokenHandle, retireAt)
+}
+
+func (ks *routedKeyStore) WrapKey(wrappingKey, key pkcs11.ObjectHandle, mechanism uint) ([]byte, error) {
+	store, tokenHandle, err := ks.storeForHandle(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+	if wrappingKey>>tokenHandleShift != key>>tokenHandleShift {
+		return nil, errors.New("the key and the wrapping key must be on the same token")
+	}
+	return store.WrapKey(tokenHandle, key&tokenHandleMask, mechanism)
+}
+
+func (ks *routedKeyStore) WrapKeyRSA(pub *rsa.PublicKey, key pkcs11.ObjectHandle) ([]byte, error) {
+	store, tokenHandle, err := ks.storeForHandle(key)
+	if err != nil {
+		return nil, err
+	}
+	return store.WrapKeyRSA(pub, tokenHandle)
+}
+
+func (ks *routedKeyStore) UnwrapKey(unwrappingKey pkcs11.ObjectHandle, mechanism uint, wrapped []byte, label string, id int32, keyType string, exportable bool) (pkcs11.ObjectHandle, error) {
+	store, tokenHandle, err := ks.storeForHandle(unwrappingKey)
+	if err != nil {
+		return 0, err
+	}
+	index := ks.router.tokenIndex(label)
+	if int(uint(unwrappingKey)>>tokenHandleShift) != index {
+		return 0, fmt.Errorf("label %s is not on the token of the unwrapping key", label)
+	}
+	handle, err := store.UnwrapKey(tokenHandle, mechanism, wrapped, label, id, keyType, exportable)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(index, handle)
+}
+
+func (ks *routedKeyStore) CreateSessionWrapKey(label string, key []byte) (pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := store.CreateSessionWrapKey(label, key)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(index, handle)
+}
+
+func (ks *routedKeyStore) DestroySessionKey(handle pkcs11.ObjectHandle) error {
+	store, tokenHandle, err := ks.storeForHandle(handle)
+	if err != nil {
+		return err
+	}
+	return store.DestroySessionKey(tokenHandle)
+}
+
+func (ks *routedKeyStore) CreateSessionComponentKey(label string, component []byte) (pkcs11.ObjectHandle, error) {
+	store, index, err := ks.storeForLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := store.CreateSessionComponentKey(label, component)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(index, handle)
+}
+
+func (ks *routedKeyStore) XORKeyComponent(base pkcs11.ObjectHandle, component []byte) (pkcs11.ObjectHandle, error) {
+	store, tokenHandle, err := ks.storeForHandle(base)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := store.XORKeyComponent(tokenHandle, component)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(int(uint(base)>>tokenHandleShift), handle)
+}
+
+func (ks *routedKeyStore) DeriveComponentKey(base pkcs11.ObjectHandle, label string, id int32, keyType string, keyLen int, store bool) (pkcs11.ObjectHandle, error) {
+	tokenStore, tokenHandle, err := ks.storeForHandle(base)
+	if err != nil {
+		return 0, err
+	}
+	index := int(uint(base) >> tokenHandleShift)
+	if ks.router.tokenIndex(label) != index {
+		return 0, fmt.Errorf("label %s is not on the token of the key components", label)
+	}
+	handle, err := tokenStore.DeriveComponentKey(tokenHandle, label, id, keyType, keyLen, store)
+	if err != nil {
+		return 0, err
+	}
+	return routeHandle(index, handle)
+}
+
+func (ks *routedKeyStore) EncryptKey(keyHandle pkcs11.ObjectHandle, iv, plaintext []byte, mechanism uint) ([]byte, error) {
+	store, tokenHandle, err := ks.storeForHandle(keyHandle)
+	if err != nil {
+		return nil, err
+	}
+	return store.EncryptKey(tokenHandle, iv, plaintext, mechanism)
+}
+
+func (ks *routedKeyStore) DecryptKey(keyHandle pkcs11.ObjectHandle, iv, ciphertext []byte, mechanism uint) ([]byte, error) {
+	store, tokenHandle, err := ks.storeForHandle(keyHandle)
+	if err != nil {
+		return nil, err
+	}
+	return store.DecryptKey(tokenHandle, iv, ciphertext, mechanism)
+}
+
+func (ks *routedKeyStore) EncryptKeyAesGCM(keyHandle pkcs11.ObjectHandle, iv, plaintext, aad []byte) ([]byte, error) {
+	store, tokenHandle, err := ks.storeForHandle(keyHandle)
+	if err != nil {
+		return nil, err
+	}
+	return store.EncryptKeyAesGCM(tokenHandle, iv, plaintext, aad)
+}
+
+func (ks *routedKeyStore) DecryptKeyAesGCM(keyHandle pkcs11.ObjectHandle, iv, ciphertext, aad []byte) ([]byte, error) {
+	store, tokenHandle, err := ks.storeForHandle(keyHandle)
+	if err != nil {
+		return nil, err
+	}
+	return store.DecryptKeyAesGCM(tokenHandle, iv, ciphertext, aad)
+}
+
+func (ks *routedKeyStore) DeriveECDH(privateKey pkcs11.ObjectHandle, peerPublic []byte) ([]byte, error) {
+	store, tokenHandle, err := ks.storeForHandle(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return store.DeriveECDH(tokenHandle, peerPublic)
+}
+
+func (ks *routedKeyStore) Sign(keyHandle pkcs11.ObjectHandle, mechanism uint, data []byte) ([]byte, error) {
+	store, tokenHandle, err := ks.storeForHandle(keyHandle)
+	if err != nil {
+		return nil, err
+	}
+	return store.Sign(tokenHandle, mechanism, data)
+}
+
+func (ks *routedKeyStore) Verify(keyHandle pkcs11.ObjectHandle, mechanism uint, data, signature []byte) error {
+	store, tokenHandle, err := ks.storeForHandle(keyHandle)
+	if err != nil {
+		return err
+	}
+	return store.Verify(tokenHandle, mechanism, data, signature)
+}
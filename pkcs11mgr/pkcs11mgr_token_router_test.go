@@ -0,0 +1,187 @@
+package pkcs11mgr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+)
+
+// limitedProvider is a memory token with a pool of one session, it records the
+// order its KeyStores are taken in
+type limitedProvider struct {
+	*MemoryProvider
+	index int
+	free  chan struct{}
+	taken *[]int
+	mu    *sync.Mutex
+}
+
+func (p *limitedProvider) GetKeyStore(ctx context.Context) (KeyStore, error) {
+	select {
+	case <-p.free:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	p.mu.Lock()
+	*p.taken = append(*p.taken, p.index)
+	p.mu.Unlock()
+	return p.MemoryProvider, nil
+}
+
+func (p *limitedProvider) ReleaseKeyStore(ks KeyStore) {
+	p.free <- struct{}{}
+}
+
+// newTestRouter routes the k4 family to a second token, the other labels stay
+// on the first one
+func newTestRouter(t *testing.T) (*TokenRouter, []*limitedProvider, *[]int) {
+	t.Helper()
+	var taken []int
+	var mu sync.Mutex
+	router := NewTokenRouter()
+	var providers []*limitedProvider
+	for i, token := range []struct {
+		name     string
+		families []string
+	}{
+		{"main", nil},
+		{"k4", []string{constants.LABEL_FAMILY_K4}},
+	} {
+		p := &limitedProvider{MemoryProvider: NewMemoryProvider(), index: i, free: make(chan struct{}, 1), taken: &taken, mu: &mu}
+		p.free <- struct{}{}
+		if err := router.AddToken(token.name, p, token.families); err != nil {
+			t.Fatal(err)
+		}
+		providers = append(providers, p)
+	}
+	return router, providers, &taken
+}
+
+func TestTokenRouterRoutesLabels(t *testing.T) {
+	router, providers, _ := newTestRouter(t)
+	if name := router.TokenFor(constants.LABEL_K4_KEY_AES); name != "k4" {
+		t.Fatalf("K4 label routed to %q", name)
+	}
+	if name := router.TokenFor(constants.LABEL_ENCRYPTION_KEY_AES128); name != "main" {
+		t.Fatalf("encryption label routed to %q", name)
+	}
+
+	ks, err := router.GetKeyStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.ReleaseKeyStore(ks)
+	k4, err := ks.StoreKey(constants.LABEL_K4_KEY_AES, bytes.Repeat([]byte{1}, 16), 1, constants.TYPE_AES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := ks.StoreKey(constants.LABEL_ENCRYPTION_KEY_AES128, bytes.Repeat([]byte{2}, 16), 1, constants.TYPE_AES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k4>>tokenHandleShift != 1 || enc>>tokenHandleShift != 0 {
+		t.Fatalf("handles %#x and %#x do not carry their token", k4, enc)
+	}
+	if _, err := providers[1].FindKey(constants.LABEL_K4_KEY_AES, 1); err != nil {
+		t.Fatalf("K4 key not on the k4 token: %v", err)
+	}
+	if _, err := providers[0].FindKey(constants.LABEL_K4_KEY_AES, 1); err == nil {
+		t.Fatal("K4 key on the main token")
+	}
+
+	found, err := ks.FindKey(constants.LABEL_K4_KEY_AES, 1)
+	if err != nil || found != k4 {
+		t.Fatalf("FindKey = %#x, %v, want %#x", found, err, k4)
+	}
+	attr, err := ks.GetObjectAttributes(k4)
+	if err != nil || attr.Handle != int32(k4) || attr.Id != 1 {
+		t.Fatalf("attributes = %+v, %v", attr, err)
+	}
+	all, err := ks.FindAllKeys()
+	if err != nil || len(all[constants.LABEL_K4_KEY_AES]) != 1 || len(all[constants.LABEL_ENCRYPTION_KEY_AES128]) != 1 {
+		t.Fatalf("FindAllKeys = %v, %v", all, err)
+	}
+	if _, err := ks.WrapKey(enc, k4, pkcs11.CKM_AES_KEY_WRAP_PAD); err == nil {
+		t.Fatal("a key was wrapped under a key of another token")
+	}
+}
+
+func TestTokenRouterRejectsInvalidHandles(t *testing.T) {
+	router, providers, _ := newTestRouter(t)
+	ks, err := router.GetKeyStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.ReleaseKeyStore(ks)
+
+	// a handle of a token that is not configured
+	if _, err := ks.GetObjectAttributes(5 << tokenHandleShift); !errors.Is(err, ErrHandleNotRoutable) {
+		t.Fatalf("handle of token 5: %v, want %v", err, ErrHandleNotRoutable)
+	}
+
+	// a token handle that would spill into the token bits
+	providers[1].nextHandle = 1 << tokenHandleShift
+	if _, err := ks.StoreKey(constants.LABEL_K4_KEY_AES, bytes.Repeat([]byte{1}, 16), 1, constants.TYPE_AES); !errors.Is(err, ErrHandleNotRoutable) {
+		t.Fatalf("handle 2^24: %v, want %v", err, ErrHandleNotRoutable)
+	}
+	if _, err := ks.FindKey(constants.LABEL_K4_KEY_AES, 1); !errors.Is(err, ErrHandleNotRoutable) {
+		t.Fatalf("FindKey of handle 2^24: %v, want %v", err, ErrHandleNotRoutable)
+	}
+}
+
+// TestTokenRouterTokenOrder runs requests that use the two tokens in opposite
+// orders on pools of one session, they must not deadlock
+func TestTokenRouterTokenOrder(t *testing.T) {
+	router, _, taken := newTestRouter(t)
+	ks, err := router.GetKeyStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.FindKey(constants.LABEL_K4_KEY_AES, 1); err == nil {
+		t.Fatal("found a key in an empty token")
+	}
+	router.ReleaseKeyStore(ks)
+	if len(*taken) != 2 || (*taken)[0] != 0 || (*taken)[1] != 1 {
+		t.Fatalf("tokens taken in order %v, want [0 1]", *taken)
+	}
+
+	labels := [][]string{
+		{constants.LABEL_K4_KEY_AES, constants.LABEL_ENCRYPTION_KEY_AES128},
+		{constants.LABEL_ENCRYPTION_KEY_AES128, constants.LABEL_K4_KEY_AES},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*20)
+	for _, order := range labels {
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ks, err := router.GetKeyStore(ctx)
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer router.ReleaseKeyStore(ks)
+				for _, label := range order {
+					if _, err := ks.FindKeysLabel(label); errors.Is(err, context.DeadlineExceeded) {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("request failed: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package pkcs11mgr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+// TokenSelector identifies the token of a Manager. Label and Serial take
+// precedence over Slot because SoftHSM assigns a new slot ID every time a
+// token is initialised; when both are set the token must match both.
+type TokenSelector struct {
+	Slot   uint
+	Label  string
+	Serial string
+}
+
+func (t TokenSelector) String() string {
+	switch {
+	case t.Label != "" && t.Serial != "":
+		return fmt.Sprintf("token with label %q and serial %q", t.Label, t.Serial)
+	case t.Label != "":
+		return fmt.Sprintf("token with label %q", t.Label)
+	case t.Serial != "":
+		return fmt.Sprintf("token with serial %q", t.Serial)
+	default:
+		return fmt.Sprintf("slot %d", t.Slot)
+	}
+}
+
+// resolveSlot returns the slot ID of the selected token. It lists the slots
+// with a token present and fails with the available tokens when none matches.
+func resolveSlot(api moduleAPI, token TokenSelector) (uint, error) {
+	if token.Label == "" && token.Serial == "" {
+		return token.Slot, nil
+	}
+
+	slots, err := api.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("listing the PKCS#11 slots: %w", err)
+	}
+
+	var matches []uint
+	var available []string
+	for _, slot := range slots {
+		info, err := api.GetTokenInfo(slot)
+		if err != nil {
+			logger.AppLog.Warnf("Failed to read the token info of slot %d: %v", slot, err)
+			continue
+		}
+
+		label := strings.TrimRight(info.Label, "\x00 ")
+		serial := strings.TrimRight(info.SerialNumber, "\x00 ")
+		available = append(available, fmt.Sprintf("slot %d (label %q, serial %q)", slot, label, serial))
+
+		if (token.Label == "" || label == token.Label) && (token.Serial == "" || serial == token.Serial) {
+			matches = append(matches, slot)
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		logger.AppLog.Infof("Found %s in slot %d", token, matches[0])
+		return matches[0], nil
+	case 0:
+		if len(available) == 0 {
+			return 0, fmt.Errorf("no %s found, the PKCS#11 module has no initialised tokens", token)
+		}
+		return 0, fmt.Errorf("no %s found, available tokens: %s", token, strings.Join(available, ", "))
+	default:
+		return 0, fmt.Errorf("%d tokens match the %s, set the serial to pick one: %s", len(matches), token, strings.Join(available, ", "))
+	}
+}
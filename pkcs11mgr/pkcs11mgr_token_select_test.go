@@ -0,0 +1,64 @@
+package pkcs11mgr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestResolveSlot(t *testing.T) {
+	// SoftHSM pads the label and serial of a token with spaces
+	f := newFakeModule(map[uint]pkcs11.TokenInfo{
+		11: fakeToken("ssm                             ", "a1b2            "),
+		12: fakeToken("backup", "c3d4"),
+		13: fakeToken("backup", "e5f6"),
+	})
+
+	for _, tc := range []struct {
+		name  string
+		token TokenSelector
+		slot  uint
+	}{
+		{"by slot", TokenSelector{Slot: 7}, 7},
+		{"by label", TokenSelector{Slot: 7, Label: "ssm"}, 11},
+		{"by serial", TokenSelector{Serial: "e5f6"}, 13},
+		{"by label and serial", TokenSelector{Label: "backup", Serial: "c3d4"}, 12},
+	} {
+		slot, err := resolveSlot(f, tc.token)
+		if err != nil || slot != tc.slot {
+			t.Fatalf("%s: slot %d, %v, want slot %d", tc.name, slot, err, tc.slot)
+		}
+	}
+
+	available := []string{
+		`slot 11 (label "ssm", serial "a1b2")`,
+		`slot 12 (label "backup", serial "c3d4")`,
+		`slot 13 (label "backup", serial "e5f6")`,
+	}
+	for _, tc := range []struct {
+		name  string
+		token TokenSelector
+		want  string
+	}{
+		{"ambiguous label", TokenSelector{Label: "backup"}, `2 tokens match the token with label "backup", set the serial to pick one`},
+		{"unknown label", TokenSelector{Label: "missing"}, `no token with label "missing" found, available tokens`},
+		{"unknown serial", TokenSelector{Serial: "0000"}, `no token with serial "0000" found, available tokens`},
+		{"label and serial of two tokens", TokenSelector{Label: "ssm", Serial: "c3d4"}, `no token with label "ssm" and serial "c3d4" found`},
+	} {
+		_, err := resolveSlot(f, tc.token)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: %v, want %q", tc.name, err, tc.want)
+		}
+		for _, token := range available {
+			if !strings.Contains(err.Error(), token) {
+				t.Fatalf("%s: %v does not list %s", tc.name, err, token)
+			}
+		}
+	}
+
+	empty := newFakeModule(map[uint]pkcs11.TokenInfo{})
+	if _, err := resolveSlot(empty, TokenSelector{Label: "ssm"}); err == nil || !strings.Contains(err.Error(), "has no initialised tokens") {
+		t.Fatalf("module without tokens: %v", err)
+	}
+}
@@ -11,8 +11,20 @@ import (
 )
 
 type ObjectAttributes struct {
-	Handle int32
-	Id     int32
+	Handle   int32
+	Id       int32
+	Validity KeyValidity // checked before the key encrypts, see CheckKeyValidity
+}
+
+// KeyDescription identifies a secret key across tokens, the replication
+// compares the descriptions of both tokens
+type KeyDescription struct {
+	Label       string
+	Id          int32
+	KeyType     string // TYPE_AES, TYPE_DES, TYPE_DES3 or TYPE_GENERIC_SECRET, empty for the other key types
+	CheckValue  []byte // CKA_CHECK_VALUE, empty when the token does not provide it
+	Extractable bool
+	Encrypt     bool // CKA_ENCRYPT, see GetKeyCheckValues
 }
 
 // FindKey returns the object handle for a given label, or 0 if not found return a one key
@@ -152,21 +164,20 @@ func GetValuesForObjects(o []pkcs11.ObjectHandle, s Session) ([]ObjectAttributes
 			logger.AppLog.Errorf("Failed to get attributes for handle %d: %v", handle, err)
 			continue
 		}
-		result = append(result, ObjectAttributes{
-			Handle: int32(handle),
-			Id:     attr.Id,
-		})
+		result = append(result, attr)
 	}
 	return result, nil
 }
 
-// GetObjectAttributes retrieves the CKA_ID and CKA_VALUE_LEN attributes for a given object handle
+// GetObjectAttributes retrieves the CKA_ID and the validity dates for a given object handle
 func GetObjectAttributes(handle pkcs11.ObjectHandle, s Session) (ObjectAttributes, error) {
 	logger.AppLog.Infof("Getting attributes for object handle: %d", handle)
 
 	// Define the attributes we want to retrieve
 	template := []*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_START_DATE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_END_DATE, nil),
 	}
 
 	// Get the attribute values
@@ -186,6 +197,10 @@ func GetObjectAttributes(handle pkcs11.ObjectHandle, s Session) (ObjectAttribute
 			if len(attr.Value) > 0 {
 				result.Id = utils.ByteToInt32(attr.Value)
 			}
+		case pkcs11.CKA_START_DATE:
+			result.Validity.Start = parseCKDate(attr.Value)
+		case pkcs11.CKA_END_DATE:
+			result.Validity.End = parseCKDate(attr.Value)
 		}
 	}
 
@@ -193,6 +208,68 @@ func GetObjectAttributes(handle pkcs11.ObjectHandle, s Session) (ObjectAttribute
 	return result, nil
 }
 
+// DescribeKey reads the label, id, key type and check value of a secret key
+func DescribeKey(handle pkcs11.ObjectHandle, s Session) (KeyDescription, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, nil),
+	}
+	attrs, err := s.Ctx.GetAttributeValue(s.Handle, handle, template)
+	if err != nil {
+		logger.AppLog.Errorf("GetAttributeValue failed for handle %d: %v", handle, err)
+		return KeyDescription{}, err
+	}
+
+	var result KeyDescription
+	for _, attr := range attrs {
+		switch attr.Type {
+		case pkcs11.CKA_LABEL:
+			result.Label = string(attr.Value)
+		case pkcs11.CKA_ID:
+			if len(attr.Value) > 0 {
+				result.Id = utils.ByteToInt32(attr.Value)
+			}
+		case pkcs11.CKA_KEY_TYPE:
+			result.KeyType = keyTypeName(attributeUint(attr))
+		case pkcs11.CKA_EXTRACTABLE:
+			result.Extractable = len(attr.Value) > 0 && attr.Value[0] != 0
+		case pkcs11.CKA_ENCRYPT:
+			result.Encrypt = len(attr.Value) > 0 && attr.Value[0] != 0
+		}
+	}
+
+	// CKA_CHECK_VALUE is read on its own, tokens older than PKCS#11 v2.40 fail
+	// the whole template when they do not know an attribute
+	attrs, err = s.Ctx.GetAttributeValue(s.Handle, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CHECK_VALUE, nil),
+	})
+	if err != nil {
+		logger.AppLog.Warnf("No check value for handle %d: %v", handle, err)
+	} else if len(attrs) > 0 {
+		result.CheckValue = attrs[0].Value
+	}
+	return result, nil
+}
+
+// keyTypeName maps a PKCS#11 secret key type to its key type name
+func keyTypeName(keyType uint) string {
+	switch keyType {
+	case pkcs11.CKK_AES:
+		return constants.TYPE_AES
+	case pkcs11.CKK_DES3:
+		return constants.TYPE_DES3
+	case pkcs11.CKK_DES:
+		return constants.TYPE_DES
+	case pkcs11.CKK_GENERIC_SECRET:
+		return constants.TYPE_GENERIC_SECRET
+	default:
+		return ""
+	}
+}
+
 // GetObjectLabel retrieves the CKA_LABEL attribute for a given object handle
 func GetObjectLabel(handle pkcs11.ObjectHandle, s Session) (string, error) {
 	template := []*pkcs11.Attribute{
@@ -0,0 +1,261 @@
+package pkcs11mgr
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/utils"
+)
+
+// Key wrap mechanism names of the API
+const (
+	WRAP_AES_KEY_WRAP     = "AES-KEY-WRAP"
+	WRAP_AES_KEY_WRAP_PAD = "AES-KEY-WRAP-PAD"
+	WRAP_RSA_OAEP         = "RSA-OAEP"
+)
+
+var WrapMechanisms = map[string]uint{
+	WRAP_AES_KEY_WRAP:     pkcs11.CKM_AES_KEY_WRAP,
+	WRAP_AES_KEY_WRAP_PAD: pkcs11.CKM_AES_KEY_WRAP_PAD,
+	WRAP_RSA_OAEP:         pkcs11.CKM_RSA_PKCS_OAEP,
+}
+
+// wrapMechanism builds the PKCS#11 mechanism of a key wrap mechanism,
+// CKM_RSA_PKCS_OAEP always uses SHA-256 with MGF1-SHA256
+func wrapMechanism(mechanism uint) ([]*pkcs11.Mechanism, error) {
+	switch mechanism {
+	case pkcs11.CKM_AES_KEY_WRAP, pkcs11.CKM_AES_KEY_WRAP_PAD:
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, nil
+	case pkcs11.CKM_RSA_PKCS_OAEP:
+		params := pkcs11.NewOAEPParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, pkcs11.CKZ_DATA_SPECIFIED, nil)
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, params)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key wrap mechanism 0x%X", mechanism)
+	}
+}
+
+// secretKeyType maps a key type name to its PKCS#11 key type
+func secretKeyType(keyType string) (uint, error) {
+	switch keyType {
+	case constants.TYPE_AES:
+		return pkcs11.CKK_AES, nil
+	case constants.TYPE_DES3:
+		return pkcs11.CKK_DES3, nil
+	case constants.TYPE_DES:
+		return pkcs11.CKK_DES, nil
+	case constants.TYPE_GENERIC_SECRET:
+		return pkcs11.CKK_GENERIC_SECRET, nil
+	default:
+		return 0, errors.New("unsupported key type")
+	}
+}
+
+// GenerateWrapKey creates an AES key that can only wrap and unwrap keys inside
+// SoftHSM. It is extractable so it can be sent to a peer SSM with WrapKeyRSA.
+func GenerateWrapKey(label string, id int32, bits int, s Session) (pkcs11.ObjectHandle, int32, error) {
+	logger.AppLog.Infof("Generating wrap key: label=%s, bits=%d", label, bits)
+	if id == 0 {
+		logger.AppLog.Info("The id is zero, return the last id + 1")
+		var err error
+		id, err = ReturnLastIDForLabel(label, s)
+		if err != nil {
+			logger.AppLog.Errorf("Error detect %s", err)
+			return 0, 0, err
+		}
+	}
+	template := append([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)),
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, bits/8),
+	}, keyUsage{wrap: true, unwrap: true, extractable: true}.attributes()...)
+
+	existingHandle, err := FindKey(label, id, s)
+	if err == nil && existingHandle != 0 {
+		logger.AppLog.Infof("Key with label '%s' already exists, returning existing handle: %v", label, existingHandle)
+		return existingHandle, id, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+	}
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}
+	handle, err := s.Ctx.GenerateKey(s.Handle, mech, template)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to generate wrap key: %v", err)
+		return 0, 0, err
+	}
+	logger.AppLog.Infof("Wrap key generated successfully: handle=%v", handle)
+	return handle, id, nil
+}
+
+// GenerateRSAWrapKeyPair creates an RSA key pair whose private key can only
+// unwrap keys, peers wrap keys for this SSM under the public key
+func GenerateRSAWrapKeyPair(label string, bits int, s Session) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	logger.AppLog.Infof("Generating RSA wrap key pair: label=%s, bits=%d", label, bits)
+
+	if _, err := findPrivateKeyByLabel(label, s); err == nil {
+		return 0, 0, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+	}
+
+	publicKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_WRAP, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+	}
+	privateKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_UNWRAP, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)}
+	pubKey, privKey, err := s.Ctx.GenerateKeyPair(s.Handle, mechanism, publicKeyTemplate, privateKeyTemplate)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to generate RSA wrap key pair: %v", err)
+		return 0, 0, err
+	}
+	logger.AppLog.Infof("RSA wrap key pair generated successfully - Public: %d, Private: %d", pubKey, privKey)
+	return pubKey, privKey, nil
+}
+
+// WrapKey exports an extractable secret key encrypted under a wrapping key of
+// the same token
+func WrapKey(wrappingKey, key pkcs11.ObjectHandle, mechanism uint, s Session) ([]byte, error) {
+	mech, err := wrapMechanism(mechanism)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := s.Ctx.WrapKey(s.Handle, mech, wrappingKey, key)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to wrap key %d: %v", key, err)
+		return nil, err
+	}
+	return wrapped, nil
+}
+
+// WrapKeyRSA exports an extractable secret key with RSA-OAEP under the public
+// key of a peer. The public key is only created as a session object.
+func WrapKeyRSA(pub *rsa.PublicKey, key pkcs11.ObjectHandle, s Session) ([]byte, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false), // session object only
+		pkcs11.NewAttribute(pkcs11.CKA_WRAP, true),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, pub.N.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, big.NewInt(int64(pub.E)).Bytes()),
+	}
+	wrappingKey, err := s.Ctx.CreateObject(s.Handle, template)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to create the peer public key: %v", err)
+		return nil, err
+	}
+	defer func() {
+		if err := s.Ctx.DestroyObject(s.Handle, wrappingKey); err != nil {
+			logger.AppLog.Warnf("Failed to destroy the peer public key: %v", err)
+		}
+	}()
+	return WrapKey(wrappingKey, key, pkcs11.CKM_RSA_PKCS_OAEP, s)
+}
+
+// CreateSessionWrapKey imports an AES key that only wraps and unwraps keys as a
+// session object, it is never stored on the token and it is destroyed with
+// DestroySessionKey or when the session is closed
+func CreateSessionWrapKey(key []byte, s Session) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, key),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false), // session object only
+		pkcs11.NewAttribute(pkcs11.CKA_WRAP, true),
+		pkcs11.NewAttribute(pkcs11.CKA_UNWRAP, true),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, false),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	}
+
+	handle, err := s.Ctx.CreateObject(s.Handle, template)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to create the session wrap key: %v", err)
+		return 0, err
+	}
+	return handle, nil
+}
+
+// DestroySessionKey destroys a key created with CreateSessionWrapKey
+func DestroySessionKey(handle pkcs11.ObjectHandle, s Session) error {
+	if err := s.Ctx.DestroyObject(s.Handle, handle); err != nil {
+		logger.AppLog.Errorf("Failed to destroy the session key %d: %v", handle, err)
+		return err
+	}
+	return nil
+}
+
+// UnwrapKey imports a wrapped secret key under label and id. The key gets the
+// usage StoreKey gives the label, exportable keys can be wrapped again.
+func UnwrapKey(unwrappingKey pkcs11.ObjectHandle, mechanism uint, wrapped []byte, label string, id int32, keyType string, exportable bool, s Session) (pkcs11.ObjectHandle, error) {
+	logger.AppLog.Infof("Unwrapping key: label=%s, id=%d, keyType=%s", label, id, keyType)
+	mech, err := wrapMechanism(mechanism)
+	if err != nil {
+		return 0, err
+	}
+	keyTypeuint, err := secretKeyType(keyType)
+	if err != nil {
+		return 0, err
+	}
+
+	usage := unwrappedKeyUsage(label, exportable)
+	template := append([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, utils.Int32ToByte(id)),
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyTypeuint),
+	}, usage.attributes()...)
+
+	existingHandle, err := FindKey(label, id, s)
+	if err == nil && existingHandle != 0 {
+		logger.AppLog.Infof("Key with label '%s' already exists, returning existing handle: %v", label, existingHandle)
+		return existingHandle, errors.New(constants.ERROR_STRING_KEY_EXISTS)
+	}
+
+	handle, err := s.Ctx.UnwrapKey(s.Handle, mech, unwrappingKey, wrapped, template)
+	if err != nil {
+		logger.AppLog.Errorf("Failed to unwrap key: %v", err)
+		return 0, err
+	}
+	logger.AppLog.Infof("Key unwrapped successfully: handle=%v", handle)
+	return handle, nil
+}
+
+// unwrappedKeyUsage returns the usage of an unwrapped key: the usage StoreKey
+// gives the label, the key encryption and internal keys encrypt as when they
+// are generated. A transport or replication key received from a peer is only
+// extractable when exportable. A received transport key only unwraps: anyone
+// can wrap a key under the public TRANSPORT_RSA key, a transport key planted
+// that way must not export the keys of this SSM.
+func unwrappedKeyUsage(label string, exportable bool) keyUsage {
+	family := constants.LabelFamilyMap[label]
+	usage := storedKeyUsage(label, family == constants.LABEL_FAMILY_ENCRYPTION || family == constants.LABEL_FAMILY_INTERNAL)
+	if label == constants.LABEL_TRANSPORT_KEY || label == constants.LABEL_REPLICATION_KEY {
+		usage.extractable = exportable
+		if label == constants.LABEL_TRANSPORT_KEY {
+			usage.wrap = false
+		}
+	} else {
+		usage.extractable = usage.extractable || exportable
+	}
+	return usage
+}
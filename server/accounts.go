@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	constants "github.com/networkgcorefullcode/ssm/const"
+	"github.com/networkgcorefullcode/ssm/database"
+	"github.com/networkgcorefullcode/ssm/handlers"
+	"github.com/networkgcorefullcode/ssm/models"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/urfave/cli/v3"
+)
+
+// accountCommand returns the subcommands that manage the service accounts of
+// /login, the passwords are printed once and never written to disk
+func (ssm *SSM) accountCommand() *cli.Command {
+	serviceIDFlag := &cli.StringFlag{Name: "service-id", Usage: "service id of the account", Required: true}
+	return &cli.Command{
+		Name:  "account",
+		Usage: "manage the service accounts of the API",
+		Commands: []*cli.Command{
+			{
+				Name:  "create",
+				Usage: "create a service account and print its password",
+				Flags: []cli.Flag{
+					serviceIDFlag,
+					&cli.StringFlag{Name: "role", Usage: "role of the account, udm or webconsole", Value: constants.ROLE_UDM},
+				},
+				Action: ssm.accountCreate,
+			},
+			{
+				Name:   "reset",
+				Usage:  "generate a new password for a service account and print it",
+				Flags:  []cli.Flag{serviceIDFlag},
+				Action: ssm.accountReset,
+			},
+			{
+				Name:   "disable",
+				Usage:  "disable a service account and revoke its tokens",
+				Flags:  []cli.Flag{serviceIDFlag},
+				Action: ssm.accountDisable,
+			},
+			{
+				Name:   "list",
+				Usage:  "list the service accounts with their last login",
+				Action: ssm.accountList,
+			},
+		},
+	}
+}
+
+// withAccounts loads the configuration and connects to MongoDB before f
+func (ssm *SSM) withAccounts(c *cli.Command, f func() error) error {
+	if err := ssm.Initialize(c); err != nil {
+		return err
+	}
+	if err := database.Connect(); err != nil {
+		return fmt.Errorf("mongodb: %w", err)
+	}
+	return f()
+}
+
+func printServicePassword(response models.ServiceAccountPasswordResponse) {
+	fmt.Fprintf(os.Stdout, "%s: service_id %s, role %s\npassword %s\n", response.Message, response.ServiceId, response.Role, response.Password)
+}
+
+func (ssm *SSM) accountCreate(ctx context.Context, c *cli.Command) error {
+	return ssm.withKeyStore(ctx, c, func(ks pkcs11mgr.KeyStore) error {
+		if err := database.Connect(); err != nil {
+			return fmt.Errorf("mongodb: %w", err)
+		}
+		response, err := handlers.CreateServiceAccount(ks, c.String("service-id"), c.String("role"))
+		if err != nil {
+			return err
+		}
+		printServicePassword(response)
+		return nil
+	})
+}
+
+func (ssm *SSM) accountReset(ctx context.Context, c *cli.Command) error {
+	return ssm.withKeyStore(ctx, c, func(ks pkcs11mgr.KeyStore) error {
+		if err := database.Connect(); err != nil {
+			return fmt.Errorf("mongodb: %w", err)
+		}
+		response, err := handlers.ResetServicePassword(ks, c.String("service-id"))
+		if err != nil {
+			return err
+		}
+		printServicePassword(response)
+		return nil
+	})
+}
+
+func (ssm *SSM) accountDisable(ctx context.Context, c *cli.Command) error {
+	return ssm.withAccounts(c, func() error {
+		response, err := handlers.DisableServiceAccount(c.String("service-id"))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s: service_id %s, %d access tokens revoked\n", response.Message, response.ServiceId, response.RevokedTokens)
+		return nil
+	})
+}
+
+func (ssm *SSM) accountList(ctx context.Context, c *cli.Command) error {
+	return ssm.withAccounts(c, func() error {
+		response, err := handlers.ListServiceAccounts()
+		if err != nil {
+			return err
+		}
+		for _, account := range response.Accounts {
+			state := "enabled"
+			if account.Disabled {
+				state = "disabled"
+			}
+			lastLogin := account.LastLoginAt
+			if lastLogin == "" {
+				lastLogin = "never"
+			}
+			fmt.Fprintf(os.Stdout, "%s\t%s\t%s\tlast login %s\n", account.ServiceId, account.Role, state, lastLogin)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/networkgcorefullcode/ssm/database"
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/handlers"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/urfave/cli/v3"
+)
+
+// backupCommand returns the backup subcommands, the password and the key
+// components are read from files so they never show in the process list
+func (ssm *SSM) backupCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "backup",
+		Usage: "back up the key inventory to a signed bundle",
+		Commands: []*cli.Command{
+			{
+				Name:  "create",
+				Usage: "write a bundle of the keys and the user secrets",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{Name: "out", Usage: "bundle file to write", Required: true},
+				}, backupCredentialFlags()...),
+				Action: ssm.backupCreate,
+			},
+			{
+				Name:  "restore",
+				Usage: "check a bundle and restore it into a token without secret keys",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{Name: "in", Usage: "bundle file to restore", Required: true},
+					&cli.BoolFlag{Name: "dry-run", Usage: "only check the signature and compare the bundle with the token"},
+				}, backupCredentialFlags()...),
+				Action: ssm.backupRestore,
+			},
+		},
+	}
+}
+
+func backupCredentialFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "password-file", Usage: "file holding the password of the bundle"},
+		&cli.StringSliceFlag{Name: "component-file", Usage: "file holding a key component in hexadecimal, once per custodian"},
+	}
+}
+
+// readBackupCredentials reads the password or the key components of a bundle
+func readBackupCredentials(c *cli.Command) (pkcs11mgr.BackupCredentials, error) {
+	var password string
+	if path := c.String("password-file"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return pkcs11mgr.BackupCredentials{}, err
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	var components []string
+	for _, path := range c.StringSlice("component-file") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return pkcs11mgr.BackupCredentials{}, err
+		}
+		components = append(components, strings.TrimSpace(string(data)))
+	}
+	return handlers.NewBackupCredentials(password, components)
+}
+
+// withKeyStore loads the configuration and runs f with a KeyStore of the
+// configured token, the token is finalized when f returns
+func (ssm *SSM) withKeyStore(ctx context.Context, c *cli.Command, f func(ks pkcs11mgr.KeyStore) error) error {
+	if err := ssm.Initialize(c); err != nil {
+		return err
+	}
+	cryptoProvider, err := newCryptoProvider()
+	if err != nil {
+		return err
+	}
+	defer cryptoProvider.Finalize()
+
+	ks, err := cryptoProvider.GetKeyStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer cryptoProvider.ReleaseKeyStore(ks)
+	return f(ks)
+}
+
+func (ssm *SSM) backupCreate(ctx context.Context, c *cli.Command) error {
+	return ssm.withKeyStore(ctx, c, func(ks pkcs11mgr.KeyStore) error {
+		credentials, err := readBackupCredentials(c)
+		if err != nil {
+			return err
+		}
+		if err := database.Connect(); err != nil {
+			return fmt.Errorf("mongodb: %w", err)
+		}
+		secrets, err := database.SecretStore{}.BackupSecrets()
+		if err != nil {
+			return fmt.Errorf("failed to read the user secrets: %w", err)
+		}
+
+		bundle, err := pkcs11mgr.CreateBackup(ks, credentials, factory.SsmConfig.GetBackup().Iterations, secrets)
+		if err != nil {
+			return err
+		}
+		raw, err := json.MarshalIndent(bundle, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(c.String("out"), raw, 0600); err != nil {
+			return err
+		}
+
+		response := handlers.NewBackupResponse(bundle, raw)
+		if len(response.NotExported) > 0 {
+			logger.AppLog.Warnf("%d keys are not extractable, the bundle only lists them", len(response.NotExported))
+		}
+		fmt.Fprintf(os.Stdout, "Backup bundle written to %s: %d keys exported, %d not exported, %d user secrets\n",
+			c.String("out"), response.Exported, len(response.NotExported), response.UserSecrets)
+		return nil
+	})
+}
+
+func (ssm *SSM) backupRestore(ctx context.Context, c *cli.Command) error {
+	return ssm.withKeyStore(ctx, c, func(ks pkcs11mgr.KeyStore) error {
+		raw, err := os.ReadFile(c.String("in"))
+		if err != nil {
+			return err
+		}
+		var bundle pkcs11mgr.BackupBundle
+		if err := json.Unmarshal(raw, &bundle); err != nil {
+			return fmt.Errorf("%s is not a backup bundle: %w", c.String("in"), err)
+		}
+		credentials, err := readBackupCredentials(c)
+		if err != nil {
+			return err
+		}
+
+		dryRun := c.Bool("dry-run")
+		if dryRun {
+			plan, err := pkcs11mgr.PlanRestore(ks, &bundle, credentials)
+			if err != nil {
+				return err
+			}
+			return printJSON(handlers.NewBackupRestoreResponse(plan, true))
+		}
+
+		if err := database.Connect(); err != nil {
+			return fmt.Errorf("mongodb: %w", err)
+		}
+		plan, err := pkcs11mgr.RestoreBackup(ks, &bundle, credentials)
+		if errors.Is(err, pkcs11mgr.ErrBackupTokenNotEmpty) {
+			if printErr := printJSON(handlers.NewBackupRestoreResponse(plan, true)); printErr != nil {
+				return printErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if err := (database.SecretStore{}).RestoreSecrets(bundle.UserSecrets); err != nil {
+			return fmt.Errorf("the keys were restored but not the user secrets: %w", err)
+		}
+		return printJSON(handlers.NewBackupRestoreResponse(plan, false))
+	})
+}
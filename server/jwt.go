@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/urfave/cli/v3"
+)
+
+// jwtCommand returns the subcommands that manage the JWT signing key
+func (ssm *SSM) jwtCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "jwt",
+		Usage: "manage the versions of the JWT signing key",
+		Commands: []*cli.Command{
+			{
+				Name:  "rotate",
+				Usage: "generate a new JWT signing key, the previous key still verifies tokens for the grace period",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "revoke", Usage: "destroy the previous keys at once, every token they signed is rejected"},
+				},
+				Action: ssm.jwtRotate,
+			},
+			{
+				Name:   "list",
+				Usage:  "list the versions of the JWT signing key",
+				Action: ssm.jwtList,
+			},
+		},
+	}
+}
+
+// configureJWT applies the jwt configuration, it is called before the JWT
+// signing key is loaded
+func configureJWT() error {
+	jwt := factory.SsmConfig.GetJWT()
+	if err := pkcs11mgr.SetJWTAlgorithm(jwt.Algorithm); err != nil {
+		return err
+	}
+	pkcs11mgr.SetJWTGracePeriod(time.Duration(jwt.GracePeriod) * time.Hour)
+	return nil
+}
+
+func (ssm *SSM) jwtRotate(ctx context.Context, c *cli.Command) error {
+	return ssm.withKeyStore(ctx, c, func(ks pkcs11mgr.KeyStore) error {
+		if err := configureJWT(); err != nil {
+			return err
+		}
+		now := time.Now()
+		key, err := pkcs11mgr.RotateJWTKey(ks, now)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "JWT signing key rotated to version %d, kid %s\n", key.Id, key.Kid)
+
+		purged, err := pkcs11mgr.PurgeJWTKeys(ks, now, c.Bool("revoke"))
+		if err != nil {
+			return err
+		}
+		if len(purged) > 0 {
+			fmt.Fprintf(os.Stdout, "Destroyed versions %v\n", purged)
+		}
+		return nil
+	})
+}
+
+func (ssm *SSM) jwtList(ctx context.Context, c *cli.Command) error {
+	return ssm.withKeyStore(ctx, c, func(ks pkcs11mgr.KeyStore) error {
+		if err := configureJWT(); err != nil {
+			return err
+		}
+		keys, err := pkcs11mgr.ListJWTKeys(ks, time.Now())
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			state := "expired"
+			switch {
+			case key.Signing:
+				state = "signing"
+			case key.Accepted:
+				state = "accepted until " + key.ExpiresAt.Format(time.RFC3339)
+			}
+			created := "unknown"
+			if !key.CreatedAt.IsZero() {
+				created = key.CreatedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(os.Stdout, "%d\t%s\tcreated %s\t%s\n", key.Id, key.Kid, created, state)
+		}
+		return nil
+	})
+}
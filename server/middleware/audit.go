@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
@@ -22,35 +23,124 @@ import (
 type AuditLog struct {
 	Start time.Time `json:"start_time"`
 	// UserID     string    `json:"user_id,omitempty"`
-	Action     string `json:"action"`
-	Method     string `json:"method"`
-	Path       string `json:"path"`
-	IP         string `json:"ip"`
-	UserAgent  string `json:"user_agent,omitempty"`
-	StatusCode int    `json:"status_code"`
-	RequestID  string `json:"request_id,omitempty"`
-	Duration   int64  `json:"duration_ms,omitempty"` // Duration in milliseconds
-	Error      string `json:"error,omitempty"`
-	Signature  string `json:"signature,omitempty"`
+	Action     string          `json:"action"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	IP         string          `json:"ip"`
+	UserAgent  string          `json:"user_agent,omitempty"`
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Duration   int64           `json:"duration_ms,omitempty"` // Duration in milliseconds
+	Error      string          `json:"error,omitempty"`
+	Signature  string          `json:"signature,omitempty"`
+	Batch      *BatchAudit     `json:"batch,omitempty"`
+	Suci       *SuciAudit      `json:"suci,omitempty"`
+	Component  *ComponentAudit `json:"component_import,omitempty"`
+}
+
+// BatchAudit summarises a batch request, the batch gets one audit record
+// instead of one per item
+type BatchAudit struct {
+	Items     int            `json:"items"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Labels    map[string]int `json:"labels,omitempty"` // items per key label
+}
+
+const batchAuditKey = "audit-batch"
+
+// SetBatchAudit attaches the summary of a batch request to its audit record
+func SetBatchAudit(c *gin.Context, batch BatchAudit) {
+	c.Set(batchAuditKey, batch)
+}
+
+// SuciAudit records the protection scheme and home network key of a SUCI
+// de-concealment, the SUPI is never logged
+type SuciAudit struct {
+	Scheme           int `json:"scheme"`
+	HomeNetworkKeyID int `json:"hn_key_id"`
+}
+
+const suciAuditKey = "audit-suci"
+
+// SetSuciAudit attaches the SUCI de-concealment details to its audit record
+func SetSuciAudit(c *gin.Context, suci SuciAudit) {
+	c.Set(suciAuditKey, suci)
+}
+
+// ComponentAudit records a step of a key component import and the service
+// identity behind it, the components are never logged, only their KCV
+type ComponentAudit struct {
+	ImportId   string   `json:"import_id"`
+	KeyLabel   string   `json:"key_label"`
+	KeyId      int32    `json:"key_id"`
+	Component  int      `json:"component,omitempty"`
+	Custodian  string   `json:"custodian,omitempty"`
+	Kcv        string   `json:"kcv,omitempty"`
+	Custodians []string `json:"custodians,omitempty"` // every custodian, on finalize
+}
+
+const componentAuditKey = "audit-component"
+
+// SetComponentAudit attaches a key component import step to its audit record
+func SetComponentAudit(c *gin.Context, component ComponentAudit) {
+	c.Set(componentAuditKey, component)
 }
 
 // Map common patterns to actions
 var ActionMap map[string]string = map[string]string{
-	"POST /crypto/encrypt":           constants.ACTION_ENCRYPT_DATA,
-	"POST /crypto/decrypt":           constants.ACTION_DECRYPT_DATA,
-	"POST /crypto/generate-aes-key":  constants.ACTION_GENERATE_AES_KEY,
-	"POST /crypto/generate-des-key":  constants.ACTION_GENERATE_DES_KEY,
-	"POST /crypto/generate-des3-key": constants.ACTION_GENERATE_DES3_KEY,
-	"POST /crypto/store-key":         constants.ACTION_STORE_KEY,
-	"PUT /crypto/store-key":          constants.ACTION_UPDATE_KEY,
-	"DELETE /crypto/store-key":       constants.ACTION_DELETE_KEY,
-	"POST /crypto/get-key":           constants.ACTION_GET_KEY,
-	"POST /crypto/get-data-keys":     constants.ACTION_GET_KEYS,
-	"POST /crypto/get-all-keys":      constants.ACTION_GET_ALL_KEYS,
-	"GET /crypto/health-check":       constants.ACTION_HEALTH_CHECK,
-	"POST /login":                    constants.ACTION_USER_LOGIN,
-	"POST /crypto/encrypt-aes-gcm":   constants.ACTION_ENCRYPT_GCM,
-	"POST /crypto/decrypt-aes-gcm":   constants.ACTION_DECRYPT_GCM,
+	"POST /crypto/encrypt":                     constants.ACTION_ENCRYPT_DATA,
+	"POST /crypto/decrypt":                     constants.ACTION_DECRYPT_DATA,
+	"POST /crypto/generate-aes-key":            constants.ACTION_GENERATE_AES_KEY,
+	"POST /crypto/generate-des-key":            constants.ACTION_GENERATE_DES_KEY,
+	"POST /crypto/generate-des3-key":           constants.ACTION_GENERATE_DES3_KEY,
+	"POST /crypto/store-key":                   constants.ACTION_STORE_KEY,
+	"PUT /crypto/store-key":                    constants.ACTION_UPDATE_KEY,
+	"DELETE /crypto/store-key":                 constants.ACTION_DELETE_KEY,
+	"POST /crypto/get-key":                     constants.ACTION_GET_KEY,
+	"POST /crypto/get-data-keys":               constants.ACTION_GET_KEYS,
+	"POST /crypto/get-all-keys":                constants.ACTION_GET_ALL_KEYS,
+	"GET /crypto/health-check":                 constants.ACTION_HEALTH_CHECK,
+	"POST /login":                              constants.ACTION_USER_LOGIN,
+	"GET /.well-known/jwks.json":               constants.ACTION_GET_JWKS,
+	"POST /logout":                             constants.ACTION_USER_LOGOUT,
+	"POST /token/refresh":                      constants.ACTION_REFRESH_TOKEN,
+	"POST /crypto/service-account":             constants.ACTION_CREATE_SERVICE_ACCOUNT,
+	"GET /crypto/service-accounts":             constants.ACTION_LIST_SERVICE_ACCOUNTS,
+	"POST /crypto/service-account-reset":       constants.ACTION_RESET_SERVICE_PASSWORD,
+	"POST /crypto/service-account-disable":     constants.ACTION_DISABLE_SERVICE_ACCOUNT,
+	"POST /crypto/encrypt-aes-gcm":             constants.ACTION_ENCRYPT_GCM,
+	"POST /crypto/decrypt-aes-gcm":             constants.ACTION_DECRYPT_GCM,
+	"POST /crypto/rotate-key":                  constants.ACTION_ROTATE_KEY,
+	"POST /crypto/purge-retired-keys":          constants.ACTION_PURGE_RETIRED_KEYS,
+	"POST /crypto/reencrypt":                   constants.ACTION_REENCRYPT_DATA,
+	"POST /crypto/encrypt-batch":               constants.ACTION_ENCRYPT_BATCH,
+	"POST /crypto/decrypt-batch":               constants.ACTION_DECRYPT_BATCH,
+	"POST /crypto/generate-rsa-key":            constants.ACTION_GENERATE_RSA_KEY,
+	"POST /crypto/generate-ec-key":             constants.ACTION_GENERATE_EC_KEY,
+	"POST /crypto/sign":                        constants.ACTION_SIGN_DATA,
+	"POST /crypto/verify":                      constants.ACTION_VERIFY_SIGNATURE,
+	"POST /crypto/public-key":                  constants.ACTION_EXPORT_PUBLIC_KEY,
+	"POST /crypto/generate-mac-key":            constants.ACTION_GENERATE_MAC_KEY,
+	"POST /crypto/mac":                         constants.ACTION_COMPUTE_MAC,
+	"POST /crypto/mac-verify":                  constants.ACTION_VERIFY_MAC,
+	"POST /crypto/auth-vector":                 constants.ACTION_AUTH_VECTOR,
+	"POST /crypto/auth-resync":                 constants.ACTION_AUTH_RESYNC,
+	"POST /crypto/generate-suci-key":           constants.ACTION_GENERATE_SUCI_KEY,
+	"POST /crypto/import-suci-key":             constants.ACTION_IMPORT_SUCI_KEY,
+	"POST /crypto/suci-public-key":             constants.ACTION_EXPORT_SUCI_PUBLIC_KEY,
+	"POST /crypto/suci-deconceal":              constants.ACTION_SUCI_DECONCEAL,
+	"POST /crypto/generate-transport-key-pair": constants.ACTION_GENERATE_TRANSPORT_KEY_PAIR,
+	"POST /crypto/generate-transport-key":      constants.ACTION_GENERATE_TRANSPORT_KEY,
+	"POST /crypto/wrap-key":                    constants.ACTION_WRAP_KEY,
+	"POST /crypto/unwrap-key":                  constants.ACTION_UNWRAP_KEY,
+	"POST /crypto/replication-sync":            constants.ACTION_REPLICATION_SYNC,
+	"POST /crypto/replication-report":          constants.ACTION_REPLICATION_REPORT,
+	"POST /crypto/backup":                      constants.ACTION_BACKUP,
+	"POST /crypto/backup-restore":              constants.ACTION_BACKUP_RESTORE,
+	"POST /crypto/component-import-start":      constants.ACTION_COMPONENT_IMPORT_START,
+	"POST /crypto/component-import-submit":     constants.ACTION_COMPONENT_IMPORT_SUBMIT,
+	"POST /crypto/component-import-finalize":   constants.ACTION_COMPONENT_IMPORT_FINALIZE,
 }
 
 func AuditRequest(c *gin.Context) {
@@ -81,6 +171,22 @@ func AuditRequest(c *gin.Context) {
 		Duration:   duration.Milliseconds(),
 	}
 
+	if value, exists := c.Get(batchAuditKey); exists {
+		if batch, ok := value.(BatchAudit); ok {
+			logEntry.Batch = &batch
+		}
+	}
+	if value, exists := c.Get(suciAuditKey); exists {
+		if suci, ok := value.(SuciAudit); ok {
+			logEntry.Suci = &suci
+		}
+	}
+	if value, exists := c.Get(componentAuditKey); exists {
+		if component, ok := value.(ComponentAudit); ok {
+			logEntry.Component = &component
+		}
+	}
+
 	// Capture errors if they exist
 	if len(c.Errors) > 0 {
 		logEntry.Error = c.Errors.String()
@@ -116,13 +222,16 @@ func logAuditEntry(entry AuditLog) {
 
 	entry.Signature = "" // Clear signature before signing
 
-	// getting pkcs11 manager and session from SsmServer
-	session := mgr.GetSession()
+	// getting a key store from the crypto provider
+	ks, err := provider.GetKeyStore(context.Background())
+	if err != nil {
+		logger.AppLog.Errorf("Failed to get key store to sign audit log: %v", err)
+		return
+	}
 	auditPrivateKey := pkcs11mgr.GetAuditPrivateKey()
 
-	var err error
-	entry.Signature, err = signAuditLog(entry, &session.Handle, session.Ctx, auditPrivateKey)
-	mgr.LogoutSession(session)
+	entry.Signature, err = signAuditLog(entry, ks, auditPrivateKey)
+	provider.ReleaseKeyStore(ks)
 	if err != nil {
 		logger.AppLog.Errorf("Failed to sign audit log: %v", err)
 		return
@@ -131,7 +240,7 @@ func logAuditEntry(entry AuditLog) {
 	go database.InsertData(database.Client, factory.SsmConfig.Configuration.Mongodb.DBName, database.CollAuditLogs, entry)
 }
 
-func signAuditLog(logData any, session *pkcs11.SessionHandle, mgr *pkcs11.Ctx, privKey pkcs11.ObjectHandle) (string, error) {
+func signAuditLog(logData any, ks pkcs11mgr.KeyStore, privKey pkcs11.ObjectHandle) (string, error) {
 	data, err := json.Marshal(logData)
 	if err != nil {
 		return "", err
@@ -139,12 +248,7 @@ func signAuditLog(logData any, session *pkcs11.SessionHandle, mgr *pkcs11.Ctx, p
 
 	hash := sha256.Sum256(data)
 
-	err = mgr.SignInit(*session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}, privKey)
-	if err != nil {
-		return "", err
-	}
-
-	signature, err := mgr.Sign(*session, hash[:])
+	signature, err := ks.Sign(privKey, pkcs11.CKM_RSA_PKCS, hash[:])
 	if err != nil {
 		return "", err
 	}
@@ -11,6 +11,18 @@ import (
 	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
 )
 
+// udmActions are the actions the udm user may call. It gets authentication
+// vectors and resynchronises the SQN instead of decrypting the subscriber
+// keys, so K and OPc never leave SSM, de-conceals SUCIs and checks the MACs of
+// the provisioning payloads.
+var udmActions = []string{
+	constants.ACTION_AUTH_VECTOR,
+	constants.ACTION_AUTH_RESYNC,
+	constants.ACTION_SUCI_DECONCEAL,
+	constants.ACTION_VERIFY_MAC,
+	constants.ACTION_HEALTH_CHECK,
+}
+
 func AuthenticateRequest() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logger.AppLog.Debugf("Authenticating request for %s %s", c.Request.Method, c.Request.URL.Path)
@@ -23,41 +35,88 @@ func AuthenticateRequest() gin.HandlerFunc {
 
 		tokenString := strings.Replace(jwtToken, "Bearer ", "", 1)
 
-		session := mgr.GetSession()
+		ks, err := provider.GetKeyStore(c.Request.Context())
+		if err != nil {
+			logger.AppLog.Errorf("Failed to get key store: %v", err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "crypto backend not available"})
+			return
+		}
 
 		// verify JWT token here
-		jwtPayload, err := pkcs11mgr.VerifyJWT(session, tokenString)
-		mgr.LogoutSession(session)
+		jwtPayload, err := pkcs11mgr.VerifyJWT(ks, tokenString)
+		provider.ReleaseKeyStore(ks)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
 			return
 		}
 
+		// the tokens revoked by /logout are rejected until they expire
+		if IsTokenRevoked(jwtPayload.Jti) {
+			logger.AppLog.Debugf("Token %s of %s is revoked", jwtPayload.Jti, jwtPayload.Sub)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "revoked token"})
+			return
+		}
+
+		// the role of the service account decides the operations, the tokens
+		// issued before the roles only carry the udm or webconsole subject
+		role := payloadRole(jwtPayload)
+
 		// check if the user is valid
-		if jwtPayload.Sub != constants.USER_UDM && jwtPayload.Sub != constants.USER_WEBCONSOLE {
-			logger.AppLog.Debugf("User: %s is invalid", jwtPayload.Sub)
+		if role != constants.ROLE_UDM && role != constants.ROLE_WEBCONSOLE {
+			logger.AppLog.Debugf("User: %s with role %s is invalid", jwtPayload.Sub, role)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
 			return
 		}
 
-		// check if the operation is allow for the role (udm only decrypt, webconsole all actions in the list)
+		// check if the operation is allow for the role (udm only the udmActions, webconsole all actions in the list)
 		action := determineAction(c)
-		if !slices.Contains(constants.ActionList, action) && jwtPayload.Sub == constants.USER_WEBCONSOLE {
+		if !slices.Contains(constants.ActionList, action) && role == constants.ROLE_WEBCONSOLE {
 			logger.AppLog.Debugf("Action is: %s", action)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid operation for the user"})
 			return
 		}
 
-		if (action != constants.ACTION_DECRYPT_DATA && action != constants.ACTION_DECRYPT_GCM && action != constants.ACTION_HEALTH_CHECK) &&
-			jwtPayload.Sub == constants.USER_UDM {
+		if !slices.Contains(udmActions, action) && role == constants.ROLE_UDM {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid operation for the user"})
 			return
 		}
 
 		// Set the payload in context for further handlers
-		c.Set("jwt-payload", jwtPayload)
+		c.Set(jwtPayloadKey, jwtPayload)
 
 		// If all is well, proceed to the handler
 		c.Next()
 	}
 }
+
+const jwtPayloadKey = "jwt-payload"
+
+// ServiceIdentity returns the subject of the JWT of the request, it is empty
+// when the API is not secured
+func ServiceIdentity(c *gin.Context) string {
+	if value, exists := c.Get(jwtPayloadKey); exists {
+		if payload, ok := value.(*pkcs11mgr.JWTPayload); ok {
+			return payload.Sub
+		}
+	}
+	return ""
+}
+
+// ServiceRole returns the role of the JWT of the request, the subject for the
+// tokens without a role claim. The key usage policies are checked against it,
+// it is empty when the API is not secured.
+func ServiceRole(c *gin.Context) string {
+	if value, exists := c.Get(jwtPayloadKey); exists {
+		if payload, ok := value.(*pkcs11mgr.JWTPayload); ok {
+			return payloadRole(payload)
+		}
+	}
+	return ""
+}
+
+func payloadRole(payload *pkcs11mgr.JWTPayload) string {
+	if payload.Role != "" {
+		return payload.Role
+	}
+	return payload.Sub
+}
@@ -6,11 +6,11 @@ import (
 	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
 )
 
-var mgr *pkcs11mgr.Manager
+var provider pkcs11mgr.CryptoProvider
 var hostname string
 
-func SetPKCS11Manager(manager *pkcs11mgr.Manager) {
-	mgr = manager
+func SetCryptoProvider(p pkcs11mgr.CryptoProvider) {
+	provider = p
 }
 
 func init() {
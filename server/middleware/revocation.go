@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/networkgcorefullcode/ssm/database"
+	"github.com/networkgcorefullcode/ssm/logger"
+)
+
+// RevocationStore gives the access tokens revoked by /logout
+type RevocationStore interface {
+	RevokedTokens(now time.Time) ([]database.RevokedToken, error)
+}
+
+var revocationStore RevocationStore = database.TokenStore{}
+
+// SetRevocationStore replaces the MongoDB store of the revoked tokens
+func SetRevocationStore(s RevocationStore) {
+	revocationStore = s
+}
+
+// revocations is the in-memory copy of the revocation list, AuthenticateRequest
+// checks the jti of every token against it without calling MongoDB. The tokens
+// revoked by another SSM are seen after the next SyncRevocations.
+var revocations = struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time // jti -> expiration of the token
+	stop    chan struct{}
+}{revoked: make(map[string]time.Time)}
+
+// RevokeToken adds a token to the in-memory revocation list until it expires
+func RevokeToken(jti string, expiresAt time.Time) {
+	revocations.mu.Lock()
+	defer revocations.mu.Unlock()
+	revocations.revoked[jti] = expiresAt
+}
+
+// IsTokenRevoked reports whether the token with the jti was revoked
+func IsTokenRevoked(jti string) bool {
+	revocations.mu.RLock()
+	defer revocations.mu.RUnlock()
+	_, ok := revocations.revoked[jti]
+	return ok
+}
+
+// SyncRevocations adds the tokens revoked in MongoDB to the in-memory list and
+// drops the expired ones
+func SyncRevocations() error {
+	now := time.Now()
+	tokens, err := revocationStore.RevokedTokens(now)
+	if err != nil {
+		return err
+	}
+
+	revocations.mu.Lock()
+	defer revocations.mu.Unlock()
+	for jti, expiresAt := range revocations.revoked {
+		if !now.Before(expiresAt) {
+			delete(revocations.revoked, jti)
+		}
+	}
+	for _, token := range tokens {
+		revocations.revoked[token.Jti] = token.ExpiresAt
+	}
+	return nil
+}
+
+// StartRevocationSync runs SyncRevocations every interval in the background
+func StartRevocationSync(interval time.Duration) {
+	if interval <= 0 || revocations.stop != nil {
+		return
+	}
+
+	if err := SyncRevocations(); err != nil {
+		logger.AppLog.Errorf("Failed to read the revoked tokens: %v", err)
+	}
+	stop := make(chan struct{})
+	revocations.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := SyncRevocations(); err != nil {
+					logger.AppLog.Errorf("Failed to read the revoked tokens: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopRevocationSync stops the background sync started by StartRevocationSync
+func StopRevocationSync() {
+	if revocations.stop != nil {
+		close(revocations.stop)
+		revocations.stop = nil
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/networkgcorefullcode/ssm/factory"
+	"github.com/networkgcorefullcode/ssm/handlers"
+	"github.com/networkgcorefullcode/ssm/logger"
+	"github.com/networkgcorefullcode/ssm/pkcs11mgr"
+	"github.com/urfave/cli/v3"
+)
+
+// replicationPairTimeout bounds the pairing of the replica when the SSM starts
+const replicationPairTimeout = 30 * time.Second
+
+// GetCommands returns the subcommands of the ssm command
+func (ssm *SSM) GetCommands() []*cli.Command {
+	return []*cli.Command{
+		ssm.backupCommand(),
+		ssm.jwtCommand(),
+		ssm.accountCommand(),
+		{
+			Name:  "replicate",
+			Usage: "replicate the keys to the replica token",
+			Commands: []*cli.Command{
+				{
+					Name:   "sync",
+					Usage:  "copy every replicated key to the replica token",
+					Action: ssm.replicateSync,
+				},
+				{
+					Name:   "report",
+					Usage:  "compare the replicated keys of both tokens",
+					Action: ssm.replicateReport,
+				},
+			},
+		},
+	}
+}
+
+// newReplicatingProvider opens the replica token and starts the incremental
+// sync of the keys changed through the returned provider
+func newReplicatingProvider(primary pkcs11mgr.CryptoProvider, replica *factory.Replica) (*pkcs11mgr.ReplicatingProvider, error) {
+	replicator, err := newReplicator(primary, replica)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), replicationPairTimeout)
+	defer cancel()
+	if err := replicator.Pair(ctx); err != nil {
+		logger.AppLog.Errorf("Failed to pair the replica token, keys are not replicated until a full sync succeeds: %v", err)
+	}
+	replicator.Start()
+	return pkcs11mgr.NewReplicatingProvider(primary, replicator), nil
+}
+
+// newReplicator opens the replica token, the keys created from now on are extractable
+func newReplicator(primary pkcs11mgr.CryptoProvider, replica *factory.Replica) (*pkcs11mgr.Replicator, error) {
+	pkcs11mgr.SetKeyExport(true)
+	replicaManager, err := newPKCS11Manager(replica.PkcsPath, pkcs11mgr.TokenSelector{
+		Slot:   uint(replica.Slot),
+		Label:  replica.Label,
+		Serial: replica.Serial,
+	}, replica.Pin, replica.MaxSessions)
+	if err != nil {
+		return nil, fmt.Errorf("replica token: %w", err)
+	}
+	return pkcs11mgr.NewReplicator(primary, replicaManager, replica.QueueSize), nil
+}
+
+// withReplicator loads the configuration and runs f with a Replicator of the
+// configured tokens, the tokens are finalized when f returns
+func (ssm *SSM) withReplicator(c *cli.Command, f func(r *pkcs11mgr.Replicator) (any, error)) error {
+	if err := ssm.Initialize(c); err != nil {
+		return err
+	}
+	replica := factory.SsmConfig.GetReplica()
+	if replica == nil {
+		return fmt.Errorf("no replica token is configured")
+	}
+
+	primary, err := newCryptoProvider()
+	if err != nil {
+		return err
+	}
+	defer primary.Finalize()
+
+	replicator, err := newReplicator(primary, replica)
+	if err != nil {
+		return err
+	}
+	defer replicator.Close()
+
+	result, err := f(replicator)
+	if err != nil {
+		return err
+	}
+	return printJSON(result)
+}
+
+// printJSON writes the result of a command to the standard output
+func printJSON(result any) error {
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(out))
+	return nil
+}
+
+func (ssm *SSM) replicateSync(ctx context.Context, c *cli.Command) error {
+	return ssm.withReplicator(c, func(r *pkcs11mgr.Replicator) (any, error) {
+		result, err := r.FullSync(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Failed) > 0 {
+			logger.AppLog.Warnf("%d keys could not be replicated", len(result.Failed))
+		}
+		return handlers.NewReplicationSyncResponse(result), nil
+	})
+}
+
+func (ssm *SSM) replicateReport(ctx context.Context, c *cli.Command) error {
+	return ssm.withReplicator(c, func(r *pkcs11mgr.Replicator) (any, error) {
+		report, err := r.Report(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !report.Consistent {
+			logger.AppLog.Warnln("The replica token is not consistent with the primary token")
+		}
+		return handlers.NewReplicationReportResponse(report), nil
+	})
+}
@@ -41,6 +41,23 @@ func CreateGinRouter() *gin.Engine {
 		handlers.HandleLogin(c)
 	})
 
+	// refresh and logout are public as well, /logout verifies its token itself
+	r.POST("/token/refresh", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /token/refresh request")
+		handlers.HandleRefreshToken(c)
+	})
+
+	r.POST("/logout", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /logout request")
+		handlers.HandleLogout(c)
+	})
+
+	// JWKS of the JWT signing keys, public like /login
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /.well-known/jwks.json request")
+		handlers.HandleGetJWKS(c)
+	})
+
 	// HealthCheck endpoint (GET recommended)
 	rc.GET("/health-check", func(c *gin.Context) {
 		logger.AppLog.Debugf("Received /health-check request")
@@ -71,6 +88,132 @@ func CreateGinRouter() *gin.Engine {
 		handlers.HandleDecryptAESGCM(c)
 	})
 
+	// Authentication vector endpoints POST
+	rc.POST("/auth-vector", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /auth-vector request")
+		handlers.HandleAuthVector(c)
+	})
+
+	rc.POST("/auth-resync", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /auth-resync request")
+		handlers.HandleAuthResync(c)
+	})
+
+	// SUCI endpoints POST
+	rc.POST("/generate-suci-key", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /generate-suci-key request")
+		handlers.HandleGenerateSuciKey(c)
+	})
+
+	rc.POST("/import-suci-key", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /import-suci-key request")
+		handlers.HandleImportSuciKey(c)
+	})
+
+	rc.POST("/suci-public-key", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /suci-public-key request")
+		handlers.HandleExportSuciPublicKey(c)
+	})
+
+	rc.POST("/suci-deconceal", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /suci-deconceal request")
+		handlers.HandleSuciDeconceal(c)
+	})
+
+	// Key transport endpoints POST
+	rc.POST("/generate-transport-key-pair", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /generate-transport-key-pair request")
+		handlers.HandleGenerateTransportKeyPair(c)
+	})
+
+	rc.POST("/generate-transport-key", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /generate-transport-key request")
+		handlers.HandleGenerateTransportKey(c)
+	})
+
+	rc.POST("/wrap-key", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /wrap-key request")
+		handlers.HandleWrapKey(c)
+	})
+
+	rc.POST("/unwrap-key", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /unwrap-key request")
+		handlers.HandleUnwrapKey(c)
+	})
+
+	// Replica token endpoints POST
+	rc.POST("/replication-sync", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /replication-sync request")
+		handlers.HandleReplicationSync(c)
+	})
+
+	rc.POST("/replication-report", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /replication-report request")
+		handlers.HandleReplicationReport(c)
+	})
+
+	// Service account endpoints, webconsole only
+	rc.POST("/service-account", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /service-account request")
+		handlers.HandleCreateServiceAccount(c)
+	})
+
+	rc.GET("/service-accounts", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /service-accounts request")
+		handlers.HandleListServiceAccounts(c)
+	})
+
+	rc.POST("/service-account-reset", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /service-account-reset request")
+		handlers.HandleResetServicePassword(c)
+	})
+
+	rc.POST("/service-account-disable", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /service-account-disable request")
+		handlers.HandleDisableServiceAccount(c)
+	})
+
+	// Backup bundle endpoints POST
+	rc.POST("/backup", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /backup request")
+		handlers.HandleBackup(c)
+	})
+
+	rc.POST("/backup-restore", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /backup-restore request")
+		handlers.HandleBackupRestore(c)
+	})
+
+	// Key component import endpoints POST
+	rc.POST("/component-import-start", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /component-import-start request")
+		handlers.HandleComponentImportStart(c)
+	})
+	rc.POST("/component-import-submit", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /component-import-submit request")
+		handlers.HandleComponentImportSubmit(c)
+	})
+	rc.POST("/component-import-finalize", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /component-import-finalize request")
+		handlers.HandleComponentImportFinalize(c)
+	})
+
+	// Re-encrypt endpoints POST
+	rc.POST("/reencrypt", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /reencrypt request")
+		handlers.HandleReencrypt(c)
+	})
+
+	// Batch endpoints POST
+	rc.POST("/encrypt-batch", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /encrypt-batch request")
+		handlers.HandleEncryptBatch(c)
+	})
+	rc.POST("/decrypt-batch", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /decrypt-batch request")
+		handlers.HandleDecryptBatch(c)
+	})
+
 	// Store Key endpoints POST
 	rc.POST("/store-key", func(c *gin.Context) {
 		logger.AppLog.Debugf("Received /store-key request")
@@ -85,6 +228,16 @@ func CreateGinRouter() *gin.Engine {
 		handlers.HandleStoreKey(c)
 	})
 
+	// Key rotation endpoints POST
+	rc.POST("/rotate-key", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /rotate-key request")
+		handlers.HandleRotateKey(c)
+	})
+	rc.POST("/purge-retired-keys", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /purge-retired-keys request")
+		handlers.HandlePurgeRetiredKeys(c)
+	})
+
 	// Generate Key endpoints POST
 	rc.POST("/generate-aes-key", func(c *gin.Context) {
 		logger.AppLog.Debugf("Received /generate-aes-key request")
@@ -101,6 +254,49 @@ func CreateGinRouter() *gin.Engine {
 		handlers.HandleGenerateDESKey(c)
 	})
 
+	// Key pair endpoints POST
+	rc.POST("/generate-rsa-key", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /generate-rsa-key request")
+		handlers.HandleGenerateRSAKey(c)
+	})
+
+	rc.POST("/generate-ec-key", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /generate-ec-key request")
+		handlers.HandleGenerateECKey(c)
+	})
+
+	rc.POST("/public-key", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /public-key request")
+		handlers.HandleExportPublicKey(c)
+	})
+
+	// Signature endpoints POST
+	rc.POST("/sign", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /sign request")
+		handlers.HandleSign(c)
+	})
+
+	rc.POST("/verify", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /verify request")
+		handlers.HandleVerify(c)
+	})
+
+	// MAC endpoints POST
+	rc.POST("/generate-mac-key", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /generate-mac-key request")
+		handlers.HandleGenerateMACKey(c)
+	})
+
+	rc.POST("/mac", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /mac request")
+		handlers.HandleMAC(c)
+	})
+
+	rc.POST("/mac-verify", func(c *gin.Context) {
+		logger.AppLog.Debugf("Received /mac-verify request")
+		handlers.HandleMACVerify(c)
+	})
+
 	// Synchronization handlers
 	rc.POST("/get-data-keys", func(c *gin.Context) {
 		logger.AppLog.Debugf("Received /get-data-keys request")
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/networkgcorefullcode/ssm/database"
@@ -132,28 +133,51 @@ func (s *SSM) Start() error {
 		return err
 	}
 
-	// init the pkcs manager
-	pkcsManager, err := pkcs11mgr.New(factory.SsmConfig.Configuration.PkcsPath,
-		uint(factory.SsmConfig.Configuration.LotsNumber),
-		factory.SsmConfig.Configuration.Pin)
+	// init the crypto provider
+	cryptoProvider, err := newCryptoProvider()
 	if err != nil {
-		logger.AppLog.Errorf("Failed to initialize PKCS11 manager: %v", err)
+		logger.AppLog.Errorf("Failed to initialize crypto provider: %v", err)
 		return err
 	}
 
-	pkcsManager.CloseAllSessions()
-	pkcs11mgr.SetChanMaxSessions(factory.SsmConfig.Configuration.MaxSessions)
+	// the keys of the replicated families are created extractable so they can be backed up
+	if factory.SsmConfig.GetBackup().Enabled {
+		pkcs11mgr.SetKeyExport(true)
+	}
+
+	// copy the replicated keys to the replica token
+	if replica := factory.SsmConfig.GetReplica(); replica != nil {
+		replicatingProvider, err := newReplicatingProvider(cryptoProvider, replica)
+		if err != nil {
+			logger.AppLog.Errorf("Failed to open the replica token: %v", err)
+			cryptoProvider.Finalize()
+			return err
+		}
+		cryptoProvider = replicatingProvider
+	}
 
-	handlers.SetPKCS11Manager(pkcsManager)
-	middleware.SetPKCS11Manager(pkcsManager)
-	pkcs11mgr.SetPKCS11Manager(pkcsManager)
-	database.SetPKCS11Manager(pkcsManager)
+	handlers.SetCryptoProvider(cryptoProvider)
+	middleware.SetCryptoProvider(cryptoProvider)
+	pkcs11mgr.SetCryptoProvider(cryptoProvider)
+	database.SetCryptoProvider(cryptoProvider)
+
+	// sign the JWTs with the configured algorithm and grace period
+	if err := configureJWT(); err != nil {
+		logger.AppLog.Errorf("Invalid JWT configuration: %v", err)
+		cryptoProvider.Finalize()
+		return err
+	}
 
 	// Initialize PKCS11 functions and constants
 	pkcs11mgr.InitPKCS11()
 	// Initialize the database functions
 	database.InitDB()
 
+	// rotate the JWT signing key on schedule
+	pkcs11mgr.StartJWTKeyRotation(time.Duration(factory.SsmConfig.GetJWT().RotationInterval) * 24 * time.Hour)
+	// keep the in-memory revocation list in sync with the other SSMs
+	middleware.StartRevocationSync(time.Duration(factory.SsmConfig.GetJWT().RevocationSync) * time.Second)
+
 	// Build Gin router with all endpoints
 	router := CreateGinRouter()
 
@@ -180,9 +204,76 @@ func (s *SSM) Start() error {
 		return err
 	}
 
-	pkcsManager.CloseAllSessions()
-	pkcsManager.Finalize()
+	middleware.StopRevocationSync()
+	pkcs11mgr.StopJWTKeyRotation()
+	cryptoProvider.Finalize()
 
 	logger.AppLog.Info("SSM server stopped gracefully")
 	return nil
 }
+
+// newCryptoProvider builds the crypto backend selected by configuration.cryptoProvider
+func newCryptoProvider() (pkcs11mgr.CryptoProvider, error) {
+	switch factory.SsmConfig.Configuration.CryptoProvider {
+	case pkcs11mgr.PROVIDER_MEMORY:
+		return pkcs11mgr.NewMemoryProvider(), nil
+	case pkcs11mgr.PROVIDER_PKCS11, "":
+		if len(factory.SsmConfig.Configuration.Tokens) > 0 {
+			return newTokenRouter()
+		}
+		token := pkcs11mgr.TokenSelector{
+			Slot:   uint(factory.SsmConfig.Configuration.LotsNumber),
+			Label:  factory.SsmConfig.Configuration.TokenLabel,
+			Serial: factory.SsmConfig.Configuration.TokenSerial,
+		}
+		pkcsManager, err := newPKCS11Manager(factory.SsmConfig.Configuration.PkcsPath, token, factory.SsmConfig.Configuration.Pin, factory.SsmConfig.Configuration.MaxSessions)
+		if err != nil {
+			return nil, err
+		}
+		return pkcsManager, nil
+	default:
+		return nil, fmt.Errorf("unknown crypto provider: %s", factory.SsmConfig.Configuration.CryptoProvider)
+	}
+}
+
+// newTokenRouter opens a manager for every configured token and routes the label families to them
+func newTokenRouter() (pkcs11mgr.CryptoProvider, error) {
+	router := pkcs11mgr.NewTokenRouter()
+	for _, token := range factory.SsmConfig.Configuration.Tokens {
+		if token.Name == "" {
+			router.Finalize()
+			return nil, fmt.Errorf("a token without name is configured")
+		}
+
+		pkcsManager, err := newPKCS11Manager(factory.SsmConfig.Configuration.PkcsPath, pkcs11mgr.TokenSelector{
+			Slot:   uint(token.Slot),
+			Label:  token.Label,
+			Serial: token.Serial,
+		}, token.Pin, token.MaxSessions)
+		if err != nil {
+			router.Finalize()
+			return nil, fmt.Errorf("token %s: %w", token.Name, err)
+		}
+
+		if err := router.AddToken(token.Name, pkcsManager, token.Families); err != nil {
+			pkcsManager.Finalize()
+			router.Finalize()
+			return nil, err
+		}
+	}
+	return router, nil
+}
+
+// newPKCS11Manager opens the session pool of a token of the module
+func newPKCS11Manager(modulePath string, token pkcs11mgr.TokenSelector, pin string, maxSessions int) (*pkcs11mgr.Manager, error) {
+	pkcsManager, err := pkcs11mgr.New(modulePath, token, pin, maxSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	pkcsManager.CloseAllSessions()
+	sessionPool := factory.SsmConfig.GetSessionPool()
+	pkcsManager.SetWaitTimeout(time.Duration(sessionPool.WaitTimeout) * time.Second)
+	pkcsManager.StartHealthCheck(time.Duration(sessionPool.HealthCheckInterval) * time.Second)
+	return pkcsManager, nil
+}
@@ -29,9 +29,10 @@ func main() {
 	app.Name = "ssm"
 	logger.AppLog.Infoln(app.Name)
 	app.Usage = "Access & Mobility Management function"
-	app.UsageText = "ssm -cfg <ssm_config_file.conf>"
+	app.UsageText = "ssm -cfg <ssm_config_file.conf> [replicate sync|report] [backup create|restore]"
 	app.Action = action
 	app.Flags = SSM.GetCliCmd()
+	app.Commands = SSM.GetCommands()
 	if err := app.Run(context.Background(), os.Args); err != nil {
 		logger.AppLog.Fatalf("SSM run error: %v", err)
 	}
@@ -0,0 +1,199 @@
+// Package suci implements the home network side of the SUCI de-concealment
+// (TS 33.501 Annex C): parsing of the SUCI and the ECIES protection schemes
+// Profile A and Profile B. The ECDH step is left to the caller so the home
+// network private key can stay in the HSM.
+package suci
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/networkgcorefullcode/ssm/safe"
+)
+
+// Protection scheme identifiers (TS 33.501 Annex C.1)
+const (
+	SchemeNull     = 0
+	SchemeProfileA = 1
+	SchemeProfileB = 2
+)
+
+// SUPI types of the SUCI (TS 23.003 section 2.2B)
+const (
+	SupiTypeIMSI = "0"
+)
+
+// Sizes of the ECIES keys and fields in bytes (TS 33.501 Annex C.3.4)
+const (
+	MacSize        = 8
+	encKeySize     = 16
+	icbSize        = 16
+	macKeySize     = 32
+	x25519KeySize  = 32
+	p256Compressed = 33
+	p256Point      = 65
+)
+
+// ErrMacMismatch is returned by Decrypt when the MAC tag of the scheme output
+// does not match, the SUCI was not concealed with the home network key
+var ErrMacMismatch = errors.New("SUCI MAC tag mismatch")
+
+// SUCI is a parsed SUCI string:
+// suci-<SUPI type>-<MCC>-<MNC>-<routing indicator>-<scheme>-<key id>-<scheme output>
+type SUCI struct {
+	SupiType         string
+	Mcc              string
+	Mnc              string
+	RoutingIndicator string
+	Scheme           int
+	HomeNetworkKeyID int
+	SchemeOutput     string
+}
+
+// Parse parses a SUCI string, the scheme output is left encoded
+func Parse(s string) (*SUCI, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 8 || parts[0] != "suci" {
+		return nil, errors.New("SUCI must have the form suci-<type>-<mcc>-<mnc>-<routing>-<scheme>-<key id>-<output>")
+	}
+	scheme, err := strconv.Atoi(parts[5])
+	if err != nil || scheme < 0 || scheme > 15 {
+		return nil, fmt.Errorf("invalid protection scheme %q", parts[5])
+	}
+	keyID, err := strconv.Atoi(parts[6])
+	if err != nil || keyID < 0 || keyID > 255 {
+		return nil, fmt.Errorf("invalid home network public key identifier %q", parts[6])
+	}
+	for _, digits := range []string{parts[2], parts[3]} {
+		if !isDigits(digits) || len(digits) < 2 || len(digits) > 3 {
+			return nil, fmt.Errorf("invalid MCC or MNC %q", digits)
+		}
+	}
+	return &SUCI{
+		SupiType:         parts[1],
+		Mcc:              parts[2],
+		Mnc:              parts[3],
+		RoutingIndicator: parts[4],
+		Scheme:           scheme,
+		HomeNetworkKeyID: keyID,
+		SchemeOutput:     parts[7],
+	}, nil
+}
+
+// SUPI returns the IMSI based SUPI of the SUCI for the de-concealed MSIN
+func (s *SUCI) SUPI(msin string) string {
+	return "imsi-" + s.Mcc + s.Mnc + msin
+}
+
+// Split decodes an ECIES scheme output into the ephemeral public key, the
+// ciphertext and the MAC tag. Profile B accepts compressed and uncompressed
+// ephemeral keys.
+func Split(scheme int, output string) (ephemeral, ciphertext, mac []byte, err error) {
+	b, err := hex.DecodeString(output)
+	if err != nil {
+		return nil, nil, nil, errors.New("the scheme output must be hexadecimal")
+	}
+	var keySize int
+	switch scheme {
+	case SchemeProfileA:
+		keySize = x25519KeySize
+	case SchemeProfileB:
+		if len(b) > 0 && b[0] == 0x04 {
+			keySize = p256Point
+		} else {
+			keySize = p256Compressed
+		}
+	default:
+		return nil, nil, nil, fmt.Errorf("protection scheme %d is not an ECIES profile", scheme)
+	}
+	if len(b) <= keySize+MacSize {
+		return nil, nil, nil, fmt.Errorf("scheme output too short: %d bytes", len(b))
+	}
+	return b[:keySize], b[keySize : len(b)-MacSize], b[len(b)-MacSize:], nil
+}
+
+// PeerPublicKey returns the ephemeral public key in the form ECDH expects, the
+// compressed Profile B points are decompressed
+func PeerPublicKey(scheme int, ephemeral []byte) ([]byte, error) {
+	if scheme != SchemeProfileB || len(ephemeral) != p256Compressed {
+		return ephemeral, nil
+	}
+	curve := elliptic.P256()
+	x, y := elliptic.UnmarshalCompressed(curve, ephemeral)
+	if x == nil {
+		return nil, errors.New("invalid compressed P-256 ephemeral key")
+	}
+	return elliptic.Marshal(curve, x, y), nil
+}
+
+// Decrypt checks the MAC tag and decrypts the ciphertext with the keys derived
+// from the ECDH shared secret (TS 33.501 Annex C.3.3). The shared secret is
+// zeroed as soon as the keys are derived from it.
+func Decrypt(sharedSecret, ephemeral, ciphertext, mac []byte) ([]byte, error) {
+	keys := x963KDF(sharedSecret, ephemeral, encKeySize+icbSize+macKeySize)
+	safe.Zero(sharedSecret)
+	defer safe.Zero(keys)
+	encKey, icb, macKey := keys[:encKeySize], keys[encKeySize:encKeySize+icbSize], keys[encKeySize+icbSize:]
+
+	h := hmac.New(sha256.New, macKey)
+	h.Write(ciphertext)
+	if !hmac.Equal(h.Sum(nil)[:MacSize], mac) {
+		return nil, ErrMacMismatch
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, icb).XORKeyStream(plain, ciphertext)
+	return plain, nil
+}
+
+// x963KDF is the ANSI X9.63 key derivation with SHA-256 the ECIES profiles use
+func x963KDF(secret, sharedInfo []byte, size int) []byte {
+	out := make([]byte, 0, size+sha256.Size)
+	for counter := uint32(1); len(out) < size; counter++ {
+		h := sha256.New()
+		h.Write(secret)
+		h.Write(binary.BigEndian.AppendUint32(nil, counter))
+		h.Write(sharedInfo)
+		out = h.Sum(out)
+	}
+	return out[:size]
+}
+
+// DecodeMSIN decodes the BCD MSIN of an IMSI based SUPI, the digits of each
+// byte are swapped and an odd number of digits is padded with 0xF
+func DecodeMSIN(b []byte) (string, error) {
+	digits := make([]byte, 0, 2*len(b))
+	for i, v := range b {
+		low, high := v&0x0F, v>>4
+		if low > 9 || (high > 9 && (high != 0x0F || i != len(b)-1)) {
+			return "", errors.New("the MSIN is not valid BCD")
+		}
+		digits = append(digits, '0'+low)
+		if high != 0x0F {
+			digits = append(digits, '0'+high)
+		}
+	}
+	return string(digits), nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
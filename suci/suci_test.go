@@ -0,0 +1,92 @@
+package suci
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestDeconcealAnnexC4 checks both ECIES profiles against the test data of
+// TS 33.501 Annex C.4
+func TestDeconcealAnnexC4(t *testing.T) {
+	tests := []struct {
+		name    string
+		curve   ecdh.Curve
+		private string
+		suci    string
+	}{
+		{
+			name:    "Profile A",
+			curve:   ecdh.X25519(),
+			private: "c53c22208b61860b06c62e5406a7b330c2b577aa5558981510d128247d38bd1d",
+			suci:    "suci-0-001-01-0000-1-0-b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa87",
+		},
+		{
+			name:    "Profile B",
+			curve:   ecdh.P256(),
+			private: "f1ab1074477ebcc7f554ea1c5fc368b1616730155e0041ac447d6301975fecda",
+			suci:    "suci-0-001-01-0000-2-0-039aab8376597021e855679a9778ea0b67396e68c66df32c0f41e9acca2da9b9d146a33fc2716ac7dae96aa30a4d",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.suci)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ephemeral, ciphertext, mac, err := Split(s.Scheme, s.SchemeOutput)
+			if err != nil {
+				t.Fatal(err)
+			}
+			peer, err := PeerPublicKey(s.Scheme, ephemeral)
+			if err != nil {
+				t.Fatal(err)
+			}
+			private, err := tt.curve.NewPrivateKey(unhex(t, tt.private))
+			if err != nil {
+				t.Fatal(err)
+			}
+			public, err := tt.curve.NewPublicKey(peer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			shared, err := private.ECDH(public)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			plain, err := Decrypt(bytes.Clone(shared), ephemeral, ciphertext, mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(plain); got != "00012080f6" {
+				t.Fatalf("plaintext = %s", got)
+			}
+			msin, err := DecodeMSIN(plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if supi := s.SUPI(msin); supi != "imsi-00101001002086" {
+				t.Fatalf("SUPI = %s", supi)
+			}
+
+			mac[0] ^= 1
+			if _, err := Decrypt(shared, ephemeral, ciphertext, mac); err != ErrMacMismatch {
+				t.Fatalf("tampered MAC: err = %v", err)
+			}
+			if !bytes.Equal(shared, make([]byte, len(shared))) {
+				t.Fatal("the shared secret was not zeroed after the KDF")
+			}
+		})
+	}
+}